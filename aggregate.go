@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+)
+
+// AggregatePart is the sub-request of the composite request
+type AggregatePart struct {
+	// Name is the parameter of the composite reply with the sub-reply parameters
+	Name    string `json:"name" yaml:"name"`
+	Command string `json:"command" yaml:"command"`
+	// Parameters maps the sub-request parameter to the composite request parameter
+	Parameters map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	// Optional part doesn't fail the composite reply
+	Optional bool `json:"optional,omitempty" yaml:"optional,omitempty"`
+}
+
+// Aggregate is the composite command joined from the sub-requests
+type Aggregate struct {
+	Command string          `json:"command" yaml:"command"`
+	Parts   []AggregatePart `json:"parts" yaml:"parts"`
+}
+
+// Aggregator fans out the composite requests into the sub-requests,
+// and joins their replies into a single reply.
+type Aggregator struct {
+	aggregates map[string]Aggregate
+}
+
+// NewAggregator returns the aggregator of the composite commands
+func NewAggregator(aggregates []Aggregate) (*Aggregator, error) {
+	aggregator := &Aggregator{aggregates: make(map[string]Aggregate, len(aggregates))}
+	for i, aggregate := range aggregates {
+		if len(aggregate.Command) == 0 || len(aggregate.Parts) == 0 {
+			return nil, fmt.Errorf("aggregates[%d] requires the command and the parts", i)
+		}
+		names := make(map[string]struct{}, len(aggregate.Parts))
+		for _, part := range aggregate.Parts {
+			if len(part.Name) == 0 || len(part.Command) == 0 {
+				return nil, fmt.Errorf("'%s' part requires the name and the command", aggregate.Command)
+			}
+			if _, ok := names[part.Name]; ok {
+				return nil, fmt.Errorf("'%s' has duplicate part '%s'", aggregate.Command, part.Name)
+			}
+			names[part.Name] = struct{}{}
+		}
+		aggregator.aggregates[aggregate.Command] = aggregate
+	}
+	return aggregator, nil
+}
+
+// subRequest returns the envelope of the part, keeping the metadata of the composite request
+func (part *AggregatePart) subRequest(req *Envelope) *Envelope {
+	sub := *req
+	sub.Id = ""
+	sub.Command = part.Command
+	sub.Parameters = make(map[string]interface{}, len(part.Parameters))
+	for name, from := range part.Parameters {
+		if value, ok := req.Parameters[from]; ok {
+			sub.Parameters[name] = value
+		}
+	}
+	return &sub
+}
+
+// Middleware passes the sub-requests of the composite commands to the next handler in parallel.
+// The other commands are passed as is.
+func (aggregator *Aggregator) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			aggregate, ok := aggregator.aggregates[req.Command]
+			if !ok {
+				return next(req)
+			}
+
+			replies := make([]*Reply, len(aggregate.Parts))
+			var wg sync.WaitGroup
+			for i := range aggregate.Parts {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					replies[i] = next(aggregate.Parts[i].subRequest(req))
+				}(i)
+			}
+			wg.Wait()
+
+			joined := make(map[string]interface{}, len(replies))
+			for i, part := range aggregate.Parts {
+				if replies[i] == nil {
+					replies[i] = Fail(fmt.Sprintf("no reply to '%s'", part.Command))
+				}
+				if replies[i].IsOK() {
+					joined[part.Name] = replies[i].Parameters
+					continue
+				}
+				if !part.Optional {
+					return Fail(fmt.Sprintf("'%s' part failed: %s", part.Name, replies[i].Message))
+				}
+				joined[part.Name] = nil
+			}
+			return Ok(joined)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"testing"
+)
+
+// aggregateBackend replies to the sub-requests of the profile
+func aggregateBackend(req *Envelope) *Reply {
+	switch req.Command {
+	case "user.get":
+		return Ok(map[string]interface{}{"name": "alice", "id": req.Parameters["id"]})
+	case "order.list":
+		return Ok(map[string]interface{}{"count": 2})
+	case "recommend.list":
+		return nil
+	default:
+		return Fail("no such command")
+	}
+}
+
+// TestAggregatorJoinsParts checks that the composite reply has the parts, and the required part fails it
+func TestAggregatorJoinsParts(t *testing.T) {
+	aggregator, err := NewAggregator([]Aggregate{
+		{Command: "profile", Parts: []AggregatePart{
+			{Name: "user", Command: "user.get", Parameters: map[string]string{"id": "user_id"}},
+			{Name: "orders", Command: "order.list"},
+			{Name: "recommended", Command: "recommend.list", Optional: true},
+		}},
+		{Command: "broken", Parts: []AggregatePart{{Name: "missing", Command: "missing.get"}}},
+	})
+	if err != nil {
+		t.Fatalf("NewAggregator: %v", err)
+	}
+	handler := Wrap(aggregateBackend, aggregator.Middleware())
+
+	reply := handler(policyRequest("profile", map[string]interface{}{"user_id": "u1"}))
+	if !reply.IsOK() {
+		t.Fatalf("the composite request failed: %s", reply.Message)
+	}
+	user, ok := reply.Parameters["user"].(map[string]interface{})
+	if !ok || user["id"] != "u1" || reply.Parameters["orders"] == nil {
+		t.Fatalf("the composite reply is %v", reply.Parameters)
+	}
+	if recommended, ok := reply.Parameters["recommended"]; !ok || recommended != nil {
+		t.Fatalf("the optional part without the reply is %v", recommended)
+	}
+
+	if reply := handler(policyRequest("broken", nil)); reply.IsOK() {
+		t.Fatalf("the composite request passed without the required part")
+	}
+	if reply := handler(policyRequest("user.get", map[string]interface{}{"id": "u2"})); reply.Parameters["id"] != "u2" {
+		t.Fatalf("the plain request replied %v", reply)
+	}
+}
+
+// TestNewAggregatorValidates checks that the parts must be named and unique
+func TestNewAggregatorValidates(t *testing.T) {
+	invalid := [][]Aggregate{
+		{{Command: "profile"}},
+		{{Command: "profile", Parts: []AggregatePart{{Name: "user"}}}},
+		{{Command: "profile", Parts: []AggregatePart{{Name: "user", Command: "a"}, {Name: "user", Command: "b"}}}},
+	}
+	for _, aggregates := range invalid {
+		if _, err := NewAggregator(aggregates); err == nil {
+			t.Fatalf("the aggregates %v passed", aggregates)
+		}
+	}
+}
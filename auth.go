@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// AuthParam is the request parameter with the token of the client
+const AuthParam = "auth_token"
+
+// Verifier validates the token of the request and returns the authenticated principal
+type Verifier interface {
+	Verify(req *Envelope) (string, error)
+}
+
+// VerifierFunc is the function used as the Verifier
+type VerifierFunc func(req *Envelope) (string, error)
+
+// Verify calls the function
+func (verify VerifierFunc) Verify(req *Envelope) (string, error) {
+	return verify(req)
+}
+
+// StaticKeys verifies the static api keys in the AuthParam.
+// The keys are kept hashed, so they are not leaked by the memory dumps.
+type StaticKeys struct {
+	principals map[[sha256.Size]byte]string
+}
+
+// NewStaticKeys returns the verifier of the api keys mapped to their principals
+func NewStaticKeys(keys map[string]string) (*StaticKeys, error) {
+	verifier := &StaticKeys{principals: make(map[[sha256.Size]byte]string, len(keys))}
+	for key, principal := range keys {
+		if len(key) == 0 || len(principal) == 0 {
+			return nil, fmt.Errorf("api key of '%s' requires the key and the principal", principal)
+		}
+		verifier.principals[sha256.Sum256([]byte(key))] = principal
+	}
+	return verifier, nil
+}
+
+// Verify returns the principal of the api key
+func (verifier *StaticKeys) Verify(req *Envelope) (string, error) {
+	key := req.StringParam(AuthParam)
+	if len(key) == 0 {
+		return "", fmt.Errorf("missing '%s' parameter", AuthParam)
+	}
+	principal, ok := verifier.principals[sha256.Sum256([]byte(key))]
+	if !ok {
+		return "", fmt.Errorf("invalid api key")
+	}
+	return principal, nil
+}
+
+// WithAuth rejects the requests that the verifier doesn't accept.
+// The accepted request has the Principal set for the next handlers,
+// and the AuthParam is removed, so the token is not forwarded to the destination.
+func WithAuth(verifier Verifier) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			principal, err := verifier.Verify(req)
+			if err != nil {
+				return Fail(fmt.Sprintf("unauthorized: %v", err))
+			}
+
+			authenticated := *req
+			authenticated.Principal = principal
+			if _, ok := req.Parameters[AuthParam]; ok {
+				authenticated.Parameters = make(map[string]interface{}, len(req.Parameters))
+				for name, value := range req.Parameters {
+					if name != AuthParam {
+						authenticated.Parameters[name] = value
+					}
+				}
+			}
+			return next(&authenticated)
+		}
+	}
+}
+
+// authFactory expects the 'keys' setting with the static api keys mapped to their principals
+func authFactory(_ string, settings map[string]interface{}) (Middleware, error) {
+	var config struct {
+		Keys map[string]string `json:"keys"`
+	}
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	if len(config.Keys) == 0 {
+		return nil, fmt.Errorf("no 'keys' setting")
+	}
+	verifier, err := NewStaticKeys(config.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("NewStaticKeys: %w", err)
+	}
+	return WithAuth(verifier), nil
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"testing"
+)
+
+// TestWithAuthSetsPrincipal checks that the accepted request has the principal and not the token
+func TestWithAuthSetsPrincipal(t *testing.T) {
+	keys, err := NewStaticKeys(map[string]string{"secret": "alice"})
+	if err != nil {
+		t.Fatalf("NewStaticKeys: %v", err)
+	}
+	var forwarded *Envelope
+	handler := Wrap(func(req *Envelope) *Reply {
+		forwarded = req
+		return Ok(nil)
+	}, WithAuth(keys))
+
+	req := policyRequest("balance", map[string]interface{}{AuthParam: "secret", "account": "a1"})
+	if reply := handler(req); !reply.IsOK() {
+		t.Fatalf("the valid key was rejected: %s", reply.Message)
+	}
+	if forwarded.Principal != "alice" || forwarded.Parameters["account"] != "a1" {
+		t.Fatalf("forwarded the principal '%s' with %v", forwarded.Principal, forwarded.Parameters)
+	}
+	if _, ok := forwarded.Parameters[AuthParam]; ok {
+		t.Fatalf("the token was forwarded to the destination")
+	}
+	if _, ok := req.Parameters[AuthParam]; !ok || len(req.Principal) > 0 {
+		t.Fatalf("the client's request was changed")
+	}
+
+	for _, parameters := range []map[string]interface{}{{}, {AuthParam: "guess"}} {
+		if reply := handler(policyRequest("balance", parameters)); reply.IsOK() {
+			t.Fatalf("the request with %v was accepted", parameters)
+		}
+	}
+}
+
+// TestAuthFactory checks that the auth middleware is built from the pipeline configuration
+func TestAuthFactory(t *testing.T) {
+	if _, err := NewStaticKeys(map[string]string{"": "alice"}); err == nil {
+		t.Fatalf("the empty key was accepted")
+	}
+	if _, err := BuildPipeline([]MiddlewareConfig{{Name: "auth"}}); err == nil {
+		t.Fatalf("the auth without the keys was built")
+	}
+	pipeline, err := BuildPipeline([]MiddlewareConfig{{Name: "auth", Settings: map[string]interface{}{
+		"keys": map[string]interface{}{"secret": "alice"},
+	}}})
+	if err != nil {
+		t.Fatalf("BuildPipeline: %v", err)
+	}
+	handler := Wrap(func(req *Envelope) *Reply {
+		return Ok(map[string]interface{}{"principal": req.Principal})
+	}, pipeline...)
+	if reply := handler(policyRequest("balance", map[string]interface{}{AuthParam: "secret"})); reply.Parameters["principal"] != "alice" {
+		t.Fatalf("the configured auth replied %v", reply)
+	}
+}
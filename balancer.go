@@ -0,0 +1,632 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+)
+
+// The load balancing strategies
+const (
+	RoundRobin       = "round-robin"
+	LeastOutstanding = "least-outstanding"
+	RandomStrategy   = "random"
+	// CostAware picks the cheapest instance that meets the latency limit
+	CostAware = "cost"
+)
+
+// costProbe is how often the CostAware strategy ignores the latency limit
+const costProbe = 20
+
+// The states of the circuit breaker of the instance
+const (
+	// BreakerClosed passes the requests to the instance
+	BreakerClosed = "closed"
+	// BreakerOpen removes the instance from the pool until the cool-down is over
+	BreakerOpen = "open"
+	// BreakerHalfOpen lets the single trial request through. It closes the circuit on success
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerConfig is the setting of the circuit breakers of the instances
+type BreakerConfig struct {
+	// Failures is the number of the consecutive failures that open the circuit
+	Failures int `json:"failures" yaml:"failures"`
+	// CoolDown is the time the instance is out of the pool
+	CoolDown time.Duration `json:"cool_down" yaml:"cool_down"`
+}
+
+// DefaultBreakerConfig is used for the unset fields of the config
+var DefaultBreakerConfig = BreakerConfig{Failures: 5, CoolDown: 30 * time.Second}
+
+// breakerStates are the values of the breaker state gauge
+var breakerStates = map[string]float64{BreakerClosed: 0, BreakerHalfOpen: 1, BreakerOpen: 2}
+
+// The admin commands of the balancer
+const (
+	BalancerStatusCommand = "balancer.status"
+	BalancerCostCommand   = "balancer.cost"
+)
+
+// Instance is the destination instance behind the balancer
+type Instance struct {
+	Name      string
+	Transport DestinationTransport
+	// Labels of the instance, for example the zone, region or capability
+	Labels map[string]string
+	// Cost of the request to the instance, for example the egress price. Used by CostAware
+	Cost float64
+}
+
+// LabelSelector requires the commands to be sent to the instances with the labels.
+// The command is a pattern with the wildcards, for example 'render.*'.
+type LabelSelector struct {
+	Command string            `json:"command" yaml:"command"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// hasLabels returns true if the instance has all the labels
+func (instance *Instance) hasLabels(labels map[string]string) bool {
+	for name, value := range labels {
+		if instance.Labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// InstanceStatus is the state of the instance in the balancer
+type InstanceStatus struct {
+	Healthy     bool   `json:"healthy"`
+	Outstanding int    `json:"outstanding"`
+	Failures    uint64 `json:"failures"`
+	// Latency is the moving average of the successful requests
+	Latency time.Duration `json:"latency"`
+	// Breaker is the state of the circuit breaker, if it's enabled
+	Breaker string `json:"breaker,omitempty"`
+}
+
+type balancedInstance struct {
+	Instance
+	InstanceStatus
+	// consecutive failures for the circuit breaker
+	consecutive int
+	openUntil   time.Time
+	trial       bool
+}
+
+// breakerAllows returns true if the circuit breaker lets the request to the instance
+func (instance *balancedInstance) breakerAllows(now time.Time) bool {
+	switch instance.Breaker {
+	case BreakerOpen:
+		return !now.Before(instance.openUntil)
+	case BreakerHalfOpen:
+		return !instance.trial
+	default:
+		return true
+	}
+}
+
+// Balancer spreads the requests over the destination instances.
+// The instances that fail to deliver the request are marked unhealthy and skipped,
+// until they are marked healthy again, for example by the health checks.
+// If all instances are unhealthy, then all of them are tried.
+//
+// With the circuit breaker, the failures open the circuit instead of marking the instance unhealthy.
+// The instance with the open circuit is out of the pool for the cool-down,
+// then the single trial request decides whether the circuit is closed or opened again.
+// If the circuit of every instance is open, then the requests fail fast.
+//
+// The commands with the label selector are sent only to the instances with the labels.
+// Among them, the instances with the preferred labels are chosen first, for example the same zone,
+// and the rest are the fallback if none of the preferred ones is healthy.
+//
+// The balancer is the destination transport itself, so it's used anywhere the single destination is.
+type Balancer struct {
+	strategy  string
+	mu        sync.Mutex
+	instances []*balancedInstance
+	next      int
+	random    *rand.Rand
+	metrics   *Metrics
+	preferred map[string]string
+	selectors []LabelSelector
+	// maxLatency is the limit of the CostAware strategy
+	maxLatency time.Duration
+	// breaker is nil if the circuit breakers are disabled
+	breaker   *BreakerConfig
+	onBreaker func(instance string, from string, to string)
+}
+
+// breakerChange is the transition of the circuit breaker, passed to the callback after the lock is released
+type breakerChange struct {
+	instance string
+	from     string
+	to       string
+}
+
+// NewBalancer returns the balancer of the instances with the strategy
+func NewBalancer(strategy string, instances []Instance) (*Balancer, error) {
+	switch strategy {
+	case RoundRobin, LeastOutstanding, RandomStrategy, CostAware:
+	default:
+		return nil, fmt.Errorf("unknown strategy '%s'", strategy)
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instances")
+	}
+
+	balancer := &Balancer{
+		strategy:  strategy,
+		instances: make([]*balancedInstance, 0, len(instances)),
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	names := make(map[string]struct{}, len(instances))
+	for _, instance := range instances {
+		if _, ok := names[instance.Name]; ok {
+			return nil, fmt.Errorf("duplicate instance '%s'", instance.Name)
+		}
+		names[instance.Name] = struct{}{}
+		balancer.instances = append(balancer.instances, &balancedInstance{
+			Instance:       instance,
+			InstanceStatus: InstanceStatus{Healthy: true},
+		})
+	}
+	return balancer, nil
+}
+
+// WithPreferred sets the labels of the instances chosen first, for example the zone of the proxy
+func (balancer *Balancer) WithPreferred(labels map[string]string) *Balancer {
+	balancer.preferred = labels
+	return balancer
+}
+
+// SetSelectors sets the label selectors of the commands. The first matching selector is applied.
+// Call it before sending the requests.
+func (balancer *Balancer) SetSelectors(selectors []LabelSelector) error {
+	for i, selector := range selectors {
+		if _, err := path.Match(selector.Command, ""); err != nil {
+			return fmt.Errorf("selectors[%d] command '%s': %w", i, selector.Command, err)
+		}
+	}
+	balancer.selectors = selectors
+	return nil
+}
+
+// WithBreaker enables the circuit breakers of the instances.
+// The onChange is called on every transition of the breaker state. Optional
+func (balancer *Balancer) WithBreaker(config BreakerConfig, onChange func(instance string, from string, to string)) *Balancer {
+	if config.Failures <= 0 {
+		config.Failures = DefaultBreakerConfig.Failures
+	}
+	if config.CoolDown <= 0 {
+		config.CoolDown = DefaultBreakerConfig.CoolDown
+	}
+	balancer.breaker = &config
+	balancer.onBreaker = onChange
+	for _, instance := range balancer.instances {
+		instance.Breaker = BreakerClosed
+		balancer.breakerGauge(instance.Name, instance.Breaker)
+	}
+	return balancer
+}
+
+// breakerGauge sets the breaker state of the instance in the metrics
+func (balancer *Balancer) breakerGauge(instance string, state string) {
+	if balancer.metrics != nil {
+		balancer.metrics.Set("proxy_breaker_state", "the circuit breaker of the instance: 0 closed, 1 half-open, 2 open", breakerStates[state], "instance", instance)
+	}
+}
+
+// setBreaker changes the breaker state of the instance.
+// Must be called with the lock.
+func (balancer *Balancer) setBreaker(instance *balancedInstance, state string) *breakerChange {
+	if instance.Breaker == state {
+		return nil
+	}
+	change := &breakerChange{instance: instance.Name, from: instance.Breaker, to: state}
+	instance.Breaker = state
+	return change
+}
+
+// notifyBreaker reports the transition to the metrics and the callback
+func (balancer *Balancer) notifyBreaker(change *breakerChange) {
+	if change == nil {
+		return
+	}
+	balancer.breakerGauge(change.instance, change.to)
+	if balancer.metrics != nil {
+		balancer.metrics.Add("proxy_breaker_transitions_total", "the state changes of the circuit breakers", 1, "instance", change.instance, "to", change.to)
+	}
+	if balancer.onBreaker != nil {
+		balancer.onBreaker(change.instance, change.from, change.to)
+	}
+}
+
+// filter returns the instances that pass the check
+func filter(instances []*balancedInstance, check func(instance *balancedInstance) bool) []*balancedInstance {
+	passed := make([]*balancedInstance, 0, len(instances))
+	for _, instance := range instances {
+		if check(instance) {
+			passed = append(passed, instance)
+		}
+	}
+	return passed
+}
+
+// candidates returns the instances that may receive the command.
+// Must be called with the lock.
+func (balancer *Balancer) candidates(command string) ([]*balancedInstance, error) {
+	candidates := balancer.instances
+	for _, selector := range balancer.selectors {
+		if matched, _ := path.Match(selector.Command, command); !matched {
+			continue
+		}
+		candidates = filter(candidates, func(instance *balancedInstance) bool {
+			return instance.hasLabels(selector.Labels)
+		})
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("no instance has the labels required by '%s'", command)
+		}
+		break
+	}
+
+	if balancer.breaker != nil {
+		now := time.Now()
+		candidates = filter(candidates, func(instance *balancedInstance) bool {
+			return instance.breakerAllows(now)
+		})
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("circuit is open for all instances of '%s'", command)
+		}
+	}
+	if healthy := filter(candidates, func(instance *balancedInstance) bool {
+		return instance.Healthy
+	}); len(healthy) > 0 {
+		candidates = healthy
+	}
+	if len(balancer.preferred) > 0 {
+		if preferred := filter(candidates, func(instance *balancedInstance) bool {
+			return instance.hasLabels(balancer.preferred)
+		}); len(preferred) > 0 {
+			candidates = preferred
+		}
+	}
+	return candidates, nil
+}
+
+// pick returns the instance for the command, counting it as outstanding
+func (balancer *Balancer) pick(command string) (*balancedInstance, error) {
+	balancer.mu.Lock()
+	picked, change, err := balancer.pickLocked(command)
+	balancer.mu.Unlock()
+
+	balancer.notifyBreaker(change)
+	return picked, err
+}
+
+// pickLocked returns the instance, and the transition of its breaker if it's the trial request.
+// Must be called with the lock.
+func (balancer *Balancer) pickLocked(command string) (*balancedInstance, *breakerChange, error) {
+	candidates, err := balancer.candidates(command)
+	if err != nil {
+		return nil, nil, err
+	}
+	var picked *balancedInstance
+	switch balancer.strategy {
+	case RoundRobin:
+		picked = candidates[balancer.next%len(candidates)]
+		balancer.next++
+	case LeastOutstanding:
+		for _, candidate := range candidates {
+			if picked == nil || candidate.Outstanding < picked.Outstanding {
+				picked = candidate
+			}
+		}
+	case CostAware:
+		// every costProbe request ignores the latency limit,
+		// so the latency of the cheap but slow instances is measured again
+		balancer.next++
+		picked = balancer.cheapest(candidates, balancer.next%costProbe == 0)
+	default:
+		picked = candidates[balancer.random.Intn(len(candidates))]
+	}
+
+	picked.Outstanding++
+	var change *breakerChange
+	if balancer.breaker != nil && picked.Breaker != BreakerClosed {
+		picked.trial = true
+		change = balancer.setBreaker(picked, BreakerHalfOpen)
+	}
+	return picked, change, nil
+}
+
+// cheapest returns the instance with the lowest cost within the latency limit.
+// The ties are broken by the outstanding requests.
+// If no instance meets the limit, then the fastest one is returned.
+// Must be called with the lock.
+func (balancer *Balancer) cheapest(candidates []*balancedInstance, unlimited bool) *balancedInstance {
+	var cheapest, fastest *balancedInstance
+	for _, candidate := range candidates {
+		if fastest == nil || candidate.Latency < fastest.Latency {
+			fastest = candidate
+		}
+		if !unlimited && balancer.maxLatency > 0 && candidate.Latency > balancer.maxLatency {
+			continue
+		}
+		if cheapest == nil || candidate.Cost < cheapest.Cost ||
+			(candidate.Cost == cheapest.Cost && candidate.Outstanding < cheapest.Outstanding) {
+			cheapest = candidate
+		}
+	}
+	if cheapest == nil {
+		return fastest
+	}
+	return cheapest
+}
+
+// done counts the result of the request to the instance
+func (balancer *Balancer) done(instance *balancedInstance, err error, latency time.Duration) {
+	balancer.mu.Lock()
+	change := balancer.doneLocked(instance, err, latency)
+	balancer.mu.Unlock()
+
+	balancer.notifyBreaker(change)
+}
+
+// doneLocked returns the transition of the breaker, if any.
+// Must be called with the lock.
+func (balancer *Balancer) doneLocked(instance *balancedInstance, err error, latency time.Duration) *breakerChange {
+	instance.Outstanding--
+	if balancer.breaker != nil {
+		return balancer.trip(instance, err, latency)
+	}
+	if err != nil {
+		instance.Failures++
+		instance.Healthy = false
+		return nil
+	}
+	balancer.observeLatency(instance, latency)
+	return nil
+}
+
+// trip counts the result in the circuit breaker.
+// Must be called with the lock.
+func (balancer *Balancer) trip(instance *balancedInstance, err error, latency time.Duration) *breakerChange {
+	trial := instance.trial && instance.Breaker == BreakerHalfOpen
+	if trial {
+		instance.trial = false
+	}
+	if err != nil {
+		instance.Failures++
+		instance.consecutive++
+		if trial || (instance.Breaker == BreakerClosed && instance.consecutive >= balancer.breaker.Failures) {
+			instance.openUntil = time.Now().Add(balancer.breaker.CoolDown)
+			return balancer.setBreaker(instance, BreakerOpen)
+		}
+		return nil
+	}
+
+	instance.consecutive = 0
+	balancer.observeLatency(instance, latency)
+	if trial {
+		return balancer.setBreaker(instance, BreakerClosed)
+	}
+	return nil
+}
+
+// observeLatency updates the moving average of the instance latency.
+// Must be called with the lock.
+func (balancer *Balancer) observeLatency(instance *balancedInstance, latency time.Duration) {
+	if instance.Latency == 0 {
+		instance.Latency = latency
+	} else {
+		instance.Latency += time.Duration(latencyWeight * float64(latency-instance.Latency))
+	}
+}
+
+// WithMaxLatency sets the latency limit of the CostAware strategy.
+// The slower instances are not picked while there are the faster ones. Zero means no limit
+func (balancer *Balancer) WithMaxLatency(limit time.Duration) *Balancer {
+	balancer.maxLatency = limit
+	return balancer
+}
+
+// SetCost changes the cost of the instance at runtime
+func (balancer *Balancer) SetCost(name string, cost float64) error {
+	balancer.mu.Lock()
+	defer balancer.mu.Unlock()
+
+	for _, instance := range balancer.instances {
+		if instance.Name == name {
+			instance.Cost = cost
+			return nil
+		}
+	}
+	return fmt.Errorf("instance '%s' not found", name)
+}
+
+// WithMetrics counts the requests and the latency of each instance.
+// With the circuit breakers, the breaker state of every instance is set at once.
+func (balancer *Balancer) WithMetrics(metrics *Metrics) *Balancer {
+	balancer.metrics = metrics
+	if balancer.breaker != nil {
+		for _, instance := range balancer.instances {
+			balancer.breakerGauge(instance.Name, instance.Breaker)
+		}
+	}
+	return balancer
+}
+
+// Send the request to the picked instance
+func (balancer *Balancer) Send(ctx context.Context, req *Envelope) (*Reply, error) {
+	instance, err := balancer.pick(req.Command)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	reply, err := instance.Transport.Send(ctx, req)
+	latency := time.Since(start)
+	balancer.done(instance, err, latency)
+	if balancer.metrics != nil {
+		balancer.metrics.ObserveInstance(instance.Name, err, latency)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("instance '%s' Send: %w", instance.Name, err)
+	}
+	return reply, nil
+}
+
+// MarkHealthy sets the health of the instance
+func (balancer *Balancer) MarkHealthy(name string, healthy bool) error {
+	balancer.mu.Lock()
+	defer balancer.mu.Unlock()
+
+	for _, instance := range balancer.instances {
+		if instance.Name == name {
+			instance.Healthy = healthy
+			return nil
+		}
+	}
+	return fmt.Errorf("instance '%s' not found", name)
+}
+
+// healthy returns true if the instance is not marked unhealthy
+func (balancer *Balancer) healthy(name string) bool {
+	balancer.mu.Lock()
+	defer balancer.mu.Unlock()
+
+	for _, instance := range balancer.instances {
+		if instance.Name == name {
+			return instance.Healthy
+		}
+	}
+	return false
+}
+
+// Status returns the state of the instances by their names
+func (balancer *Balancer) Status() map[string]InstanceStatus {
+	balancer.mu.Lock()
+	defer balancer.mu.Unlock()
+
+	statuses := make(map[string]InstanceStatus, len(balancer.instances))
+	for _, instance := range balancer.instances {
+		statuses[instance.Name] = instance.InstanceStatus
+	}
+	return statuses
+}
+
+// Instances returns the instances in the order they were given
+func (balancer *Balancer) Instances() []Instance {
+	balancer.mu.Lock()
+	defer balancer.mu.Unlock()
+
+	instances := make([]Instance, len(balancer.instances))
+	for i, instance := range balancer.instances {
+		instances[i] = instance.Instance
+	}
+	return instances
+}
+
+// Close the transports of all instances. Returns the first error
+func (balancer *Balancer) Close() error {
+	var first error
+	for _, instance := range balancer.Instances() {
+		if err := instance.Transport.Close(); err != nil && first == nil {
+			first = fmt.Errorf("instance '%s' Close: %w", instance.Name, err)
+		}
+	}
+	return first
+}
+
+// Handler replies to the balancer admin commands.
+// The BalancerCostCommand expects the 'instance' and 'cost' parameters.
+func (balancer *Balancer) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		switch req.Command {
+		case BalancerStatusCommand:
+			parameters, err := toParameters(balancer.Status())
+			if err != nil {
+				return Fail(fmt.Sprintf("toParameters: %v", err))
+			}
+			return Ok(parameters)
+		case BalancerCostCommand:
+			cost, ok := req.Parameters["cost"].(float64)
+			if !ok || cost < 0 {
+				return Fail("missing 'cost' parameter")
+			}
+			if err := balancer.SetCost(req.StringParam("instance"), cost); err != nil {
+				return Fail(fmt.Sprintf("balancer.SetCost: %v", err))
+			}
+			return Ok(nil)
+		default:
+			return Fail(fmt.Sprintf("unknown command '%s'", req.Command))
+		}
+	}
+}
+
+// instanceState is the runtime state of the instance that survives the restart
+type instanceState struct {
+	Healthy     bool      `json:"healthy"`
+	Breaker     string    `json:"breaker,omitempty"`
+	Consecutive int       `json:"consecutive,omitempty"`
+	OpenUntil   time.Time `json:"open_until,omitempty"`
+}
+
+// Snapshot returns the health and the circuit breakers of the instances, so they are saved by the StateStore
+func (balancer *Balancer) Snapshot() ([]byte, error) {
+	balancer.mu.Lock()
+	states := make(map[string]instanceState, len(balancer.instances))
+	for _, instance := range balancer.instances {
+		states[instance.Name] = instanceState{
+			Healthy:     instance.Healthy,
+			Breaker:     instance.Breaker,
+			Consecutive: instance.consecutive,
+			OpenUntil:   instance.openUntil,
+		}
+	}
+	balancer.mu.Unlock()
+
+	data, err := json.Marshal(states)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	return data, nil
+}
+
+// Restore the instances from the snapshot.
+// The instances missing in the balancer are skipped, so the snapshot survives the changed instances.
+// The breaker state is restored only if the circuit breakers are enabled.
+func (balancer *Balancer) Restore(data []byte) error {
+	states := make(map[string]instanceState)
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	balancer.mu.Lock()
+	defer balancer.mu.Unlock()
+
+	for _, instance := range balancer.instances {
+		state, ok := states[instance.Name]
+		if !ok {
+			continue
+		}
+		instance.Healthy = state.Healthy
+		if balancer.breaker == nil {
+			continue
+		}
+		if _, ok := breakerStates[state.Breaker]; !ok {
+			continue
+		}
+		instance.Breaker = state.Breaker
+		instance.consecutive = state.Consecutive
+		instance.openUntil = state.OpenUntil
+		instance.trial = false
+		balancer.breakerGauge(instance.Name, instance.Breaker)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// funcTransport is the destination transport of the tests
+type funcTransport func(req *Envelope) (*Reply, error)
+
+func (transport funcTransport) Send(_ context.Context, req *Envelope) (*Reply, error) {
+	return transport(req)
+}
+
+func (transport funcTransport) Close() error {
+	return nil
+}
+
+// TestBalancerSkipsUnhealthy checks that the failed instance is skipped until it's marked healthy
+func TestBalancerSkipsUnhealthy(t *testing.T) {
+	var calls [2]int32
+	instance := func(index int, err error) Instance {
+		return Instance{Name: fmt.Sprintf("i%d", index), Transport: funcTransport(func(req *Envelope) (*Reply, error) {
+			atomic.AddInt32(&calls[index], 1)
+			if err != nil {
+				return nil, err
+			}
+			return Ok(nil), nil
+		})}
+	}
+	balancer, err := NewBalancer(RoundRobin, []Instance{instance(0, fmt.Errorf("down")), instance(1, nil)})
+	if err != nil {
+		t.Fatalf("NewBalancer: %v", err)
+	}
+
+	if _, err := balancer.Send(context.Background(), NewEnvelope(&Request{Command: "get"})); err == nil {
+		t.Fatalf("the failing instance succeeded")
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := balancer.Send(context.Background(), NewEnvelope(&Request{Command: "get"})); err != nil {
+			t.Fatalf("Send after the failover: %v", err)
+		}
+	}
+	if calls[0] != 1 || calls[1] != 4 {
+		t.Fatalf("calls %v, expected the unhealthy instance to be skipped", calls)
+	}
+	if balancer.Status()["i0"].Healthy {
+		t.Fatalf("the failed instance is still healthy")
+	}
+
+	if err := balancer.MarkHealthy("i0", true); err != nil {
+		t.Fatalf("MarkHealthy: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		_, _ = balancer.Send(context.Background(), NewEnvelope(&Request{Command: "get"}))
+	}
+	if calls[0] != 2 {
+		t.Fatalf("the recovered instance got %d requests", calls[0])
+	}
+}
+
+// TestBalancerOutstandingUnderConcurrency checks that the outstanding requests are counted back to zero
+func TestBalancerOutstandingUnderConcurrency(t *testing.T) {
+	ok := funcTransport(func(req *Envelope) (*Reply, error) { return Ok(nil), nil })
+	balancer, err := NewBalancer(LeastOutstanding, []Instance{{Name: "a", Transport: ok}, {Name: "b", Transport: ok}})
+	if err != nil {
+		t.Fatalf("NewBalancer: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := balancer.Send(context.Background(), NewEnvelope(&Request{Command: "get"})); err != nil {
+				t.Errorf("Send: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	for name, status := range balancer.Status() {
+		if status.Outstanding != 0 {
+			t.Fatalf("instance '%s' has %d outstanding requests after all replied", name, status.Outstanding)
+		}
+	}
+}
+
+// TestBreakerSingleTrial checks that the half-open breaker lets only one of the concurrent requests through
+func TestBreakerSingleTrial(t *testing.T) {
+	var failing int32 = 1
+	var calls int32
+	release := make(chan struct{})
+	transport := funcTransport(func(req *Envelope) (*Reply, error) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			return nil, fmt.Errorf("down")
+		}
+		<-release
+		return Ok(nil), nil
+	})
+
+	var mu sync.Mutex
+	var transitions []string
+	balancer, err := NewBalancer(RoundRobin, []Instance{{Name: "a", Transport: transport}})
+	if err != nil {
+		t.Fatalf("NewBalancer: %v", err)
+	}
+	balancer.WithBreaker(BreakerConfig{Failures: 1, CoolDown: 10 * time.Millisecond}, func(instance string, from string, to string) {
+		mu.Lock()
+		transitions = append(transitions, from+">"+to)
+		mu.Unlock()
+	})
+
+	if _, err := balancer.Send(context.Background(), NewEnvelope(&Request{Command: "get"})); err == nil {
+		t.Fatalf("the failing instance succeeded")
+	}
+	if state := balancer.Status()["a"].Breaker; state != BreakerOpen {
+		t.Fatalf("the breaker is %s after the failure", state)
+	}
+	time.Sleep(20 * time.Millisecond)
+	atomic.StoreInt32(&failing, 0)
+	atomic.StoreInt32(&calls, 0)
+
+	var wg sync.WaitGroup
+	var rejected int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := balancer.Send(context.Background(), NewEnvelope(&Request{Command: "get"})); err != nil {
+				atomic.AddInt32(&rejected, 1)
+			}
+		}()
+	}
+	for atomic.LoadInt32(&rejected) < 9 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("the half-open breaker let %d requests through", calls)
+	}
+	if state := balancer.Status()["a"].Breaker; state != BreakerClosed {
+		t.Fatalf("the breaker is %s after the successful trial", state)
+	}
+	expected := fmt.Sprint([]string{"closed>open", "open>half-open", "half-open>closed"})
+	if fmt.Sprint(transitions) != expected {
+		t.Fatalf("transitions %v, expected %s", transitions, expected)
+	}
+}
+
+// TestBalancerSnapshotRestore checks that the open breaker survives the restart
+func TestBalancerSnapshotRestore(t *testing.T) {
+	transport := funcTransport(func(req *Envelope) (*Reply, error) {
+		return nil, fmt.Errorf("down")
+	})
+	config := BreakerConfig{Failures: 1, CoolDown: time.Minute}
+	balancer, err := NewBalancer(RoundRobin, []Instance{{Name: "a", Transport: transport}})
+	if err != nil {
+		t.Fatalf("NewBalancer: %v", err)
+	}
+	balancer.WithBreaker(config, nil)
+	_, _ = balancer.Send(context.Background(), NewEnvelope(&Request{Command: "get"}))
+
+	data, err := balancer.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	restored, err := NewBalancer(RoundRobin, []Instance{{Name: "a", Transport: transport}})
+	if err != nil {
+		t.Fatalf("NewBalancer: %v", err)
+	}
+	restored.WithBreaker(config, nil)
+	if err := restored.Restore(data); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if state := restored.Status()["a"].Breaker; state != BreakerOpen {
+		t.Fatalf("the restored breaker is %s", state)
+	}
+	if _, err := restored.Send(context.Background(), NewEnvelope(&Request{Command: "get"})); err == nil {
+		t.Fatalf("the restored open breaker let the request through")
+	}
+}
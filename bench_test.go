@@ -0,0 +1,501 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The loopback servers and the clients of the benchmarks and the transport tests
+
+// serveTCP serves the source on the loopback until the test ends
+func serveTCP(t testing.TB, source *TCPSource, handler Handler) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		_ = source.serve(ctx, listener, handler)
+		close(served)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+	return listener.Addr().String()
+}
+
+// wsClient is the minimal websocket client of the tests
+type wsClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// serveLoopback serves the http handler, for example of the websocket source, on the loopback until the test ends
+func serveLoopback(t testing.TB, handler http.Handler) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		_ = serveHTTP(ctx, listener, handler, time.Second)
+		close(served)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+	return listener.Addr().String()
+}
+
+// dialWebSocket upgrades the connection to the websocket. The origin is sent like by the browser, unless empty
+func dialWebSocket(address string, origin string) (*wsClient, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("net.Dial: %w", err)
+	}
+
+	header := ""
+	if len(origin) > 0 {
+		header = "Origin: " + origin + "\r\n"
+	}
+	_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n%s\r\n", address, header)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("write upgrade: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("http.ReadResponse: %w", err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		_ = conn.Close()
+		return nil, fmt.Errorf("upgrade status %d", response.StatusCode)
+	}
+	return &wsClient{conn: conn, reader: reader}, nil
+}
+
+// write the json as the masked text frame
+func (client *wsClient) write(message interface{}) error {
+	return client.writeCodec(jsonCodec{}, wsText, message)
+}
+
+// writeCodec writes the message encoded by the codec as the masked frame of the opcode
+func (client *wsClient) writeCodec(codec Codec, opcode byte, message interface{}) error {
+	payload, err := codec.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("%s.Marshal: %w", codec.Name(), err)
+	}
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err = client.conn.Write(frame)
+	return err
+}
+
+// read the json of the next unmasked frame
+func (client *wsClient) read(message interface{}) error {
+	_, err := client.readCodec(jsonCodec{}, message)
+	return err
+}
+
+// readCodec reads the next unmasked frame encoded by the codec, and returns its opcode
+func (client *wsClient) readCodec(codec Codec, message interface{}) (byte, error) {
+	_ = client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head [2]byte
+	if _, err := io.ReadFull(client.reader, head[:]); err != nil {
+		return 0, fmt.Errorf("read frame: %w", err)
+	}
+	length := int(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(client.reader, extended[:]); err != nil {
+			return 0, fmt.Errorf("read length: %w", err)
+		}
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(client.reader, extended[:]); err != nil {
+			return 0, fmt.Errorf("read length: %w", err)
+		}
+		length = int(binary.BigEndian.Uint64(extended[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(client.reader, payload); err != nil {
+		return 0, fmt.Errorf("read payload: %w", err)
+	}
+	if err := codec.Unmarshal(payload, message); err != nil {
+		return 0, fmt.Errorf("%s.Unmarshal: %w", codec.Name(), err)
+	}
+	return head[0] & 0x0F, nil
+}
+
+// The benchmarks forward the request through every transport with every middleware combination.
+// Compare two runs to catch the regressions:
+//
+//	go test -run '^$' -bench Forward -benchmem -count 5 > old.txt
+//	go test -run '^$' -bench Forward -benchmem -count 5 > new.txt
+//	BENCH_OLD=old.txt BENCH_NEW=new.txt BENCH_THRESHOLD=0.1 go test -run TestBenchmarkRegression
+
+// DefaultBenchThreshold is the slowdown of ns/op that fails the comparison, 0.1 is 10%
+const DefaultBenchThreshold = 0.1
+
+// benchSend forwards the request and returns the reply
+type benchSend func(req *Request) (*Reply, error)
+
+// benchTransport serves the handler and returns the dial of the client.
+// The dial is called by each parallel goroutine, so the connection-oriented clients get their own connection.
+type benchTransport struct {
+	name  string
+	serve func(b *testing.B, handler Handler) func() (benchSend, error)
+}
+
+var benchTransports = []benchTransport{
+	{name: "handler", serve: func(b *testing.B, handler Handler) func() (benchSend, error) {
+		return func() (benchSend, error) {
+			return func(req *Request) (*Reply, error) {
+				return handler(NewEnvelope(req)), nil
+			}, nil
+		}
+	}},
+	{name: "memory", serve: func(b *testing.B, handler Handler) func() (benchSend, error) {
+		transport := NewMemoryTransport(1024)
+		served := make(chan struct{})
+		go func() {
+			_ = transport.Serve(context.Background(), handler)
+			close(served)
+		}()
+		b.Cleanup(func() {
+			_ = transport.Close()
+			<-served
+		})
+		return func() (benchSend, error) {
+			return func(req *Request) (*Reply, error) {
+				return transport.Send(context.Background(), NewEnvelope(req))
+			}, nil
+		}
+	}},
+	{name: "tcp", serve: func(b *testing.B, handler Handler) func() (benchSend, error) {
+		source, err := NewTCPSource(TCPSourceConfig{Port: 1, MaxInFlight: 1024})
+		if err != nil {
+			b.Fatalf("NewTCPSource: %v", err)
+		}
+		destination := NewTCPDestination(serveTCP(b, source, handler))
+		b.Cleanup(func() { _ = destination.Close() })
+		return func() (benchSend, error) {
+			return func(req *Request) (*Reply, error) {
+				return destination.Send(context.Background(), NewEnvelope(req))
+			}, nil
+		}
+	}},
+	{name: "http", serve: func(b *testing.B, handler Handler) func() (benchSend, error) {
+		source, err := NewHTTPSource(HTTPSourceConfig{Port: 1})
+		if err != nil {
+			b.Fatalf("NewHTTPSource: %v", err)
+		}
+		url := "http://" + serveLoopback(b, source.HTTPHandler(handler)) + "/"
+		client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 1024}}
+		b.Cleanup(client.CloseIdleConnections)
+		return func() (benchSend, error) {
+			return func(req *Request) (*Reply, error) {
+				body, err := json.Marshal(req.Parameters)
+				if err != nil {
+					return nil, fmt.Errorf("json.Marshal: %w", err)
+				}
+				response, err := client.Post(url+req.Command, "application/json", bytes.NewReader(body))
+				if err != nil {
+					return nil, fmt.Errorf("client.Post: %w", err)
+				}
+				defer response.Body.Close()
+				var reply Reply
+				if err := json.NewDecoder(response.Body).Decode(&reply); err != nil {
+					return nil, fmt.Errorf("json.Decode: %w", err)
+				}
+				return &reply, nil
+			}, nil
+		}
+	}},
+	{name: "websocket", serve: func(b *testing.B, handler Handler) func() (benchSend, error) {
+		source, err := NewWebSocketSource(WebSocketSourceConfig{Port: 1}, nil)
+		if err != nil {
+			b.Fatalf("NewWebSocketSource: %v", err)
+		}
+		address := serveLoopback(b, source.HTTPHandler(handler))
+		return func() (benchSend, error) {
+			client, err := dialWebSocket(address, "")
+			if err != nil {
+				return nil, fmt.Errorf("dialWebSocket: %w", err)
+			}
+			b.Cleanup(func() { _ = client.conn.Close() })
+			return func(req *Request) (*Reply, error) {
+				if err := client.write(req); err != nil {
+					return nil, err
+				}
+				var reply Reply
+				if err := client.read(&reply); err != nil {
+					return nil, err
+				}
+				return &reply, nil
+			}, nil
+		}
+	}},
+}
+
+// benchPipeline is the middleware combination in front of the destination
+type benchPipeline struct {
+	name        string
+	middlewares func(b *testing.B) []Middleware
+}
+
+// benchKey is the api key of the requests, accepted by the auth middleware
+const benchKey = "bench-key"
+
+func benchAuth(b *testing.B) Middleware {
+	keys, err := NewStaticKeys(map[string]string{benchKey: "bench"})
+	if err != nil {
+		b.Fatalf("NewStaticKeys: %v", err)
+	}
+	return WithAuth(keys)
+}
+
+// benchRateLimit never rejects, so only the cost of the buckets is measured
+func benchRateLimit() Middleware {
+	return NewRateLimiter(RateLimitConfig{Global: 1e9, Client: 1e9}).Middleware()
+}
+
+var benchPipelines = []benchPipeline{
+	{name: "plain", middlewares: func(b *testing.B) []Middleware {
+		return nil
+	}},
+	{name: "auth", middlewares: func(b *testing.B) []Middleware {
+		return []Middleware{benchAuth(b)}
+	}},
+	{name: "auth+ratelimit", middlewares: func(b *testing.B) []Middleware {
+		return []Middleware{benchAuth(b), benchRateLimit()}
+	}},
+	{name: "tracing+metrics", middlewares: func(b *testing.B) []Middleware {
+		return []Middleware{NewMetrics().Middleware(), Tracing(nil, "bench")}
+	}},
+	{name: "full", middlewares: func(b *testing.B) []Middleware {
+		return []Middleware{NewMetrics().Middleware(), Tracing(nil, "bench"), benchAuth(b), benchRateLimit()}
+	}},
+}
+
+// benchDestination echoes the parameters, like the destination that returns a small object
+func benchDestination(req *Envelope) *Reply {
+	return Ok(map[string]interface{}{"id": req.Parameters["id"], "name": "alice"})
+}
+
+func benchRequest() *Request {
+	return &Request{Command: "get-user", Parameters: map[string]interface{}{"id": "42", AuthParam: benchKey}}
+}
+
+// benchForward runs the benchmark for every transport and pipeline
+func benchForward(b *testing.B, run func(b *testing.B, dial func() (benchSend, error))) {
+	for _, transport := range benchTransports {
+		for _, pipeline := range benchPipelines {
+			transport, pipeline := transport, pipeline
+			b.Run(transport.name+"/"+pipeline.name, func(b *testing.B) {
+				handler := Wrap(benchDestination, pipeline.middlewares(b)...)
+				run(b, transport.serve(b, handler))
+			})
+		}
+	}
+}
+
+// BenchmarkForwardLatency sends the requests one by one
+func BenchmarkForwardLatency(b *testing.B) {
+	benchForward(b, func(b *testing.B, dial func() (benchSend, error)) {
+		send, err := dial()
+		if err != nil {
+			b.Fatalf("dial: %v", err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			reply, err := send(benchRequest())
+			if err != nil {
+				b.Fatalf("send: %v", err)
+			}
+			if !reply.IsOK() {
+				b.Fatalf("reply failed: %s", reply.Message)
+			}
+		}
+	})
+}
+
+// BenchmarkForwardThroughput sends the requests in parallel
+func BenchmarkForwardThroughput(b *testing.B) {
+	benchForward(b, func(b *testing.B, dial func() (benchSend, error)) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			send, err := dial()
+			if err != nil {
+				b.Errorf("dial: %v", err)
+				return
+			}
+			for pb.Next() {
+				reply, err := send(benchRequest())
+				if err != nil {
+					b.Errorf("send: %v", err)
+					return
+				}
+				if !reply.IsOK() {
+					b.Errorf("reply failed: %s", reply.Message)
+					return
+				}
+			}
+		})
+	})
+}
+
+// parseBenchmarks returns the average ns/op of each benchmark in the go test output.
+// The GOMAXPROCS suffix is dropped, so the runs on the different machines are still comparable by name.
+func parseBenchmarks(reader io.Reader) (map[string]float64, error) {
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		for i := 2; i+1 < len(fields); i++ {
+			if fields[i+1] != "ns/op" {
+				continue
+			}
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("strconv.ParseFloat('%s'): %w", fields[i], err)
+			}
+			name := fields[0]
+			if dash := strings.LastIndex(name, "-"); dash > 0 {
+				if _, err := strconv.Atoi(name[dash+1:]); err == nil {
+					name = name[:dash]
+				}
+			}
+			sums[name] += value
+			counts[name]++
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner.Err: %w", err)
+	}
+
+	averages := make(map[string]float64, len(sums))
+	for name, sum := range sums {
+		averages[name] = sum / float64(counts[name])
+	}
+	return averages, nil
+}
+
+// compareBenchmarks returns the benchmarks that are slower than the old run over the threshold.
+// The benchmarks missing in either run are skipped.
+func compareBenchmarks(old map[string]float64, new map[string]float64, threshold float64) []string {
+	regressions := make([]string, 0)
+	for name, before := range old {
+		after, ok := new[name]
+		if !ok || before <= 0 {
+			continue
+		}
+		if change := (after - before) / before; change > threshold {
+			regressions = append(regressions, fmt.Sprintf("%s: %.0f ns/op -> %.0f ns/op (+%.1f%%)", name, before, after, change*100))
+		}
+	}
+	sort.Strings(regressions)
+	return regressions
+}
+
+// readBenchmarks parses the go test output in the file
+func readBenchmarks(path string) (map[string]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open('%s'): %w", path, err)
+	}
+	defer file.Close()
+	return parseBenchmarks(file)
+}
+
+// TestBenchmarkRegression compares the benchmark runs in BENCH_OLD and BENCH_NEW files.
+// BENCH_THRESHOLD is the allowed slowdown, DefaultBenchThreshold by default.
+func TestBenchmarkRegression(t *testing.T) {
+	oldPath, newPath := os.Getenv("BENCH_OLD"), os.Getenv("BENCH_NEW")
+	if len(oldPath) == 0 || len(newPath) == 0 {
+		t.Skip("set BENCH_OLD and BENCH_NEW to compare the benchmark runs")
+	}
+	threshold := DefaultBenchThreshold
+	if value := os.Getenv("BENCH_THRESHOLD"); len(value) > 0 {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("BENCH_THRESHOLD: %v", err)
+		}
+		threshold = parsed
+	}
+
+	old, err := readBenchmarks(oldPath)
+	if err != nil {
+		t.Fatalf("readBenchmarks: %v", err)
+	}
+	new, err := readBenchmarks(newPath)
+	if err != nil {
+		t.Fatalf("readBenchmarks: %v", err)
+	}
+	for _, regression := range compareBenchmarks(old, new, threshold) {
+		t.Error(regression)
+	}
+}
+
+func TestCompareBenchmarks(t *testing.T) {
+	old, err := parseBenchmarks(strings.NewReader(`goos: linux
+BenchmarkForwardLatency/tcp/plain-8   	   50000	     20000 ns/op	    4000 B/op	      60 allocs/op
+BenchmarkForwardLatency/tcp/plain-8   	   50000	     22000 ns/op	    4000 B/op	      60 allocs/op
+BenchmarkForwardLatency/http/plain-8  	   20000	     50000 ns/op
+PASS`))
+	if err != nil {
+		t.Fatalf("parseBenchmarks: %v", err)
+	}
+	if old["BenchmarkForwardLatency/tcp/plain"] != 21000 {
+		t.Fatalf("the runs are not averaged: %v", old)
+	}
+
+	new, err := parseBenchmarks(strings.NewReader(`BenchmarkForwardLatency/tcp/plain-4   	   50000	     25000 ns/op
+BenchmarkForwardLatency/http/plain-4  	   20000	     51000 ns/op`))
+	if err != nil {
+		t.Fatalf("parseBenchmarks: %v", err)
+	}
+	regressions := compareBenchmarks(old, new, DefaultBenchThreshold)
+	if len(regressions) != 1 || !strings.HasPrefix(regressions[0], "BenchmarkForwardLatency/tcp/plain:") {
+		t.Fatalf("regressions %v, expected only the tcp one", regressions)
+	}
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// FrameHeaderSize is the length of the binary frame header in bytes:
+// the version, flags, priority, reserved byte and the command id.
+const FrameHeaderSize = 8
+
+// FrameVersion is the version of the binary frame header
+const FrameVersion byte = 1
+
+// PayloadParam is the request parameter with the opaque payload of the binary frame
+const PayloadParam = "payload"
+
+// BinaryParam marks the PayloadParam as the payload of the binary frame.
+// After the json hop, for example the journal, the payload bytes are the base64 string,
+// and only the marked string is decoded, so the text payload of the json request is never mistaken for it.
+const BinaryParam = "payload_binary"
+
+// The flags of the binary frame header
+const (
+	// FlagAck requires the at-least-once delivery, like Envelope.Ack
+	FlagAck byte = 1 << iota
+)
+
+// FrameHeader is the only part of the binary frame parsed by the proxy
+type FrameHeader struct {
+	CommandId uint32
+	Flags     byte
+	Priority  int8
+}
+
+// Frame is the binary message with the compact header and the opaque payload.
+// The proxy routes it by the header, without parsing the payload.
+type Frame struct {
+	FrameHeader
+	Payload []byte
+}
+
+// DecodeFrame parses the header. The payload shares the memory with the data
+func DecodeFrame(data []byte) (*Frame, error) {
+	if len(data) < FrameHeaderSize {
+		return nil, fmt.Errorf("frame is %d bytes, header requires %d", len(data), FrameHeaderSize)
+	}
+	if data[0] != FrameVersion {
+		return nil, fmt.Errorf("unsupported frame version %d", data[0])
+	}
+	return &Frame{
+		FrameHeader: FrameHeader{
+			Flags:     data[1],
+			Priority:  int8(data[2]),
+			CommandId: binary.BigEndian.Uint32(data[4:FrameHeaderSize]),
+		},
+		Payload: data[FrameHeaderSize:],
+	}, nil
+}
+
+// Encode the frame with the header
+func (frame *Frame) Encode() []byte {
+	data := make([]byte, FrameHeaderSize+len(frame.Payload))
+	data[0] = FrameVersion
+	data[1] = frame.Flags
+	data[2] = byte(frame.Priority)
+	binary.BigEndian.PutUint32(data[4:FrameHeaderSize], frame.CommandId)
+	copy(data[FrameHeaderSize:], frame.Payload)
+	return data
+}
+
+// CommandRegistry maps the command ids of the binary frames to the command names.
+// So the routing, metrics and other middlewares work with the binary frames by the command names.
+type CommandRegistry struct {
+	mu    sync.RWMutex
+	names map[uint32]string
+	ids   map[string]uint32
+}
+
+// NewCommandRegistry returns the registry of the commands by their ids
+func NewCommandRegistry(commands map[uint32]string) (*CommandRegistry, error) {
+	registry := &CommandRegistry{
+		names: make(map[uint32]string, len(commands)),
+		ids:   make(map[string]uint32, len(commands)),
+	}
+	for id, name := range commands {
+		if err := registry.Register(id, name); err != nil {
+			return nil, fmt.Errorf("registry.Register: %w", err)
+		}
+	}
+	return registry, nil
+}
+
+// Register the command. Neither the id, nor the name could be registered twice
+func (registry *CommandRegistry) Register(id uint32, name string) error {
+	if len(name) == 0 {
+		return fmt.Errorf("command %d has no name", id)
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if registered, ok := registry.names[id]; ok {
+		return fmt.Errorf("command id %d registered as '%s'", id, registered)
+	}
+	if registered, ok := registry.ids[name]; ok {
+		return fmt.Errorf("command '%s' registered with id %d", name, registered)
+	}
+	registry.names[id] = name
+	registry.ids[name] = id
+	return nil
+}
+
+// Name returns the command name by the id
+func (registry *CommandRegistry) Name(id uint32) (string, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	name, ok := registry.names[id]
+	return name, ok
+}
+
+// Id returns the command id by the name
+func (registry *CommandRegistry) Id(name string) (uint32, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	id, ok := registry.ids[name]
+	return id, ok
+}
+
+// Envelope returns the envelope of the frame with the command name and the header metadata.
+// The payload is kept as bytes in the PayloadParam, it's never decoded.
+func (registry *CommandRegistry) Envelope(frame *Frame) (*Envelope, error) {
+	name, ok := registry.Name(frame.CommandId)
+	if !ok {
+		return nil, fmt.Errorf("command id %d not registered", frame.CommandId)
+	}
+
+	envelope := NewEnvelope(&Request{
+		Command:    name,
+		Parameters: map[string]interface{}{PayloadParam: frame.Payload, BinaryParam: true},
+	})
+	envelope.Priority = int(frame.Priority)
+	envelope.Ack = frame.Flags&FlagAck != 0
+	return envelope, nil
+}
+
+// framePayload returns the opaque payload of the parameters.
+// The frame has only the payload, so the parameters with anything else are not the frame.
+// After the json hop, for example the journal, the bytes are the base64 string marked by the BinaryParam.
+func framePayload(parameters map[string]interface{}) ([]byte, error) {
+	for name := range parameters {
+		if name != PayloadParam && name != BinaryParam {
+			return nil, fmt.Errorf("'%s' parameter doesn't fit the frame", name)
+		}
+	}
+	switch payload := parameters[PayloadParam].(type) {
+	case []byte:
+		return payload, nil
+	case string:
+		if marked, _ := parameters[BinaryParam].(bool); !marked {
+			return nil, fmt.Errorf("'%s' parameter is not marked as binary", PayloadParam)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, fmt.Errorf("base64 '%s' parameter: %w", PayloadParam, err)
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("no binary '%s' parameter", PayloadParam)
+	}
+}
+
+// Frame returns the binary frame of the envelope created by Envelope
+func (registry *CommandRegistry) Frame(envelope *Envelope) (*Frame, error) {
+	id, ok := registry.Id(envelope.Command)
+	if !ok {
+		return nil, fmt.Errorf("command '%s' not registered", envelope.Command)
+	}
+	payload, err := framePayload(envelope.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.Priority < -128 || envelope.Priority > 127 {
+		return nil, fmt.Errorf("priority %d doesn't fit the header", envelope.Priority)
+	}
+
+	frame := &Frame{
+		FrameHeader: FrameHeader{CommandId: id, Priority: int8(envelope.Priority)},
+		Payload:     payload,
+	}
+	if envelope.Ack {
+		frame.Flags |= FlagAck
+	}
+	return frame, nil
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// TestFramePayloadMarker checks that only the marked string is decoded as the payload,
+// and the parameters that don't fit the frame are not dropped
+func TestFramePayloadMarker(t *testing.T) {
+	if _, err := framePayload(map[string]interface{}{PayloadParam: "aGVsbG8="}); err == nil {
+		t.Fatalf("the text payload was decoded as base64")
+	}
+	payload, err := framePayload(map[string]interface{}{PayloadParam: "aGVsbG8=", BinaryParam: true})
+	if err != nil || string(payload) != "hello" {
+		t.Fatalf("the marked payload is '%s': %v", payload, err)
+	}
+	if _, err := framePayload(map[string]interface{}{PayloadParam: []byte("hello"), "user": "alice"}); err == nil {
+		t.Fatalf("the parameters out of the frame were dropped")
+	}
+}
+
+// TestFrameSurvivesJsonHop checks that the frame envelope is the same frame after the json encoding
+func TestFrameSurvivesJsonHop(t *testing.T) {
+	registry, err := NewCommandRegistry(map[uint32]string{7: "upload"})
+	if err != nil {
+		t.Fatalf("NewCommandRegistry: %v", err)
+	}
+	frame := &Frame{FrameHeader: FrameHeader{CommandId: 7, Priority: 2, Flags: FlagAck}, Payload: []byte{0, 1, 2, 255}}
+	decoded, err := DecodeFrame(frame.Encode())
+	if err != nil {
+		t.Fatalf("DecodeFrame: %v", err)
+	}
+	envelope, err := registry.Envelope(decoded)
+	if err != nil {
+		t.Fatalf("registry.Envelope: %v", err)
+	}
+	data, err := envelope.Encode(LatestEnvelopeVersion)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	hopped, err := DecodeEnvelope(data)
+	if err != nil {
+		t.Fatalf("DecodeEnvelope: %v", err)
+	}
+	again, err := registry.Frame(hopped)
+	if err != nil {
+		t.Fatalf("registry.Frame: %v", err)
+	}
+	if !bytes.Equal(again.Payload, frame.Payload) || again.FrameHeader != frame.FrameHeader {
+		t.Fatalf("the frame %+v became %+v", frame, again)
+	}
+}
+
+// TestTCPFrameReplies checks that the payload reply comes back as the frame, and the other reply keeps its parameters
+func TestTCPFrameReplies(t *testing.T) {
+	registry, err := NewCommandRegistry(map[uint32]string{1: "echo", 2: "describe"})
+	if err != nil {
+		t.Fatalf("NewCommandRegistry: %v", err)
+	}
+	source, err := NewTCPSource(TCPSourceConfig{Port: 1})
+	if err != nil {
+		t.Fatalf("NewTCPSource: %v", err)
+	}
+	address := serveTCP(t, source.WithFrames(registry), func(req *Envelope) *Reply {
+		payload, err := framePayload(req.Parameters)
+		if err != nil {
+			return Fail(err.Error())
+		}
+		if req.Command == "describe" {
+			return Ok(map[string]interface{}{PayloadParam: payload, "size": len(payload)})
+		}
+		return Ok(map[string]interface{}{PayloadParam: payload})
+	})
+	destination := NewTCPDestination(address).WithFrames(registry)
+	defer destination.Close()
+
+	send := func(command string) *Reply {
+		req := NewEnvelope(&Request{Command: command, Parameters: map[string]interface{}{PayloadParam: []byte("data")}})
+		reply, err := destination.Send(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if !reply.IsOK() {
+			t.Fatalf("'%s' failed: %s", command, reply.Message)
+		}
+		return reply
+	}
+	if payload, _ := send("echo").Parameters[PayloadParam].([]byte); string(payload) != "data" {
+		t.Fatalf("the frame reply has the payload '%s'", payload)
+	}
+	if size := send("describe").Parameters["size"]; size != float64(4) {
+		t.Fatalf("the reply lost the parameter 'size': %v", size)
+	}
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SwitchCommand switches the routes from one destination to another.
+// The parameters are 'from', 'to' and the optional 'groups' list.
+const SwitchCommand = "proxy.switch"
+
+// BlueGreen switches the routes between the destination sets atomically.
+// After the switch, the error rate of the new destination is watched,
+// and if it exceeds the threshold within the window, then the switch is rolled back.
+type BlueGreen struct {
+	// Threshold is the error rate from 0 to 1 that triggers the rollback
+	Threshold float64
+	// Window is the time to watch the new destination
+	Window time.Duration
+	// MinRequests before the error rate is considered
+	MinRequests uint64
+	// OnRollback is called when the switch is rolled back
+	OnRollback func(from string, to string, errorRate float64)
+
+	table  *RouteTable
+	mu     sync.Mutex
+	active *blueGreenSwitch
+}
+
+type blueGreenSwitch struct {
+	from     string
+	to       string
+	groups   []string
+	defaults bool
+	total    uint64
+	failed   uint64
+	timer    *time.Timer
+}
+
+// NewBlueGreen returns the switcher of the routes in the table
+func NewBlueGreen(table *RouteTable, threshold float64, window time.Duration) *BlueGreen {
+	return &BlueGreen{
+		Threshold:   threshold,
+		Window:      window,
+		MinRequests: 10,
+		table:       table,
+	}
+}
+
+// move sets the destination of the groups, or all groups if the list is nil,
+// and the default policy if defaults is true.
+// Only the routes with the 'from' destination are changed.
+// The routes are swapped at once, so either all of them are moved or none.
+// Returns the names of the changed groups and whether the default policy was changed.
+func (blueGreen *BlueGreen) move(from string, to string, groups []string, defaults bool) ([]string, bool, error) {
+	var changed []string
+	moved := false
+	err := blueGreen.table.Update(func(policy *RoutePolicy, current []RouteGroup) error {
+		changed, moved = nil, false
+		for i := range current {
+			if current[i].Destination != from || (groups != nil && !contains(groups, current[i].Name)) {
+				continue
+			}
+			current[i].Destination = to
+			changed = append(changed, current[i].Name)
+		}
+		if defaults && policy.Destination == from {
+			policy.Destination = to
+			moved = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("table.Update: %w", err)
+	}
+	return changed, moved, nil
+}
+
+// Switch the routes from the destination to another.
+// If the groups are not given, then all routes including the default policy are switched.
+func (blueGreen *BlueGreen) Switch(from string, to string, groups ...string) error {
+	blueGreen.mu.Lock()
+	defer blueGreen.mu.Unlock()
+
+	if blueGreen.active != nil {
+		return fmt.Errorf("switch from '%s' to '%s' is being watched", blueGreen.active.from, blueGreen.active.to)
+	}
+
+	all := len(groups) == 0
+	if all {
+		groups = nil
+	}
+	changed, defaults, err := blueGreen.move(from, to, groups, all)
+	if err != nil {
+		return fmt.Errorf("move: %w", err)
+	}
+	if len(changed) == 0 && !defaults {
+		return fmt.Errorf("no routes to '%s'", from)
+	}
+
+	active := &blueGreenSwitch{from: from, to: to, groups: changed, defaults: defaults}
+	active.timer = time.AfterFunc(blueGreen.Window, func() {
+		blueGreen.mu.Lock()
+		if blueGreen.active == active {
+			blueGreen.active = nil
+		}
+		blueGreen.mu.Unlock()
+	})
+	blueGreen.active = active
+	return nil
+}
+
+// Watching returns true if the last switch is in the watch window
+func (blueGreen *BlueGreen) Watching() bool {
+	blueGreen.mu.Lock()
+	defer blueGreen.mu.Unlock()
+
+	return blueGreen.active != nil
+}
+
+// observe counts the reply of the new destination, and rolls back if the error rate is too high
+func (blueGreen *BlueGreen) observe(destination string, reply *Reply) {
+	blueGreen.mu.Lock()
+	active := blueGreen.active
+	if active == nil || active.to != destination {
+		blueGreen.mu.Unlock()
+		return
+	}
+
+	active.total++
+	if !reply.IsOK() {
+		active.failed++
+	}
+	rate := float64(active.failed) / float64(active.total)
+	if active.total < blueGreen.MinRequests || rate <= blueGreen.Threshold {
+		blueGreen.mu.Unlock()
+		return
+	}
+
+	active.timer.Stop()
+	blueGreen.active = nil
+	_, _, _ = blueGreen.move(active.to, active.from, append([]string{}, active.groups...), active.defaults)
+	blueGreen.mu.Unlock()
+
+	if blueGreen.OnRollback != nil {
+		blueGreen.OnRollback(active.from, active.to, rate)
+	}
+}
+
+// Middleware watches the replies of the switched routes
+func (blueGreen *BlueGreen) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			policy, _ := blueGreen.table.Routes().Policy(req.Command)
+			reply := next(req)
+			blueGreen.observe(policy.Destination, reply)
+			return reply
+		}
+	}
+}
+
+// Handler replies to the SwitchCommand
+func (blueGreen *BlueGreen) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		from, to := req.StringParam("from"), req.StringParam("to")
+		if len(from) == 0 || len(to) == 0 {
+			return Fail("missing 'from' or 'to' parameter")
+		}
+		var groups []string
+		if raw, ok := req.Parameters["groups"].([]interface{}); ok {
+			for _, group := range raw {
+				groups = append(groups, fmt.Sprint(group))
+			}
+		}
+		if err := blueGreen.Switch(from, to, groups...); err != nil {
+			return Fail(fmt.Sprintf("blueGreen.Switch: %v", err))
+		}
+		return Ok(nil)
+	}
+}
+
+// contains returns true if the list has the item
+func contains(list []string, item string) bool {
+	for _, listed := range list {
+		if listed == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// destinations returns the distinct destinations of the routes, including the default one
+func destinations(routes *Routes) map[string]struct{} {
+	found := map[string]struct{}{routes.Default.Destination: {}}
+	for _, group := range routes.Groups() {
+		found[group.Destination] = struct{}{}
+	}
+	return found
+}
+
+// TestBlueGreenSwitchIsAtomic checks that the readers never see the half switched routes
+func TestBlueGreenSwitchIsAtomic(t *testing.T) {
+	groups := make([]RouteGroup, 0, 20)
+	for i := 0; i < 20; i++ {
+		groups = append(groups, RouteGroup{
+			Name:        fmt.Sprintf("group-%d", i),
+			Commands:    []string{fmt.Sprintf("command-%d", i)},
+			RoutePolicy: RoutePolicy{Destination: "blue"},
+		})
+	}
+	routes, err := NewRoutes(RoutePolicy{Destination: "blue"}, groups)
+	if err != nil {
+		t.Fatalf("NewRoutes: %v", err)
+	}
+	table := NewRouteTable(routes, "")
+	blueGreen := NewBlueGreen(table, 0.5, time.Millisecond)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if found := destinations(table.Routes()); len(found) != 1 {
+					t.Errorf("half switched routes: %v", found)
+					return
+				}
+			}
+		}()
+	}
+
+	from, to := "blue", "green"
+	for i := 0; i < 10; i++ {
+		for blueGreen.Watching() {
+			time.Sleep(time.Millisecond)
+		}
+		if err := blueGreen.Switch(from, to); err != nil {
+			t.Fatalf("Switch: %v", err)
+		}
+		from, to = to, from
+	}
+}
+
+// TestBlueGreenRollback checks that the failing new destination is switched back
+func TestBlueGreenRollback(t *testing.T) {
+	routes, err := NewRoutes(RoutePolicy{Destination: "blue"}, nil)
+	if err != nil {
+		t.Fatalf("NewRoutes: %v", err)
+	}
+	table := NewRouteTable(routes, "")
+	blueGreen := NewBlueGreen(table, 0.5, time.Minute)
+	var rolledBack string
+	blueGreen.OnRollback = func(from string, to string, errorRate float64) {
+		rolledBack = fmt.Sprintf("%s>%s at %.1f", to, from, errorRate)
+	}
+	handler := Wrap(func(req *Envelope) *Reply {
+		if table.Routes().Default.Destination == "green" {
+			return Fail("green is broken")
+		}
+		return Ok(nil)
+	}, blueGreen.Middleware())
+
+	if err := blueGreen.Switch("blue", "green"); err != nil {
+		t.Fatalf("Switch: %v", err)
+	}
+	if err := blueGreen.Switch("blue", "green"); err == nil {
+		t.Fatalf("the second switch started while the first one is watched")
+	}
+	for i := uint64(0); i < blueGreen.MinRequests; i++ {
+		handler(NewEnvelope(&Request{Command: "get"}))
+	}
+
+	if destination := table.Routes().Default.Destination; destination != "blue" {
+		t.Fatalf("the failing switch was not rolled back, routes go to '%s'", destination)
+	}
+	if rolledBack != "green>blue at 1.0" {
+		t.Fatalf("rollback reported '%s'", rolledBack)
+	}
+	if blueGreen.Watching() {
+		t.Fatalf("the rolled back switch is still watched")
+	}
+}
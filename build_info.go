@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// InfoCommand returns the build information of the proxy
+const InfoCommand = "proxy.info"
+
+// modulePath of this library in the build information
+const modulePath = "github.com/ahmetson/proxy-lib"
+
+// Version of the proxy. The build information is used if it's not set with
+//
+//	-ldflags "-X github.com/ahmetson/proxy-lib.Version=v1.2.3"
+var Version = ""
+
+// BuildInfo is the version of the binary and the library
+type BuildInfo struct {
+	// Main is the module of the binary
+	Main string `json:"main"`
+	// Version of the proxy-lib used by the binary
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// ReadBuildInfo returns the build information embedded into the binary
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{Version: Version, GoVersion: runtime.Version()}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	info.Main = build.Main.Path
+	if len(info.Version) == 0 {
+		if build.Main.Path == modulePath {
+			info.Version = build.Main.Version
+		}
+		for _, dep := range build.Deps {
+			if dep.Path == modulePath {
+				info.Version = dep.Version
+				if dep.Replace != nil {
+					info.Version = dep.Replace.Version
+				}
+				break
+			}
+		}
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.time":
+			info.BuildTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+
+	return info
+}
+
+// String returns the build information for the startup log
+func (info BuildInfo) String() string {
+	text := fmt.Sprintf("%s proxy-lib %s (%s)", info.Main, info.Version, info.GoVersion)
+	if len(info.Commit) > 0 {
+		text += fmt.Sprintf(" commit %s", info.Commit)
+		if info.Modified {
+			text += "-dirty"
+		}
+	}
+	if len(info.BuildTime) > 0 {
+		text += fmt.Sprintf(" built %s", info.BuildTime)
+	}
+	return text
+}
+
+// InfoHandler replies to the InfoCommand
+func InfoHandler() Handler {
+	info := ReadBuildInfo()
+	return func(req *Envelope) *Reply {
+		parameters, err := toParameters(info)
+		if err != nil {
+			return Fail(fmt.Sprintf("toParameters: %v", err))
+		}
+		return Ok(parameters)
+	}
+}
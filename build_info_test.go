@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"runtime"
+	"testing"
+)
+
+// TestReadBuildInfoVersion checks that the version set with the ldflags overrides the build information
+func TestReadBuildInfoVersion(t *testing.T) {
+	defer func(version string) { Version = version }(Version)
+	Version = "v1.2.3"
+
+	info := ReadBuildInfo()
+	if info.Version != "v1.2.3" || info.GoVersion != runtime.Version() {
+		t.Fatalf("the build info is %+v", info)
+	}
+
+	reply := InfoHandler()(policyRequest(InfoCommand, nil))
+	if !reply.IsOK() || reply.Parameters["version"] != "v1.2.3" {
+		t.Fatalf("the info command replied %v", reply)
+	}
+}
+
+// TestBuildInfoString checks the startup line of the build information
+func TestBuildInfoString(t *testing.T) {
+	info := BuildInfo{Main: "example.com/proxy", Version: "v1.2.3", GoVersion: "go1.19", Commit: "abc", Modified: true, BuildTime: "2023-09-01T00:00:00Z"}
+	expected := "example.com/proxy proxy-lib v1.2.3 (go1.19) commit abc-dirty built 2023-09-01T00:00:00Z"
+	if text := info.String(); text != expected {
+		t.Fatalf("the build info is '%s', expected '%s'", text, expected)
+	}
+}
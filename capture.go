@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CaptureCommand starts the traffic capture.
+// The 'seconds' parameter is the duration of the capture.
+const CaptureCommand = "proxy.capture"
+
+// CaptureRecord is the proxied request and its reply
+type CaptureRecord struct {
+	Time    time.Time     `json:"time"`
+	Latency time.Duration `json:"latency"`
+	// Tenant of the request, if the capture has the tenants
+	Tenant  string    `json:"tenant,omitempty"`
+	Request *Envelope `json:"request"`
+	Reply   *Reply    `json:"reply"`
+}
+
+// Capture writes the proxied traffic into the files for the offline analysis.
+// Each capture is a new file with a record per line.
+type Capture struct {
+	dir      string
+	scrubber *Scrubber
+	tenants  *Tenants
+
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	timer   *time.Timer
+}
+
+// NewCapture returns the capture that writes the files into the directory.
+// The secrets are removed from the captured parameters and reply messages by the scrubber.
+// If the scrubber is nil, then the parameters are captured as is.
+func NewCapture(dir string, scrubber *Scrubber) *Capture {
+	return &Capture{dir: dir, scrubber: scrubber}
+}
+
+// WithTenants records the tenant of each request.
+// Put the middleware after WithAuth, so the tenant is the authenticated principal.
+func (capture *Capture) WithTenants(tenants *Tenants) *Capture {
+	capture.tenants = tenants
+	return capture
+}
+
+// Start capturing for the duration. Returns the path of the capture file
+func (capture *Capture) Start(duration time.Duration) (string, error) {
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+
+	if capture.file != nil {
+		return "", fmt.Errorf("capture is already running into '%s'", capture.file.Name())
+	}
+	if err := os.MkdirAll(capture.dir, 0750); err != nil {
+		return "", fmt.Errorf("os.MkdirAll: %w", err)
+	}
+
+	path := filepath.Join(capture.dir, fmt.Sprintf("capture-%d.jsonl", time.Now().UnixNano()))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("os.Create('%s'): %w", path, err)
+	}
+	capture.file = file
+	capture.encoder = json.NewEncoder(file)
+	capture.timer = time.AfterFunc(duration, func() {
+		capture.mu.Lock()
+		defer capture.mu.Unlock()
+		// the capture could be stopped and started again before the timer fired
+		if capture.file == file {
+			_ = capture.stop()
+		}
+	})
+
+	return path, nil
+}
+
+// Stop the capture and close the file
+func (capture *Capture) Stop() error {
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+
+	return capture.stop()
+}
+
+func (capture *Capture) stop() error {
+	if capture.file == nil {
+		return nil
+	}
+	capture.timer.Stop()
+	err := capture.file.Close()
+	capture.file = nil
+	capture.encoder = nil
+	if err != nil {
+		return fmt.Errorf("file.Close: %w", err)
+	}
+	return nil
+}
+
+// Running returns true if the capture is in progress
+func (capture *Capture) Running() bool {
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+
+	return capture.file != nil
+}
+
+// write the record if the capture is running
+func (capture *Capture) write(record *CaptureRecord) {
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+
+	if capture.encoder == nil {
+		return
+	}
+	// the capture is the best effort, it never fails the request
+	_ = capture.encoder.Encode(record)
+}
+
+// redact returns the parameters without the secrets
+func (capture *Capture) redact(parameters map[string]interface{}) map[string]interface{} {
+	if capture.scrubber == nil {
+		return parameters
+	}
+	return capture.scrubber.Parameters(parameters)
+}
+
+// Middleware records the requests and replies while the capture is running
+func (capture *Capture) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			if !capture.Running() {
+				return next(req)
+			}
+
+			request := *req
+			request.Parameters = capture.redact(req.Parameters)
+			start := time.Now()
+
+			reply := next(req)
+
+			record := &CaptureRecord{
+				Time:    start,
+				Latency: time.Since(start),
+				Request: &request,
+			}
+			// the missing reply is recorded as null
+			if reply != nil {
+				recorded := *reply
+				recorded.Parameters = capture.redact(reply.Parameters)
+				if capture.scrubber != nil {
+					recorded.Message = capture.scrubber.String(reply.Message)
+				}
+				record.Reply = &recorded
+			}
+			if capture.tenants != nil {
+				// the request without a tenant is recorded too
+				record.Tenant, _ = capture.tenants.Tenant(req)
+			}
+			capture.write(record)
+			return reply
+		}
+	}
+}
+
+// Handler replies to the CaptureCommand with the path of the capture file
+func (capture *Capture) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		seconds, ok := req.Parameters["seconds"].(float64)
+		if !ok || seconds <= 0 {
+			return Fail("missing 'seconds' parameter")
+		}
+		path, err := capture.Start(time.Duration(seconds * float64(time.Second)))
+		if err != nil {
+			return Fail(fmt.Sprintf("capture.Start: %v", err))
+		}
+		return Ok(map[string]interface{}{"path": path})
+	}
+}
+
+// CaptureReader reads the records of the capture file
+type CaptureReader struct {
+	file    *os.File
+	decoder *json.Decoder
+}
+
+// OpenCapture opens the capture file for reading
+func OpenCapture(path string) (*CaptureReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open('%s'): %w", path, err)
+	}
+	return &CaptureReader{file: file, decoder: json.NewDecoder(file)}, nil
+}
+
+// Next returns the next record. Returns io.EOF at the end of the capture
+func (reader *CaptureReader) Next() (*CaptureRecord, error) {
+	var record CaptureRecord
+	if err := reader.decoder.Decode(&record); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("decoder.Decode: %w", err)
+	}
+	return &record, nil
+}
+
+// Close the capture file
+func (reader *CaptureReader) Close() error {
+	return reader.file.Close()
+}
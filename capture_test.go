@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// TestCaptureRecordsScrubbedTraffic checks that the traffic is recorded only while the capture runs,
+// and the secrets are not recorded
+func TestCaptureRecordsScrubbedTraffic(t *testing.T) {
+	capture := NewCapture(t.TempDir(), NewScrubber())
+	handler := Wrap(func(req *Envelope) *Reply {
+		if req.Command == "lost" {
+			return nil
+		}
+		return Fail("login failed for password=hunter2")
+	}, capture.Middleware())
+
+	handler(policyRequest("before", nil))
+	reply := capture.Handler()(policyRequest(CaptureCommand, map[string]interface{}{"seconds": float64(60)}))
+	path, ok := reply.Parameters["path"].(string)
+	if !reply.IsOK() || !ok {
+		t.Fatalf("the capture command replied %v", reply)
+	}
+	if reply := capture.Handler()(policyRequest(CaptureCommand, map[string]interface{}{"seconds": float64(60)})); reply.IsOK() {
+		t.Fatalf("the second capture started while the first is running")
+	}
+
+	handler(policyRequest("login", map[string]interface{}{"user": "alice", "password": "hunter2"}))
+	handler(policyRequest("lost", nil))
+	if err := capture.Stop(); err != nil {
+		t.Fatalf("capture.Stop: %v", err)
+	}
+	handler(policyRequest("after", nil))
+
+	reader, err := OpenCapture(path)
+	if err != nil {
+		t.Fatalf("OpenCapture: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	login, err := reader.Next()
+	if err != nil {
+		t.Fatalf("reader.Next: %v", err)
+	}
+	if login.Request.Command != "login" || login.Request.Parameters["password"] != MaskedValue || login.Request.Parameters["user"] != "alice" {
+		t.Fatalf("the recorded request is %+v", login.Request)
+	}
+	if login.Reply.Message != "login failed for password="+MaskedValue {
+		t.Fatalf("the recorded reply message is '%s'", login.Reply.Message)
+	}
+	lost, err := reader.Next()
+	if err != nil || lost.Request.Command != "lost" || lost.Reply != nil {
+		t.Fatalf("the request without the reply is recorded as %+v, %v", lost, err)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("recorded the traffic outside the capture: %v", err)
+	}
+}
+
+// TestCaptureStopsAfterDuration checks that the capture stops by itself
+func TestCaptureStopsAfterDuration(t *testing.T) {
+	capture := NewCapture(t.TempDir(), nil)
+	if _, err := capture.Start(10 * time.Millisecond); err != nil {
+		t.Fatalf("capture.Start: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for capture.Running() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the capture is running after its duration")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
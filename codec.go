@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec serializes the messages
+type Codec interface {
+	Name() string
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, value interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return JsonCodec
+}
+
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	list map[string]Codec
+}{list: map[string]Codec{JsonCodec: jsonCodec{}}}
+
+// RegisterCodec adds the codec, so it could be used by its name.
+// The json codec is registered by default.
+func RegisterCodec(codec Codec) {
+	codecs.Lock()
+	codecs.list[codec.Name()] = codec
+	codecs.Unlock()
+}
+
+// CodecByName returns the registered codec
+func CodecByName(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	codec, ok := codecs.list[name]
+	if !ok {
+		return nil, fmt.Errorf("codec '%s' not registered", name)
+	}
+	return codec, nil
+}
+
+// Transcoder converts the messages between the source and destination codecs.
+// The destination codec could be different per command,
+// so the backends could be migrated to the binary encodings one by one.
+//
+// Set it to the sources and the destinations with WithTranscoder.
+// The sources decode the requests and encode the replies with the source codec,
+// the destinations encode the requests and decode the replies with the codec of the command.
+// The nil transcoder uses the json codec.
+type Transcoder struct {
+	source       Codec
+	destination  Codec
+	destinations map[string]Codec
+}
+
+// NewTranscoder returns the transcoder with the codec names.
+// The routes map the command to the destination codec.
+// The commands not in the routes use the destination codec.
+func NewTranscoder(source string, destination string, routes map[string]string) (*Transcoder, error) {
+	sourceCodec, err := CodecByName(source)
+	if err != nil {
+		return nil, fmt.Errorf("source CodecByName: %w", err)
+	}
+	destinationCodec, err := CodecByName(destination)
+	if err != nil {
+		return nil, fmt.Errorf("destination CodecByName: %w", err)
+	}
+
+	transcoder := &Transcoder{
+		source:       sourceCodec,
+		destination:  destinationCodec,
+		destinations: make(map[string]Codec, len(routes)),
+	}
+	for command, name := range routes {
+		codec, err := CodecByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("command '%s' CodecByName: %w", command, err)
+		}
+		transcoder.destinations[command] = codec
+	}
+
+	return transcoder, nil
+}
+
+// DestinationCodec returns the codec of the command in the destination
+func (transcoder *Transcoder) DestinationCodec(command string) Codec {
+	if codec, ok := transcoder.destinations[command]; ok {
+		return codec
+	}
+	return transcoder.destination
+}
+
+// SourceCodec returns the codec of the source
+func (transcoder *Transcoder) SourceCodec() Codec {
+	if transcoder == nil {
+		return jsonCodec{}
+	}
+	return transcoder.source
+}
+
+// commandCodec returns the destination codec of the command
+func (transcoder *Transcoder) commandCodec(command string) Codec {
+	if transcoder == nil {
+		return jsonCodec{}
+	}
+	return transcoder.DestinationCodec(command)
+}
+
+// DecodeRequest decodes the envelope received by the source
+func (transcoder *Transcoder) DecodeRequest(data []byte) (*Envelope, error) {
+	return decodeEnvelope(transcoder.SourceCodec(), data)
+}
+
+// EncodeReply encodes the reply sent by the source
+func (transcoder *Transcoder) EncodeReply(reply interface{}) ([]byte, error) {
+	codec := transcoder.SourceCodec()
+	data, err := codec.Marshal(reply)
+	if err != nil {
+		return nil, fmt.Errorf("%s.Marshal: %w", codec.Name(), err)
+	}
+	return data, nil
+}
+
+// EncodeRequest encodes the latest envelope sent by the destination with the codec of its command
+func (transcoder *Transcoder) EncodeRequest(req *Envelope) ([]byte, error) {
+	return req.encode(transcoder.commandCodec(req.Command), LatestEnvelopeVersion)
+}
+
+// DecodeReply decodes the reply of the command received by the destination
+func (transcoder *Transcoder) DecodeReply(command string, data []byte) (*Reply, error) {
+	codec := transcoder.commandCodec(command)
+	var reply Reply
+	if err := codec.Unmarshal(data, &reply); err != nil {
+		return nil, fmt.Errorf("%s.Unmarshal: %w", codec.Name(), err)
+	}
+	return &reply, nil
+}
+
+// Request decodes the source request and encodes it for the destination
+func (transcoder *Transcoder) Request(data []byte) (*Envelope, []byte, error) {
+	var envelope Envelope
+	if err := transcoder.source.Unmarshal(data, &envelope); err != nil {
+		return nil, nil, fmt.Errorf("%s.Unmarshal: %w", transcoder.source.Name(), err)
+	}
+
+	codec := transcoder.DestinationCodec(envelope.Command)
+	encoded, err := codec.Marshal(&envelope)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s.Marshal: %w", codec.Name(), err)
+	}
+	return &envelope, encoded, nil
+}
+
+// Reply decodes the destination reply of the command and encodes it for the source
+func (transcoder *Transcoder) Reply(command string, data []byte) (*Reply, []byte, error) {
+	codec := transcoder.DestinationCodec(command)
+
+	var reply Reply
+	if err := codec.Unmarshal(data, &reply); err != nil {
+		return nil, nil, fmt.Errorf("%s.Unmarshal: %w", codec.Name(), err)
+	}
+
+	encoded, err := transcoder.source.Marshal(&reply)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s.Marshal: %w", transcoder.source.Name(), err)
+	}
+	return &reply, encoded, nil
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// base64Codec is the json in base64, so it's not readable as json
+type base64Codec struct{}
+
+func (base64Codec) Name() string {
+	return "base64json"
+}
+
+func (base64Codec) Marshal(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(data)), nil
+}
+
+func (base64Codec) Unmarshal(data []byte, value interface{}) error {
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, value)
+}
+
+// base64Transcoder returns the transcoder of the base64 source, and the destination codecs of the routes
+func base64Transcoder(t *testing.T, source string, routes map[string]string) *Transcoder {
+	RegisterCodec(base64Codec{})
+	transcoder, err := NewTranscoder(source, JsonCodec, routes)
+	if err != nil {
+		t.Fatalf("NewTranscoder: %v", err)
+	}
+	return transcoder
+}
+
+// echoCommand replies with the command and the parameters of the request
+func echoCommand(req *Envelope) *Reply {
+	return Ok(map[string]interface{}{"command": req.Command, "name": req.Parameters["name"]})
+}
+
+// TestTCPTranscodesByCommand checks that only the migrated command is sent to the backend in its codec
+func TestTCPTranscodesByCommand(t *testing.T) {
+	backend, err := NewTCPSource(TCPSourceConfig{Port: 1})
+	if err != nil {
+		t.Fatalf("NewTCPSource: %v", err)
+	}
+	address := serveTCP(t, backend.WithTranscoder(base64Transcoder(t, "base64json", nil)), echoCommand)
+	destination := NewTCPDestination(address).WithTranscoder(base64Transcoder(t, JsonCodec, map[string]string{"migrated": "base64json"}))
+	defer func() { _ = destination.Close() }()
+
+	req := NewEnvelope(&Request{Command: "migrated", Parameters: map[string]interface{}{"name": "alice"}})
+	reply, err := destination.Send(context.Background(), req)
+	if err != nil || !reply.IsOK() || reply.Parameters["name"] != "alice" {
+		t.Fatalf("the migrated command replied %v, %v", reply, err)
+	}
+	if _, err := destination.Send(context.Background(), NewEnvelope(&Request{Command: "legacy"})); err == nil {
+		t.Fatalf("the json command was read by the base64 backend")
+	}
+}
+
+// TestHTTPSourceTranscodes checks that the http body and the reply are in the source codec
+func TestHTTPSourceTranscodes(t *testing.T) {
+	source, err := NewHTTPSource(HTTPSourceConfig{Port: 1})
+	if err != nil {
+		t.Fatalf("NewHTTPSource: %v", err)
+	}
+	codec := base64Codec{}
+	handler := source.WithTranscoder(base64Transcoder(t, "base64json", nil)).HTTPHandler(echoCommand)
+
+	body, _ := codec.Marshal(map[string]interface{}{"name": "alice"})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/greet", strings.NewReader(string(body))))
+	if recorder.Header().Get("Content-Type") != "application/base64json" {
+		t.Fatalf("the reply content type is '%s'", recorder.Header().Get("Content-Type"))
+	}
+	var reply Reply
+	if err := codec.Unmarshal(recorder.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("the reply is not in the source codec: %v", err)
+	}
+	if !reply.IsOK() || reply.Parameters["name"] != "alice" {
+		t.Fatalf("the source replied %v", reply)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/greet", strings.NewReader(`{"name":"alice"}`)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("the json body was accepted with %d status", recorder.Code)
+	}
+}
+
+// TestWebSocketSourceTranscodes checks that the messages of the binary codec are sent as the binary messages
+func TestWebSocketSourceTranscodes(t *testing.T) {
+	source, err := NewWebSocketSource(WebSocketSourceConfig{Port: 1}, nil)
+	if err != nil {
+		t.Fatalf("NewWebSocketSource: %v", err)
+	}
+	source.WithTranscoder(base64Transcoder(t, "base64json", nil))
+	client := mustDialWebSocket(t, serveLoopback(t, source.HTTPHandler(echoCommand)))
+
+	codec := base64Codec{}
+	if err := client.writeCodec(codec, wsBinary, Request{Command: "greet", Parameters: map[string]interface{}{"name": "alice"}}); err != nil {
+		t.Fatalf("writeCodec: %v", err)
+	}
+	var reply Reply
+	opcode, err := client.readCodec(codec, &reply)
+	if err != nil {
+		t.Fatalf("readCodec: %v", err)
+	}
+	if opcode != wsBinary || !reply.IsOK() || reply.Parameters["name"] != "alice" {
+		t.Fatalf("the source replied %v in the frame %d", reply, opcode)
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CommandMapping maps the deprecated command to the command of the new destination version
+type CommandMapping struct {
+	// Command is the new command name. Empty name keeps the command as is
+	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+	// Parameters maps the deprecated parameter names to the new names
+	Parameters map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+}
+
+// VersionMap lists the deprecated commands per destination version.
+// The key is the destination version, the value is the mapping by the deprecated command name.
+type VersionMap map[string]map[string]CommandMapping
+
+// Compat translates the requests of the old clients to the destination version
+type Compat struct {
+	rewriters map[string]*Rewriter
+}
+
+// NewCompat returns the compatibility layer for the version map.
+// The mappings are converted to the rewrite rules.
+func NewCompat(versions VersionMap) (*Compat, error) {
+	compat := &Compat{rewriters: make(map[string]*Rewriter, len(versions))}
+
+	for version, mappings := range versions {
+		commands := make([]string, 0, len(mappings))
+		for command := range mappings {
+			commands = append(commands, command)
+		}
+		// sorted, so the chained renames are applied in the same order
+		sort.Strings(commands)
+
+		rules := make([]RewriteRule, 0, len(mappings))
+		for _, command := range commands {
+			mapping := mappings[command]
+			rule := RewriteRule{
+				Command:    command,
+				SetCommand: mapping.Command,
+				Rename:     make([]ParameterRename, 0, len(mapping.Parameters)),
+			}
+			for from, to := range mapping.Parameters {
+				rule.Rename = append(rule.Rename, ParameterRename{From: from, To: to})
+			}
+			// the deprecated names are distinct, so the order matters only for the chained renames
+			sort.Slice(rule.Rename, func(i, j int) bool {
+				return rule.Rename[i].From < rule.Rename[j].From
+			})
+			rules = append(rules, rule)
+		}
+
+		rewriter, err := NewRewriter(rules)
+		if err != nil {
+			return nil, fmt.Errorf("version '%s' NewRewriter: %w", version, err)
+		}
+		compat.rewriters[version] = rewriter
+	}
+
+	return compat, nil
+}
+
+// Translate the request to the destination version.
+// Returns false if the version has no mapping.
+func (compat *Compat) Translate(version string, req *Request) bool {
+	rewriter, ok := compat.rewriters[version]
+	if !ok {
+		return false
+	}
+	rewriter.Rewrite(req)
+	return true
+}
+
+// Middleware translates the requests to the destination version.
+// The version is a function, since the destination could be upgraded during the proxy's lifetime.
+func (compat *Compat) Middleware(version func() string) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			translated := rewritable(req)
+			compat.Translate(version(), &translated.Request)
+			return next(translated)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import "testing"
+
+// TestCompatTranslates checks that the deprecated command of the version is translated
+func TestCompatTranslates(t *testing.T) {
+	compat, err := NewCompat(VersionMap{"v2": {
+		"getUser": {Command: "user.get", Parameters: map[string]string{"userId": "id", "fields": "select"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewCompat: %v", err)
+	}
+	handler := Wrap(func(req *Envelope) *Reply {
+		return Ok(map[string]interface{}{"command": req.Command, "id": req.Parameters["id"], "select": req.Parameters["select"]})
+	}, compat.Middleware(func() string { return "v2" }))
+
+	req := NewEnvelope(&Request{Command: "getUser", Parameters: map[string]interface{}{"userId": 7, "fields": "name"}})
+	reply := handler(req)
+	if reply.Parameters["command"] != "user.get" || reply.Parameters["id"] != 7 || reply.Parameters["select"] != "name" {
+		t.Fatalf("translated as %v", reply.Parameters)
+	}
+	if _, ok := req.Parameters["userId"]; !ok {
+		t.Fatalf("the request of the caller was changed")
+	}
+	if compat.Translate("v1", &Request{Command: "getUser"}) {
+		t.Fatalf("the version without the mapping was translated")
+	}
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// The defaults of the adaptive limiter
+const (
+	DefaultTolerance     = 2.0
+	DefaultDecrease      = 0.9
+	latencyResetInterval = 1000
+)
+
+// AdaptiveLimiter limits the requests in flight to the destination.
+// The limit is adjusted by the additive increase, multiplicative decrease (AIMD):
+// while the latency stays near the lowest seen latency, the limit grows by one per limit of requests.
+// When the latency grows over the tolerance or the request fails, the limit is decreased.
+type AdaptiveLimiter struct {
+	// Min and Max bound the limit
+	Min int
+	Max int
+	// Tolerance is how many times the latency could exceed the lowest latency before decreasing the limit
+	Tolerance float64
+	// Decrease is the multiplier of the limit when the destination is overloaded
+	Decrease float64
+
+	mu         sync.Mutex
+	limit      float64
+	inFlight   int
+	minLatency time.Duration
+	samples    int
+}
+
+// NewAdaptiveLimiter returns the limiter starting from the min limit
+func NewAdaptiveLimiter(low int, high int) *AdaptiveLimiter {
+	if low < 1 {
+		low = 1
+	}
+	if high < low {
+		high = low
+	}
+	return &AdaptiveLimiter{
+		Min:       low,
+		Max:       high,
+		Tolerance: DefaultTolerance,
+		Decrease:  DefaultDecrease,
+		limit:     float64(low),
+	}
+}
+
+// Acquire the slot for the request. Returns false if the limit is reached
+func (limiter *AdaptiveLimiter) Acquire() bool {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if limiter.inFlight >= int(limiter.limit) {
+		return false
+	}
+	limiter.inFlight++
+	return true
+}
+
+// Release the slot, adjusting the limit by the latency and the result of the request
+func (limiter *AdaptiveLimiter) Release(latency time.Duration, failed bool) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.inFlight--
+
+	// the lowest latency is forgotten from time to time, so the limiter adapts to the slower destination
+	limiter.samples++
+	if limiter.samples >= latencyResetInterval {
+		limiter.samples = 0
+		limiter.minLatency = 0
+	}
+	if limiter.minLatency == 0 || latency < limiter.minLatency {
+		limiter.minLatency = latency
+	}
+
+	overloaded := failed || float64(latency) > float64(limiter.minLatency)*limiter.Tolerance
+	if overloaded {
+		limiter.limit *= limiter.Decrease
+	} else {
+		limiter.limit += 1 / limiter.limit
+	}
+
+	if limiter.limit < float64(limiter.Min) {
+		limiter.limit = float64(limiter.Min)
+	}
+	if limiter.limit > float64(limiter.Max) {
+		limiter.limit = float64(limiter.Max)
+	}
+}
+
+// Limit returns the current limit
+func (limiter *AdaptiveLimiter) Limit() int {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	return int(limiter.limit)
+}
+
+// InFlight returns the amount of the requests in flight
+func (limiter *AdaptiveLimiter) InFlight() int {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	return limiter.inFlight
+}
+
+// Middleware rejects the requests over the limit
+func (limiter *AdaptiveLimiter) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			if !limiter.Acquire() {
+				return Fail("destination is at the concurrency limit")
+			}
+			start := time.Now()
+			reply := next(req)
+			limiter.Release(time.Since(start), reply == nil || !reply.IsOK())
+			return reply
+		}
+	}
+}
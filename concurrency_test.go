@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAdaptiveLimiterAIMD checks that the limit grows while the latency is low,
+// and decreases on the failures
+func TestAdaptiveLimiterAIMD(t *testing.T) {
+	limiter := NewAdaptiveLimiter(2, 4)
+	if !limiter.Acquire() || !limiter.Acquire() {
+		t.Fatalf("the limiter rejected the requests below the limit")
+	}
+	if limiter.Acquire() {
+		t.Fatalf("the limiter accepted the request over the limit %d", limiter.Limit())
+	}
+	limiter.Release(time.Millisecond, false)
+	limiter.Release(time.Millisecond, false)
+	if limiter.InFlight() != 0 {
+		t.Fatalf("%d requests in flight after the release", limiter.InFlight())
+	}
+
+	for i := 0; i < 20; i++ {
+		limiter.Acquire()
+		limiter.Release(time.Millisecond, false)
+	}
+	if limiter.Limit() != 4 {
+		t.Fatalf("the limit %d didn't grow to the max", limiter.Limit())
+	}
+
+	for i := 0; i < 20; i++ {
+		limiter.Acquire()
+		limiter.Release(time.Millisecond, true)
+	}
+	if limiter.Limit() != 2 {
+		t.Fatalf("the limit %d didn't decrease to the min", limiter.Limit())
+	}
+}
+
+// TestAdaptiveLimiterMiddleware checks that the missing reply is counted as the failure
+func TestAdaptiveLimiterMiddleware(t *testing.T) {
+	limiter := NewAdaptiveLimiter(1, 1)
+	handler := Wrap(func(req *Envelope) *Reply {
+		if limiter.InFlight() != 1 {
+			t.Errorf("%d requests in flight in the handler", limiter.InFlight())
+		}
+		return nil
+	}, limiter.Middleware())
+
+	if reply := handler(policyRequest("lost", nil)); reply != nil {
+		t.Fatalf("the middleware replied %v", reply)
+	}
+	if limiter.InFlight() != 0 {
+		t.Fatalf("the slot of the missing reply is not released")
+	}
+
+	limiter.Acquire()
+	if reply := handler(policyRequest("over", nil)); reply == nil || reply.IsOK() {
+		t.Fatalf("the request over the limit is passed: %v", reply)
+	}
+}
@@ -0,0 +1,277 @@
+package proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DatabaseCredentials are the temporary credentials of the database
+type DatabaseCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// CredentialProvider returns the socket keys, api credentials and database credentials,
+// regardless of the backend that stores them.
+type CredentialProvider interface {
+	// GetString returns the secret by the bucket and the key
+	GetString(bucket string, key string) (string, error)
+	GetDatabaseCredentials() (DatabaseCredentials, error)
+	// Renew extends or reloads the credentials
+	Renew() error
+}
+
+// EnvCredentials reads the credentials from the environment variables.
+// The secret of the bucket and the key is in PREFIX_BUCKET_KEY, in upper case.
+// The database credentials are in PREFIX_DATABASE_USERNAME and PREFIX_DATABASE_PASSWORD.
+type EnvCredentials struct {
+	Prefix string
+}
+
+// NewEnvCredentials returns the provider of the environment variables with the prefix
+func NewEnvCredentials(prefix string) *EnvCredentials {
+	return &EnvCredentials{Prefix: prefix}
+}
+
+// variable returns the environment variable name of the parts
+func (provider *EnvCredentials) variable(parts ...string) string {
+	if len(provider.Prefix) > 0 {
+		parts = append([]string{provider.Prefix}, parts...)
+	}
+	name := strings.ToUpper(strings.Join(parts, "_"))
+	return strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(name)
+}
+
+// GetString returns the environment variable of the bucket and the key
+func (provider *EnvCredentials) GetString(bucket string, key string) (string, error) {
+	name := provider.variable(bucket, key)
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable '%s' not set", name)
+	}
+	return value, nil
+}
+
+// GetDatabaseCredentials returns the database username and password from the environment
+func (provider *EnvCredentials) GetDatabaseCredentials() (DatabaseCredentials, error) {
+	username, err := provider.GetString("database", "username")
+	if err != nil {
+		return DatabaseCredentials{}, err
+	}
+	password, err := provider.GetString("database", "password")
+	if err != nil {
+		return DatabaseCredentials{}, err
+	}
+	return DatabaseCredentials{Username: username, Password: password}, nil
+}
+
+// Renew does nothing, the environment is read on every call
+func (provider *EnvCredentials) Renew() error {
+	return nil
+}
+
+// credentialsFile is the decrypted content of the encrypted credentials file
+type credentialsFile struct {
+	Buckets  map[string]map[string]string `json:"buckets"`
+	Database DatabaseCredentials          `json:"database"`
+}
+
+// FileCredentials reads the credentials from the local file encrypted with AES-256-GCM.
+// The file is the nonce followed by the encrypted json of the buckets and the database credentials.
+// Use EncryptCredentials to create the file.
+type FileCredentials struct {
+	path    string
+	key     []byte
+	mu      sync.RWMutex
+	content credentialsFile
+}
+
+// NewFileCredentials returns the provider of the file decrypted with the 32 bytes key
+func NewFileCredentials(path string, key []byte) (*FileCredentials, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key is %d bytes, expected 32", len(key))
+	}
+	provider := &FileCredentials{path: path, key: key}
+	if err := provider.Renew(); err != nil {
+		return nil, fmt.Errorf("provider.Renew: %w", err)
+	}
+	return provider, nil
+}
+
+// newGCM returns the cipher of the key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewCipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cipher.NewGCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// EncryptCredentials writes the credentials into the file encrypted with the 32 bytes key
+func EncryptCredentials(path string, key []byte, buckets map[string]map[string]string, database DatabaseCredentials) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return fmt.Errorf("newGCM: %w", err)
+	}
+	plain, err := json.Marshal(credentialsFile{Buckets: buckets, Database: database})
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("rand.Read: %w", err)
+	}
+	if err := writeFile(path, gcm.Seal(nonce, nonce, plain, nil)); err != nil {
+		return fmt.Errorf("writeFile: %w", err)
+	}
+	return nil
+}
+
+// GetString returns the secret of the bucket and the key
+func (provider *FileCredentials) GetString(bucket string, key string) (string, error) {
+	provider.mu.RLock()
+	defer provider.mu.RUnlock()
+
+	value, ok := provider.content.Buckets[bucket][key]
+	if !ok {
+		return "", fmt.Errorf("secret '%s' not found in '%s' bucket", key, bucket)
+	}
+	return value, nil
+}
+
+// GetDatabaseCredentials returns the database credentials of the file
+func (provider *FileCredentials) GetDatabaseCredentials() (DatabaseCredentials, error) {
+	provider.mu.RLock()
+	defer provider.mu.RUnlock()
+
+	if len(provider.content.Database.Username) == 0 {
+		return DatabaseCredentials{}, fmt.Errorf("no database credentials in '%s'", provider.path)
+	}
+	return provider.content.Database, nil
+}
+
+// Renew decrypts the file again, so the rotated credentials are picked up
+func (provider *FileCredentials) Renew() error {
+	data, err := os.ReadFile(provider.path)
+	if err != nil {
+		return fmt.Errorf("os.ReadFile('%s'): %w", provider.path, err)
+	}
+	gcm, err := newGCM(provider.key)
+	if err != nil {
+		return fmt.Errorf("newGCM: %w", err)
+	}
+	if len(data) < gcm.NonceSize() {
+		return fmt.Errorf("'%s' is too short", provider.path)
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return fmt.Errorf("gcm.Open('%s'): %w", provider.path, err)
+	}
+
+	var content credentialsFile
+	if err := json.Unmarshal(plain, &content); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	provider.mu.Lock()
+	provider.content = content
+	provider.mu.Unlock()
+	return nil
+}
+
+// save encrypts the changed content into the file, then keeps it.
+// Must be called with the lock.
+func (provider *FileCredentials) save(content credentialsFile) error {
+	if err := EncryptCredentials(provider.path, provider.key, content.Buckets, content.Database); err != nil {
+		return fmt.Errorf("EncryptCredentials: %w", err)
+	}
+	provider.content = content
+	return nil
+}
+
+// copyBuckets returns the deep copy of the buckets
+func copyBuckets(buckets map[string]map[string]string) map[string]map[string]string {
+	copied := make(map[string]map[string]string, len(buckets))
+	for bucket, secrets := range buckets {
+		copied[bucket] = make(map[string]string, len(secrets))
+		for key, value := range secrets {
+			copied[bucket][key] = value
+		}
+	}
+	return copied
+}
+
+// SetString sets the secret and saves the file
+func (provider *FileCredentials) SetString(bucket string, key string, value string) error {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	content := provider.content
+	content.Buckets = copyBuckets(content.Buckets)
+	if content.Buckets[bucket] == nil {
+		content.Buckets[bucket] = make(map[string]string)
+	}
+	content.Buckets[bucket][key] = value
+	return provider.save(content)
+}
+
+// GetSecret returns the copy of the bucket
+func (provider *FileCredentials) GetSecret(bucket string) (map[string]string, error) {
+	provider.mu.RLock()
+	defer provider.mu.RUnlock()
+
+	secrets, ok := provider.content.Buckets[bucket]
+	if !ok {
+		return nil, fmt.Errorf("bucket '%s' not found", bucket)
+	}
+	copied := make(map[string]string, len(secrets))
+	for key, value := range secrets {
+		copied[key] = value
+	}
+	return copied, nil
+}
+
+// DeleteSecret deletes the key or the whole bucket, and saves the file
+func (provider *FileCredentials) DeleteSecret(bucket string, key string) error {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if _, ok := provider.content.Buckets[bucket]; !ok {
+		return fmt.Errorf("bucket '%s' not found", bucket)
+	}
+	content := provider.content
+	content.Buckets = copyBuckets(content.Buckets)
+	if len(key) == 0 {
+		delete(content.Buckets, bucket)
+	} else {
+		if _, ok := content.Buckets[bucket][key]; !ok {
+			return fmt.Errorf("secret '%s' not found in '%s' bucket", key, bucket)
+		}
+		delete(content.Buckets[bucket], key)
+	}
+	return provider.save(content)
+}
+
+// ListSecrets returns the sorted bucket names
+func (provider *FileCredentials) ListSecrets() ([]string, error) {
+	provider.mu.RLock()
+	buckets := make([]string, 0, len(provider.content.Buckets))
+	for bucket := range provider.content.Buckets {
+		buckets = append(buckets, bucket)
+	}
+	provider.mu.RUnlock()
+
+	sort.Strings(buckets)
+	return buckets, nil
+}
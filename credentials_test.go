@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestEnvCredentials checks the variable names of the buckets and the keys
+func TestEnvCredentials(t *testing.T) {
+	t.Setenv("PROXY_API_AUTH_TOKEN", "token")
+	t.Setenv("PROXY_DATABASE_USERNAME", "alice")
+	t.Setenv("PROXY_DATABASE_PASSWORD", "hunter2")
+
+	provider := NewEnvCredentials("proxy")
+	if value, err := provider.GetString("api", "auth-token"); err != nil || value != "token" {
+		t.Fatalf("provider.GetString: '%s', %v", value, err)
+	}
+	if _, err := provider.GetString("api", "missing"); err == nil {
+		t.Fatalf("the missing variable returned no error")
+	}
+	database, err := provider.GetDatabaseCredentials()
+	if err != nil || database != (DatabaseCredentials{Username: "alice", Password: "hunter2"}) {
+		t.Fatalf("provider.GetDatabaseCredentials: %+v, %v", database, err)
+	}
+}
+
+// TestFileCredentials checks that the secrets are encrypted, changed and renewed
+func TestFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	key := bytes.Repeat([]byte{7}, 32)
+	buckets := map[string]map[string]string{"api": {"auth_token": "token"}}
+	if err := EncryptCredentials(path, key, buckets, DatabaseCredentials{Username: "alice", Password: "hunter2"}); err != nil {
+		t.Fatalf("EncryptCredentials: %v", err)
+	}
+
+	if _, err := NewFileCredentials(path, key[:16]); err == nil {
+		t.Fatalf("the short key is accepted")
+	}
+	if _, err := NewFileCredentials(path, bytes.Repeat([]byte{8}, 32)); err == nil {
+		t.Fatalf("the file is decrypted with the wrong key")
+	}
+
+	provider, err := NewFileCredentials(path, key)
+	if err != nil {
+		t.Fatalf("NewFileCredentials: %v", err)
+	}
+	if value, err := provider.GetString("api", "auth_token"); err != nil || value != "token" {
+		t.Fatalf("provider.GetString: '%s', %v", value, err)
+	}
+	if database, err := provider.GetDatabaseCredentials(); err != nil || database.Username != "alice" {
+		t.Fatalf("provider.GetDatabaseCredentials: %+v, %v", database, err)
+	}
+
+	if err := provider.SetString("socket", "public_key", "public"); err != nil {
+		t.Fatalf("provider.SetString: %v", err)
+	}
+	if err := provider.DeleteSecret("api", "missing"); err == nil {
+		t.Fatalf("deleted the missing secret")
+	}
+	if err := provider.DeleteSecret("api", ""); err != nil {
+		t.Fatalf("provider.DeleteSecret: %v", err)
+	}
+	if buckets, _ := provider.ListSecrets(); !reflect.DeepEqual(buckets, []string{"socket"}) {
+		t.Fatalf("the buckets are %v", buckets)
+	}
+
+	// the changes are saved in the file
+	reloaded, err := NewFileCredentials(path, key)
+	if err != nil {
+		t.Fatalf("NewFileCredentials: %v", err)
+	}
+	secrets, err := reloaded.GetSecret("socket")
+	if err != nil || secrets["public_key"] != "public" {
+		t.Fatalf("reloaded.GetSecret: %v, %v", secrets, err)
+	}
+	if _, err := reloaded.GetString("api", "auth_token"); err == nil {
+		t.Fatalf("the deleted bucket is in the file")
+	}
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DestinationConfig is the destination of the proxy defined in the configuration
+type DestinationConfig struct {
+	Type     string                 `json:"type" yaml:"type"`
+	Settings map[string]interface{} `json:"settings,omitempty" yaml:"settings,omitempty"`
+}
+
+// DestinationFactory creates the destination transport from the configuration settings
+type DestinationFactory func(settings map[string]interface{}) (DestinationTransport, error)
+
+var destinationTypes = struct {
+	sync.RWMutex
+	factories map[string]DestinationFactory
+}{factories: map[string]DestinationFactory{
+	"tcp": tcpDestinationFactory,
+}}
+
+// RegisterDestinationFactory adds the destination type contributed by the external package,
+// so it could be used in the configuration. The destination is created by NewDestination.
+func RegisterDestinationFactory(name string, factory DestinationFactory) error {
+	if len(name) == 0 {
+		return fmt.Errorf("empty destination type")
+	}
+
+	destinationTypes.Lock()
+	defer destinationTypes.Unlock()
+
+	if _, ok := destinationTypes.factories[name]; ok {
+		return fmt.Errorf("destination type '%s' already registered", name)
+	}
+	destinationTypes.factories[name] = factory
+	return nil
+}
+
+// RegisteredDestinationTypes returns the sorted names of the destination types
+func RegisteredDestinationTypes() []string {
+	destinationTypes.RLock()
+	names := make([]string, 0, len(destinationTypes.factories))
+	for name := range destinationTypes.factories {
+		names = append(names, name)
+	}
+	destinationTypes.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// ValidateDestination returns an error if the destination type is not registered
+func ValidateDestination(config DestinationConfig) error {
+	destinationTypes.RLock()
+	defer destinationTypes.RUnlock()
+
+	if _, ok := destinationTypes.factories[config.Type]; !ok {
+		return fmt.Errorf("destination type '%s' not registered", config.Type)
+	}
+	return nil
+}
+
+// NewDestination creates the destination transport of the registered type
+func NewDestination(config DestinationConfig) (DestinationTransport, error) {
+	destinationTypes.RLock()
+	factory, ok := destinationTypes.factories[config.Type]
+	destinationTypes.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("destination type '%s' not registered", config.Type)
+	}
+
+	destination, err := factory(config.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("destination type '%s': %w", config.Type, err)
+	}
+	return destination, nil
+}
+
+// tcpDestinationSettings are the settings of the 'tcp' destination type
+type tcpDestinationSettings struct {
+	Address      string `json:"address" yaml:"address"`
+	MaxReplySize int64  `json:"max_reply_size,omitempty" yaml:"max_reply_size,omitempty"`
+}
+
+// tcpDestinationFactory expects the 'address' and the optional 'max_reply_size' settings
+func tcpDestinationFactory(settings map[string]interface{}) (DestinationTransport, error) {
+	var config tcpDestinationSettings
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	if len(config.Address) == 0 {
+		return nil, fmt.Errorf("missing 'address' setting")
+	}
+	return NewTCPDestination(config.Address).WithMaxReplySize(config.MaxReplySize), nil
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+)
+
+// TestRegisterDestinationFactory checks that the contributed destination type is created from the configuration
+func TestRegisterDestinationFactory(t *testing.T) {
+	factory := func(settings map[string]interface{}) (DestinationTransport, error) {
+		reply := Ok(map[string]interface{}{"greeting": settings["greeting"]})
+		return NewHandlerDestination(func(*Envelope) *Reply { return reply }), nil
+	}
+	if err := RegisterDestinationFactory("test.greeter", factory); err != nil {
+		t.Fatalf("RegisterDestinationFactory: %v", err)
+	}
+	if err := RegisterDestinationFactory("test.greeter", factory); err == nil {
+		t.Fatalf("the destination type registered twice")
+	}
+
+	config := DestinationConfig{Type: "test.greeter", Settings: map[string]interface{}{"greeting": "hello"}}
+	if err := ValidateDestination(config); err != nil {
+		t.Fatalf("ValidateDestination: %v", err)
+	}
+	destination, err := NewDestination(config)
+	if err != nil {
+		t.Fatalf("NewDestination: %v", err)
+	}
+	reply, err := destination.Send(context.Background(), NewEnvelope(&Request{Command: "greet"}))
+	if err != nil || !reply.IsOK() || reply.Parameters["greeting"] != "hello" {
+		t.Fatalf("the destination replied %v, %v", reply, err)
+	}
+
+	if err := ValidateDestination(DestinationConfig{Type: "test.missing"}); err == nil {
+		t.Fatalf("the unregistered destination type passed")
+	}
+	if _, err := NewDestination(DestinationConfig{Type: "tcp", Settings: map[string]interface{}{}}); err == nil {
+		t.Fatalf("the tcp destination without the address created")
+	}
+}
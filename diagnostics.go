@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// DiagnosticsCommand returns the diagnostics recorded at the startup
+const DiagnosticsCommand = "proxy.diagnostics"
+
+// Diagnostics is the snapshot of the proxy taken at the startup, for the support and incident triage
+type Diagnostics struct {
+	Started time.Time `json:"started"`
+	Name    string    `json:"name"`
+	Build   BuildInfo `json:"build"`
+	// Context is the type of the context the proxy runs in, for example 'dev'
+	Context string `json:"context,omitempty"`
+	// Endpoints are the bound urls of the sources and the destinations
+	Endpoints   map[string]string `json:"endpoints,omitempty"`
+	Middlewares []string          `json:"middlewares,omitempty"`
+	// Config is the summary of the resolved configuration. The secrets are scrubbed on the output
+	Config   map[string]interface{} `json:"config,omitempty"`
+	Platform string                 `json:"platform"`
+	Cpus     int                    `json:"cpus"`
+
+	scrubber *Scrubber
+}
+
+// NewDiagnostics returns the diagnostics with the build and the platform filled
+func NewDiagnostics(name string, pipeline []MiddlewareConfig) *Diagnostics {
+	diagnostics := &Diagnostics{
+		Started:     time.Now().UTC(),
+		Name:        name,
+		Build:       ReadBuildInfo(),
+		Endpoints:   map[string]string{},
+		Middlewares: make([]string, len(pipeline)),
+		Platform:    runtime.GOOS + "/" + runtime.GOARCH,
+		Cpus:        runtime.NumCPU(),
+		scrubber:    NewScrubber(),
+	}
+	for i, config := range pipeline {
+		diagnostics.Middlewares[i] = config.Name
+	}
+	return diagnostics
+}
+
+// WithScrubber replaces the default scrubber of the configuration, for example to add the secret names
+func (diagnostics *Diagnostics) WithScrubber(scrubber *Scrubber) *Diagnostics {
+	diagnostics.scrubber = scrubber
+	return diagnostics
+}
+
+// scrubbed returns the copy of the diagnostics with the scrubbed configuration
+func (diagnostics *Diagnostics) scrubbed() *Diagnostics {
+	scrubbed := *diagnostics
+	if diagnostics.Config != nil {
+		scrubbed.Config = diagnostics.scrubber.Parameters(diagnostics.Config)
+	}
+	return &scrubbed
+}
+
+// Emit writes the diagnostics as the single json line, usually to the log at the startup
+func (diagnostics *Diagnostics) Emit(w io.Writer) error {
+	data, err := json.Marshal(diagnostics.scrubbed())
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("w.Write: %w", err)
+	}
+	return nil
+}
+
+// Handler replies to the DiagnosticsCommand with the diagnostics and the uptime in seconds
+func (diagnostics *Diagnostics) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		parameters, err := toParameters(diagnostics.scrubbed())
+		if err != nil {
+			return Fail(fmt.Sprintf("toParameters: %v", err))
+		}
+		parameters["uptime"] = time.Since(diagnostics.Started).Seconds()
+		return Ok(parameters)
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestDiagnosticsScrubsConfig checks that the secrets of the configuration are not emitted or replied
+func TestDiagnosticsScrubsConfig(t *testing.T) {
+	diagnostics := NewDiagnostics("proxy", []MiddlewareConfig{{Name: "auth"}, {Name: "metrics"}})
+	diagnostics.Config = map[string]interface{}{
+		"url":      "tcp://localhost:6000",
+		"password": "hunter2",
+		"database": map[string]interface{}{"secret": "hunter2"},
+	}
+
+	var out bytes.Buffer
+	if err := diagnostics.Emit(&out); err != nil {
+		t.Fatalf("diagnostics.Emit: %v", err)
+	}
+	if strings.Contains(out.String(), "hunter2") || strings.Count(out.String(), "\n") != 1 {
+		t.Fatalf("emitted %s", out.String())
+	}
+	var emitted Diagnostics
+	if err := json.Unmarshal(out.Bytes(), &emitted); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if emitted.Name != "proxy" || len(emitted.Middlewares) != 2 || emitted.Middlewares[1] != "metrics" {
+		t.Fatalf("emitted %+v", emitted)
+	}
+	if emitted.Config["url"] != "tcp://localhost:6000" || emitted.Config["password"] != MaskedValue {
+		t.Fatalf("emitted the config %v", emitted.Config)
+	}
+	// the scrubbed copy doesn't change the diagnostics
+	if diagnostics.Config["password"] != "hunter2" {
+		t.Fatalf("the config is scrubbed in place")
+	}
+
+	reply := diagnostics.Handler()(policyRequest(DiagnosticsCommand, nil))
+	if !reply.IsOK() || reply.Parameters["name"] != "proxy" {
+		t.Fatalf("the diagnostics command replied %v", reply)
+	}
+	if _, ok := reply.Parameters["uptime"].(float64); !ok {
+		t.Fatalf("no uptime in %v", reply.Parameters)
+	}
+	config, _ := reply.Parameters["config"].(map[string]interface{})
+	database, _ := config["database"].(map[string]interface{})
+	if database["secret"] != MaskedValue {
+		t.Fatalf("replied the config %v", config)
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// DiscoverCommand is sent to the destination to list its commands.
+// The destination replies with the 'commands' parameter that maps the command name to its CommandSchema.
+const DiscoverCommand = "proxy.discover"
+
+// Discover asks the destination for its commands and their schemas
+func Discover(ctx context.Context, destination DestinationTransport) (map[string]CommandSchema, error) {
+	req := NewEnvelope(&Request{Command: DiscoverCommand, Parameters: map[string]interface{}{}})
+	reply, err := destination.Send(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("destination.Send: %w", err)
+	}
+	if reply == nil {
+		return nil, fmt.Errorf("no reply to '%s'", DiscoverCommand)
+	}
+	if !reply.IsOK() {
+		return nil, fmt.Errorf("destination replied: %s", reply.Message)
+	}
+
+	raw, ok := reply.Parameters["commands"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("missing 'commands' in the reply")
+	}
+	var schemas map[string]CommandSchema
+	if err := fromParameters(raw, &schemas); err != nil {
+		return nil, fmt.Errorf("fromParameters: %w", err)
+	}
+	return schemas, nil
+}
+
+// DiscoverRoutes puts the discovered commands into the route group, and sets the group in the table.
+// The commands that are already in the other groups keep their group.
+// Returns the discovered schemas, to be used by the Conformance and the Manifest.
+func DiscoverRoutes(ctx context.Context, destination DestinationTransport, table *RouteTable, group RouteGroup) (map[string]CommandSchema, error) {
+	schemas, err := Discover(ctx, destination)
+	if err != nil {
+		return nil, fmt.Errorf("Discover: %w", err)
+	}
+
+	routes := table.Routes()
+	commands := make([]string, 0, len(schemas))
+	for command := range schemas {
+		if _, name := routes.Policy(command); len(name) > 0 && name != group.Name {
+			continue
+		}
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	group.Commands = commands
+
+	if err := table.Set(group); err != nil {
+		return nil, fmt.Errorf("table.Set: %w", err)
+	}
+	return schemas, nil
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// TestDiscoverRoutes checks that the discovered commands are put into the group,
+// except the commands of the other groups
+func TestDiscoverRoutes(t *testing.T) {
+	destination := funcTransport(func(req *Envelope) (*Reply, error) {
+		if req.Command != DiscoverCommand {
+			return Fail("unexpected command"), nil
+		}
+		return Ok(map[string]interface{}{"commands": map[string]interface{}{
+			"users.get":    map[string]interface{}{"request": map[string]interface{}{"id": map[string]interface{}{"type": StringType, "required": true}}},
+			"users.list":   map[string]interface{}{},
+			"users.delete": map[string]interface{}{},
+		}}), nil
+	})
+	table := policyTable(t, RoutePolicy{}, "users.delete")
+
+	schemas, err := DiscoverRoutes(context.Background(), destination, table, RouteGroup{Name: "users"})
+	if err != nil {
+		t.Fatalf("DiscoverRoutes: %v", err)
+	}
+	if len(schemas) != 3 || !schemas["users.get"].Request["id"].Required {
+		t.Fatalf("discovered %+v", schemas)
+	}
+	group, ok := table.Routes().Group("users")
+	if !ok || !reflect.DeepEqual(group.Commands, []string{"users.get", "users.list"}) {
+		t.Fatalf("the discovered group is %+v", group)
+	}
+	if _, name := table.Routes().Policy("users.delete"); name != "private" {
+		t.Fatalf("'users.delete' moved to '%s' group", name)
+	}
+}
+
+// TestDiscoverFails checks the errors of the destination replies
+func TestDiscoverFails(t *testing.T) {
+	replies := map[string]*Reply{
+		"failed":   Fail("unknown command"),
+		"commands": Ok(nil),
+		"nil":      nil,
+	}
+	for name, reply := range replies {
+		destination := funcTransport(func(req *Envelope) (*Reply, error) { return reply, nil })
+		if _, err := Discover(context.Background(), destination); err == nil {
+			t.Fatalf("'%s' reply discovered the commands", name)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// The envelope versions.
+// The first version is the raw request without any metadata.
+const (
+	EnvelopeV1            uint = 1
+	EnvelopeV2            uint = 2
+	LatestEnvelopeVersion      = EnvelopeV2
+)
+
+// Envelope is the request with the metadata used by the proxies.
+// The metadata is available since the second version.
+type Envelope struct {
+	Version uint `json:"version,omitempty"`
+	// Id is the unique message id, used to acknowledge and deduplicate the messages
+	Id      string `json:"id,omitempty"`
+	TraceId string `json:"trace_id,omitempty"`
+	// SpanId is the span of the sender in the trace, so the receiver's span is its child
+	SpanId string `json:"span_id,omitempty"`
+	// TraceFlags are the W3C trace flags as two hex characters, for example '01' if the trace is sampled.
+	// Empty means sampled, like the envelopes sent before the flags.
+	TraceFlags string `json:"trace_flags,omitempty"`
+	Priority   int    `json:"priority,omitempty"`
+	// Timestamp is the unix time in milliseconds when the envelope was encoded first
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// Ttl is the time to live in milliseconds after the Timestamp. Zero means no limit
+	Ttl uint64 `json:"ttl,omitempty"`
+	// Ack is set by the client that requires at-least-once delivery
+	Ack bool `json:"ack,omitempty"`
+	// Hops is the amount of the proxy instances that forwarded the envelope to each other
+	Hops uint `json:"hops,omitempty"`
+	// Principal is the authenticated caller. It's set by the proxy, never by the client
+	Principal string `json:"-"`
+	Request
+}
+
+// NewEnvelope wraps the request into the latest envelope
+func NewEnvelope(req *Request) *Envelope {
+	return &Envelope{Version: LatestEnvelopeVersion, Request: *req}
+}
+
+// DecodeEnvelope parses the message of any supported version.
+// The message without a version is the raw request of the first version.
+func DecodeEnvelope(data []byte) (*Envelope, error) {
+	return decodeEnvelope(jsonCodec{}, data)
+}
+
+// decodeEnvelope parses the message encoded by the codec
+func decodeEnvelope(codec Codec, data []byte) (*Envelope, error) {
+	var envelope Envelope
+	if err := codec.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("%s.Unmarshal: %w", codec.Name(), err)
+	}
+
+	if envelope.Version == 0 {
+		envelope.Version = EnvelopeV1
+	}
+	if envelope.Version > LatestEnvelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", envelope.Version)
+	}
+	if len(envelope.Command) == 0 {
+		return nil, fmt.Errorf("missing command")
+	}
+	if envelope.Parameters == nil {
+		envelope.Parameters = map[string]interface{}{}
+	}
+
+	return &envelope, nil
+}
+
+// Encode the envelope in the given version.
+// The first version drops the metadata, so the old clients and services could read it.
+func (envelope *Envelope) Encode(version uint) ([]byte, error) {
+	return envelope.encode(jsonCodec{}, version)
+}
+
+// encode the envelope in the given version with the codec
+func (envelope *Envelope) encode(codec Codec, version uint) ([]byte, error) {
+	switch version {
+	case EnvelopeV1:
+		data, err := codec.Marshal(&envelope.Request)
+		if err != nil {
+			return nil, fmt.Errorf("%s.Marshal: %w", codec.Name(), err)
+		}
+		return data, nil
+	case EnvelopeV2:
+		copied := *envelope
+		copied.Version = EnvelopeV2
+		if copied.Timestamp == 0 {
+			copied.Timestamp = time.Now().UnixMilli()
+		}
+		data, err := codec.Marshal(&copied)
+		if err != nil {
+			return nil, fmt.Errorf("%s.Marshal: %w", codec.Name(), err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported envelope version %d", version)
+	}
+}
+
+// Expired returns true if the time to live of the envelope passed
+func (envelope *Envelope) Expired(now time.Time) bool {
+	if envelope.Ttl == 0 || envelope.Timestamp == 0 {
+		return false
+	}
+	return now.UnixMilli() > envelope.Timestamp+int64(envelope.Ttl)
+}
+
+// NewId returns the random message id
+func NewId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		// the crypto source never fails on the supported platforms
+		panic(fmt.Sprintf("rand.Read: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
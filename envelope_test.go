@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEnvelopeVersions checks that the first version is decoded as the raw request,
+// and the metadata is dropped when the envelope is encoded for the old clients
+func TestEnvelopeVersions(t *testing.T) {
+	raw, err := DecodeEnvelope([]byte(`{"command":"ping"}`))
+	if err != nil {
+		t.Fatalf("DecodeEnvelope: %v", err)
+	}
+	if raw.Version != EnvelopeV1 || raw.Command != "ping" || raw.Parameters == nil {
+		t.Fatalf("decoded the raw request as %+v", raw)
+	}
+
+	envelope := policyRequest("ping", map[string]interface{}{"user": "alice"})
+	envelope.Id = "id-1"
+	envelope.Principal = "alice"
+	data, err := envelope.Encode(LatestEnvelopeVersion)
+	if err != nil {
+		t.Fatalf("envelope.Encode: %v", err)
+	}
+	decoded, err := DecodeEnvelope(data)
+	if err != nil {
+		t.Fatalf("DecodeEnvelope: %v", err)
+	}
+	if decoded.Version != EnvelopeV2 || decoded.Id != "id-1" || decoded.Timestamp == 0 || decoded.Principal != "" {
+		t.Fatalf("decoded %+v", decoded)
+	}
+	if envelope.Timestamp != 0 {
+		t.Fatalf("encoding changed the envelope")
+	}
+
+	data, err = envelope.Encode(EnvelopeV1)
+	if err != nil {
+		t.Fatalf("envelope.Encode: %v", err)
+	}
+	if strings.Contains(string(data), "id-1") || strings.Contains(string(data), "version") {
+		t.Fatalf("the first version has the metadata: %s", data)
+	}
+	if _, err := envelope.Encode(LatestEnvelopeVersion + 1); err == nil {
+		t.Fatalf("encoded the unsupported version")
+	}
+}
+
+// TestDecodeEnvelopeRejects checks the invalid messages
+func TestDecodeEnvelopeRejects(t *testing.T) {
+	messages := []string{
+		`not json`,
+		`{"parameters":{}}`,
+		`{"version":3,"command":"ping"}`,
+	}
+	for _, message := range messages {
+		if _, err := DecodeEnvelope([]byte(message)); err == nil {
+			t.Fatalf("decoded '%s'", message)
+		}
+	}
+}
+
+// TestEnvelopeExpired checks the time to live
+func TestEnvelopeExpired(t *testing.T) {
+	now := time.Now()
+	envelope := &Envelope{Timestamp: now.UnixMilli(), Ttl: 1000}
+	if envelope.Expired(now.Add(500 * time.Millisecond)) {
+		t.Fatalf("expired before the ttl")
+	}
+	if !envelope.Expired(now.Add(2 * time.Second)) {
+		t.Fatalf("not expired after the ttl")
+	}
+	if (&Envelope{Timestamp: now.UnixMilli()}).Expired(now.Add(time.Hour)) {
+		t.Fatalf("expired without the ttl")
+	}
+	if NewId() == NewId() || len(NewId()) != 32 {
+		t.Fatalf("the ids are not unique")
+	}
+}
@@ -0,0 +1,330 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultDedupLimit is the maximum amount of the cached replies
+const DefaultDedupLimit = 100000
+
+// DedupCache remembers the replies by the message key, for example the ScopedId,
+// so the retried message gets the same reply without reaching the destination again.
+// The cache keeps at most the limit of the replies within the memory budget,
+// the oldest replies are evicted first.
+// The expired replies are purged as the new replies are added.
+type DedupCache struct {
+	ttl       time.Duration
+	limit     int
+	budget    uint64
+	used      uint64
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List
+	lastPurge time.Time
+	evicted   uint64
+	now       func() time.Time
+}
+
+type dedupEntry struct {
+	Reply   *Reply    `json:"reply"`
+	Expires time.Time `json:"expires"`
+	id      string
+	size    uint64
+}
+
+// replySize returns the approximate size of the reply in bytes
+func replySize(reply *Reply) uint64 {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return 0
+	}
+	return uint64(len(data))
+}
+
+// NewDedupCache returns the cache that keeps the replies for the ttl
+func NewDedupCache(ttl time.Duration) *DedupCache {
+	return &DedupCache{
+		ttl:     ttl,
+		limit:   DefaultDedupLimit,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// WithLimit sets the maximum amount of the cached replies
+func (cache *DedupCache) WithLimit(limit int) *DedupCache {
+	if limit > 0 {
+		cache.limit = limit
+	}
+	return cache
+}
+
+// WithBudget limits the size of the cached replies in bytes. Zero means no limit
+func (cache *DedupCache) WithBudget(budget uint64) *DedupCache {
+	cache.budget = budget
+	return cache
+}
+
+// MemoryUsage returns the approximate size of the cached replies in bytes
+func (cache *DedupCache) MemoryUsage() uint64 {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.used
+}
+
+// remove the element. Must be called with the lock
+func (cache *DedupCache) remove(element *list.Element) {
+	entry := cache.order.Remove(element).(*dedupEntry)
+	delete(cache.entries, entry.id)
+	cache.used -= entry.size
+}
+
+// Get the copy of the reply of the message
+func (cache *DedupCache) Get(id string) (*Reply, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*dedupEntry)
+	if cache.now().After(entry.Expires) {
+		return nil, false
+	}
+	return entry.Reply.Copy(), true
+}
+
+// Put the copy of the reply of the message
+func (cache *DedupCache) Put(id string, reply *Reply) {
+	now := cache.now()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if now.Sub(cache.lastPurge) >= cache.ttl || len(cache.entries) >= cache.limit {
+		cache.purge(now)
+	}
+	if element, ok := cache.entries[id]; ok {
+		cache.remove(element)
+	}
+	size := replySize(reply)
+	if cache.budget > 0 && size > cache.budget {
+		return
+	}
+	for len(cache.entries) >= cache.limit || (cache.budget > 0 && cache.used+size > cache.budget) {
+		cache.remove(cache.order.Front())
+		cache.evicted++
+	}
+	cache.entries[id] = cache.order.PushBack(&dedupEntry{Reply: reply.Copy(), Expires: now.Add(cache.ttl), id: id, size: size})
+	cache.used += size
+}
+
+// Purge removes the expired replies. Returns the amount of the removed replies
+func (cache *DedupCache) Purge() int {
+	now := cache.now()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.purge(now)
+}
+
+// purge removes the expired replies from the front, since they are ordered by the expiration.
+// Must be called with the lock.
+func (cache *DedupCache) purge(now time.Time) int {
+	cache.lastPurge = now
+	removed := 0
+	for element := cache.order.Front(); element != nil; element = cache.order.Front() {
+		entry := element.Value.(*dedupEntry)
+		if !now.After(entry.Expires) {
+			break
+		}
+		cache.remove(element)
+		removed++
+	}
+	return removed
+}
+
+// Len returns the amount of the cached replies
+func (cache *DedupCache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return len(cache.entries)
+}
+
+// Evicted returns the amount of the replies evicted over the limit or the budget before they expired
+func (cache *DedupCache) Evicted() uint64 {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.evicted
+}
+
+// Snapshot returns the cached replies, so they are saved by the StateStore
+func (cache *DedupCache) Snapshot() ([]byte, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entries := make(map[string]*dedupEntry, len(cache.entries))
+	for id, element := range cache.entries {
+		entries[id] = element.Value.(*dedupEntry)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	return data, nil
+}
+
+// Restore the cached replies from the snapshot
+func (cache *DedupCache) Restore(data []byte) error {
+	entries := make(map[string]*dedupEntry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	restored := make([]*dedupEntry, 0, len(entries))
+	for id, entry := range entries {
+		if entry == nil || entry.Reply == nil {
+			continue
+		}
+		entry.id = id
+		entry.size = replySize(entry.Reply)
+		restored = append(restored, entry)
+	}
+	sort.Slice(restored, func(i, j int) bool {
+		return restored[i].Expires.Before(restored[j].Expires)
+	})
+	if len(restored) > cache.limit {
+		restored = restored[len(restored)-cache.limit:]
+	}
+
+	cache.mu.Lock()
+	cache.entries = make(map[string]*list.Element, len(restored))
+	cache.order = list.New()
+	cache.used = 0
+	for _, entry := range restored {
+		cache.entries[entry.id] = cache.order.PushBack(entry)
+		cache.used += entry.size
+	}
+	for cache.budget > 0 && cache.used > cache.budget {
+		cache.remove(cache.order.Front())
+	}
+	cache.mu.Unlock()
+	return nil
+}
+
+// ExactlyOnce forwards the critical commands exactly once.
+// The messages are journaled until acknowledged, the retries of the client are
+// answered from the dedup cache, and the concurrent duplicates wait for the first one.
+//
+// The messages are keyed by their ScopedId, so the clients that reuse the same id
+// never get each other's replies or replace each other's journaled messages.
+// The destination gets the message with the ScopedId, and the journal redelivers it with the same id,
+// so the destination must treat the message id as the idempotency key.
+// Use ExactlyOnce.Redeliver rather than Journal.Redeliver, so the redelivered replies are cached.
+type ExactlyOnce struct {
+	critical map[string]struct{}
+	journal  *Journal
+	cache    *DedupCache
+
+	mu       sync.Mutex
+	inFlight map[string]chan struct{}
+}
+
+// NewExactlyOnce returns the exactly-once forwarding of the critical commands
+func NewExactlyOnce(critical []string, journal *Journal, cache *DedupCache) *ExactlyOnce {
+	exactlyOnce := &ExactlyOnce{
+		critical: make(map[string]struct{}, len(critical)),
+		journal:  journal,
+		cache:    cache,
+		inFlight: make(map[string]chan struct{}),
+	}
+	for _, command := range critical {
+		exactlyOnce.critical[command] = struct{}{}
+	}
+	return exactlyOnce
+}
+
+// Critical returns true if the command is forwarded exactly once
+func (exactlyOnce *ExactlyOnce) Critical(command string) bool {
+	_, ok := exactlyOnce.critical[command]
+	return ok
+}
+
+// Middleware forwards the critical commands exactly once.
+// The critical commands must have the message id set by the client.
+func (exactlyOnce *ExactlyOnce) Middleware() Middleware {
+	return func(next Handler) Handler {
+		journaled := exactlyOnce.journal.Middleware()(next)
+
+		return func(req *Envelope) *Reply {
+			if !exactlyOnce.Critical(req.Command) {
+				return next(req)
+			}
+			if len(req.Id) == 0 {
+				return Fail(fmt.Sprintf("'%s' command requires the message id", req.Command))
+			}
+
+			req.Ack = true
+			return exactlyOnce.forward(ScopedId(req), req, journaled)
+		}
+	}
+}
+
+// Redeliver sends the journaled messages again, like Journal.Redeliver.
+// The successful replies are cached, so the retry of the client doesn't reach the destination again.
+// The redelivery and the retry of the client with the same id are never sent at the same time.
+func (exactlyOnce *ExactlyOnce) Redeliver(ctx context.Context, handler Handler, interval time.Duration, timeout time.Duration) {
+	exactlyOnce.journal.Redeliver(ctx, func(req *Envelope) *Reply {
+		// the journaled message already has the scoped id
+		return exactlyOnce.forward(req.Id, req, handler)
+	}, interval, timeout)
+}
+
+// forward sends the message once, unless the reply of the key is cached or the message with the same key is in flight
+func (exactlyOnce *ExactlyOnce) forward(key string, req *Envelope, send Handler) *Reply {
+	for {
+		if reply, ok := exactlyOnce.cache.Get(key); ok {
+			return reply
+		}
+
+		exactlyOnce.mu.Lock()
+		wait, ok := exactlyOnce.inFlight[key]
+		if !ok {
+			done := make(chan struct{})
+			exactlyOnce.inFlight[key] = done
+			exactlyOnce.mu.Unlock()
+
+			reply := send(req)
+			if reply == nil {
+				reply = Fail(fmt.Sprintf("no reply to '%s'", req.Command))
+			}
+			if reply.IsOK() {
+				exactlyOnce.cache.Put(key, reply)
+			}
+
+			exactlyOnce.mu.Lock()
+			delete(exactlyOnce.inFlight, key)
+			exactlyOnce.mu.Unlock()
+			close(done)
+			return reply
+		}
+		exactlyOnce.mu.Unlock()
+
+		// the duplicate waits for the first message, then reads its reply from the cache
+		<-wait
+		if _, ok := exactlyOnce.cache.Get(key); !ok {
+			return Fail("the first message with the same id failed")
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// payment returns the critical message of the principal with the client id
+func payment(principal string, id string) *Envelope {
+	req := NewEnvelope(&Request{Command: "pay", Parameters: map[string]interface{}{}})
+	req.Id = id
+	req.Principal = principal
+	return req
+}
+
+// TestExactlyOnceDuplicatesGetOwnReplies sends the concurrent duplicates through the middleware
+// that writes into the reply, like the journal does. Run with -race.
+func TestExactlyOnceDuplicatesGetOwnReplies(t *testing.T) {
+	var calls int32
+	destination := func(req *Envelope) *Reply {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return Ok(map[string]interface{}{"balance": 100})
+	}
+	exactlyOnce := NewExactlyOnce([]string{"pay"}, NewJournal(0), NewDedupCache(time.Minute))
+	stamp := func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			reply := next(req)
+			reply.Parameters["stamp"] = req.Parameters["caller"]
+			return reply
+		}
+	}
+	handler := Wrap(destination, stamp, exactlyOnce.Middleware())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := payment("alice", "payment-1")
+			req.Parameters["caller"] = i
+			reply := handler(req)
+			if !reply.IsOK() {
+				t.Errorf("duplicate %d failed: %s", i, reply.Message)
+				return
+			}
+			if reply.Parameters["stamp"] != i {
+				t.Errorf("duplicate %d got the reply of '%v'", i, reply.Parameters["stamp"])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("destination called %d times, expected once", calls)
+	}
+	cached, _ := exactlyOnce.cache.Get(ScopedId(payment("alice", "payment-1")))
+	if _, ok := cached.Parameters["stamp"]; ok {
+		t.Fatalf("the cached reply was changed by the caller")
+	}
+}
+
+// TestExactlyOnceKeysByPrincipal checks that the clients reusing the same id don't share the replies
+func TestExactlyOnceKeysByPrincipal(t *testing.T) {
+	var ids sync.Map
+	destination := func(req *Envelope) *Reply {
+		ids.Store(req.Id, req.Principal)
+		return Ok(map[string]interface{}{"owner": req.Principal})
+	}
+	exactlyOnce := NewExactlyOnce([]string{"pay"}, NewJournal(0), NewDedupCache(time.Minute))
+	handler := Wrap(destination, exactlyOnce.Middleware())
+
+	for _, principal := range []string{"alice", "bob", "alice"} {
+		reply := handler(payment(principal, "shared-id"))
+		if reply.Parameters["owner"] != principal {
+			t.Fatalf("'%s' got the reply of '%v'", principal, reply.Parameters["owner"])
+		}
+		if reply.Parameters[AckIdParam] != "shared-id" {
+			t.Fatalf("the client got the ack id '%v' instead of its own", reply.Parameters[AckIdParam])
+		}
+	}
+
+	forwarded := 0
+	ids.Range(func(id interface{}, principal interface{}) bool {
+		forwarded++
+		if id == "shared-id" {
+			t.Errorf("the destination got the client id of '%v' unscoped", principal)
+		}
+		return true
+	})
+	if forwarded != 2 {
+		t.Fatalf("the destination got %d distinct ids, expected one per principal", forwarded)
+	}
+}
+
+// TestExactlyOnceNilReply checks that the missing reply fails instead of panicking
+func TestExactlyOnceNilReply(t *testing.T) {
+	exactlyOnce := NewExactlyOnce([]string{"pay"}, NewJournal(0), NewDedupCache(time.Minute))
+	handler := Wrap(func(req *Envelope) *Reply { return nil }, exactlyOnce.Middleware())
+
+	if reply := handler(payment("alice", "payment-3")); reply == nil || reply.IsOK() {
+		t.Fatalf("the missing reply was not failed: %v", reply)
+	}
+	if exactlyOnce.journal.Len() != 1 {
+		t.Fatalf("the unanswered message is not kept for the redelivery")
+	}
+}
+
+// TestExactlyOnceRedeliveryFillsCache checks that the retry of the client after the redelivery
+// doesn't reach the destination again, and that the redelivered message keeps its principal
+func TestExactlyOnceRedeliveryFillsCache(t *testing.T) {
+	var calls int32
+	var principals sync.Map
+	destination := func(req *Envelope) *Reply {
+		atomic.AddInt32(&calls, 1)
+		principals.Store(req.Id, req.Principal)
+		return Ok(nil)
+	}
+	journal := NewJournal(0)
+	exactlyOnce := NewExactlyOnce([]string{"pay"}, journal, NewDedupCache(time.Minute))
+
+	// the message was journaled, but the proxy restarted before the destination replied
+	lost := payment("alice", "payment-2")
+	lost.Id = ScopedId(lost)
+	journal.Append(lost)
+	data, err := journal.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	journal = NewJournal(0)
+	if err := journal.Restore(data); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	exactlyOnce = NewExactlyOnce([]string{"pay"}, journal, exactlyOnce.cache)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exactlyOnce.Redeliver(ctx, destination, time.Millisecond, 0)
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); journal.Len() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("the message was not redelivered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if principal, _ := principals.Load(lost.Id); principal != "alice" {
+		t.Fatalf("the redelivered message has the principal '%v'", principal)
+	}
+	if reply := Wrap(destination, exactlyOnce.Middleware())(payment("alice", "payment-2")); !reply.IsOK() {
+		t.Fatalf("retry failed: %s", reply.Message)
+	}
+	if calls != 1 {
+		t.Fatalf("destination called %d times, expected once", calls)
+	}
+}
+
+// TestJournalDropsOldestOverBudget checks that the budget drops the messages in the order they were sent
+func TestJournalDropsOldestOverBudget(t *testing.T) {
+	now := time.Now()
+	journal := NewJournal(0)
+	journal.now = func() time.Time { return now }
+	journal.Budget = 4 * uint64(payment("alice", "m0").Size())
+
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Second)
+		journal.Append(payment("alice", fmt.Sprintf("m%d", i)))
+		if i == 1 {
+			// only m0 is due, the resent message becomes the latest
+			now = now.Add(time.Second)
+			if due := journal.Due(1500 * time.Millisecond); len(due) != 1 || due[0].Id != "m0" {
+				t.Fatalf("due %v, expected m0", due)
+			}
+		}
+	}
+	if journal.Len() != 4 || journal.Dropped() != 1 {
+		t.Fatalf("len %d, dropped %d over the budget of 4 messages", journal.Len(), journal.Dropped())
+	}
+	if journal.Ack("m1") != 0 {
+		t.Fatalf("the oldest sent message m1 was kept")
+	}
+	if journal.Ack("m0", "m2", "m3", "m4") != 4 {
+		t.Fatalf("the latest sent messages were dropped")
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// The experiment arms
+const (
+	ControlArm   = "control"
+	AlternateArm = "alternate"
+)
+
+// ExperimentArmParam is the reply parameter that has the experiment arm
+const ExperimentArmParam = "experiment_arm"
+
+// Experiment routes the deterministic subset of the clients of the destination to the alternate destination.
+// The client is identified by its authenticated principal, so it can't pick the arm.
+// Set it to the Router with SetExperiments, then the replies are tagged with the arm.
+type Experiment struct {
+	Name string `json:"name" yaml:"name"`
+	// Key is the request parameter that identifies the client without the principal. Optional
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// Percent of the clients routed to the alternate destination
+	Percent     uint   `json:"percent" yaml:"percent"`
+	Destination string `json:"destination" yaml:"destination"`
+	Alternate   string `json:"alternate" yaml:"alternate"`
+}
+
+// Validate the experiment parameters
+func (experiment *Experiment) Validate() error {
+	if len(experiment.Name) == 0 {
+		return fmt.Errorf("experiment has no name")
+	}
+	if experiment.Percent > 100 {
+		return fmt.Errorf("experiment '%s' percent %d is over 100", experiment.Name, experiment.Percent)
+	}
+	if len(experiment.Destination) == 0 || len(experiment.Alternate) == 0 {
+		return fmt.Errorf("experiment '%s' requires destination and alternate", experiment.Name)
+	}
+	return nil
+}
+
+// Arm returns the experiment arm of the client.
+// The same client always gets the same arm.
+// The experiment name is the part of the hash, so different experiments split the clients differently.
+// The requests without the principal and the key go to the control arm.
+func (experiment *Experiment) Arm(req *Envelope) string {
+	var value interface{} = req.Principal
+	if len(req.Principal) == 0 {
+		param, ok := req.Parameters[experiment.Key]
+		if len(experiment.Key) == 0 || !ok {
+			return ControlArm
+		}
+		value = param
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(experiment.Name))
+	// the separator keeps the name 'ab' with the client 'c' apart from the name 'a' with the client 'bc'
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write([]byte(fmt.Sprint(value)))
+	if uint(hash.Sum32()%100) < experiment.Percent {
+		return AlternateArm
+	}
+	return ControlArm
+}
+
+// Route returns the destination of the request and the experiment arm
+func (experiment *Experiment) Route(req *Envelope) (string, string) {
+	arm := experiment.Arm(req)
+	if arm == AlternateArm {
+		return experiment.Alternate, arm
+	}
+	return experiment.Destination, arm
+}
+
+// Tag the reply with the experiment arm
+func (experiment *Experiment) Tag(reply *Reply, arm string) {
+	if reply == nil {
+		return
+	}
+	if reply.Parameters == nil {
+		reply.Parameters = map[string]interface{}{}
+	}
+	reply.Parameters[ExperimentArmParam] = arm
+}
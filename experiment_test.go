@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestRouterSplitsExperimentByPrincipal checks that the experiment of the destination splits the principals,
+// and the client can't pick the arm with the parameter
+func TestRouterSplitsExperimentByPrincipal(t *testing.T) {
+	named := func(name string) DestinationTransport {
+		return NewHandlerDestination(func(req *Envelope) *Reply {
+			return Ok(map[string]interface{}{"destination": name})
+		})
+	}
+	router, err := NewRouter(map[string]DestinationTransport{"v1": named("v1"), "v2": named("v2")}, nil, nil, "v1")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	experiment := Experiment{Name: "checkout", Key: "client", Percent: 30, Destination: "v1", Alternate: "v2"}
+	if err := router.SetExperiments([]Experiment{experiment, experiment}); err == nil {
+		t.Fatalf("two experiments of the same destination were set")
+	}
+	if err := router.SetExperiments([]Experiment{{Name: "typo", Percent: 30, Destination: "v1", Alternate: "v3"}}); err == nil {
+		t.Fatalf("the experiment of the unknown destination was set")
+	}
+	if err := router.SetExperiments([]Experiment{experiment}); err != nil {
+		t.Fatalf("SetExperiments: %v", err)
+	}
+	handler := router.Handler()
+
+	alternate := 0
+	for i := 0; i < 1000; i++ {
+		req := NewEnvelope(&Request{Command: "pay", Parameters: map[string]interface{}{"client": "same"}})
+		req.Principal = fmt.Sprintf("user-%d", i)
+		reply := handler(req)
+		arm, _ := reply.Parameters[ExperimentArmParam].(string)
+		expected := "v1"
+		if arm == AlternateArm {
+			expected = "v2"
+			alternate++
+		} else if arm != ControlArm {
+			t.Fatalf("the reply is tagged with '%s'", arm)
+		}
+		if reply.Parameters["destination"] != expected {
+			t.Fatalf("the %s arm reached '%v'", arm, reply.Parameters["destination"])
+		}
+		if again := handler(req); again.Parameters[ExperimentArmParam] != arm {
+			t.Fatalf("'%s' moved from the %s arm", req.Principal, arm)
+		}
+	}
+	if alternate < 200 || alternate > 400 {
+		t.Fatalf("%d of 1000 principals got the alternate of 30 percent", alternate)
+	}
+}
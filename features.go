@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"sort"
+	"sync"
+)
+
+// FeatureFlags are the features of the proxy toggled at runtime.
+// The clients learn them in the handshake, and degrade gracefully instead of sending
+// the requests that the proxy would reject.
+type FeatureFlags struct {
+	mu    sync.RWMutex
+	flags map[string]bool
+}
+
+// NewFeatureFlags returns the flags with the initial state
+func NewFeatureFlags(flags map[string]bool) *FeatureFlags {
+	copied := make(map[string]bool, len(flags))
+	for feature, enabled := range flags {
+		copied[feature] = enabled
+	}
+	return &FeatureFlags{flags: copied}
+}
+
+// Set the feature flag
+func (flags *FeatureFlags) Set(feature string, enabled bool) {
+	flags.mu.Lock()
+	flags.flags[feature] = enabled
+	flags.mu.Unlock()
+}
+
+// Enabled returns true if the feature is on. The unknown features are off
+func (flags *FeatureFlags) Enabled(feature string) bool {
+	flags.mu.RLock()
+	defer flags.mu.RUnlock()
+
+	return flags.flags[feature]
+}
+
+// Snapshot returns the copy of the flags
+func (flags *FeatureFlags) Snapshot() map[string]bool {
+	flags.mu.RLock()
+	defer flags.mu.RUnlock()
+
+	copied := make(map[string]bool, len(flags.flags))
+	for feature, enabled := range flags.flags {
+		copied[feature] = enabled
+	}
+	return copied
+}
+
+// EnabledFeatures returns the sorted list of the enabled features
+func (flags *FeatureFlags) EnabledFeatures() []string {
+	flags.mu.RLock()
+	enabled := make([]string, 0, len(flags.flags))
+	for feature, on := range flags.flags {
+		if on {
+			enabled = append(enabled, feature)
+		}
+	}
+	flags.mu.RUnlock()
+
+	sort.Strings(enabled)
+	return enabled
+}
+
+// HandshakeHandler replies to the HandshakeCommand with the negotiated features and the current flags.
+// The enabled features are also offered as the capabilities of the proxy,
+// so the agreed capabilities are the ones both the client announced and the proxy has enabled.
+func (flags *FeatureFlags) HandshakeHandler(local Handshake) Handler {
+	return func(req *Envelope) *Reply {
+		current := local
+		current.Features = flags.Snapshot()
+		current.Capabilities = append(append([]string{}, local.Capabilities...), flags.EnabledFeatures()...)
+		return HandshakeHandler(current)(req)
+	}
+}
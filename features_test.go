@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestFeatureFlagsHandshake checks that the clients learn the current flags in the handshake,
+// and agree only on the enabled features
+func TestFeatureFlagsHandshake(t *testing.T) {
+	initial := map[string]bool{StreamingCapability: true, SubscribeCapability: false}
+	flags := NewFeatureFlags(initial)
+	initial[SubscribeCapability] = true
+	if flags.Enabled(SubscribeCapability) || flags.Enabled("unknown") {
+		t.Fatalf("the flags share the initial state")
+	}
+
+	local := DefaultHandshake()
+	local.Capabilities = []string{TracingCapability}
+	remote := DefaultHandshake()
+	remote.Capabilities = []string{TracingCapability, StreamingCapability, SubscribeCapability}
+	remote.Features = map[string]bool{SubscribeCapability: true}
+	req, err := remote.Request()
+	if err != nil {
+		t.Fatalf("remote.Request: %v", err)
+	}
+	handler := flags.HandshakeHandler(local)
+
+	agreed := func() Handshake {
+		reply := handler(NewEnvelope(req))
+		if !reply.IsOK() {
+			t.Fatalf("the handshake failed: %s", reply.Message)
+		}
+		handshake, err := HandshakeFromParameters(reply.Parameters)
+		if err != nil {
+			t.Fatalf("HandshakeFromParameters: %v", err)
+		}
+		return handshake
+	}
+
+	handshake := agreed()
+	if !reflect.DeepEqual(handshake.Capabilities, []string{TracingCapability, StreamingCapability}) {
+		t.Fatalf("agreed on %v", handshake.Capabilities)
+	}
+	if handshake.Enabled(SubscribeCapability) || !handshake.Enabled(StreamingCapability) {
+		t.Fatalf("the features of the remote side are used: %v", handshake.Features)
+	}
+
+	flags.Set(SubscribeCapability, true)
+	flags.Set(StreamingCapability, false)
+	handshake = agreed()
+	if !reflect.DeepEqual(handshake.Capabilities, []string{TracingCapability, SubscribeCapability}) {
+		t.Fatalf("agreed on %v after the flags changed", handshake.Capabilities)
+	}
+	if !reflect.DeepEqual(flags.EnabledFeatures(), []string{SubscribeCapability}) {
+		t.Fatalf("the enabled features are %v", flags.EnabledFeatures())
+	}
+	if len(local.Capabilities) != 1 {
+		t.Fatalf("the handler changed the local capabilities %v", local.Capabilities)
+	}
+}
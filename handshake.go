@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// HandshakeCommand is sent by the proxy when it connects to the next proxy in the chain
+const HandshakeCommand = "proxy.handshake"
+
+// JsonCodec is the default codec of the messages
+const JsonCodec = "json"
+
+// NoCompression is the default compression of the messages
+const NoCompression = "none"
+
+// The capabilities the clients announce in the handshake
+const (
+	StreamingCapability   = "streaming"
+	SubscribeCapability   = "subscribe"
+	BinaryFrameCapability = "binary-frames"
+	TracingCapability     = "tracing"
+	AcknowledgeCapability = "ack"
+)
+
+// Handshake is the list of the features that the proxy supports.
+// The chained proxies exchange it to agree on the features of the connection.
+// The lists are in the order of preference.
+type Handshake struct {
+	// Version of the service that sends the handshake
+	Version         string   `json:"version,omitempty"`
+	EnvelopeVersion uint     `json:"envelope_version"`
+	Codecs          []string `json:"codecs"`
+	Compressions    []string `json:"compressions"`
+	Auth            []string `json:"auth,omitempty"`
+	// MaxMessageSize in bytes, zero means no limit
+	MaxMessageSize uint64   `json:"max_message_size,omitempty"`
+	Capabilities   []string `json:"capabilities,omitempty"`
+	// Features are the flags of the proxy, so the clients don't use the disabled features.
+	// Only the proxy sets them, the features of the remote side are ignored.
+	Features map[string]bool `json:"features,omitempty"`
+}
+
+// DefaultHandshake returns the features that any proxy supports
+func DefaultHandshake() Handshake {
+	return Handshake{
+		EnvelopeVersion: LatestEnvelopeVersion,
+		Codecs:          []string{JsonCodec},
+		Compressions:    []string{NoCompression},
+	}
+}
+
+// Negotiate returns the features that are supported by both sides.
+// The preference order of the local side wins.
+// The version is the local version, so the remote side learns it from the reply.
+// Returns an error if the sides have no common codec.
+func Negotiate(local Handshake, remote Handshake) (Handshake, error) {
+	agreed := Handshake{
+		Version:         local.Version,
+		EnvelopeVersion: local.EnvelopeVersion,
+		Codecs:          intersect(local.Codecs, remote.Codecs),
+		Compressions:    intersect(local.Compressions, remote.Compressions),
+		Auth:            intersect(local.Auth, remote.Auth),
+		MaxMessageSize:  local.MaxMessageSize,
+		Capabilities:    intersect(local.Capabilities, remote.Capabilities),
+		Features:        local.Features,
+	}
+
+	if remote.EnvelopeVersion < agreed.EnvelopeVersion {
+		agreed.EnvelopeVersion = remote.EnvelopeVersion
+	}
+	if agreed.EnvelopeVersion == 0 {
+		return agreed, fmt.Errorf("no envelope version")
+	}
+	if len(agreed.Codecs) == 0 {
+		return agreed, fmt.Errorf("no common codec in %v and %v", local.Codecs, remote.Codecs)
+	}
+	if len(agreed.Compressions) == 0 {
+		agreed.Compressions = []string{NoCompression}
+	}
+	if remote.MaxMessageSize > 0 && (agreed.MaxMessageSize == 0 || remote.MaxMessageSize < agreed.MaxMessageSize) {
+		agreed.MaxMessageSize = remote.MaxMessageSize
+	}
+
+	return agreed, nil
+}
+
+// Codec returns the agreed codec
+func (handshake *Handshake) Codec() string {
+	if len(handshake.Codecs) == 0 {
+		return JsonCodec
+	}
+	return handshake.Codecs[0]
+}
+
+// Compression returns the agreed compression
+func (handshake *Handshake) Compression() string {
+	if len(handshake.Compressions) == 0 {
+		return NoCompression
+	}
+	return handshake.Compressions[0]
+}
+
+// Has returns true if the capability is in the handshake
+func (handshake *Handshake) Has(capability string) bool {
+	for _, has := range handshake.Capabilities {
+		if has == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled returns true if the feature flag is on
+func (handshake *Handshake) Enabled(feature string) bool {
+	return handshake.Features[feature]
+}
+
+// Request returns the handshake request to send to the next proxy
+func (handshake *Handshake) Request() (*Request, error) {
+	parameters, err := toParameters(handshake)
+	if err != nil {
+		return nil, fmt.Errorf("toParameters: %w", err)
+	}
+	return &Request{Command: HandshakeCommand, Parameters: parameters}, nil
+}
+
+// HandshakeFromParameters returns the handshake from the request or reply parameters
+func HandshakeFromParameters(parameters map[string]interface{}) (Handshake, error) {
+	var handshake Handshake
+	if err := fromParameters(parameters, &handshake); err != nil {
+		return handshake, fmt.Errorf("fromParameters: %w", err)
+	}
+	return handshake, nil
+}
+
+// HandshakeHandler replies to the HandshakeCommand with the negotiated features
+func HandshakeHandler(local Handshake) Handler {
+	return func(req *Envelope) *Reply {
+		remote, err := HandshakeFromParameters(req.Parameters)
+		if err != nil {
+			return Fail(fmt.Sprintf("HandshakeFromParameters: %v", err))
+		}
+		agreed, err := Negotiate(local, remote)
+		if err != nil {
+			return Fail(fmt.Sprintf("Negotiate: %v", err))
+		}
+		parameters, err := toParameters(agreed)
+		if err != nil {
+			return Fail(fmt.Sprintf("toParameters: %v", err))
+		}
+		return Ok(parameters)
+	}
+}
+
+// intersect returns the items of the first list that are in the second list
+func intersect(first []string, second []string) []string {
+	common := make([]string, 0, len(first))
+	for _, item := range first {
+		for _, other := range second {
+			if item == other {
+				common = append(common, item)
+				break
+			}
+		}
+	}
+	return common
+}
+
+// toParameters converts the structure into the message parameters
+func toParameters(value interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	var parameters map[string]interface{}
+	if err := json.Unmarshal(data, &parameters); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return parameters, nil
+}
+
+// fromParameters converts the message parameters into the structure
+func fromParameters(parameters map[string]interface{}, value interface{}) error {
+	data, err := json.Marshal(parameters)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestNegotiate checks that the local preference wins, and the lowest limits are agreed
+func TestNegotiate(t *testing.T) {
+	local := Handshake{
+		Version:         "1.2.0",
+		EnvelopeVersion: EnvelopeV2,
+		Codecs:          []string{"msgpack", JsonCodec},
+		Compressions:    []string{"gzip"},
+		MaxMessageSize:  1024,
+	}
+	remote := Handshake{
+		Version:         "1.0.0",
+		EnvelopeVersion: EnvelopeV1,
+		Codecs:          []string{JsonCodec, "msgpack"},
+		Compressions:    []string{"zstd"},
+		MaxMessageSize:  512,
+	}
+
+	agreed, err := Negotiate(local, remote)
+	if err != nil {
+		t.Fatalf("Negotiate: %v", err)
+	}
+	if agreed.Version != "1.2.0" || agreed.EnvelopeVersion != EnvelopeV1 || agreed.MaxMessageSize != 512 {
+		t.Fatalf("agreed on %+v", agreed)
+	}
+	if agreed.Codec() != "msgpack" || agreed.Compression() != NoCompression {
+		t.Fatalf("agreed on '%s' codec and '%s' compression", agreed.Codec(), agreed.Compression())
+	}
+
+	remote.Codecs = []string{"protobuf"}
+	if _, err := Negotiate(local, remote); err == nil {
+		t.Fatalf("negotiated without the common codec")
+	}
+	if _, err := Negotiate(local, Handshake{Codecs: []string{JsonCodec}}); err == nil {
+		t.Fatalf("negotiated without the envelope version")
+	}
+}
+
+// TestHandshakeHandler checks the handshake request and reply round trip
+func TestHandshakeHandler(t *testing.T) {
+	remote := DefaultHandshake()
+	remote.Capabilities = []string{AcknowledgeCapability, StreamingCapability}
+	req, err := remote.Request()
+	if err != nil {
+		t.Fatalf("remote.Request: %v", err)
+	}
+	if req.Command != HandshakeCommand {
+		t.Fatalf("the handshake request is '%s'", req.Command)
+	}
+
+	local := DefaultHandshake()
+	local.Capabilities = []string{StreamingCapability}
+	reply := HandshakeHandler(local)(NewEnvelope(req))
+	if !reply.IsOK() {
+		t.Fatalf("the handshake failed: %s", reply.Message)
+	}
+	agreed, err := HandshakeFromParameters(reply.Parameters)
+	if err != nil {
+		t.Fatalf("HandshakeFromParameters: %v", err)
+	}
+	if !reflect.DeepEqual(agreed.Capabilities, []string{StreamingCapability}) || agreed.Has(AcknowledgeCapability) {
+		t.Fatalf("agreed on %v", agreed.Capabilities)
+	}
+
+	invalid := policyRequest(HandshakeCommand, map[string]interface{}{"codecs": "json"})
+	if reply := HandshakeHandler(local)(invalid); reply.IsOK() {
+		t.Fatalf("the invalid handshake is accepted")
+	}
+}
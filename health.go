@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HealthConfig is the setting of the active health checks
+type HealthConfig struct {
+	Interval time.Duration `json:"interval" yaml:"interval"`
+	Timeout  time.Duration `json:"timeout" yaml:"timeout"`
+	// FailThreshold is the number of the consecutive failed checks to fail over the instance
+	FailThreshold int `json:"fail_threshold" yaml:"fail_threshold"`
+	// RiseThreshold is the number of the consecutive passed checks to fail back the instance
+	RiseThreshold int `json:"rise_threshold" yaml:"rise_threshold"`
+}
+
+// DefaultHealthConfig is used for the unset fields of the config
+var DefaultHealthConfig = HealthConfig{
+	Interval:      5 * time.Second,
+	Timeout:       time.Second,
+	FailThreshold: 3,
+	RiseThreshold: 2,
+}
+
+// HealthStatus is the result of the health checks of the instance
+type HealthStatus struct {
+	Healthy   bool      `json:"healthy"`
+	Failures  int       `json:"failures"`
+	Passes    int       `json:"passes"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// HealthChecker pings each instance of the balancer periodically.
+// The instances failing the checks are taken out of the balancer, and returned back once they recover.
+type HealthChecker struct {
+	balancer  *Balancer
+	config    HealthConfig
+	mu        sync.Mutex
+	statuses  map[string]*HealthStatus
+	resources *Resources
+}
+
+// NewHealthChecker returns the health checker of the balancer's instances
+func NewHealthChecker(balancer *Balancer, config HealthConfig) *HealthChecker {
+	if config.Interval <= 0 {
+		config.Interval = DefaultHealthConfig.Interval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultHealthConfig.Timeout
+	}
+	if config.FailThreshold <= 0 {
+		config.FailThreshold = DefaultHealthConfig.FailThreshold
+	}
+	if config.RiseThreshold <= 0 {
+		config.RiseThreshold = DefaultHealthConfig.RiseThreshold
+	}
+
+	checker := &HealthChecker{
+		balancer: balancer,
+		config:   config,
+		statuses: make(map[string]*HealthStatus),
+	}
+	for _, instance := range balancer.Instances() {
+		checker.statuses[instance.Name] = &HealthStatus{Healthy: true}
+	}
+	return checker
+}
+
+// WithResources tracks the goroutines of the checks
+func (checker *HealthChecker) WithResources(resources *Resources) *HealthChecker {
+	checker.resources = resources
+	return checker
+}
+
+// Run checks the instances until the context is cancelled
+func (checker *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(checker.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		checker.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check pings all instances once in parallel
+func (checker *HealthChecker) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, instance := range checker.balancer.Instances() {
+		instance := instance
+		wg.Add(1)
+		// the ping is limited by the timeout, so the check outliving it twice is stuck
+		checker.resources.Go("health", "check "+instance.Name, 2*checker.config.Timeout, func() {
+			defer wg.Done()
+			checker.record(instance.Name, checker.ping(ctx, instance))
+		})
+	}
+	wg.Wait()
+}
+
+// ping sends the PingCommand to the instance
+func (checker *HealthChecker) ping(ctx context.Context, instance Instance) error {
+	ctx, cancel := context.WithTimeout(ctx, checker.config.Timeout)
+	defer cancel()
+
+	reply, err := instance.Transport.Send(ctx, NewEnvelope(&Request{Command: PingCommand}))
+	if err != nil {
+		return fmt.Errorf("Send: %w", err)
+	}
+	if reply == nil {
+		return fmt.Errorf("no reply to '%s'", PingCommand)
+	}
+	if !reply.IsOK() {
+		return fmt.Errorf("reply: %s", reply.Message)
+	}
+	return nil
+}
+
+// record the result of the check, and fail over or fail back the instance if the threshold is reached
+func (checker *HealthChecker) record(name string, err error) {
+	down := !checker.balancer.healthy(name)
+
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+
+	status, ok := checker.statuses[name]
+	if !ok {
+		status = &HealthStatus{Healthy: true}
+		checker.statuses[name] = status
+	}
+	status.LastCheck = time.Now()
+
+	if err != nil {
+		status.LastError = err.Error()
+		status.Failures++
+		status.Passes = 0
+		if status.Failures >= checker.config.FailThreshold {
+			status.Healthy = false
+		}
+	} else {
+		// the balancer marked the instance unhealthy after the failed requests,
+		// so it rises the same way as after the failed checks
+		if status.Healthy && down {
+			status.Healthy = false
+			status.Passes = 0
+		}
+		status.LastError = ""
+		status.Passes++
+		status.Failures = 0
+		if status.Passes >= checker.config.RiseThreshold {
+			status.Healthy = true
+		}
+	}
+
+	// the balancer also marks the instances unhealthy when the requests fail.
+	// The check below the threshold leaves it as it is.
+	if (err == nil) == status.Healthy {
+		_ = checker.balancer.MarkHealthy(name, status.Healthy)
+	}
+}
+
+// DestinationStatus returns the health of the instances by their names
+func (checker *HealthChecker) DestinationStatus() map[string]HealthStatus {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+
+	statuses := make(map[string]HealthStatus, len(checker.statuses))
+	for name, status := range checker.statuses {
+		statuses[name] = *status
+	}
+	return statuses
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// TestHealthCheckerThresholds checks that the instance fails over after the failed checks,
+// and fails back after the passed checks
+func TestHealthCheckerThresholds(t *testing.T) {
+	var down int32
+	flaky := funcTransport(func(req *Envelope) (*Reply, error) {
+		if req.Command != PingCommand {
+			return Fail("unexpected command"), nil
+		}
+		if atomic.LoadInt32(&down) == 1 {
+			return nil, fmt.Errorf("connection refused")
+		}
+		if atomic.LoadInt32(&down) == 2 {
+			return nil, nil
+		}
+		return Ok(nil), nil
+	})
+	balancer, err := NewBalancer(RoundRobin, []Instance{{Name: "flaky", Transport: flaky}})
+	if err != nil {
+		t.Fatalf("NewBalancer: %v", err)
+	}
+	checker := NewHealthChecker(balancer, HealthConfig{FailThreshold: 2, RiseThreshold: 2})
+	ctx := context.Background()
+
+	atomic.StoreInt32(&down, 1)
+	checker.Check(ctx)
+	if status := checker.DestinationStatus()["flaky"]; !status.Healthy || status.Failures != 1 || len(status.LastError) == 0 {
+		t.Fatalf("failed over below the threshold: %+v", status)
+	}
+	// the missing reply fails the check too
+	atomic.StoreInt32(&down, 2)
+	checker.Check(ctx)
+	if status := checker.DestinationStatus()["flaky"]; status.Healthy || balancer.healthy("flaky") {
+		t.Fatalf("not failed over after the threshold: %+v", status)
+	}
+
+	atomic.StoreInt32(&down, 0)
+	checker.Check(ctx)
+	if status := checker.DestinationStatus()["flaky"]; status.Healthy || status.Passes != 1 {
+		t.Fatalf("failed back below the threshold: %+v", status)
+	}
+	checker.Check(ctx)
+	if status := checker.DestinationStatus()["flaky"]; !status.Healthy || !balancer.healthy("flaky") || len(status.LastError) > 0 {
+		t.Fatalf("not failed back after the threshold: %+v", status)
+	}
+}
@@ -0,0 +1,341 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AckCommand is sent by the destination to acknowledge the processed messages.
+// The 'ids' parameter is the list of the message ids.
+const AckCommand = "proxy.ack"
+
+// AckIdParam is the reply parameter with the id of the journaled message
+const AckIdParam = "ack_id"
+
+// JournalEntry is the message waiting for the acknowledgement
+type JournalEntry struct {
+	Envelope *Envelope `json:"envelope"`
+	// Principal of the envelope, which is not in its json, so the redelivered message keeps it
+	Principal string    `json:"principal,omitempty"`
+	Sent      time.Time `json:"sent"`
+	Attempts  int       `json:"attempts"`
+	size      uint64
+	element   *list.Element
+}
+
+// ScopedId returns the id of the message that is unique to its principal and command.
+// The clients choose the message ids, so two clients could send the same id.
+// The scoped id keeps their messages apart in the journal, the dedup cache and the destination.
+// It's derived deterministically, so the retries of the client get the same scoped id.
+func ScopedId(envelope *Envelope) string {
+	sum := sha256.Sum256([]byte(envelope.Principal + "\x00" + envelope.Command + "\x00" + envelope.Id))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Journal keeps the messages until the destination acknowledges them.
+// The messages without the acknowledgement are redelivered.
+// Over the Budget, the oldest messages are dropped as if they exceeded MaxAttempts.
+type Journal struct {
+	// MaxAttempts of the delivery. Zero means no limit
+	MaxAttempts int
+	// Budget is the limit of the waiting messages in bytes. Zero means no limit
+	Budget uint64
+
+	mu      sync.Mutex
+	entries map[string]*JournalEntry
+	// order of the entries by the sent time, the oldest first
+	order       *list.List
+	used        uint64
+	dropped     uint64
+	deadLetters *json.Encoder
+	scrubber    *Scrubber
+	now         func() time.Time
+}
+
+// NewJournal returns an empty journal
+func NewJournal(maxAttempts int) *Journal {
+	return &Journal{
+		MaxAttempts: maxAttempts,
+		entries:     make(map[string]*JournalEntry),
+		order:       list.New(),
+		now:         time.Now,
+	}
+}
+
+// WithDeadLetters writes the messages dropped after MaxAttempts as the json lines.
+// The secrets are removed from the written messages by the scrubber.
+// The journaled messages themselves keep the parameters, since they are redelivered as is.
+func (journal *Journal) WithDeadLetters(w io.Writer, scrubber *Scrubber) *Journal {
+	journal.deadLetters = json.NewEncoder(w)
+	journal.scrubber = scrubber
+	return journal
+}
+
+// deadLetter writes the dropped message. Must be called with the lock
+func (journal *Journal) deadLetter(entry *JournalEntry) {
+	if journal.deadLetters == nil {
+		return
+	}
+	record := *entry
+	if journal.scrubber != nil {
+		envelope := *entry.Envelope
+		envelope.Parameters = journal.scrubber.Parameters(envelope.Parameters)
+		record.Envelope = &envelope
+	}
+	// the dead letters are the best effort, like the audit
+	_ = journal.deadLetters.Encode(&record)
+}
+
+// remove the message. Must be called with the lock
+func (journal *Journal) remove(id string, entry *JournalEntry) {
+	delete(journal.entries, id)
+	journal.order.Remove(entry.element)
+	journal.used -= entry.size
+}
+
+// drop the message that is not delivered anymore. Must be called with the lock
+func (journal *Journal) drop(id string, entry *JournalEntry) {
+	journal.remove(id, entry)
+	journal.dropped++
+	journal.deadLetter(entry)
+}
+
+// dropOldest drops the message sent the earliest. Must be called with the lock
+func (journal *Journal) dropOldest() {
+	if element := journal.order.Front(); element != nil {
+		entry := element.Value.(*JournalEntry)
+		journal.drop(entry.Envelope.Id, entry)
+	}
+}
+
+// add the entry as the latest sent. Must be called with the lock
+func (journal *Journal) add(entry *JournalEntry) {
+	journal.entries[entry.Envelope.Id] = entry
+	entry.element = journal.order.PushBack(entry)
+	journal.used += entry.size
+}
+
+// Append the message to the journal under its id.
+// If the message has no id, then it's generated.
+// If the journal is over the Budget, then the oldest messages are dropped.
+// Returns the message id.
+func (journal *Journal) Append(envelope *Envelope) string {
+	if len(envelope.Id) == 0 {
+		envelope.Id = NewId()
+	}
+	entry := &JournalEntry{
+		Envelope:  envelope,
+		Principal: envelope.Principal,
+		Sent:      journal.now(),
+		Attempts:  1,
+		size:      uint64(envelope.Size()),
+	}
+
+	journal.mu.Lock()
+	if previous, ok := journal.entries[envelope.Id]; ok {
+		journal.remove(envelope.Id, previous)
+	}
+	for journal.Budget > 0 && len(journal.entries) > 0 && journal.used+entry.size > journal.Budget {
+		journal.dropOldest()
+	}
+	journal.add(entry)
+	journal.mu.Unlock()
+
+	return envelope.Id
+}
+
+// Ack removes the messages from the journal.
+// Returns the amount of the removed messages.
+func (journal *Journal) Ack(ids ...string) int {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	removed := 0
+	for _, id := range ids {
+		if entry, ok := journal.entries[id]; ok {
+			journal.remove(id, entry)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Due returns the messages that were not acknowledged within the timeout.
+// The returned messages are counted as the new delivery attempt.
+// The messages over the MaxAttempts are dropped.
+func (journal *Journal) Due(timeout time.Duration) []*Envelope {
+	now := journal.now()
+
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	var due []*Envelope
+	for id, entry := range journal.entries {
+		if now.Sub(entry.Sent) < timeout {
+			continue
+		}
+		if journal.MaxAttempts > 0 && entry.Attempts >= journal.MaxAttempts {
+			journal.drop(id, entry)
+			continue
+		}
+		entry.Attempts++
+		entry.Sent = now
+		journal.order.MoveToBack(entry.element)
+		due = append(due, entry.Envelope)
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Timestamp < due[j].Timestamp
+	})
+	return due
+}
+
+// Len returns the amount of the messages waiting for the acknowledgement
+func (journal *Journal) Len() int {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return len(journal.entries)
+}
+
+// MemoryUsage returns the approximate size of the waiting messages in bytes
+func (journal *Journal) MemoryUsage() uint64 {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return journal.used
+}
+
+// Dropped returns the amount of the messages dropped after MaxAttempts or over the Budget
+func (journal *Journal) Dropped() uint64 {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return journal.dropped
+}
+
+// Middleware journals the messages of the clients that require the acknowledgement.
+// The message is passed to the next handler with its ScopedId, which the destination acknowledges.
+// The message is acknowledged when the next handler replies successfully.
+// Otherwise, it stays in the journal for the redelivery.
+// The reply has the message id of the client, so the client knows the proxy received the message.
+func (journal *Journal) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			if !req.Ack {
+				return next(req)
+			}
+			if len(req.Id) == 0 {
+				req.Id = NewId()
+			}
+
+			scoped := *req
+			scoped.Id = ScopedId(req)
+			journal.Append(&scoped)
+			reply := next(&scoped)
+			if reply == nil {
+				return Fail(fmt.Sprintf("no reply to '%s'", req.Command))
+			}
+			if reply.IsOK() {
+				journal.Ack(scoped.Id)
+			}
+			// the destination may share the reply
+			reply = reply.Copy()
+			if reply.Parameters == nil {
+				reply.Parameters = map[string]interface{}{}
+			}
+			reply.Parameters[AckIdParam] = req.Id
+			return reply
+		}
+	}
+}
+
+// AckHandler replies to the AckCommand of the destination
+func (journal *Journal) AckHandler() Handler {
+	return func(req *Envelope) *Reply {
+		raw, ok := req.Parameters["ids"].([]interface{})
+		if !ok {
+			return Fail("missing 'ids' parameter")
+		}
+		ids := make([]string, 0, len(raw))
+		for _, id := range raw {
+			ids = append(ids, fmt.Sprint(id))
+		}
+		return Ok(map[string]interface{}{"acknowledged": journal.Ack(ids...)})
+	}
+}
+
+// Redeliver sends the due messages to the handler every interval until the context is done.
+// The message is acknowledged when the handler replies successfully.
+func (journal *Journal) Redeliver(ctx context.Context, handler Handler, interval time.Duration, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, envelope := range journal.Due(timeout) {
+				if reply := handler(envelope); reply != nil && reply.IsOK() {
+					journal.Ack(envelope.Id)
+				}
+			}
+		}
+	}
+}
+
+// Snapshot returns the waiting messages, so they are saved by the StateStore
+func (journal *Journal) Snapshot() ([]byte, error) {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	data, err := json.Marshal(journal.entries)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	return data, nil
+}
+
+// Restore the waiting messages from the snapshot
+func (journal *Journal) Restore(data []byte) error {
+	entries := make(map[string]*JournalEntry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	restored := make([]*JournalEntry, 0, len(entries))
+	for id, entry := range entries {
+		if entry == nil || entry.Envelope == nil {
+			continue
+		}
+		entry.Envelope.Id = id
+		entry.Envelope.Principal = entry.Principal
+		entry.size = uint64(entry.Envelope.Size())
+		restored = append(restored, entry)
+	}
+	sort.Slice(restored, func(i, j int) bool {
+		return restored[i].Sent.Before(restored[j].Sent)
+	})
+
+	journal.mu.Lock()
+	journal.entries = make(map[string]*JournalEntry, len(restored))
+	journal.order = list.New()
+	journal.used = 0
+	for _, entry := range restored {
+		journal.add(entry)
+	}
+	for journal.Budget > 0 && journal.used > journal.Budget {
+		journal.dropOldest()
+	}
+	journal.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestJournalMiddleware checks that the failed messages stay in the journal for the redelivery,
+// and the client learns its message id
+func TestJournalMiddleware(t *testing.T) {
+	journal := NewJournal(0)
+	shared := Ok(nil)
+	failed := true
+	var received *Envelope
+	handler := Wrap(func(req *Envelope) *Reply {
+		received = req
+		if failed {
+			return Fail("destination is down")
+		}
+		return shared
+	}, journal.Middleware())
+
+	req := policyRequest("payment", nil)
+	req.Id = "client-1"
+	req.Ack = true
+	reply := handler(req)
+	if reply.IsOK() || reply.Parameters[AckIdParam] != "client-1" {
+		t.Fatalf("the middleware replied %v", reply)
+	}
+	if received.Id != ScopedId(req) || journal.Len() != 1 {
+		t.Fatalf("the message is not journaled under its scoped id")
+	}
+
+	// the retry of the client has the same scoped id
+	failed = false
+	if reply := handler(req); !reply.IsOK() || reply.Parameters[AckIdParam] != "client-1" {
+		t.Fatalf("the middleware replied %v", reply)
+	}
+	if journal.Len() != 0 {
+		t.Fatalf("the delivered message stays in the journal")
+	}
+	if _, ok := shared.Parameters[AckIdParam]; ok {
+		t.Fatalf("the shared reply of the destination is changed")
+	}
+
+	if reply := journal.AckHandler()(policyRequest(AckCommand, map[string]interface{}{"ids": []interface{}{"missing"}})); !reply.IsOK() || reply.Parameters["acknowledged"] != 0 {
+		t.Fatalf("the ack command replied %v", reply)
+	}
+}
+
+// TestJournalDueAndDeadLetters checks the redelivery attempts and the scrubbed dead letters
+func TestJournalDueAndDeadLetters(t *testing.T) {
+	now := time.Now()
+	var deadLetters bytes.Buffer
+	journal := NewJournal(2).WithDeadLetters(&deadLetters, NewScrubber())
+	journal.now = func() time.Time { return now }
+
+	id := journal.Append(policyRequest("login", map[string]interface{}{"password": "hunter2"}))
+	if due := journal.Due(time.Minute); len(due) != 0 {
+		t.Fatalf("%d messages are due before the timeout", len(due))
+	}
+	now = now.Add(2 * time.Minute)
+	if due := journal.Due(time.Minute); len(due) != 1 || due[0].Id != id {
+		t.Fatalf("the message is not due after the timeout")
+	}
+	now = now.Add(2 * time.Minute)
+	if due := journal.Due(time.Minute); len(due) != 0 || journal.Len() != 0 || journal.Dropped() != 1 {
+		t.Fatalf("the message is not dropped after the max attempts")
+	}
+	if !strings.Contains(deadLetters.String(), id) || strings.Contains(deadLetters.String(), "hunter2") {
+		t.Fatalf("the dead letter is %s", deadLetters.String())
+	}
+}
+
+// TestJournalBudgetAndSnapshot checks that the oldest messages are dropped over the budget,
+// and the waiting messages are restored with their principals
+func TestJournalBudgetAndSnapshot(t *testing.T) {
+	journal := NewJournal(0)
+	first := policyRequest("first", nil)
+	first.Principal = "alice"
+	journal.Append(first)
+	journal.Budget = journal.MemoryUsage() + 1
+	second := journal.Append(policyRequest("second", nil))
+	if journal.Len() != 1 || journal.Dropped() != 1 {
+		t.Fatalf("the oldest message is not dropped over the budget")
+	}
+
+	data, err := journal.Snapshot()
+	if err != nil {
+		t.Fatalf("journal.Snapshot: %v", err)
+	}
+	restored := NewJournal(0)
+	if err := restored.Restore(data); err != nil {
+		t.Fatalf("restored.Restore: %v", err)
+	}
+	if restored.Len() != 1 || restored.Ack(second) != 1 {
+		t.Fatalf("the waiting message is not restored")
+	}
+
+	journal = NewJournal(0)
+	journal.Append(first)
+	data, _ = journal.Snapshot()
+	if err := restored.Restore(data); err != nil {
+		t.Fatalf("restored.Restore: %v", err)
+	}
+	due := restored.Due(0)
+	if len(due) != 1 || due[0].Principal != "alice" {
+		t.Fatalf("the principal is not restored: %+v", due)
+	}
+}
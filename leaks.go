@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LeaksCommand returns the resources alive past their expected lifetime
+const LeaksCommand = "proxy.leaks"
+
+// The kinds of the tracked resources
+const (
+	GoroutineResource = "goroutine"
+	SocketResource    = "socket"
+)
+
+// DefaultRequestMaxAge is the expected lifetime of the goroutine handling one request
+const DefaultRequestMaxAge = time.Minute
+
+// Resource is the tracked goroutine or socket
+type Resource struct {
+	Id        uint64    `json:"id"`
+	Subsystem string    `json:"subsystem"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Created   time.Time `json:"created"`
+	// MaxAge is the expected lifetime. Zero means the resource lives as long as the proxy
+	MaxAge time.Duration `json:"max_age"`
+}
+
+// Resources accounts the goroutines and sockets created by the subsystems.
+// The resources alive past their lifetime are reported as the leaks,
+// helping to find the stuck destinations and the abandoned client sessions.
+//
+// The subsystems accept the resources by WithResources.
+// The nil resources track nothing, so the subsystems without them work as is.
+type Resources struct {
+	mu    sync.Mutex
+	next  uint64
+	alive map[uint64]*Resource
+	now   func() time.Time
+}
+
+// NewResources returns an empty accounting
+func NewResources() *Resources {
+	return &Resources{alive: make(map[uint64]*Resource), now: time.Now}
+}
+
+// Track the resource. Call the returned function when the resource is released
+func (resources *Resources) Track(subsystem string, kind string, name string, maxAge time.Duration) func() {
+	if resources == nil {
+		return func() {}
+	}
+
+	resources.mu.Lock()
+	resources.next++
+	id := resources.next
+	resources.alive[id] = &Resource{
+		Id:        id,
+		Subsystem: subsystem,
+		Kind:      kind,
+		Name:      name,
+		Created:   resources.now(),
+		MaxAge:    maxAge,
+	}
+	resources.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			resources.mu.Lock()
+			delete(resources.alive, id)
+			resources.mu.Unlock()
+		})
+	}
+}
+
+// Go runs the function in the tracked goroutine
+func (resources *Resources) Go(subsystem string, name string, maxAge time.Duration, fn func()) {
+	release := resources.Track(subsystem, GoroutineResource, name, maxAge)
+	go func() {
+		defer release()
+		fn()
+	}()
+}
+
+// Counts returns the amount of the alive resources by subsystem and kind
+func (resources *Resources) Counts() map[string]map[string]int {
+	if resources == nil {
+		return map[string]map[string]int{}
+	}
+	resources.mu.Lock()
+	defer resources.mu.Unlock()
+
+	counts := make(map[string]map[string]int)
+	for _, resource := range resources.alive {
+		if _, ok := counts[resource.Subsystem]; !ok {
+			counts[resource.Subsystem] = make(map[string]int)
+		}
+		counts[resource.Subsystem][resource.Kind]++
+	}
+	return counts
+}
+
+// Leaks returns the resources alive past their lifetime, the oldest first
+func (resources *Resources) Leaks() []Resource {
+	if resources == nil {
+		return nil
+	}
+	now := resources.now()
+
+	resources.mu.Lock()
+	var leaks []Resource
+	for _, resource := range resources.alive {
+		if resource.MaxAge > 0 && now.Sub(resource.Created) > resource.MaxAge {
+			leaks = append(leaks, *resource)
+		}
+	}
+	resources.mu.Unlock()
+
+	sort.Slice(leaks, func(i, j int) bool {
+		return leaks[i].Created.Before(leaks[j].Created)
+	})
+	return leaks
+}
+
+// Handler replies to the LeaksCommand
+func (resources *Resources) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		parameters, err := toParameters(map[string]interface{}{
+			"goroutines": runtime.NumGoroutine(),
+			"alive":      resources.Counts(),
+			"leaks":      resources.Leaks(),
+		})
+		if err != nil {
+			return Fail(fmt.Sprintf("toParameters: %v", err))
+		}
+		return Ok(parameters)
+	}
+}
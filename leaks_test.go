@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// TestResourcesLeaks checks that only the resources alive past their lifetime are reported
+func TestResourcesLeaks(t *testing.T) {
+	now := time.Now()
+	resources := NewResources()
+	resources.now = func() time.Time { return now }
+
+	releaseSession := resources.Track("websocket", SocketResource, "session", time.Minute)
+	releaseListener := resources.Track("tcp", SocketResource, "listener", 0)
+	defer releaseListener()
+	done := make(chan struct{})
+	stuck := make(chan struct{})
+	resources.Go("health", "check", time.Second, func() {
+		<-stuck
+		close(done)
+	})
+
+	if counts := resources.Counts(); counts["websocket"][SocketResource] != 1 || counts["health"][GoroutineResource] != 1 {
+		t.Fatalf("the alive resources are %v", counts)
+	}
+	now = now.Add(30 * time.Second)
+	leaks := resources.Leaks()
+	if len(leaks) != 1 || leaks[0].Subsystem != "health" {
+		t.Fatalf("the leaks are %+v", leaks)
+	}
+	now = now.Add(time.Minute)
+	if leaks := resources.Leaks(); len(leaks) != 2 {
+		t.Fatalf("the leaks are %+v", leaks)
+	}
+
+	releaseSession()
+	releaseSession()
+	close(stuck)
+	<-done
+	deadline := time.Now().Add(time.Second)
+	for len(resources.Leaks()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the released resources are reported: %+v", resources.Leaks())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	reply := resources.Handler()(policyRequest(LeaksCommand, nil))
+	if !reply.IsOK() || reply.Parameters["alive"] == nil {
+		t.Fatalf("the leaks command replied %v", reply)
+	}
+}
+
+// TestNilResources checks that the nil resources track nothing
+func TestNilResources(t *testing.T) {
+	var resources *Resources
+	done := make(chan struct{})
+	resources.Go("health", "check", time.Second, func() { close(done) })
+	<-done
+	resources.Track("tcp", SocketResource, "listener", 0)()
+	if len(resources.Counts()) != 0 || len(resources.Leaks()) != 0 {
+		t.Fatalf("the nil resources tracked the resources")
+	}
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The process exit codes, so the supervisors could distinguish the failures.
+// The codes follow the sysexits.h
+const (
+	ExitOk     = 0
+	ExitFatal  = 1
+	ExitSocket = 74
+	ExitConfig = 78
+)
+
+// The errors to wrap, so ExitCode returns the matching code
+var (
+	ErrConfig = errors.New("configuration error")
+	ErrSocket = errors.New("socket error")
+)
+
+// The systemd notification states
+const (
+	SdReady    = "READY=1"
+	SdStopping = "STOPPING=1"
+)
+
+// DefaultDrainTimeout is the time given to the in-flight requests on shutdown
+const DefaultDrainTimeout = 10 * time.Second
+
+// ExitCode returns the process exit code of the error
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOk
+	case errors.Is(err, ErrConfig):
+		return ExitConfig
+	case errors.Is(err, ErrSocket):
+		return ExitSocket
+	default:
+		return ExitFatal
+	}
+}
+
+// SdNotify sends the state to systemd.
+// If the process is not run by systemd, then it does nothing.
+func SdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if len(socket) == 0 {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("net.DialUnix('%s'): %w", socket, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("conn.Write: %w", err)
+	}
+	return nil
+}
+
+// Lifecycle runs the proxy under the process supervisor.
+// On SIGTERM or SIGINT, it drains the proxy then waits for Start to return.
+type Lifecycle struct {
+	// Start runs the proxy until the context is cancelled.
+	// Call SdNotify(SdReady) inside once the sockets are bound.
+	Start func(ctx context.Context) error
+	// Drain finishes the in-flight requests. Optional
+	Drain func(ctx context.Context) error
+	// DrainTimeout limits the Drain. Zero means DefaultDrainTimeout
+	DrainTimeout time.Duration
+}
+
+// Run the lifecycle and return the process exit code
+func (lifecycle *Lifecycle) Run() int {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	return ExitCode(lifecycle.run(ctx))
+}
+
+func (lifecycle *Lifecycle) run(signalled context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lifecycle.Start(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("lifecycle.Start: %w", err)
+		}
+		return nil
+	case <-signalled.Done():
+	}
+
+	_ = SdNotify(SdStopping)
+
+	var drainErr error
+	if lifecycle.Drain != nil {
+		timeout := lifecycle.DrainTimeout
+		if timeout == 0 {
+			timeout = DefaultDrainTimeout
+		}
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), timeout)
+		drainErr = lifecycle.Drain(drainCtx)
+		drainCancel()
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		return fmt.Errorf("lifecycle.Start: %w", err)
+	}
+	if drainErr != nil {
+		return fmt.Errorf("lifecycle.Drain: %w", drainErr)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// TestExitCode checks that the wrapped errors keep their exit codes
+func TestExitCode(t *testing.T) {
+	codes := map[error]int{
+		nil:                                     ExitOk,
+		fmt.Errorf("NewSource: %w", ErrConfig):  ExitConfig,
+		fmt.Errorf("net.Listen: %w", ErrSocket): ExitSocket,
+		errors.New("destination replied too slow"): ExitFatal,
+	}
+	for err, code := range codes {
+		if ExitCode(err) != code {
+			t.Fatalf("'%v' exit code is %d, expected %d", err, ExitCode(err), code)
+		}
+	}
+}
+
+// TestSdNotify checks that the state is sent to the systemd socket
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := SdNotify(SdReady); err != nil {
+		t.Fatalf("SdNotify without systemd: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("net.ListenUnixgram: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if err := SdNotify(SdReady); err != nil {
+		t.Fatalf("SdNotify: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != SdReady {
+		t.Fatalf("systemd received '%s', %v", buf[:n], err)
+	}
+}
+
+// TestLifecycleDrainsOnSignal checks that the proxy is drained before it's stopped
+func TestLifecycleDrainsOnSignal(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	var steps []string
+	started := make(chan struct{})
+	lifecycle := &Lifecycle{
+		Start: func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			steps = append(steps, "stopped")
+			return nil
+		},
+		Drain: func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("the drain has no timeout")
+			}
+			steps = append(steps, "drained")
+			return fmt.Errorf("requests in flight")
+		},
+	}
+
+	signalled, signal := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		signal()
+	}()
+	err := lifecycle.run(signalled)
+	if err == nil || ExitCode(err) != ExitFatal {
+		t.Fatalf("the failed drain returned %v", err)
+	}
+	if len(steps) != 2 || steps[0] != "drained" || steps[1] != "stopped" {
+		t.Fatalf("the lifecycle steps are %v", steps)
+	}
+
+	failed := &Lifecycle{Start: func(ctx context.Context) error {
+		return fmt.Errorf("config.Load: %w", ErrConfig)
+	}}
+	if err := failed.run(context.Background()); ExitCode(err) != ExitConfig {
+		t.Fatalf("the failed start returned %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// The lint rules
+const (
+	LintSourceType          = "L001"
+	LintSourceSettings      = "L002"
+	LintMiddlewareType      = "L003"
+	LintMiddlewareSettings  = "L004"
+	LintNoDestinations      = "L005"
+	LintDestinationType     = "L006"
+	LintDestinationSettings = "L007"
+	LintDefaultDestination  = "L008"
+	LintRuleDestination     = "L009"
+	LintRuleCommand         = "L010"
+	LintUnusedDestination   = "L011"
+)
+
+// LintConfig is the configuration of the proxy checked by Lint
+type LintConfig struct {
+	Source       SourceConfig                 `json:"source" yaml:"source"`
+	Pipeline     []MiddlewareConfig           `json:"pipeline,omitempty" yaml:"pipeline,omitempty"`
+	Destinations map[string]DestinationConfig `json:"destinations" yaml:"destinations"`
+	Rules        []DestinationRule            `json:"rules,omitempty" yaml:"rules,omitempty"`
+	// Default is the name of the destination of the requests not matching the rules
+	Default string `json:"default" yaml:"default"`
+}
+
+// LintIssue is the problem found by Lint with the suggestion how to fix it
+type LintIssue struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Fix     string `json:"fix"`
+}
+
+// String returns the issue as 'rule message; fix'
+func (issue LintIssue) String() string {
+	return issue.Rule + " " + issue.Message + "; " + issue.Fix
+}
+
+// LintIssues is the list of the problems found by Lint
+type LintIssues []LintIssue
+
+// Error returns the issues one per line
+func (issues LintIssues) Error() string {
+	lines := make([]string, len(issues))
+	for i, issue := range issues {
+		lines[i] = issue.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Lint checks the configuration for the common mistakes, without running the proxy.
+// Besides the ValidateTypes checks, the destinations are checked the same way,
+// and the rules must lead to the defined destinations, while each destination must be reachable.
+//
+// The factories are called to check the settings, the created sources and middlewares are discarded,
+// the created destinations are closed.
+// Returns nil if there is no problem.
+func Lint(config LintConfig) LintIssues {
+	issues := lintTypes(config.Source, config.Pipeline)
+
+	if len(config.Destinations) == 0 {
+		return append(issues, LintIssue{
+			Rule:    LintNoDestinations,
+			Message: "no destinations",
+			Fix:     "add the destination with one of the types [" + strings.Join(RegisteredDestinationTypes(), ", ") + "]",
+		})
+	}
+
+	names := make([]string, 0, len(config.Destinations))
+	for name := range config.Destinations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		destination := config.Destinations[name]
+		if err := ValidateDestination(destination); err != nil {
+			issues = append(issues, LintIssue{
+				Rule:    LintDestinationType,
+				Message: fmt.Sprintf("destinations[%s]: %v", name, err),
+				Fix:     "use one of [" + strings.Join(RegisteredDestinationTypes(), ", ") + "] or RegisterDestinationFactory",
+			})
+			continue
+		}
+		created, err := NewDestination(destination)
+		if err != nil {
+			issues = append(issues, LintIssue{
+				Rule:    LintDestinationSettings,
+				Message: fmt.Sprintf("destinations[%s]: %v", name, err),
+				Fix:     fmt.Sprintf("check the settings of the '%s' type", destination.Type),
+			})
+			continue
+		}
+		_ = created.Close()
+	}
+
+	used := make(map[string]struct{}, len(config.Destinations))
+	if _, ok := config.Destinations[config.Default]; ok {
+		used[config.Default] = struct{}{}
+	} else {
+		issues = append(issues, LintIssue{
+			Rule:    LintDefaultDestination,
+			Message: fmt.Sprintf("default destination '%s' not defined", config.Default),
+			Fix:     "set the default to one of [" + strings.Join(names, ", ") + "]",
+		})
+	}
+	for i, rule := range config.Rules {
+		if _, err := path.Match(rule.Command, ""); err != nil {
+			issues = append(issues, LintIssue{
+				Rule:    LintRuleCommand,
+				Message: fmt.Sprintf("rules[%d] command '%s': %v", i, rule.Command, err),
+				Fix:     "use the command pattern with the '*', '?' or '[...]' wildcards",
+			})
+		}
+		if _, ok := config.Destinations[rule.Destination]; !ok {
+			issues = append(issues, LintIssue{
+				Rule:    LintRuleDestination,
+				Message: fmt.Sprintf("rules[%d] destination '%s' not defined", i, rule.Destination),
+				Fix:     "route the rule to one of [" + strings.Join(names, ", ") + "]",
+			})
+			continue
+		}
+		used[rule.Destination] = struct{}{}
+	}
+	for _, name := range names {
+		if _, ok := used[name]; !ok {
+			issues = append(issues, LintIssue{
+				Rule:    LintUnusedDestination,
+				Message: fmt.Sprintf("destination '%s' is never reached", name),
+				Fix:     "add the rule to the destination, or remove it",
+			})
+		}
+	}
+
+	if len(issues) == 0 {
+		return nil
+	}
+	return issues
+}
+
+// lintTypes checks that the source and the middlewares are registered, and their settings are accepted
+func lintTypes(source SourceConfig, pipeline []MiddlewareConfig) LintIssues {
+	var issues LintIssues
+
+	if err := ValidateSource(source); err != nil {
+		issues = append(issues, LintIssue{
+			Rule:    LintSourceType,
+			Message: fmt.Sprintf("source: %v", err),
+			Fix:     "use one of [" + strings.Join(RegisteredSourceTypes(), ", ") + "] or RegisterSourceType",
+		})
+	} else if _, err := NewSource(source); err != nil {
+		issues = append(issues, LintIssue{
+			Rule:    LintSourceSettings,
+			Message: fmt.Sprintf("source: %v", err),
+			Fix:     fmt.Sprintf("check the settings of the '%s' type", source.Type),
+		})
+	}
+
+	registered := make(map[string]struct{})
+	for _, name := range RegisteredMiddlewares() {
+		registered[name] = struct{}{}
+	}
+	for i, config := range pipeline {
+		name := config.Name
+		if at := strings.Index(name, ":"); at > -1 {
+			name = name[:at]
+		}
+		if _, ok := registered[name]; !ok {
+			issues = append(issues, LintIssue{
+				Rule:    LintMiddlewareType,
+				Message: fmt.Sprintf("pipeline[%d]: middleware '%s' not registered", i, name),
+				Fix:     "use one of [" + strings.Join(RegisteredMiddlewares(), ", ") + "] or RegisterMiddleware",
+			})
+			continue
+		}
+		if _, err := newMiddleware(config); err != nil {
+			issues = append(issues, LintIssue{
+				Rule:    LintMiddlewareSettings,
+				Message: fmt.Sprintf("pipeline[%d]: %v", i, err),
+				Fix:     fmt.Sprintf("check the settings of '%s'", config.Name),
+			})
+		}
+	}
+	return issues
+}
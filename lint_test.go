@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+// lintRules returns the rules of the issues
+func lintRules(issues LintIssues) []string {
+	rules := make([]string, len(issues))
+	for i, issue := range issues {
+		rules[i] = issue.Rule
+	}
+	return rules
+}
+
+// TestLintFindsMistakes checks that each mistake of the configuration is reported with its rule and fix
+func TestLintFindsMistakes(t *testing.T) {
+	valid := LintConfig{
+		Source:       SourceConfig{Type: "tcp", Settings: map[string]interface{}{"port": 8080}},
+		Destinations: map[string]DestinationConfig{"main": {Type: "tcp", Settings: map[string]interface{}{"address": "localhost:9090"}}},
+		Default:      "main",
+	}
+	if issues := Lint(valid); issues != nil {
+		t.Fatalf("the valid configuration has issues:\n%v", issues)
+	}
+
+	config := LintConfig{
+		Source:   SourceConfig{Type: "udp"},
+		Pipeline: []MiddlewareConfig{{Name: "missing"}},
+		Destinations: map[string]DestinationConfig{
+			"main":    {Type: "tcp", Settings: map[string]interface{}{"address": "localhost:9090"}},
+			"no-addr": {Type: "tcp"},
+			"queue":   {Type: "amqp"},
+		},
+		Rules:   []DestinationRule{{Command: "user.[", Destination: "main"}, {Command: "job.*", Destination: "jobs"}},
+		Default: "primary",
+	}
+	issues := Lint(config)
+	expected := []string{
+		LintSourceType, LintMiddlewareType, LintDestinationSettings, LintDestinationType,
+		LintDefaultDestination, LintRuleCommand, LintRuleDestination, LintUnusedDestination, LintUnusedDestination,
+	}
+	if strings.Join(lintRules(issues), ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected the rules %v, got:\n%v", expected, issues)
+	}
+	for _, issue := range issues {
+		if len(issue.Fix) == 0 {
+			t.Fatalf("the issue has no fix: %s", issue)
+		}
+	}
+
+	if issues := Lint(LintConfig{Source: valid.Source}); len(issues) != 1 || issues[0].Rule != LintNoDestinations {
+		t.Fatalf("expected no destinations issue, got:\n%v", issues)
+	}
+}
+
+// TestValidateTypesKeepsHints checks that ValidateTypes reports the type problems with their hints
+func TestValidateTypesKeepsHints(t *testing.T) {
+	err := ValidateTypes(SourceConfig{Type: "udp"}, []MiddlewareConfig{{Name: "missing"}})
+	errs, ok := err.(ValidationErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected two validation errors, got %v", err)
+	}
+	if !strings.Contains(errs[0].Error(), "or RegisterSourceType") || !strings.Contains(errs[1].Error(), "or RegisterMiddleware") {
+		t.Fatalf("the errors have no hints:\n%v", err)
+	}
+}
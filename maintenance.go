@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMaintenanceMessage is returned if the maintenance message is not set
+const DefaultMaintenanceMessage = "service under maintenance"
+
+// The admin commands of the maintenance mode
+const (
+	MaintenanceEnableCommand  = "proxy.maintenance.enable"
+	MaintenanceDisableCommand = "proxy.maintenance.disable"
+)
+
+// maintenanceExempt are the commands that are passed through while the maintenance mode is on,
+// so the proxy is still probed and administered.
+var maintenanceExempt = []string{
+	PingCommand,
+	MaintenanceEnableCommand,
+	MaintenanceDisableCommand,
+	SwitchCommand,
+	InfoCommand,
+	DiagnosticsCommand,
+	TopologyCommand,
+	LeaksCommand,
+	MemoryCommand,
+	UsageReportCommand,
+	BalancerStatusCommand,
+	BalancerCostCommand,
+	RouteListCommand,
+	RouteSetCommand,
+	RouteDeleteCommand,
+	RouteSaveCommand,
+	ScheduleListCommand,
+	ScheduleAddCommand,
+	ScheduleRemoveCommand,
+}
+
+// Maintenance mode replies to the commands with the static reply without touching the destination.
+// It's toggled by the admin, so the destination could be taken down cleanly.
+type Maintenance struct {
+	mu         sync.RWMutex
+	enabled    bool
+	message    string
+	retryAfter time.Duration
+	commands   map[string]struct{}
+	exempt     map[string]struct{}
+}
+
+// NewMaintenance returns the disabled maintenance mode.
+// The ping and the admin commands of the proxy are exempt from it.
+func NewMaintenance() *Maintenance {
+	maintenance := &Maintenance{
+		commands: make(map[string]struct{}),
+		exempt:   make(map[string]struct{}, len(maintenanceExempt)),
+	}
+	return maintenance.Exempt(maintenanceExempt...)
+}
+
+// Exempt the commands from the maintenance mode, for example the custom admin commands
+func (maintenance *Maintenance) Exempt(commands ...string) *Maintenance {
+	maintenance.mu.Lock()
+	defer maintenance.mu.Unlock()
+
+	for _, command := range commands {
+		maintenance.exempt[command] = struct{}{}
+	}
+	return maintenance
+}
+
+// Enable the maintenance mode.
+// If commands are not given, then all commands are replied with the static reply.
+// The retryAfter is passed to the clients as a hint, when to try again.
+// The zero retryAfter is omitted.
+func (maintenance *Maintenance) Enable(message string, retryAfter time.Duration, commands ...string) {
+	if len(message) == 0 {
+		message = DefaultMaintenanceMessage
+	}
+
+	maintenance.mu.Lock()
+	defer maintenance.mu.Unlock()
+
+	maintenance.enabled = true
+	maintenance.message = message
+	maintenance.retryAfter = retryAfter
+	maintenance.commands = make(map[string]struct{}, len(commands))
+	for _, command := range commands {
+		maintenance.commands[command] = struct{}{}
+	}
+}
+
+// Disable the maintenance mode
+func (maintenance *Maintenance) Disable() {
+	maintenance.mu.Lock()
+	maintenance.enabled = false
+	maintenance.mu.Unlock()
+}
+
+// Enabled returns true if the maintenance mode is on
+func (maintenance *Maintenance) Enabled() bool {
+	maintenance.mu.RLock()
+	defer maintenance.mu.RUnlock()
+
+	return maintenance.enabled
+}
+
+// Reply returns the static reply for the command.
+// Returns false if the command is not under maintenance.
+func (maintenance *Maintenance) Reply(command string) (*Reply, bool) {
+	maintenance.mu.RLock()
+	defer maintenance.mu.RUnlock()
+
+	if !maintenance.enabled {
+		return nil, false
+	}
+	if _, ok := maintenance.exempt[command]; ok {
+		return nil, false
+	}
+	if len(maintenance.commands) > 0 {
+		if _, ok := maintenance.commands[command]; !ok {
+			return nil, false
+		}
+	}
+
+	reply := Fail(maintenance.message)
+	if maintenance.retryAfter > 0 {
+		reply.Parameters[RetryAfterParam] = maintenance.retryAfter.Seconds()
+	}
+	return reply, true
+}
+
+// Middleware returns the static reply while the maintenance mode is on.
+// Otherwise, the request is passed to the next handler.
+func (maintenance *Maintenance) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			if reply, ok := maintenance.Reply(req.Command); ok {
+				return reply
+			}
+			return next(req)
+		}
+	}
+}
+
+// Handler replies to the maintenance admin commands.
+// The MaintenanceEnableCommand has the optional 'message', 'retry_after' in seconds
+// and 'commands' list parameters.
+func (maintenance *Maintenance) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		switch req.Command {
+		case MaintenanceEnableCommand:
+			var retryAfter time.Duration
+			if raw, ok := req.Parameters[RetryAfterParam]; ok {
+				seconds, ok := raw.(float64)
+				if !ok || seconds < 0 {
+					return Fail(fmt.Sprintf("'%s' parameter must be the seconds", RetryAfterParam))
+				}
+				retryAfter = time.Duration(seconds * float64(time.Second))
+			}
+			var commands []string
+			if raw, ok := req.Parameters["commands"]; ok {
+				list, ok := raw.([]interface{})
+				if !ok {
+					return Fail("'commands' parameter must be a list")
+				}
+				for i, command := range list {
+					name, ok := command.(string)
+					if !ok || len(name) == 0 {
+						return Fail(fmt.Sprintf("commands[%d] must be a command name", i))
+					}
+					commands = append(commands, name)
+				}
+			}
+			maintenance.Enable(req.StringParam("message"), retryAfter, commands...)
+			return Ok(nil)
+		case MaintenanceDisableCommand:
+			maintenance.Disable()
+			return Ok(nil)
+		default:
+			return Fail(fmt.Sprintf("unknown command '%s'", req.Command))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"testing"
+)
+
+// TestMaintenanceExemptsAdminCommands checks that the maintenance is toggled by the admin commands,
+// while the ping and the admin commands are passed through
+func TestMaintenanceExemptsAdminCommands(t *testing.T) {
+	maintenance := NewMaintenance().Exempt("custom.admin")
+	admin := maintenance.Handler()
+	handler := Wrap(func(req *Envelope) *Reply {
+		if req.Command == MaintenanceEnableCommand || req.Command == MaintenanceDisableCommand {
+			return admin(req)
+		}
+		return Ok(nil)
+	}, maintenance.Middleware())
+
+	enable := policyRequest(MaintenanceEnableCommand, map[string]interface{}{
+		"message":       "upgrading",
+		RetryAfterParam: float64(30),
+		"commands":      []interface{}{"balance", PingCommand, "custom.admin"},
+	})
+	if reply := handler(enable); !reply.IsOK() {
+		t.Fatalf("enable failed: %s", reply.Message)
+	}
+	if !maintenance.Enabled() {
+		t.Fatalf("maintenance is not enabled")
+	}
+
+	reply := handler(policyRequest("balance", map[string]interface{}{}))
+	if reply.IsOK() || reply.Message != "upgrading" || reply.Parameters[RetryAfterParam] != float64(30) {
+		t.Fatalf("the command under maintenance replied %v", reply)
+	}
+	for _, command := range []string{"transfer", PingCommand, "custom.admin"} {
+		if reply := handler(policyRequest(command, map[string]interface{}{})); !reply.IsOK() {
+			t.Fatalf("'%s' is blocked by the maintenance: %s", command, reply.Message)
+		}
+	}
+
+	if reply := handler(policyRequest(MaintenanceDisableCommand, map[string]interface{}{})); !reply.IsOK() {
+		t.Fatalf("disable failed: %s", reply.Message)
+	}
+	if reply := handler(policyRequest("balance", map[string]interface{}{})); !reply.IsOK() {
+		t.Fatalf("the command failed after the maintenance: %s", reply.Message)
+	}
+}
+
+// TestMaintenanceEnableValidates checks that the invalid parameters don't enable the maintenance
+func TestMaintenanceEnableValidates(t *testing.T) {
+	maintenance := NewMaintenance()
+	admin := maintenance.Handler()
+
+	invalid := []map[string]interface{}{
+		{RetryAfterParam: "soon"},
+		{RetryAfterParam: float64(-1)},
+		{"commands": "balance"},
+		{"commands": []interface{}{""}},
+	}
+	for _, parameters := range invalid {
+		if reply := admin(policyRequest(MaintenanceEnableCommand, parameters)); reply.IsOK() {
+			t.Fatalf("enable with %v passed", parameters)
+		}
+	}
+	if maintenance.Enabled() {
+		t.Fatalf("maintenance enabled by the invalid parameters")
+	}
+}
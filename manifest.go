@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ManifestCommand returns the manifest of the commands exposed by the proxy
+const ManifestCommand = "proxy.manifest"
+
+// OpenAPICommand returns the OpenAPI document of the commands exposed by the http source
+const OpenAPICommand = "proxy.openapi"
+
+// OpenAPIVersion is the version of the generated OpenAPI documents
+const OpenAPIVersion = "3.0.3"
+
+// ManifestEntry is the exposed command.
+// The destination is not exposed, since it's internal to the proxy.
+type ManifestEntry struct {
+	Command string `json:"command"`
+	Group   string `json:"group,omitempty"`
+	Auth    string `json:"auth,omitempty"`
+	// Cache is the time in seconds that the reply could be cached
+	Cache uint `json:"cache,omitempty"`
+	CommandSchema
+}
+
+// Manifest describes everything the proxy exposes,
+// so the clients could discover the commands programmatically.
+type Manifest struct {
+	Name     string          `json:"name"`
+	Commands []ManifestEntry `json:"commands"`
+}
+
+// NewManifest generates the manifest from the route table and the command schemas.
+// The commands are listed if they are in the route groups or have the schema.
+func NewManifest(name string, routes *Routes, schemas map[string]CommandSchema) *Manifest {
+	commands := make(map[string]struct{})
+	for _, command := range routes.Commands() {
+		commands[command] = struct{}{}
+	}
+	for command := range schemas {
+		commands[command] = struct{}{}
+	}
+
+	manifest := &Manifest{Name: name, Commands: make([]ManifestEntry, 0, len(commands))}
+	for command := range commands {
+		policy, group := routes.Policy(command)
+		manifest.Commands = append(manifest.Commands, ManifestEntry{
+			Command:       command,
+			Group:         group,
+			Auth:          policy.Auth,
+			Cache:         policy.Cache,
+			CommandSchema: schemas[command],
+		})
+	}
+	sort.Slice(manifest.Commands, func(i, j int) bool {
+		return manifest.Commands[i].Command < manifest.Commands[j].Command
+	})
+
+	return manifest
+}
+
+// ManifestHandler replies to the ManifestCommand.
+// The manifest is generated on each request, so the route changes are reflected.
+func ManifestHandler(name string, table *RouteTable, schemas map[string]CommandSchema) Handler {
+	return func(req *Envelope) *Reply {
+		parameters, err := toParameters(NewManifest(name, table.Routes(), schemas))
+		if err != nil {
+			return Fail(fmt.Sprintf("toParameters: %v", err))
+		}
+		return Ok(parameters)
+	}
+}
+
+// openAPITypes are the OpenAPI types of the schema types. The AnyType has no type
+var openAPITypes = map[string]string{
+	StringType: "string",
+	NumberType: "number",
+	BoolType:   "boolean",
+	ObjectType: "object",
+	ArrayType:  "array",
+}
+
+// openAPIObject returns the OpenAPI schema of the parameters
+func openAPIObject(params map[string]ParamSchema) map[string]interface{} {
+	properties := make(map[string]interface{}, len(params))
+	var required []string
+	for name, param := range params {
+		property := map[string]interface{}{}
+		if openAPIType, ok := openAPITypes[param.Type]; ok {
+			property["type"] = openAPIType
+		}
+		if len(param.Description) > 0 {
+			property["description"] = param.Description
+		}
+		properties[name] = property
+		if param.Required {
+			required = append(required, name)
+		}
+	}
+
+	object := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		object["required"] = required
+	}
+	return object
+}
+
+// OpenAPI returns the OpenAPI document of the commands, as they are served by the HTTPSource with the path prefix.
+// Each command is the POST of its path with the json parameters,
+// and the reply is the json with the status, the message and the reply parameters.
+// The commands with the auth policy require the bearer token.
+func (manifest *Manifest) OpenAPI(pathPrefix string) map[string]interface{} {
+	prefix := "/" + strings.Trim(pathPrefix, "/")
+	if prefix != "/" {
+		prefix += "/"
+	}
+
+	paths := make(map[string]interface{}, len(manifest.Commands))
+	for _, entry := range manifest.Commands {
+		reply := openAPIObject(nil)
+		reply["properties"] = map[string]interface{}{
+			"status":     map[string]interface{}{"type": "string", "enum": []string{OK, FAIL}},
+			"message":    map[string]interface{}{"type": "string"},
+			"parameters": openAPIObject(entry.Reply),
+		}
+		operation := map[string]interface{}{
+			"operationId": entry.Command,
+			"requestBody": map[string]interface{}{
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": openAPIObject(entry.Request)},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "the reply, even if it failed",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": reply},
+					},
+				},
+			},
+		}
+		if len(entry.Description) > 0 {
+			operation["summary"] = entry.Description
+		}
+		if len(entry.Group) > 0 {
+			operation["tags"] = []string{entry.Group}
+		}
+		if len(entry.Auth) > 0 {
+			operation["security"] = []map[string][]string{{"bearer": {}}}
+		}
+		paths[prefix+entry.Command] = map[string]interface{}{"post": operation}
+	}
+
+	// the document requires the version, while the proxy built without it has none
+	version := ReadBuildInfo().Version
+	if len(version) == 0 {
+		version = "0.0.0"
+	}
+	return map[string]interface{}{
+		"openapi": OpenAPIVersion,
+		"info":    map[string]interface{}{"title": manifest.Name, "version": version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// OpenAPIHandler replies to the OpenAPICommand with the document of the http source with the path prefix.
+// The document is generated on each request, so the route changes are reflected.
+func OpenAPIHandler(name string, pathPrefix string, table *RouteTable, schemas map[string]CommandSchema) Handler {
+	return func(req *Envelope) *Reply {
+		parameters, err := toParameters(NewManifest(name, table.Routes(), schemas).OpenAPI(pathPrefix))
+		if err != nil {
+			return Fail(fmt.Sprintf("toParameters: %v", err))
+		}
+		return Ok(parameters)
+	}
+}
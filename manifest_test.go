@@ -0,0 +1,70 @@
+package proxy
+
+import "testing"
+
+// TestManifestListsCommands checks that the manifest has the routed commands and the commands with the schema
+func TestManifestListsCommands(t *testing.T) {
+	table := policyTable(t, RoutePolicy{Auth: "bearer", Cache: 30}, "users.get")
+	schemas := map[string]CommandSchema{
+		"users.get":  {Description: "returns the user", Request: map[string]ParamSchema{"id": {Type: StringType, Required: true}}},
+		"users.list": {},
+	}
+
+	manifest := NewManifest("proxy", table.Routes(), schemas)
+	if len(manifest.Commands) != 2 || manifest.Commands[0].Command != "users.get" || manifest.Commands[1].Command != "users.list" {
+		t.Fatalf("the manifest commands are %+v", manifest.Commands)
+	}
+	get := manifest.Commands[0]
+	if get.Group != "private" || get.Auth != "bearer" || get.Cache != 30 || !get.Request["id"].Required {
+		t.Fatalf("the manifest entry is %+v", get)
+	}
+
+	reply := ManifestHandler("proxy", table, schemas)(policyRequest(ManifestCommand, nil))
+	commands, _ := reply.Parameters["commands"].([]interface{})
+	if !reply.IsOK() || reply.Parameters["name"] != "proxy" || len(commands) != 2 {
+		t.Fatalf("the manifest command replied %v", reply)
+	}
+}
+
+// TestOpenAPIPaths checks the paths of the http source and their security
+func TestOpenAPIPaths(t *testing.T) {
+	table := policyTable(t, RoutePolicy{Auth: "bearer"}, "users.get")
+	schemas := map[string]CommandSchema{
+		"users.get":  {Request: map[string]ParamSchema{"id": {Type: StringType, Required: true}, "fields": {Type: AnyType}}},
+		"users.list": {},
+	}
+
+	document := NewManifest("proxy", table.Routes(), schemas).OpenAPI("/api/")
+	if document["openapi"] != OpenAPIVersion {
+		t.Fatalf("the document version is %v", document["openapi"])
+	}
+	paths := document["paths"].(map[string]interface{})
+	if len(paths) != 2 {
+		t.Fatalf("the paths are %v", paths)
+	}
+	get, ok := paths["/api/users.get"].(map[string]interface{})["post"].(map[string]interface{})
+	if !ok || get["security"] == nil {
+		t.Fatalf("the authenticated command is %v", get)
+	}
+	list := paths["/api/users.list"].(map[string]interface{})["post"].(map[string]interface{})
+	if list["security"] != nil {
+		t.Fatalf("the public command requires the auth")
+	}
+
+	schema := openAPIObject(schemas["users.get"].Request)
+	properties := schema["properties"].(map[string]interface{})
+	if properties["id"].(map[string]interface{})["type"] != "string" || properties["fields"].(map[string]interface{})["type"] != nil {
+		t.Fatalf("the request schema is %v", schema)
+	}
+	if required := schema["required"].([]string); len(required) != 1 || required[0] != "id" {
+		t.Fatalf("the required parameters are %v", required)
+	}
+
+	if paths := NewManifest("proxy", table.Routes(), nil).OpenAPI("")["paths"].(map[string]interface{}); paths["/users.get"] == nil {
+		t.Fatalf("the paths without the prefix are %v", paths)
+	}
+	reply := OpenAPIHandler("proxy", "api", table, schemas)(policyRequest(OpenAPICommand, nil))
+	if !reply.IsOK() || reply.Parameters["openapi"] != OpenAPIVersion {
+		t.Fatalf("the openapi command replied %v", reply)
+	}
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryCommand returns the memory usage of the proxy
+const MemoryCommand = "proxy.memory"
+
+// memStatsInterval limits how often the runtime memory is read, since it stops the world
+const memStatsInterval = time.Second
+
+// MemoryUser is the component that reports its memory usage in bytes
+type MemoryUser interface {
+	MemoryUsage() uint64
+}
+
+// MemoryGuard watches the memory of the proxy.
+// When the heap exceeds the high watermark, the proxy is under the memory pressure.
+// Pass Pressure to the LoadShedder, so the proxy degrades instead of running out of memory.
+// The components keep their own budgets: DedupCache.WithBudget, Journal.Budget,
+// UsageTracker.MaxPrincipals and RateLimitConfig.MaxBuckets.
+type MemoryGuard struct {
+	// HighWatermark is the heap size in bytes. Zero means no limit
+	HighWatermark uint64
+
+	mu         sync.Mutex
+	components map[string]MemoryUser
+	stats      runtime.MemStats
+	read       time.Time
+}
+
+// NewMemoryGuard returns the guard with the high watermark
+func NewMemoryGuard(highWatermark uint64) *MemoryGuard {
+	return &MemoryGuard{HighWatermark: highWatermark, components: make(map[string]MemoryUser)}
+}
+
+// Register the component to report its usage in the memory stats
+func (guard *MemoryGuard) Register(name string, component MemoryUser) {
+	guard.mu.Lock()
+	guard.components[name] = component
+	guard.mu.Unlock()
+}
+
+// heap returns the recently read heap size. Must be called with the lock
+func (guard *MemoryGuard) heap() uint64 {
+	if time.Since(guard.read) > memStatsInterval {
+		runtime.ReadMemStats(&guard.stats)
+		guard.read = time.Now()
+	}
+	return guard.stats.HeapAlloc
+}
+
+// Pressure returns true if the heap is over the high watermark
+func (guard *MemoryGuard) Pressure() bool {
+	if guard.HighWatermark == 0 {
+		return false
+	}
+
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+
+	return guard.heap() > guard.HighWatermark
+}
+
+// Handler replies to the MemoryCommand with the heap and the components usage
+func (guard *MemoryGuard) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		guard.mu.Lock()
+		heap := guard.heap()
+		names := make([]string, 0, len(guard.components))
+		for name := range guard.components {
+			names = append(names, name)
+		}
+		components := make(map[string]interface{}, len(names))
+		sort.Strings(names)
+		for _, name := range names {
+			components[name] = guard.components[name].MemoryUsage()
+		}
+		guard.mu.Unlock()
+
+		return Ok(map[string]interface{}{
+			"heap":           heap,
+			"high_watermark": guard.HighWatermark,
+			"components":     components,
+		})
+	}
+}
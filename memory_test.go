@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"testing"
+)
+
+// TestMemoryGuardPressure checks that the heap over the high watermark sheds the low priority requests
+func TestMemoryGuardPressure(t *testing.T) {
+	if NewMemoryGuard(0).Pressure() {
+		t.Fatalf("the guard without the watermark is under the pressure")
+	}
+	guard := NewMemoryGuard(1)
+	if !guard.Pressure() {
+		t.Fatalf("the heap is not over the watermark of 1 byte")
+	}
+
+	shedder := NewLoadShedder(10, nil, 0, 0)
+	shedder.Pressure = guard.Pressure
+	if !shedder.Shed(0) {
+		t.Fatalf("the low priority request passed under the pressure")
+	}
+	if shedder.Shed(10) {
+		t.Fatalf("the protected request was shed")
+	}
+}
+
+// TestMemoryGuardReportsComponents checks that the memory stats have the usage of the registered components
+func TestMemoryGuardReportsComponents(t *testing.T) {
+	journal := NewJournal(3)
+	journal.Append(policyRequest("transfer", map[string]interface{}{"amount": 10}))
+	guard := NewMemoryGuard(0)
+	guard.Register("journal", journal)
+
+	reply := guard.Handler()(policyRequest(MemoryCommand, nil))
+	components, ok := reply.Parameters["components"].(map[string]interface{})
+	if !reply.IsOK() || !ok {
+		t.Fatalf("the memory stats replied %v", reply)
+	}
+	if usage, ok := components["journal"].(uint64); !ok || usage == 0 || usage != journal.MemoryUsage() {
+		t.Fatalf("the journal usage is %v, expected %d", components["journal"], journal.MemoryUsage())
+	}
+	if heap, ok := reply.Parameters["heap"].(uint64); !ok || heap == 0 {
+		t.Fatalf("the heap is %v", reply.Parameters["heap"])
+	}
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import "encoding/json"
+
+// The reply statuses
+const (
+	OK   = "OK"
+	FAIL = "fail"
+)
+
+// Request is the message that proxy receives from the source
+type Request struct {
+	Command    string                 `json:"command"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// Reply is the message that proxy returns to the source
+type Reply struct {
+	Status     string                 `json:"status"`
+	Message    string                 `json:"message"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// Handler processes the request and returns the reply.
+// The request comes in the envelope, so the handlers could read the metadata.
+type Handler func(req *Envelope) *Reply
+
+// Ok reply returned with the given parameters
+func Ok(parameters map[string]interface{}) *Reply {
+	if parameters == nil {
+		parameters = map[string]interface{}{}
+	}
+	return &Reply{Status: OK, Parameters: parameters}
+}
+
+// Fail reply returned with the given message
+func Fail(message string) *Reply {
+	return &Reply{Status: FAIL, Message: message, Parameters: map[string]interface{}{}}
+}
+
+// IsOK returns true if the reply was successful
+func (reply *Reply) IsOK() bool {
+	return reply.Status == OK
+}
+
+// Copy returns the deep copy of the reply, so the copy's parameters are changed without a race
+func (reply *Reply) Copy() *Reply {
+	copied := *reply
+	if reply.Parameters != nil {
+		copied.Parameters = copyValue(reply.Parameters).(map[string]interface{})
+	}
+	return &copied
+}
+
+// copyValue returns the deep copy of the decoded json value
+func copyValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(typed))
+		for key, nested := range typed {
+			copied[key] = copyValue(nested)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, nested := range typed {
+			copied[i] = copyValue(nested)
+		}
+		return copied
+	case []byte:
+		return append([]byte{}, typed...)
+	default:
+		return value
+	}
+}
+
+// StringParam returns the string parameter of the request.
+// If the parameter is missing or not a string, then an empty string returned.
+func (req *Request) StringParam(name string) string {
+	value, ok := req.Parameters[name].(string)
+	if !ok {
+		return ""
+	}
+	return value
+}
+
+// Middleware wraps the handler to process the request before or after the next handler
+type Middleware func(next Handler) Handler
+
+// Size returns the length of the request encoded in json
+func (req *Request) Size() int {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// Wrap the handler with the middlewares.
+// The first middleware is the first to receive the request.
+func Wrap(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsPath is the http path of the metrics endpoint
+const MetricsPath = "/metrics"
+
+// OtherCommand is the command label of the commands that are not tracked one by one
+const OtherCommand = "other"
+
+// OtherTenant is the tenant label of the tenants that are not configured
+const OtherTenant = "other"
+
+// DefaultMaxCommands is the amount of the command labels without the route table
+const DefaultMaxCommands = 100
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the latency histograms
+var DefaultLatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// The metric types in the Prometheus text format
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// metric is the family of the series by their labels
+type metric struct {
+	kind       string
+	help       string
+	values     map[string]float64
+	histograms map[string]*histogram
+}
+
+// Metrics collects the counters, gauges and latency histograms of the proxy,
+// and exposes them in the Prometheus text format.
+// The labels are given as the name and value pairs.
+//
+// The commands come from the clients, so the command label is bounded:
+// with the route table, only the commands in the route groups have their own label,
+// otherwise the first DefaultMaxCommands commands do. The rest are counted as the OtherCommand.
+type Metrics struct {
+	mu       sync.Mutex
+	buckets  []float64
+	metrics  map[string]*metric
+	routes   *RouteTable
+	tenants  *Tenants
+	commands map[string]struct{}
+}
+
+// NewMetrics returns the metrics with the build info gauge
+func NewMetrics() *Metrics {
+	metrics := &Metrics{
+		buckets:  DefaultLatencyBuckets,
+		metrics:  make(map[string]*metric),
+		commands: make(map[string]struct{}),
+	}
+	info := ReadBuildInfo()
+	metrics.Set("proxy_build_info", "the version of the proxy", 1, "version", info.Version, "go_version", info.GoVersion)
+	return metrics
+}
+
+// EnableMetrics serves the metrics on the port's MetricsPath until the context is cancelled
+func EnableMetrics(ctx context.Context, port uint64) (*Metrics, error) {
+	listener, err := listenPort(port)
+	if err != nil {
+		return nil, fmt.Errorf("listenPort: %w", err)
+	}
+
+	metrics := NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, metrics.Handler())
+	go func() {
+		_ = serveHTTP(ctx, listener, mux, DefaultDrainTimeout)
+	}()
+	return metrics, nil
+}
+
+// WithRoutes labels only the commands of the route groups, the rest are the OtherCommand
+func (metrics *Metrics) WithRoutes(routes *RouteTable) *Metrics {
+	metrics.routes = routes
+	return metrics
+}
+
+// WithTenants labels the requests of the Middleware with their tenant, see Tenants.Label.
+// Put the middleware after WithAuth, so the tenant is the authenticated principal.
+func (metrics *Metrics) WithTenants(tenants *Tenants) *Metrics {
+	metrics.tenants = tenants
+	return metrics
+}
+
+// commandLabel returns the label of the command, so the clients can't create the unbounded series
+func (metrics *Metrics) commandLabel(command string) string {
+	if metrics.routes != nil {
+		if _, group := metrics.routes.Routes().Policy(command); len(group) > 0 {
+			return command
+		}
+		return OtherCommand
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	if _, ok := metrics.commands[command]; ok {
+		return command
+	}
+	if len(metrics.commands) >= DefaultMaxCommands {
+		return OtherCommand
+	}
+	metrics.commands[command] = struct{}{}
+	return command
+}
+
+// labelString renders the label pairs, for example '{command="get",status="OK"}'
+func labelString(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(labels[i])
+		builder.WriteString(`="`)
+		builder.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1]))
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+// family returns the metric, creating it if necessary.
+// Must be called with the lock.
+func (metrics *Metrics) family(name string, kind string, help string) *metric {
+	family, ok := metrics.metrics[name]
+	if !ok {
+		family = &metric{
+			kind:       kind,
+			help:       help,
+			values:     make(map[string]float64),
+			histograms: make(map[string]*histogram),
+		}
+		metrics.metrics[name] = family
+	}
+	return family
+}
+
+// Add the value to the counter
+func (metrics *Metrics) Add(name string, help string, value float64, labels ...string) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.family(name, counterType, help).values[labelString(labels)] += value
+}
+
+// Set the value of the gauge
+func (metrics *Metrics) Set(name string, help string, value float64, labels ...string) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.family(name, gaugeType, help).values[labelString(labels)] = value
+}
+
+// Observe the value in the histogram
+func (metrics *Metrics) Observe(name string, help string, value float64, labels ...string) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	family := metrics.family(name, histogramType, help)
+	key := labelString(labels)
+	observed, ok := family.histograms[key]
+	if !ok {
+		observed = &histogram{counts: make([]uint64, len(metrics.buckets))}
+		family.histograms[key] = observed
+	}
+	for i, bound := range metrics.buckets {
+		if value <= bound {
+			observed.counts[i]++
+		}
+	}
+	observed.sum += value
+	observed.count++
+}
+
+// ObserveRequest counts the request of the command and its latency
+func (metrics *Metrics) ObserveRequest(command string, status string, duration time.Duration) {
+	metrics.observeRequest(command, status, duration)
+}
+
+// observeRequest counts the request with the extra labels
+func (metrics *Metrics) observeRequest(command string, status string, duration time.Duration, labels ...string) {
+	command = metrics.commandLabel(command)
+	metrics.Add("proxy_requests_total", "the requests by command and reply status", 1,
+		append([]string{"command", command, "status", status}, labels...)...)
+	metrics.Observe("proxy_request_duration_seconds", "the latency of the requests by command", duration.Seconds(),
+		append([]string{"command", command}, labels...)...)
+}
+
+// ObserveInstance counts the request sent to the destination instance, and whether it failed
+func (metrics *Metrics) ObserveInstance(instance string, err error, duration time.Duration) {
+	status := OK
+	if err != nil {
+		status = FAIL
+	}
+	metrics.Add("proxy_instance_requests_total", "the requests by destination instance and result", 1, "instance", instance, "status", status)
+	metrics.Observe("proxy_instance_duration_seconds", "the latency of the destination instances", duration.Seconds(), "instance", instance)
+}
+
+// Middleware counts the requests and their latency by the command, and by the tenant WithTenants
+func (metrics *Metrics) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			start := time.Now()
+			reply := next(req)
+			status := FAIL
+			if reply != nil {
+				status = reply.Status
+			}
+			var labels []string
+			if metrics.tenants != nil {
+				labels = []string{"tenant", metrics.tenants.Label(req)}
+			}
+			metrics.observeRequest(req.Command, status, time.Since(start), labels...)
+			return reply
+		}
+	}
+}
+
+// withLabel inserts the label into the rendered labels
+func withLabel(labels string, name string, value string) string {
+	label := name + `="` + value + `"`
+	if len(labels) == 0 {
+		return "{" + label + "}"
+	}
+	return labels[:len(labels)-1] + "," + label + "}"
+}
+
+// formatValue writes the float like Prometheus does
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sorted sorts the keys in place and returns them
+func sorted(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+// The metrics are rendered under the lock, and written after it, so the slow reader doesn't block the requests.
+func (metrics *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var out bytes.Buffer
+	metrics.render(&out)
+	return out.WriteTo(w)
+}
+
+// render writes the metrics into the buffer
+func (metrics *Metrics) render(out *bytes.Buffer) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	names := make([]string, 0, len(metrics.metrics))
+	for name := range metrics.metrics {
+		names = append(names, name)
+	}
+	for _, name := range sorted(names) {
+		family := metrics.metrics[name]
+		series := make([]string, 0, len(family.values)+len(family.histograms))
+		for labels := range family.values {
+			series = append(series, labels)
+		}
+		for labels := range family.histograms {
+			series = append(series, labels)
+		}
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+
+		for _, labels := range sorted(series) {
+			if value, ok := family.values[labels]; ok {
+				fmt.Fprintf(out, "%s%s %s\n", name, labels, formatValue(value))
+				continue
+			}
+			observed := family.histograms[labels]
+			for i, bound := range metrics.buckets {
+				fmt.Fprintf(out, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatValue(bound)), observed.counts[i])
+			}
+			fmt.Fprintf(out, "%s_bucket%s %d\n", name, withLabel(labels, "le", "+Inf"), observed.count)
+			fmt.Fprintf(out, "%s_sum%s %s\n", name, labels, formatValue(observed.sum))
+			fmt.Fprintf(out, "%s_count%s %d\n", name, labels, observed.count)
+		}
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (metrics *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = metrics.WriteTo(w)
+	})
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// renderMetrics returns the metrics in the Prometheus text format
+func renderMetrics(t *testing.T, metrics *Metrics) string {
+	var out bytes.Buffer
+	if _, err := metrics.WriteTo(&out); err != nil {
+		t.Fatalf("metrics.WriteTo: %v", err)
+	}
+	return out.String()
+}
+
+// TestMetricsMiddleware checks the request counters, including the requests without the reply
+func TestMetricsMiddleware(t *testing.T) {
+	metrics := NewMetrics()
+	handler := Wrap(func(req *Envelope) *Reply {
+		if req.Command == "lost" {
+			return nil
+		}
+		return Ok(nil)
+	}, metrics.Middleware())
+
+	handler(policyRequest("users.get", nil))
+	handler(policyRequest("users.get", nil))
+	handler(policyRequest("lost", nil))
+
+	text := renderMetrics(t, metrics)
+	expected := []string{
+		"# TYPE proxy_requests_total counter",
+		`proxy_requests_total{command="users.get",status="OK"} 2`,
+		`proxy_requests_total{command="lost",status="fail"} 1`,
+		"# TYPE proxy_request_duration_seconds histogram",
+		`proxy_request_duration_seconds_bucket{command="users.get",le="+Inf"} 2`,
+		`proxy_request_duration_seconds_count{command="users.get"} 2`,
+		"# TYPE proxy_build_info gauge",
+	}
+	for _, line := range expected {
+		if !strings.Contains(text, line) {
+			t.Fatalf("no '%s' in the metrics:\n%s", line, text)
+		}
+	}
+}
+
+// TestMetricsBoundedCommands checks that the clients can't create the unbounded series
+func TestMetricsBoundedCommands(t *testing.T) {
+	metrics := NewMetrics()
+	for i := 0; i < DefaultMaxCommands+10; i++ {
+		metrics.ObserveRequest(fmt.Sprintf("command-%d", i), OK, time.Millisecond)
+	}
+	text := renderMetrics(t, metrics)
+	if !strings.Contains(text, `proxy_requests_total{command="other",status="OK"} 10`) {
+		t.Fatalf("the commands over the limit are not counted as other:\n%s", text)
+	}
+	if strings.Contains(text, fmt.Sprintf(`command="command-%d"`, DefaultMaxCommands)) {
+		t.Fatalf("the command over the limit has its own label")
+	}
+
+	routed := NewMetrics().WithRoutes(policyTable(t, RoutePolicy{}, "users.get"))
+	routed.ObserveRequest("users.get", OK, time.Millisecond)
+	routed.ObserveRequest("users.list", OK, time.Millisecond)
+	text = renderMetrics(t, routed)
+	if !strings.Contains(text, `command="users.get"`) || strings.Contains(text, `command="users.list"`) {
+		t.Fatalf("the commands out of the route groups have their own label:\n%s", text)
+	}
+}
+
+// TestMetricsHandler checks the escaped labels and the content type
+func TestMetricsHandler(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Set("proxy_queue_length", "the queued requests", 3, "queue", "a \"quoted\"\nname")
+	metrics.ObserveInstance("i1", fmt.Errorf("down"), time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", MetricsPath, nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("the content type is '%s'", recorder.Header().Get("Content-Type"))
+	}
+	text := recorder.Body.String()
+	if !strings.Contains(text, `proxy_queue_length{queue="a \"quoted\"\nname"} 3`) {
+		t.Fatalf("the label is not escaped:\n%s", text)
+	}
+	if !strings.Contains(text, `proxy_instance_requests_total{instance="i1",status="fail"} 1`) {
+		t.Fatalf("the failed instance request is not counted:\n%s", text)
+	}
+}
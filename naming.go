@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"text/template"
+)
+
+// DefaultNaming names the instances by the proxy name and the index starting from 1, like 'proxy1'
+const DefaultNaming = "{{.Name}}{{.Index}}"
+
+// InstanceIdentity is the data of the naming template
+type InstanceIdentity struct {
+	Name     string
+	Index    int
+	Hostname string
+	Pid      int
+}
+
+// Naming creates the instance names from the template, so the replicas across the fleet are distinguished
+// in the logs, metrics and registries. For example '{{.Name}}-{{.Hostname}}-{{.Pid}}-{{.Index}}'.
+type Naming struct {
+	template *template.Template
+	hostname string
+}
+
+// NewNaming returns the naming of the template. The empty template is DefaultNaming
+func NewNaming(text string) (*Naming, error) {
+	if len(text) == 0 {
+		text = DefaultNaming
+	}
+	parsed, err := template.New("naming").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template.Parse: %w", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return &Naming{template: parsed, hostname: hostname}, nil
+}
+
+// Name returns the name of the instance of the proxy
+func (naming *Naming) Name(name string, index int) (string, error) {
+	var buf bytes.Buffer
+	identity := InstanceIdentity{Name: name, Index: index, Hostname: naming.hostname, Pid: os.Getpid()}
+	if err := naming.template.Execute(&buf, identity); err != nil {
+		return "", fmt.Errorf("template.Execute: %w", err)
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("naming template gives the empty name")
+	}
+	return buf.String(), nil
+}
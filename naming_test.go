@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// TestNaming checks the default and the custom naming templates
+func TestNaming(t *testing.T) {
+	naming, err := NewNaming("")
+	if err != nil {
+		t.Fatalf("NewNaming: %v", err)
+	}
+	if name, err := naming.Name("proxy", 1); err != nil || name != "proxy1" {
+		t.Fatalf("the default name is '%s', %v", name, err)
+	}
+
+	naming, err = NewNaming("{{.Name}}-{{.Hostname}}-{{.Pid}}-{{.Index}}")
+	if err != nil {
+		t.Fatalf("NewNaming: %v", err)
+	}
+	expected := fmt.Sprintf("proxy-%s-%d-2", naming.hostname, os.Getpid())
+	if name, err := naming.Name("proxy", 2); err != nil || name != expected {
+		t.Fatalf("the name is '%s', %v, expected '%s'", name, err, expected)
+	}
+
+	if _, err := NewNaming("{{.Name"); err == nil {
+		t.Fatalf("the invalid template is parsed")
+	}
+	for _, text := range []string{"{{.Region}}", "{{if false}}x{{end}}"} {
+		naming, err := NewNaming(text)
+		if err != nil {
+			t.Fatalf("NewNaming('%s'): %v", text, err)
+		}
+		if name, err := naming.Name("proxy", 1); err == nil {
+			t.Fatalf("'%s' gave the name '%s'", text, name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ClaimParam is the request parameter with the key of the offloaded parameters
+const ClaimParam = "claim_check"
+
+// BlobStore keeps the large payloads outside the messages.
+// Implement it to offload the payloads into S3, MinIO or any other storage.
+type BlobStore interface {
+	Put(ctx context.Context, data []byte) (string, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// DiskStore keeps the payloads as the files in the directory.
+// It's shared only by the proxy and the destination on the same machine.
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore returns the store in the directory
+func NewDiskStore(dir string) *DiskStore {
+	return &DiskStore{dir: dir}
+}
+
+// path returns the file of the key. The key must be generated by Put
+func (store *DiskStore) path(key string) (string, error) {
+	if len(key) == 0 || filepath.Base(key) != key {
+		return "", fmt.Errorf("invalid key '%s'", key)
+	}
+	return filepath.Join(store.dir, key), nil
+}
+
+// Put writes the data into the new file
+func (store *DiskStore) Put(_ context.Context, data []byte) (string, error) {
+	key := NewId()
+	path, _ := store.path(key)
+	if err := writeFile(path, data); err != nil {
+		return "", fmt.Errorf("writeFile: %w", err)
+	}
+	return key, nil
+}
+
+// Get reads the data of the key
+func (store *DiskStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile('%s'): %w", path, err)
+	}
+	return data, nil
+}
+
+// Delete the data of the key
+func (store *DiskStore) Delete(_ context.Context, key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("os.Remove('%s'): %w", path, err)
+	}
+	return nil
+}
+
+// Offloader replaces the parameters of the large requests with the claim check.
+// The destination fetches the parameters back with Claim.
+type Offloader struct {
+	// Threshold is the request size in bytes after which the parameters are offloaded
+	Threshold int
+	store     BlobStore
+}
+
+// NewOffloader returns the offloader of the requests above the threshold
+func NewOffloader(threshold int, store BlobStore) *Offloader {
+	return &Offloader{Threshold: threshold, store: store}
+}
+
+// Offload the parameters of the request if it's above the threshold.
+// Returns false if the request is small enough to be sent as is.
+func (offloader *Offloader) Offload(ctx context.Context, req *Envelope) (bool, error) {
+	if req.Size() <= offloader.Threshold {
+		return false, nil
+	}
+
+	data, err := json.Marshal(req.Parameters)
+	if err != nil {
+		return false, fmt.Errorf("json.Marshal: %w", err)
+	}
+	key, err := offloader.store.Put(ctx, data)
+	if err != nil {
+		return false, fmt.Errorf("store.Put: %w", err)
+	}
+
+	req.Parameters = map[string]interface{}{ClaimParam: key}
+	return true, nil
+}
+
+// Middleware offloads the large requests before passing them to the next handler
+func (offloader *Offloader) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			if _, err := offloader.Offload(context.Background(), req); err != nil {
+				return Fail(fmt.Sprintf("offloader.Offload: %v", err))
+			}
+			return next(req)
+		}
+	}
+}
+
+// Claim returns the offloaded parameters of the request.
+// It's the helper for the destination.
+// If the request was not offloaded, then its parameters are returned as is.
+// The payload stays in the store, since the request could be redelivered.
+// Call store.Delete once the request is processed.
+func Claim(ctx context.Context, store BlobStore, req *Request) (map[string]interface{}, error) {
+	key := req.StringParam(ClaimParam)
+	if len(key) == 0 {
+		return req.Parameters, nil
+	}
+
+	data, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("store.Get: %w", err)
+	}
+	var parameters map[string]interface{}
+	if err := json.Unmarshal(data, &parameters); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return parameters, nil
+}
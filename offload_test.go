@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestOffloadClaim checks that only the large requests are offloaded,
+// and the destination claims their parameters back
+func TestOffloadClaim(t *testing.T) {
+	ctx := context.Background()
+	store := NewDiskStore(t.TempDir())
+	offloader := NewOffloader(256, store)
+
+	var received *Envelope
+	handler := Wrap(func(req *Envelope) *Reply {
+		received = req
+		return Ok(nil)
+	}, offloader.Middleware())
+
+	handler(policyRequest("small", map[string]interface{}{"text": "hello"}))
+	if received.StringParam("text") != "hello" {
+		t.Fatalf("the small request is offloaded: %v", received.Parameters)
+	}
+
+	large := strings.Repeat("x", 1024)
+	handler(policyRequest("large", map[string]interface{}{"text": large}))
+	key := received.StringParam(ClaimParam)
+	if len(key) == 0 || len(received.Parameters) != 1 {
+		t.Fatalf("the large request is not offloaded: %d parameters", len(received.Parameters))
+	}
+
+	parameters, err := Claim(ctx, store, &received.Request)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if parameters["text"] != large {
+		t.Fatalf("claimed %d parameters", len(parameters))
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("store.Delete: %v", err)
+	}
+	if _, err := Claim(ctx, store, &received.Request); err == nil {
+		t.Fatalf("claimed the deleted payload")
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("deleting twice: %v", err)
+	}
+}
+
+// TestDiskStoreRejectsPaths checks that the keys can't escape the directory
+func TestDiskStoreRejectsPaths(t *testing.T) {
+	store := NewDiskStore(t.TempDir())
+	for _, key := range []string{"", "../secrets", "dir/key"} {
+		if _, err := store.Get(context.Background(), key); err == nil || !strings.Contains(err.Error(), "invalid key") {
+			t.Fatalf("'%s' key returned %v", key, err)
+		}
+		if err := store.Delete(context.Background(), key); err == nil {
+			t.Fatalf("deleted '%s' key", key)
+		}
+	}
+}
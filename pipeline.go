@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MiddlewareConfig is the middleware in the pipeline defined in the configuration.
+// The name could have an argument after the colon, for example 'transform:my-rule'.
+type MiddlewareConfig struct {
+	Name     string                 `json:"name" yaml:"name"`
+	Settings map[string]interface{} `json:"settings,omitempty" yaml:"settings,omitempty"`
+}
+
+// MiddlewareFactory creates the middleware from the configuration.
+// The argument is the part of the name after the colon.
+type MiddlewareFactory func(argument string, settings map[string]interface{}) (Middleware, error)
+
+var middlewares = struct {
+	sync.RWMutex
+	factories map[string]MiddlewareFactory
+}{factories: map[string]MiddlewareFactory{
+	"rewrite":      rewriteFactory,
+	"reply-filter": replyFilterFactory,
+	"probe":        probeFactory,
+	"transform":    transformFactory,
+	"timestamps":   timestampFactory,
+	"rate-limit":   rateLimitFactory,
+	"auth":         authFactory,
+	"timeout":      timeoutFactory,
+}}
+
+// RegisterMiddleware adds the middleware factory, so it could be used in the configuration
+func RegisterMiddleware(name string, factory MiddlewareFactory) error {
+	if len(name) == 0 || strings.Contains(name, ":") {
+		return fmt.Errorf("invalid middleware name '%s'", name)
+	}
+
+	middlewares.Lock()
+	defer middlewares.Unlock()
+
+	if _, ok := middlewares.factories[name]; ok {
+		return fmt.Errorf("middleware '%s' already registered", name)
+	}
+	middlewares.factories[name] = factory
+	return nil
+}
+
+// RegisteredMiddlewares returns the sorted names of the middlewares
+func RegisteredMiddlewares() []string {
+	middlewares.RLock()
+	names := make([]string, 0, len(middlewares.factories))
+	for name := range middlewares.factories {
+		names = append(names, name)
+	}
+	middlewares.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// BuildPipeline creates the middlewares in the order of the configuration.
+// Pass the result to Wrap or Pipe.
+func BuildPipeline(configs []MiddlewareConfig) ([]Middleware, error) {
+	pipeline := make([]Middleware, 0, len(configs))
+	for i, config := range configs {
+		middleware, err := newMiddleware(config)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline[%d]: %w", i, err)
+		}
+		pipeline = append(pipeline, middleware)
+	}
+	return pipeline, nil
+}
+
+// newMiddleware creates the middleware by the registered factory
+func newMiddleware(config MiddlewareConfig) (Middleware, error) {
+	name, argument := config.Name, ""
+	if at := strings.Index(config.Name, ":"); at > -1 {
+		name, argument = config.Name[:at], config.Name[at+1:]
+	}
+
+	middlewares.RLock()
+	factory, ok := middlewares.factories[name]
+	middlewares.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("middleware '%s' not registered", name)
+	}
+
+	middleware, err := factory(argument, config.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("middleware '%s': %w", config.Name, err)
+	}
+	return middleware, nil
+}
+
+// rewriteFactory expects the 'rules' setting with the list of RewriteRule
+func rewriteFactory(_ string, settings map[string]interface{}) (Middleware, error) {
+	var config struct {
+		Rules []RewriteRule `json:"rules"`
+	}
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	rewriter, err := NewRewriter(config.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("NewRewriter: %w", err)
+	}
+	return rewriter.Middleware(), nil
+}
+
+// replyFilterFactory expects the 'filters' setting with the ReplyFilter by command
+func replyFilterFactory(_ string, settings map[string]interface{}) (Middleware, error) {
+	var config struct {
+		Filters map[string]ReplyFilter `json:"filters"`
+	}
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	return NewReplyFilters(config.Filters).Middleware(), nil
+}
+
+// probeFactory uses the argument as the proxy name, and the optional 'last' setting
+func probeFactory(argument string, settings map[string]interface{}) (Middleware, error) {
+	var config struct {
+		Last bool `json:"last"`
+	}
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	return NewProbe(argument, config.Last).Middleware(), nil
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestBuildPipeline checks that the middlewares are created in the order of the configuration,
+// with the argument after the colon
+func TestBuildPipeline(t *testing.T) {
+	stamp := func(argument string, settings map[string]interface{}) (Middleware, error) {
+		return func(next Handler) Handler {
+			return func(req *Envelope) *Reply {
+				req.Parameters["stamps"] = req.StringParam("stamps") + argument
+				return next(req)
+			}
+		}, nil
+	}
+	if err := RegisterMiddleware("test-stamp", stamp); err != nil {
+		t.Fatalf("RegisterMiddleware: %v", err)
+	}
+	for _, name := range []string{"test-stamp", "", "test:stamp", "auth"} {
+		if err := RegisterMiddleware(name, stamp); err == nil {
+			t.Fatalf("registered '%s' middleware", name)
+		}
+	}
+	registered := strings.Join(RegisteredMiddlewares(), ",")
+	if !strings.Contains(registered, "test-stamp") || !strings.Contains(registered, "rewrite") {
+		t.Fatalf("the registered middlewares are %s", registered)
+	}
+
+	pipeline, err := BuildPipeline([]MiddlewareConfig{{Name: "test-stamp:a"}, {Name: "test-stamp:b"}})
+	if err != nil {
+		t.Fatalf("BuildPipeline: %v", err)
+	}
+	handler := Wrap(func(req *Envelope) *Reply {
+		return Ok(map[string]interface{}{"stamps": req.StringParam("stamps")})
+	}, pipeline...)
+	if reply := handler(policyRequest("ping", map[string]interface{}{})); reply.Parameters["stamps"] != "ab" {
+		t.Fatalf("the pipeline order is %v", reply.Parameters["stamps"])
+	}
+
+	_, err = BuildPipeline([]MiddlewareConfig{{Name: "test-stamp"}, {Name: "missing"}})
+	if err == nil || !strings.Contains(err.Error(), "pipeline[1]") {
+		t.Fatalf("the unknown middleware returned %v", err)
+	}
+	_, err = BuildPipeline([]MiddlewareConfig{{Name: "probe:proxy", Settings: map[string]interface{}{"last": "yes"}}})
+	if err == nil {
+		t.Fatalf("the invalid settings are accepted")
+	}
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+)
+
+// The principal tiers
+const (
+	FreeTier     = "free"
+	PaidTier     = "paid"
+	InternalTier = "internal"
+)
+
+// TierPolicy is the priority and the queue weight of the principal tier.
+// The queue with the weight 4 is served four times as often as the queue with the weight 1.
+type TierPolicy struct {
+	Priority int  `json:"priority" yaml:"priority"`
+	Weight   uint `json:"weight" yaml:"weight"`
+}
+
+// DefaultWorkers is the amount of the requests forwarded at once by the PriorityQueue.Dispatch
+const DefaultWorkers = 64
+
+// DefaultTiers returns the policies of the built-in tiers
+func DefaultTiers() map[string]TierPolicy {
+	return map[string]TierPolicy{
+		FreeTier:     {Priority: 0, Weight: 1},
+		PaidTier:     {Priority: 1, Weight: 4},
+		InternalTier: {Priority: 2, Weight: 8},
+	}
+}
+
+// PriorityQueue holds the envelopes per priority.
+// The queues are served by the smooth weighted round-robin,
+// so the low priority envelopes are delayed but not starved.
+type PriorityQueue struct {
+	mu       sync.Mutex
+	tiers    map[string]TierPolicy
+	levels   map[int]*priorityLevel
+	size     int
+	notify   chan struct{}
+	fallback TierPolicy
+}
+
+type priorityLevel struct {
+	priority int
+	weight   int
+	current  int
+	items    []*Envelope
+}
+
+// NewPriorityQueue returns the queue for the tiers.
+// The unknown tiers and priorities get the lowest priority.
+func NewPriorityQueue(tiers map[string]TierPolicy) *PriorityQueue {
+	if len(tiers) == 0 {
+		tiers = DefaultTiers()
+	}
+
+	queue := &PriorityQueue{
+		tiers:  tiers,
+		levels: make(map[int]*priorityLevel),
+		notify: make(chan struct{}, 1),
+	}
+	first := true
+	for _, policy := range tiers {
+		if first || policy.Priority < queue.fallback.Priority {
+			queue.fallback = TierPolicy{Priority: policy.Priority, Weight: 1}
+			first = false
+		}
+		queue.levels[policy.Priority] = &priorityLevel{priority: policy.Priority, weight: weight(policy.Weight)}
+	}
+
+	return queue
+}
+
+// Prioritize sets the priority of the envelope by the principal tier
+func (queue *PriorityQueue) Prioritize(envelope *Envelope, tier string) {
+	policy, ok := queue.tiers[tier]
+	if !ok {
+		policy = queue.fallback
+	}
+	envelope.Priority = policy.Priority
+}
+
+// Authenticate is WithAuth that also sets the priority by the tier of the principal.
+// The principals missing in the tiers get the lowest priority.
+// The priority sent by the client is always overwritten, so the client can't claim the higher tier.
+func (queue *PriorityQueue) Authenticate(verifier Verifier, tiers map[string]string) Middleware {
+	authenticate := WithAuth(verifier)
+	return func(next Handler) Handler {
+		return authenticate(func(req *Envelope) *Reply {
+			queue.Prioritize(req, tiers[req.Principal])
+			return next(req)
+		})
+	}
+}
+
+// Dispatch queues the requests by their priority, and the workers pop them into the next handler.
+// So under the load, the higher priority requests overtake the lower ones.
+// The workers run until the context is cancelled, then the waiting requests fail.
+// Put it after Authenticate, so the priority is set by the proxy.
+// The queue must have only one Dispatch, since the workers reply to the requests of their own middleware.
+func (queue *PriorityQueue) Dispatch(ctx context.Context, workers int) Middleware {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	return func(next Handler) Handler {
+		var mu sync.Mutex
+		waiting := make(map[*Envelope]chan *Reply)
+
+		for i := 0; i < workers; i++ {
+			go func() {
+				for {
+					envelope, err := queue.Pop(ctx)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					replied, ok := waiting[envelope]
+					delete(waiting, envelope)
+					mu.Unlock()
+					// the request gave up while it was queued
+					if !ok {
+						continue
+					}
+					replied <- next(envelope)
+				}
+			}()
+		}
+
+		return func(req *Envelope) *Reply {
+			// the copy is the key of the waiting request
+			queued := *req
+			replied := make(chan *Reply, 1)
+			mu.Lock()
+			waiting[&queued] = replied
+			mu.Unlock()
+			queue.Push(&queued)
+
+			select {
+			case reply := <-replied:
+				return reply
+			case <-ctx.Done():
+				mu.Lock()
+				delete(waiting, &queued)
+				mu.Unlock()
+				return Fail("proxy is stopping")
+			}
+		}
+	}
+}
+
+// Push the envelope into the queue of its priority.
+// The envelope with the priority of no tier is queued with the lowest priority.
+func (queue *PriorityQueue) Push(envelope *Envelope) {
+	queue.mu.Lock()
+	level, ok := queue.levels[envelope.Priority]
+	if !ok {
+		envelope.Priority = queue.fallback.Priority
+		level = queue.levels[envelope.Priority]
+	}
+	level.items = append(level.items, envelope)
+	queue.size++
+	queue.mu.Unlock()
+
+	select {
+	case queue.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Pop returns the next envelope.
+// Blocks until there is an envelope or the context is done.
+func (queue *PriorityQueue) Pop(ctx context.Context) (*Envelope, error) {
+	for {
+		if envelope, ok := queue.TryPop(); ok {
+			return envelope, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-queue.notify:
+		}
+	}
+}
+
+// TryPop returns the next envelope without blocking.
+// Returns false if the queue is empty.
+func (queue *PriorityQueue) TryPop() (*Envelope, bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if queue.size == 0 {
+		return nil, false
+	}
+
+	total := 0
+	var selected *priorityLevel
+	for _, level := range queue.levels {
+		if len(level.items) == 0 {
+			continue
+		}
+		level.current += level.weight
+		total += level.weight
+		// on a tie, the higher priority wins
+		if selected == nil || level.current > selected.current ||
+			(level.current == selected.current && level.priority > selected.priority) {
+			selected = level
+		}
+	}
+	selected.current -= total
+
+	envelope := selected.items[0]
+	selected.items[0] = nil
+	selected.items = selected.items[1:]
+	queue.size--
+
+	// wake up the other consumers
+	if queue.size > 0 {
+		select {
+		case queue.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	return envelope, true
+}
+
+// Len returns the amount of the queued envelopes
+func (queue *PriorityQueue) Len() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	return queue.size
+}
+
+// weight returns at least one, so the queue is never starved
+func weight(value uint) int {
+	if value == 0 {
+		return 1
+	}
+	return int(value)
+}
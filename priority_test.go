@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until the queue has the amount of the envelopes
+func waitQueued(t *testing.T, queue *PriorityQueue, amount int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for queue.Len() < amount {
+		if time.Now().After(deadline) {
+			t.Fatalf("the queue has %d envelopes, expected %d", queue.Len(), amount)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestPriorityQueueWeightedOrder checks that the queues are served in the proportion of their weights
+func TestPriorityQueueWeightedOrder(t *testing.T) {
+	queue := NewPriorityQueue(nil)
+	tiers := DefaultTiers()
+	for i := 0; i < 26; i++ {
+		for _, tier := range []string{FreeTier, PaidTier, InternalTier} {
+			envelope := NewEnvelope(&Request{Command: tier})
+			queue.Prioritize(envelope, tier)
+			queue.Push(envelope)
+		}
+	}
+
+	// one round of the weights 1, 4 and 8 serves 13 envelopes
+	served := make(map[string]int)
+	for i := 0; i < 26; i++ {
+		envelope, ok := queue.TryPop()
+		if !ok {
+			t.Fatalf("the queue is empty after %d pops", i)
+		}
+		served[envelope.Command]++
+	}
+	for tier, policy := range tiers {
+		if expected := 2 * int(policy.Weight); served[tier] != expected {
+			t.Fatalf("served %v, expected %d of '%s'", served, expected, tier)
+		}
+	}
+
+	// the lowest priority is not starved
+	envelope := NewEnvelope(&Request{Command: "unknown"})
+	envelope.Priority = 100
+	queue.Push(envelope)
+	if envelope.Priority != tiers[FreeTier].Priority {
+		t.Fatalf("the unknown priority is queued as %d", envelope.Priority)
+	}
+	if queue.Len() != 3*26-26+1 {
+		t.Fatalf("the queue has %d envelopes", queue.Len())
+	}
+}
+
+// TestPriorityDispatchOvertakes checks that the queued higher priority request is forwarded first
+func TestPriorityDispatchOvertakes(t *testing.T) {
+	queue := NewPriorityQueue(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	busy := make(chan struct{})
+	release := make(chan struct{})
+	order := make(chan string, 3)
+	handler := Wrap(func(req *Envelope) *Reply {
+		if req.Command == "busy" {
+			close(busy)
+			<-release
+		}
+		order <- req.Command
+		return Ok(nil)
+	}, queue.Dispatch(ctx, 1))
+
+	request := func(command string, tier string) chan *Reply {
+		replied := make(chan *Reply, 1)
+		envelope := NewEnvelope(&Request{Command: command})
+		queue.Prioritize(envelope, tier)
+		go func() { replied <- handler(envelope) }()
+		return replied
+	}
+	first := request("busy", FreeTier)
+	<-busy
+	free := request("free", FreeTier)
+	// the requests are pushed in order
+	waitQueued(t, queue, 1)
+	internal := request("internal", InternalTier)
+	waitQueued(t, queue, 2)
+	close(release)
+
+	for _, replied := range []chan *Reply{first, free, internal} {
+		if reply := <-replied; !reply.IsOK() {
+			t.Fatalf("request failed: %s", reply.Message)
+		}
+	}
+	for _, expected := range []string{"busy", "internal", "free"} {
+		if command := <-order; command != expected {
+			t.Fatalf("forwarded '%s', expected '%s'", command, expected)
+		}
+	}
+
+	cancel()
+	if reply := handler(NewEnvelope(&Request{Command: "late"})); reply.IsOK() {
+		t.Fatalf("the request was forwarded after the workers stopped")
+	}
+}
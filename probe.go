@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+)
+
+// The reserved commands answered by the proxy itself
+const (
+	// PingCommand is replied by the proxy without touching the destination
+	PingCommand = "proxy.ping"
+	// TraceCommand is forwarded through the proxy chain, each proxy adds its hop to the reply
+	TraceCommand = "proxy.trace"
+)
+
+// HopsParam is the reply parameter of the TraceCommand with the list of hops
+const HopsParam = "hops"
+
+// Probe answers the PingCommand and TraceCommand
+type Probe struct {
+	// Name of the proxy in the hops
+	Name string
+	// Last is true if the proxy is the last one in the chain.
+	// The last proxy doesn't forward the TraceCommand to the destination.
+	Last bool
+	now  func() time.Time
+}
+
+// NewProbe returns the probe of the proxy
+func NewProbe(name string, last bool) *Probe {
+	return &Probe{Name: name, Last: last, now: time.Now}
+}
+
+// Ping returns the reply with the time when the request was received and replied
+func (probe *Probe) Ping(received time.Time) *Reply {
+	return Ok(map[string]interface{}{
+		"name":     probe.Name,
+		"received": received.UnixNano(),
+		"replied":  probe.now().UnixNano(),
+	})
+}
+
+// Middleware replies to the probe commands.
+// The other commands are passed to the next handler.
+func (probe *Probe) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			switch req.Command {
+			case PingCommand:
+				return probe.Ping(probe.now())
+			case TraceCommand:
+				received := probe.now()
+				var reply *Reply
+				if probe.Last {
+					reply = Ok(nil)
+				} else if reply = next(req); reply == nil {
+					reply = Fail(fmt.Sprintf("no reply to '%s'", req.Command))
+				} else {
+					// the destination may share the reply
+					reply = reply.Copy()
+				}
+				probe.addHop(reply, received)
+				return reply
+			default:
+				return next(req)
+			}
+		}
+	}
+}
+
+// addHop puts this proxy in the beginning of the hops,
+// so the hops are listed from the entry proxy to the last proxy.
+func (probe *Probe) addHop(reply *Reply, received time.Time) {
+	if reply.Parameters == nil {
+		reply.Parameters = map[string]interface{}{}
+	}
+
+	hop := map[string]interface{}{
+		"name":       probe.Name,
+		"status":     reply.Status,
+		"latency_ns": probe.now().Sub(received).Nanoseconds(),
+	}
+
+	hops := []interface{}{hop}
+	if downstream, ok := reply.Parameters[HopsParam].([]interface{}); ok {
+		hops = append(hops, downstream...)
+	}
+	reply.Parameters[HopsParam] = hops
+}
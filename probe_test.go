@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"testing"
+)
+
+// TestProbeTracesChain checks that each proxy of the chain adds its hop from the entry to the last one
+func TestProbeTracesChain(t *testing.T) {
+	last := Wrap(func(*Envelope) *Reply { return Fail("the trace reached the destination") }, NewProbe("last", true).Middleware())
+	entry := Wrap(last, NewProbe("entry", false).Middleware())
+
+	reply := entry(policyRequest(TraceCommand, nil))
+	hops, ok := reply.Parameters[HopsParam].([]interface{})
+	if !reply.IsOK() || !ok || len(hops) != 2 {
+		t.Fatalf("the trace replied %v", reply)
+	}
+	for i, name := range []string{"entry", "last"} {
+		if hop := hops[i].(map[string]interface{}); hop["name"] != name {
+			t.Fatalf("hops[%d] is '%v', expected '%s'", i, hop["name"], name)
+		}
+	}
+
+	if reply := entry(policyRequest(PingCommand, nil)); !reply.IsOK() || reply.Parameters["name"] != "entry" {
+		t.Fatalf("the ping replied %v", reply)
+	}
+}
+
+// TestProbeTraceWithoutReply checks that the missing reply of the next proxy is the failed hop
+func TestProbeTraceWithoutReply(t *testing.T) {
+	shared := Ok(map[string]interface{}{})
+	probe := NewProbe("entry", false).Middleware()
+
+	reply := probe(func(*Envelope) *Reply { return nil })(policyRequest(TraceCommand, nil))
+	if reply == nil || reply.IsOK() {
+		t.Fatalf("the trace without the reply replied %v", reply)
+	}
+	if hops, ok := reply.Parameters[HopsParam].([]interface{}); !ok || len(hops) != 1 {
+		t.Fatalf("the failed trace has no hop: %v", reply)
+	}
+
+	probe(func(*Envelope) *Reply { return shared })(policyRequest(TraceCommand, nil))
+	if _, ok := shared.Parameters[HopsParam]; ok {
+		t.Fatalf("the hop was added to the shared reply")
+	}
+}
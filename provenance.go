@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ExplainCommand returns the effective configuration with the layer of each value.
+// The request has the 'command' parameter to explain the route policy,
+// or the 'tenant' parameter to explain the tenant configuration.
+const ExplainCommand = "proxy.explain"
+
+// The configuration layers
+const (
+	DefaultLayer = "default"
+	GroupLayer   = "group"
+	BaseLayer    = "base"
+	TenantLayer  = "tenant"
+	OverlayLayer = "overlay"
+)
+
+// provenance returns the layer of each field by its json name.
+// The layers are given in the order they are merged, so the last layer where the field is set wins.
+// The fields that are not set in any layer are omitted.
+func provenance(names []string, layers ...interface{}) map[string]string {
+	sources := make(map[string]string)
+	for i, layer := range layers {
+		value := reflect.ValueOf(layer)
+		for field := 0; field < value.NumField(); field++ {
+			name := strings.Split(value.Type().Field(field).Tag.Get("json"), ",")[0]
+			if len(name) == 0 || name == "-" {
+				continue
+			}
+			fieldValue := value.Field(field)
+			switch fieldValue.Kind() {
+			case reflect.Slice, reflect.Map:
+				if fieldValue.Len() == 0 {
+					continue
+				}
+			default:
+				if fieldValue.IsZero() {
+					continue
+				}
+			}
+			sources[name] = names[i]
+		}
+	}
+	return sources
+}
+
+// Explain returns the policy of the command, and the layer of each policy value
+func (routes *Routes) Explain(command string) (RoutePolicy, map[string]string) {
+	at, ok := routes.commands[command]
+	if !ok {
+		return routes.Default, provenance([]string{DefaultLayer}, routes.Default)
+	}
+	group := routes.groups[at]
+	sources := provenance([]string{DefaultLayer, GroupLayer + " '" + group.Name + "'"}, routes.Default, group.RoutePolicy)
+	return routes.Default.Merge(group.RoutePolicy), sources
+}
+
+// Explain returns the configuration of the tenant, and the layer of each configuration value
+func (tenants *Tenants) Explain(tenant string) (TenantConfig, map[string]string, error) {
+	names := []string{BaseLayer, TenantLayer}
+	layers := []interface{}{tenants.Base, tenants.tenants[tenant]}
+	if tenants.overlays != nil {
+		overlay, err := tenants.overlays.Overlay(tenant)
+		if err != nil {
+			return TenantConfig{}, nil, fmt.Errorf("overlays.Overlay: %w", err)
+		}
+		names = append(names, OverlayLayer)
+		layers = append(layers, overlay)
+	}
+
+	config, err := tenants.Config(tenant)
+	if err != nil {
+		return TenantConfig{}, nil, fmt.Errorf("tenants.Config: %w", err)
+	}
+	return config, provenance(names, layers...), nil
+}
+
+// ExplainHandler replies to the ExplainCommand.
+// The table or the tenants could be nil, then they are not explained.
+func ExplainHandler(table *RouteTable, tenants *Tenants) Handler {
+	return func(req *Envelope) *Reply {
+		var values interface{}
+		var sources map[string]string
+
+		if command := req.StringParam("command"); len(command) > 0 && table != nil {
+			values, sources = table.Routes().Explain(command)
+		} else if tenant := req.StringParam("tenant"); len(tenant) > 0 && tenants != nil {
+			config, explained, err := tenants.Explain(tenant)
+			if err != nil {
+				return Fail(fmt.Sprintf("tenants.Explain: %v", err))
+			}
+			values, sources = config, explained
+		} else {
+			return Fail("missing 'command' or 'tenant' parameter")
+		}
+
+		parameters, err := toParameters(values)
+		if err != nil {
+			return Fail(fmt.Sprintf("toParameters: %v", err))
+		}
+		return Ok(map[string]interface{}{
+			"values":  parameters,
+			"sources": sources,
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import "testing"
+
+// TestExplainRoutePolicy checks that each policy value has the layer where it's set
+func TestExplainRoutePolicy(t *testing.T) {
+	routes, err := NewRoutes(RoutePolicy{Destination: "shared", Timeout: 500}, []RouteGroup{
+		{Name: "users", Commands: []string{"users.get"}, RoutePolicy: RoutePolicy{Timeout: 100, Auth: "bearer"}},
+	})
+	if err != nil {
+		t.Fatalf("NewRoutes: %v", err)
+	}
+	table := NewRouteTable(routes, "")
+	handler := ExplainHandler(table, nil)
+
+	reply := handler(policyRequest(ExplainCommand, map[string]interface{}{"command": "users.get"}))
+	if !reply.IsOK() {
+		t.Fatalf("the explain command failed: %s", reply.Message)
+	}
+	values := reply.Parameters["values"].(map[string]interface{})
+	sources := reply.Parameters["sources"].(map[string]string)
+	if values["destination"] != "shared" || values["timeout"] != float64(100) {
+		t.Fatalf("the effective policy is %v", values)
+	}
+	expected := map[string]string{"destination": DefaultLayer, "timeout": GroupLayer + " 'users'", "auth": GroupLayer + " 'users'"}
+	if len(sources) != len(expected) {
+		t.Fatalf("the sources are %v", sources)
+	}
+	for name, layer := range expected {
+		if sources[name] != layer {
+			t.Fatalf("'%s' is from '%s', expected '%s'", name, sources[name], layer)
+		}
+	}
+
+	_, sources = routes.Explain("users.list")
+	if sources["timeout"] != DefaultLayer || len(sources["auth"]) > 0 {
+		t.Fatalf("the command out of the groups has the sources %v", sources)
+	}
+	if reply := handler(policyRequest(ExplainCommand, map[string]interface{}{"tenant": "acme"})); reply.IsOK() {
+		t.Fatalf("explained the tenant without the tenants")
+	}
+}
+
+// TestExplainTenant checks the layers of the tenant configuration
+func TestExplainTenant(t *testing.T) {
+	tenants := NewTenants(map[string]TenantConfig{"acme": {DailyQuota: 10}}, nil)
+	tenants.Base = TenantConfig{DailyQuota: 100, RateLimit: 5}
+
+	reply := ExplainHandler(nil, tenants)(policyRequest(ExplainCommand, map[string]interface{}{"tenant": "acme"}))
+	if !reply.IsOK() {
+		t.Fatalf("the explain command failed: %s", reply.Message)
+	}
+	values := reply.Parameters["values"].(map[string]interface{})
+	sources := reply.Parameters["sources"].(map[string]string)
+	if values["daily_quota"] != float64(10) || sources["daily_quota"] != TenantLayer || sources["rate_limit"] != BaseLayer {
+		t.Fatalf("the tenant is explained as %v from %v", values, sources)
+	}
+	if reply := ExplainHandler(nil, tenants)(policyRequest(ExplainCommand, nil)); reply.IsOK() {
+		t.Fatalf("explained without the parameters")
+	}
+}
@@ -0,0 +1,334 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RetryAfterParam is the fail reply parameter with the seconds to wait before trying again
+const RetryAfterParam = "retry_after"
+
+// DefaultMaxBuckets is the amount of the client and tenant buckets kept before the idle ones are dropped
+const DefaultMaxBuckets = 10000
+
+// RateLimitConfig is the requests per second allowed by the rate limiter. Zero means no limit.
+type RateLimitConfig struct {
+	Global float64 `json:"global,omitempty" yaml:"global,omitempty"`
+	// Commands limits each command separately
+	Commands map[string]float64 `json:"commands,omitempty" yaml:"commands,omitempty"`
+	// Client limits each client separately
+	Client float64 `json:"client,omitempty" yaml:"client,omitempty"`
+	// ClientParam is the request parameter with the client identity.
+	// If it's empty, then the authenticated principal of the envelope is the client.
+	ClientParam string `json:"client_param,omitempty" yaml:"client_param,omitempty"`
+	// Burst is the seconds of the rate allowed at once. Zero means one second
+	Burst float64 `json:"burst,omitempty" yaml:"burst,omitempty"`
+	// MaxBuckets is the limit of the client buckets and of the tenant buckets. Zero means DefaultMaxBuckets.
+	// Over the limit, the idle buckets are dropped first, then the least recently used ones.
+	MaxBuckets int `json:"max_buckets,omitempty" yaml:"max_buckets,omitempty"`
+}
+
+// tokenBucket refills at the rate of tokens per second up to the burst.
+// Each request takes one token.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens since the last refill, and updates the rate if it was changed
+func (bucket *tokenBucket) refill(now time.Time, rate float64, burst float64) {
+	capacity := math.Max(rate*burst, 1)
+	if bucket.last.IsZero() {
+		bucket.tokens = capacity
+	} else {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+	}
+	bucket.tokens = math.Min(bucket.tokens, capacity)
+	bucket.rate = rate
+	bucket.last = now
+}
+
+// wait returns the time until the bucket has a token
+func (bucket *tokenBucket) wait() time.Duration {
+	if bucket.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+}
+
+// RateLimiter rejects the requests over the global, per-command, per-tenant and per-client limits.
+// The request is counted in all the limits only if none of them is exceeded.
+type RateLimiter struct {
+	config   RateLimitConfig
+	mu       sync.Mutex
+	global   tokenBucket
+	commands map[string]*tokenBucket
+	clients  map[string]*tokenBucket
+	tenants  map[string]*tokenBucket
+	routes   *RouteTable
+	tenancy  *Tenants
+	now      func() time.Time
+}
+
+// NewRateLimiter returns the rate limiter with the limits
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	if config.Burst <= 0 {
+		config.Burst = 1
+	}
+	if config.MaxBuckets <= 0 {
+		config.MaxBuckets = DefaultMaxBuckets
+	}
+	return &RateLimiter{
+		config:   config,
+		commands: make(map[string]*tokenBucket),
+		clients:  make(map[string]*tokenBucket),
+		tenants:  make(map[string]*tokenBucket),
+		now:      time.Now,
+	}
+}
+
+// WithRoutes limits the commands by the RateLimit of their route policy.
+// The route policy takes precedence over the per-command limit of the configuration.
+func (limiter *RateLimiter) WithRoutes(routes *RouteTable) *RateLimiter {
+	limiter.routes = routes
+	return limiter
+}
+
+// WithTenants limits the tenants by the RateLimit of their configuration
+func (limiter *RateLimiter) WithTenants(tenants *Tenants) *RateLimiter {
+	limiter.tenancy = tenants
+	return limiter
+}
+
+// limit is the bucket with its rate to check
+type limit struct {
+	name   string
+	bucket *tokenBucket
+	rate   float64
+}
+
+// bucket returns the bucket of the key, creating it if necessary
+func bucket(buckets map[string]*tokenBucket, key string) *tokenBucket {
+	found, ok := buckets[key]
+	if !ok {
+		found = &tokenBucket{}
+		buckets[key] = found
+	}
+	return found
+}
+
+// tenantRate returns the tenant of the request and its rate limit.
+// The tenant configuration may be read from the overlay file, so it's called without the lock.
+func (limiter *RateLimiter) tenantRate(req *Envelope) (string, float64) {
+	if limiter.tenancy == nil {
+		return "", 0
+	}
+	tenant, err := limiter.tenancy.Tenant(req)
+	if err != nil {
+		return "", 0
+	}
+	config, err := limiter.tenancy.Config(tenant)
+	if err != nil {
+		return "", 0
+	}
+	return tenant, config.RateLimit
+}
+
+// limits returns the limits applied to the request with the rate limit of its tenant.
+// Must be called with the lock.
+func (limiter *RateLimiter) limits(req *Envelope, tenant string, tenantRate float64) []limit {
+	limits := make([]limit, 0, 4)
+	if limiter.config.Global > 0 {
+		limits = append(limits, limit{"global", &limiter.global, limiter.config.Global})
+	}
+
+	rate := limiter.config.Commands[req.Command]
+	if limiter.routes != nil {
+		if policy, _ := limiter.routes.Routes().Policy(req.Command); policy.RateLimit > 0 {
+			rate = policy.RateLimit
+		}
+	}
+	if rate > 0 {
+		limits = append(limits, limit{"command '" + req.Command + "'", bucket(limiter.commands, req.Command), rate})
+	}
+
+	if tenantRate > 0 {
+		limits = append(limits, limit{"tenant '" + tenant + "'", bucket(limiter.tenants, tenant), tenantRate})
+	}
+
+	if limiter.config.Client > 0 {
+		client := req.Principal
+		if len(limiter.config.ClientParam) > 0 {
+			client = req.StringParam(limiter.config.ClientParam)
+		}
+		if len(client) > 0 {
+			limits = append(limits, limit{"client '" + client + "'", bucket(limiter.clients, client), limiter.config.Client})
+		}
+	}
+	return limits
+}
+
+// Allow takes the token of the request.
+// If any limit is exceeded, then returns its name and the time until the request could pass.
+func (limiter *RateLimiter) Allow(req *Envelope) (string, time.Duration) {
+	now := limiter.now()
+	tenant, tenantRate := limiter.tenantRate(req)
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limits := limiter.limits(req, tenant, tenantRate)
+	exceeded, retryAfter := "", time.Duration(0)
+	for _, limit := range limits {
+		limit.bucket.refill(now, limit.rate, limiter.config.Burst)
+		if wait := limit.bucket.wait(); wait > retryAfter {
+			exceeded, retryAfter = limit.name, wait
+		}
+	}
+	if retryAfter > 0 {
+		return exceeded, retryAfter
+	}
+
+	for _, limit := range limits {
+		limit.bucket.tokens--
+	}
+	limiter.dropIdle(now)
+	return "", 0
+}
+
+// dropIdle removes the full buckets of the clients and tenants, once there are too many of them.
+// The full bucket is the same as the missing one.
+// If there are still too many buckets, then the least recently used ones are dropped.
+//
+// The buckets are swept only after they grow by a tenth over the MaxBuckets,
+// so the sweep is amortized over the new buckets instead of done on every request.
+// Must be called with the lock.
+func (limiter *RateLimiter) dropIdle(now time.Time) {
+	threshold := limiter.config.MaxBuckets + limiter.config.MaxBuckets/10
+	for _, buckets := range []map[string]*tokenBucket{limiter.clients, limiter.tenants} {
+		if len(buckets) <= threshold {
+			continue
+		}
+		for key, bucket := range buckets {
+			bucket.refill(now, bucket.rate, limiter.config.Burst)
+			if bucket.tokens >= math.Max(bucket.rate*limiter.config.Burst, 1) {
+				delete(buckets, key)
+			}
+		}
+		dropLeastUsed(buckets, limiter.config.MaxBuckets)
+	}
+}
+
+// dropLeastUsed removes the buckets refilled the earliest, until the limit is left
+func dropLeastUsed(buckets map[string]*tokenBucket, limit int) {
+	if len(buckets) <= limit {
+		return
+	}
+	keys := make([]string, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return buckets[keys[i]].last.Before(buckets[keys[j]].last)
+	})
+	for _, key := range keys[:len(keys)-limit] {
+		delete(buckets, key)
+	}
+}
+
+// bucketState is the token bucket that survives the restart
+type bucketState struct {
+	Rate   float64   `json:"rate"`
+	Tokens float64   `json:"tokens"`
+	Last   time.Time `json:"last"`
+}
+
+// rateLimitState is the snapshot of the rate limiter buckets
+type rateLimitState struct {
+	Global   bucketState            `json:"global"`
+	Commands map[string]bucketState `json:"commands,omitempty"`
+	Clients  map[string]bucketState `json:"clients,omitempty"`
+	Tenants  map[string]bucketState `json:"tenants,omitempty"`
+}
+
+// saveBuckets returns the states of the buckets
+func saveBuckets(buckets map[string]*tokenBucket) map[string]bucketState {
+	states := make(map[string]bucketState, len(buckets))
+	for key, bucket := range buckets {
+		states[key] = bucketState{Rate: bucket.rate, Tokens: bucket.tokens, Last: bucket.last}
+	}
+	return states
+}
+
+// loadBuckets returns the buckets of the states
+func loadBuckets(states map[string]bucketState) map[string]*tokenBucket {
+	buckets := make(map[string]*tokenBucket, len(states))
+	for key, state := range states {
+		buckets[key] = &tokenBucket{rate: state.Rate, tokens: state.Tokens, last: state.Last}
+	}
+	return buckets
+}
+
+// Snapshot returns the buckets, so the clients don't get the full burst again after the restart
+func (limiter *RateLimiter) Snapshot() ([]byte, error) {
+	limiter.mu.Lock()
+	state := rateLimitState{
+		Global:   bucketState{Rate: limiter.global.rate, Tokens: limiter.global.tokens, Last: limiter.global.last},
+		Commands: saveBuckets(limiter.commands),
+		Clients:  saveBuckets(limiter.clients),
+		Tenants:  saveBuckets(limiter.tenants),
+	}
+	limiter.mu.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	return data, nil
+}
+
+// Restore the buckets from the snapshot.
+// The buckets are refilled for the time since the snapshot on the next request.
+func (limiter *RateLimiter) Restore(data []byte) error {
+	var state rateLimitState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	limiter.mu.Lock()
+	limiter.global = tokenBucket{rate: state.Global.Rate, tokens: state.Global.Tokens, last: state.Global.Last}
+	limiter.commands = loadBuckets(state.Commands)
+	limiter.clients = loadBuckets(state.Clients)
+	limiter.tenants = loadBuckets(state.Tenants)
+	limiter.mu.Unlock()
+	return nil
+}
+
+// Middleware replies with the fail and the RetryAfterParam to the requests over the limits
+func (limiter *RateLimiter) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			exceeded, retryAfter := limiter.Allow(req)
+			if retryAfter == 0 {
+				return next(req)
+			}
+			reply := Fail(fmt.Sprintf("rate limit of %s exceeded", exceeded))
+			reply.Parameters[RetryAfterParam] = retryAfter.Seconds()
+			return reply
+		}
+	}
+}
+
+// rateLimitFactory expects the settings of RateLimitConfig
+func rateLimitFactory(_ string, settings map[string]interface{}) (Middleware, error) {
+	var config RateLimitConfig
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	return NewRateLimiter(config).Middleware(), nil
+}
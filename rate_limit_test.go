@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// exhaust takes every token of the global limit at the fixed time, and returns the amount allowed
+func exhaust(limiter *RateLimiter, requests int) int32 {
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if exceeded, _ := limiter.Allow(NewEnvelope(&Request{Command: "get"})); len(exceeded) == 0 {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	return allowed
+}
+
+// TestRateLimiterConcurrentAllow checks that the concurrent requests don't take more tokens than the burst
+func TestRateLimiterConcurrentAllow(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{Global: 10})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	if allowed := exhaust(limiter, 50); allowed != 10 {
+		t.Fatalf("allowed %d requests over the burst of 10", allowed)
+	}
+	exceeded, retryAfter := limiter.Allow(NewEnvelope(&Request{Command: "get"}))
+	if exceeded != "global" || retryAfter <= 0 {
+		t.Fatalf("exceeded '%s' retry after %s, expected the global limit", exceeded, retryAfter)
+	}
+
+	now = now.Add(time.Second)
+	if allowed := exhaust(limiter, 50); allowed != 10 {
+		t.Fatalf("allowed %d requests after the refill, expected 10", allowed)
+	}
+}
+
+// TestRateLimiterMaxBuckets checks that the client buckets stay around the limit
+func TestRateLimiterMaxBuckets(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{Client: 1, ClientParam: "client", MaxBuckets: 100})
+	for i := 0; i < 1000; i++ {
+		limiter.Allow(NewEnvelope(&Request{Command: "get", Parameters: map[string]interface{}{"client": fmt.Sprint(i)}}))
+	}
+	if buckets := len(limiter.clients); buckets > 110 {
+		t.Fatalf("%d client buckets over the limit of 100", buckets)
+	}
+}
+
+// TestRateLimiterSnapshotRestore checks that the restart doesn't refill the exhausted buckets
+func TestRateLimiterSnapshotRestore(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{Global: 10})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	exhaust(limiter, 10)
+
+	data, err := limiter.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	restored := NewRateLimiter(RateLimitConfig{Global: 10})
+	restored.now = limiter.now
+	if err := restored.Restore(data); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if exceeded, _ := restored.Allow(NewEnvelope(&Request{Command: "get"})); len(exceeded) == 0 {
+		t.Fatalf("the restart refilled the exhausted bucket")
+	}
+}
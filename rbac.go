@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+)
+
+// RBACConfig is the role-based access to the admin commands.
+// The command patterns support the wildcards, for example 'route.*'.
+type RBACConfig struct {
+	// Roles maps the role to the command patterns it may call
+	Roles map[string][]string `json:"roles" yaml:"roles"`
+	// Principals maps the principal to its roles
+	Principals map[string][]string `json:"principals" yaml:"principals"`
+}
+
+// AuditRecord is the admin command call
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal"`
+	Command   string    `json:"command"`
+	Allowed   bool      `json:"allowed"`
+	Status    string    `json:"status,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// RBAC allows the admin commands by the roles of the principal.
+// Every call is written to the audit log.
+type RBAC struct {
+	config   RBACConfig
+	mu       sync.Mutex
+	audit    *json.Encoder
+	scrubber *Scrubber
+}
+
+// NewRBAC returns the access control with the audit log.
+// If the audit is nil, then the calls are not recorded.
+func NewRBAC(config RBACConfig, audit io.Writer) (*RBAC, error) {
+	for role, patterns := range config.Roles {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("role '%s' pattern '%s': %w", role, pattern, err)
+			}
+		}
+	}
+	for principal, roles := range config.Principals {
+		for _, role := range roles {
+			if _, ok := config.Roles[role]; !ok {
+				return nil, fmt.Errorf("principal '%s' has unknown role '%s'", principal, role)
+			}
+		}
+	}
+
+	rbac := &RBAC{config: config}
+	if audit != nil {
+		rbac.audit = json.NewEncoder(audit)
+	}
+	return rbac, nil
+}
+
+// WithScrubber masks the secrets in the audited reply messages
+func (rbac *RBAC) WithScrubber(scrubber *Scrubber) *RBAC {
+	rbac.scrubber = scrubber
+	return rbac
+}
+
+// Allowed returns true if any role of the principal may call the command
+func (rbac *RBAC) Allowed(principal string, command string) bool {
+	for _, role := range rbac.config.Principals[principal] {
+		for _, pattern := range rbac.config.Roles[role] {
+			if matched, _ := path.Match(pattern, command); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// record writes the audit record
+func (rbac *RBAC) record(record AuditRecord) {
+	if rbac.audit == nil {
+		return
+	}
+
+	if rbac.scrubber != nil {
+		record.Message = rbac.scrubber.String(record.Message)
+	}
+
+	rbac.mu.Lock()
+	defer rbac.mu.Unlock()
+	// the audit is the best effort, it never fails the command
+	_ = rbac.audit.Encode(record)
+}
+
+// Middleware rejects the admin commands that the principal is not allowed to call.
+// The principal is set by the authentication.
+func (rbac *RBAC) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			record := AuditRecord{Time: time.Now(), Principal: req.Principal, Command: req.Command}
+
+			if !rbac.Allowed(req.Principal, req.Command) {
+				rbac.record(record)
+				return Fail(fmt.Sprintf("'%s' is not allowed to call '%s'", req.Principal, req.Command))
+			}
+
+			reply := next(req)
+			record.Allowed = true
+			if reply != nil {
+				record.Status = reply.Status
+				record.Message = reply.Message
+			}
+			rbac.record(record)
+			return reply
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestRBACMiddleware checks that only the allowed admin commands pass, and every call is audited
+func TestRBACMiddleware(t *testing.T) {
+	var audit bytes.Buffer
+	rbac, err := NewRBAC(RBACConfig{
+		Roles:      map[string][]string{"operator": {"route.*", PingCommand}},
+		Principals: map[string][]string{"alice": {"operator"}},
+	}, &audit)
+	if err != nil {
+		t.Fatalf("NewRBAC: %v", err)
+	}
+	rbac.WithScrubber(NewScrubber())
+	handler := Wrap(func(req *Envelope) *Reply {
+		if req.Command == PingCommand {
+			return nil
+		}
+		return Fail("token=abcdef is invalid")
+	}, rbac.Middleware())
+
+	if reply := handler(tenantRequest("alice", "route.set")); reply.IsOK() || reply.Message != "token=abcdef is invalid" {
+		t.Fatalf("the allowed command replied %v", reply)
+	}
+	if reply := handler(tenantRequest("alice", PingCommand)); reply != nil {
+		t.Fatalf("the allowed command replied %v", reply)
+	}
+	if reply := handler(tenantRequest("bob", "route.set")); reply.IsOK() {
+		t.Fatalf("the unknown principal is allowed")
+	}
+	if reply := handler(tenantRequest("alice", "proxy.switch")); reply.IsOK() {
+		t.Fatalf("the command out of the roles is allowed")
+	}
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(&audit)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("json.Unmarshal: %v", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 4 {
+		t.Fatalf("audited %d calls", len(records))
+	}
+	if !records[0].Allowed || records[0].Status != FAIL || records[0].Message != "token="+MaskedValue+" is invalid" {
+		t.Fatalf("the allowed call is audited as %+v", records[0])
+	}
+	if !records[1].Allowed || records[1].Status != "" {
+		t.Fatalf("the call without the reply is audited as %+v", records[1])
+	}
+	if records[2].Allowed || records[2].Principal != "bob" || records[3].Allowed {
+		t.Fatalf("the rejected calls are audited as %+v", records[2:])
+	}
+}
+
+// TestNewRBACValidates checks the invalid patterns and the unknown roles
+func TestNewRBACValidates(t *testing.T) {
+	configs := []RBACConfig{
+		{Roles: map[string][]string{"operator": {"route.["}}},
+		{Roles: map[string][]string{"operator": {"route.*"}}, Principals: map[string][]string{"alice": {"admin"}}},
+	}
+	for _, config := range configs {
+		if _, err := NewRBAC(config, nil); err == nil {
+			t.Fatalf("the invalid config %+v is accepted", config)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+)
+
+// The commands the proxy sends to its parent service
+const (
+	RegisterCommand   = "proxy.register"
+	DeregisterCommand = "proxy.deregister"
+)
+
+// Registration is the proxy as its parent service sees it
+type Registration struct {
+	// Name of the proxy instance, unique among the proxies of the parent
+	Name string `json:"name"`
+	// Endpoints are the urls of the proxy source
+	Endpoints []string `json:"endpoints"`
+	Version   string   `json:"version,omitempty"`
+}
+
+// Register the proxy in the parent service
+func (registration *Registration) Register(ctx context.Context, parent DestinationTransport) error {
+	if len(registration.Name) == 0 {
+		return fmt.Errorf("registration has no name")
+	}
+	if len(registration.Version) == 0 {
+		registration.Version = ReadBuildInfo().Version
+	}
+
+	parameters, err := toParameters(registration)
+	if err != nil {
+		return fmt.Errorf("toParameters: %w", err)
+	}
+	return registration.send(ctx, parent, &Request{Command: RegisterCommand, Parameters: parameters})
+}
+
+// Deregister the proxy from the parent service
+func (registration *Registration) Deregister(ctx context.Context, parent DestinationTransport) error {
+	req := &Request{Command: DeregisterCommand, Parameters: map[string]interface{}{"name": registration.Name}}
+	return registration.send(ctx, parent, req)
+}
+
+func (registration *Registration) send(ctx context.Context, parent DestinationTransport, req *Request) error {
+	reply, err := parent.Send(ctx, NewEnvelope(req))
+	if err != nil {
+		return fmt.Errorf("parent.Send: %w", err)
+	}
+	if reply == nil {
+		return fmt.Errorf("no reply to '%s'", req.Command)
+	}
+	if !reply.IsOK() {
+		return fmt.Errorf("parent replied to '%s': %s", req.Command, reply.Message)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+)
+
+// TestRegistration checks the commands sent to the parent service
+func TestRegistration(t *testing.T) {
+	var received []*Envelope
+	reply := Ok(nil)
+	parent := funcTransport(func(req *Envelope) (*Reply, error) {
+		received = append(received, req)
+		return reply, nil
+	})
+	ctx := context.Background()
+
+	if err := (&Registration{}).Register(ctx, parent); err == nil || len(received) != 0 {
+		t.Fatalf("registered without the name")
+	}
+
+	registration := &Registration{Name: "proxy1", Endpoints: []string{"tcp://localhost:6000"}, Version: "1.0.0"}
+	if err := registration.Register(ctx, parent); err != nil {
+		t.Fatalf("registration.Register: %v", err)
+	}
+	if err := registration.Deregister(ctx, parent); err != nil {
+		t.Fatalf("registration.Deregister: %v", err)
+	}
+	if len(received) != 2 || received[0].Command != RegisterCommand || received[1].Command != DeregisterCommand {
+		t.Fatalf("the parent received %d requests", len(received))
+	}
+	registered, err := toParameters(registration)
+	if err != nil {
+		t.Fatalf("toParameters: %v", err)
+	}
+	if received[0].Parameters["name"] != registered["name"] || received[0].Parameters["version"] != "1.0.0" {
+		t.Fatalf("registered as %v", received[0].Parameters)
+	}
+	if received[1].StringParam("name") != "proxy1" {
+		t.Fatalf("deregistered as %v", received[1].Parameters)
+	}
+
+	reply = Fail("duplicate name")
+	if err := registration.Register(ctx, parent); err == nil {
+		t.Fatalf("the failed registration returned no error")
+	}
+	reply = nil
+	if err := registration.Deregister(ctx, parent); err == nil {
+		t.Fatalf("the deregistration without the reply returned no error")
+	}
+}
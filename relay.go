@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Publisher delivers the events to the downstream subscribers.
+// Returns the number of the subscribers received the event.
+type Publisher interface {
+	Publish(topic string, parameters map[string]interface{}) int
+}
+
+// Relay is the publisher/subscriber mode of the proxy.
+// It subscribes to the topics of the destination, and re-publishes the events downstream.
+// The topics not in the list are not relayed.
+type Relay struct {
+	from      Subscriber
+	to        Publisher
+	topics    []string
+	resources *Resources
+}
+
+// NewRelay returns the relay of the topics from the destination to the downstream publisher
+func NewRelay(from Subscriber, to Publisher, topics ...string) (*Relay, error) {
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("no topics")
+	}
+	return &Relay{from: from, to: to, topics: topics}, nil
+}
+
+// WithResources tracks the goroutines of the relayed topics
+func (relay *Relay) WithResources(resources *Resources) *Relay {
+	relay.resources = resources
+	return relay
+}
+
+// Run relays the events until the context is cancelled.
+// Returns an error if any topic could not be subscribed, in that case nothing is relayed.
+func (relay *Relay) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	subscriptions := make([]<-chan Event, len(relay.topics))
+	for i, topic := range relay.topics {
+		events, err := relay.from.Subscribe(ctx, topic)
+		if err != nil {
+			return fmt.Errorf("from.Subscribe('%s'): %w", topic, err)
+		}
+		subscriptions[i] = events
+	}
+
+	var wg sync.WaitGroup
+	for i, events := range subscriptions {
+		events := events
+		wg.Add(1)
+		relay.resources.Go("relay", relay.topics[i], 0, func() {
+			defer wg.Done()
+			for event := range events {
+				relay.to.Publish(event.Topic, event.Parameters)
+			}
+		})
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestRelayTopics checks that only the listed topics are relayed downstream,
+// and the relay stops with the context
+func TestRelayTopics(t *testing.T) {
+	upstream := NewMemoryPublisher(8)
+	downstream := NewMemoryPublisher(8)
+	if _, err := NewRelay(upstream, downstream); err == nil {
+		t.Fatalf("the relay without the topics is created")
+	}
+	relay, err := NewRelay(upstream, downstream, "prices")
+	if err != nil {
+		t.Fatalf("NewRelay: %v", err)
+	}
+	resources := NewResources()
+	relay.WithResources(resources)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := downstream.Subscribe(ctx, "prices")
+	if err != nil {
+		t.Fatalf("downstream.Subscribe: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- relay.Run(ctx)
+	}()
+
+	// the relay subscribes in the background
+	deadline := time.Now().Add(time.Second)
+	for upstream.Publish("prices", map[string]interface{}{"btc": 1}) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the relay didn't subscribe to the topic")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if upstream.Publish("orders", nil) != 0 {
+		t.Fatalf("the relay subscribed to the topic out of the list")
+	}
+
+	select {
+	case event := <-events:
+		if event.Topic != "prices" || event.Parameters["btc"] != 1 {
+			t.Fatalf("relayed %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the event is not relayed")
+	}
+	if counts := resources.Counts(); counts["relay"][GoroutineResource] != 1 {
+		t.Fatalf("the relay goroutines are %v", counts)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("relay.Run: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the relay didn't stop with the context")
+	}
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CredentialLogin is the provider that could log in again when the renewal fails permanently,
+// for example when the token expired.
+type CredentialLogin interface {
+	Login() error
+}
+
+// DefaultRenewAttempts is the number of the renew attempts before logging in again
+const DefaultRenewAttempts = 5
+
+// Renewal renews the credentials of the provider periodically in the background.
+// The failed renewal is retried with the backoff, and then the provider logs in again if it supports it.
+type Renewal struct {
+	Provider CredentialProvider
+	Interval time.Duration
+	Backoff  Backoff
+	// Attempts of the renewal before logging in again
+	Attempts int
+	// OnRenew is called after the credentials are renewed,
+	// so the dependent components like the database connections are refreshed
+	OnRenew func(provider CredentialProvider)
+	// OnError is called when the credentials could not be renewed nor logged in
+	OnError func(err error)
+}
+
+// NewRenewal returns the renewal of the provider with the default backoff and attempts
+func NewRenewal(provider CredentialProvider, interval time.Duration) *Renewal {
+	return &Renewal{
+		Provider: provider,
+		Interval: interval,
+		Backoff:  DefaultBackoff(),
+		Attempts: DefaultRenewAttempts,
+	}
+}
+
+// Run renews the credentials every interval until the context is cancelled
+func (renewal *Renewal) Run(ctx context.Context) {
+	ticker := time.NewTicker(renewal.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := renewal.Renew(ctx)
+		if err != nil && ctx.Err() == nil && renewal.OnError != nil {
+			renewal.OnError(err)
+		}
+	}
+}
+
+// Renew the credentials once, retrying with the backoff, then logging in again
+func (renewal *Renewal) Renew(ctx context.Context) error {
+	attempts := renewal.Attempts
+	if attempts <= 0 {
+		attempts = DefaultRenewAttempts
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(renewal.Backoff.Delay(attempt)):
+		}
+
+		if lastErr = renewal.Provider.Renew(); lastErr == nil {
+			renewal.renewed()
+			return nil
+		}
+	}
+
+	login, ok := renewal.Provider.(CredentialLogin)
+	if !ok {
+		return fmt.Errorf("provider.Renew: %w", lastErr)
+	}
+	if err := login.Login(); err != nil {
+		return fmt.Errorf("provider.Login after renew failed with '%v': %w", lastErr, err)
+	}
+	renewal.renewed()
+	return nil
+}
+
+func (renewal *Renewal) renewed() {
+	if renewal.OnRenew != nil {
+		renewal.OnRenew(renewal.Provider)
+	}
+}
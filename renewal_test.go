@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// renewingProvider fails the renewal the given times, and logs in if the login is set
+type renewingProvider struct {
+	EnvCredentials
+	failures int
+	renewed  int
+	login    error
+	logins   int
+}
+
+func (provider *renewingProvider) Renew() error {
+	if provider.failures > 0 {
+		provider.failures--
+		return fmt.Errorf("token expired")
+	}
+	provider.renewed++
+	return nil
+}
+
+func (provider *renewingProvider) Login() error {
+	provider.logins++
+	return provider.login
+}
+
+// TestRenewalRetriesThenLogsIn checks that the failed renewal is retried,
+// and the provider logs in again after the attempts
+func TestRenewalRetriesThenLogsIn(t *testing.T) {
+	provider := &renewingProvider{failures: 2}
+	renewal := NewRenewal(provider, time.Hour)
+	renewal.Backoff = Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1}
+	renewal.Attempts = 3
+	notified := 0
+	renewal.OnRenew = func(CredentialProvider) { notified++ }
+
+	if err := renewal.Renew(context.Background()); err != nil {
+		t.Fatalf("renewal.Renew: %v", err)
+	}
+	if provider.renewed != 1 || provider.logins != 0 || notified != 1 {
+		t.Fatalf("renewed %d times, logged in %d times, notified %d times", provider.renewed, provider.logins, notified)
+	}
+
+	provider.failures = 3
+	if err := renewal.Renew(context.Background()); err != nil {
+		t.Fatalf("renewal.Renew: %v", err)
+	}
+	if provider.logins != 1 || notified != 2 {
+		t.Fatalf("logged in %d times, notified %d times", provider.logins, notified)
+	}
+
+	provider.failures = 3
+	provider.login = fmt.Errorf("invalid password")
+	if err := renewal.Renew(context.Background()); err == nil || notified != 2 {
+		t.Fatalf("the failed login returned %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	provider.failures = 3
+	if err := renewal.Renew(ctx); err != context.Canceled {
+		t.Fatalf("the cancelled renewal returned %v", err)
+	}
+}
+
+// TestRenewalWithoutLogin checks that the provider without the login returns the renew error
+func TestRenewalWithoutLogin(t *testing.T) {
+	dir := t.TempDir()
+	key := make([]byte, 32)
+	path := filepath.Join(dir, "credentials")
+	if err := EncryptCredentials(path, key, nil, DatabaseCredentials{}); err != nil {
+		t.Fatalf("EncryptCredentials: %v", err)
+	}
+	provider, err := NewFileCredentials(path, key)
+	if err != nil {
+		t.Fatalf("NewFileCredentials: %v", err)
+	}
+	provider.path = filepath.Join(dir, "missing")
+
+	renewal := NewRenewal(provider, time.Hour)
+	renewal.Backoff = Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1}
+	renewal.Attempts = 2
+	if err := renewal.Renew(context.Background()); err == nil {
+		t.Fatalf("the failed renewal returned no error")
+	}
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// The limits of the Reorder
+const (
+	DefaultReorderClients = 10000
+	DefaultReorderPending = 1024
+	DefaultReorderTimeout = 30 * time.Second
+)
+
+// Reorder sends the replies per client in the order of the requests.
+// It's used when the requests are forwarded in parallel,
+// while the client depends on the order of the replies.
+//
+// The reply waits for the replies of the earlier requests up to the Timeout.
+// Then the missing replies are skipped, and sent as they come.
+type Reorder struct {
+	// MaxClients is the limit of the ordered clients. The requests of the other clients are not ordered
+	MaxClients int
+	// MaxPending is the limit of the replies waiting per client. Over it, the missing replies are skipped
+	MaxPending int
+	// Timeout is how long the replies wait for the missing reply
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*reorderBuffer
+}
+
+type reorderBuffer struct {
+	next     uint64
+	release  uint64
+	pending  map[uint64]func()
+	ready    []func()
+	flushing bool
+	timer    *time.Timer
+}
+
+// NewReorder returns an empty reordering buffer with the default limits
+func NewReorder() *Reorder {
+	return &Reorder{
+		MaxClients: DefaultReorderClients,
+		MaxPending: DefaultReorderPending,
+		Timeout:    DefaultReorderTimeout,
+		clients:    make(map[string]*reorderBuffer),
+	}
+}
+
+// Next returns the sequence number of the client's request.
+// Call it when the request is received.
+// Returns false if the new client is over the MaxClients, then its reply is not ordered.
+func (reorder *Reorder) Next(client string) (uint64, bool) {
+	reorder.mu.Lock()
+	defer reorder.mu.Unlock()
+
+	buffer, ok := reorder.clients[client]
+	if !ok {
+		if reorder.MaxClients > 0 && len(reorder.clients) >= reorder.MaxClients {
+			return 0, false
+		}
+		buffer = &reorderBuffer{pending: make(map[uint64]func())}
+		reorder.clients[client] = buffer
+	}
+	sequence := buffer.next
+	buffer.next++
+	return sequence, true
+}
+
+// Done sends the reply of the request with the sequence number, once the replies of the earlier requests are sent.
+// The replies of the client are sent by one goroutine at a time, in the order.
+// The reply of the forgotten client, or the reply that was skipped after the timeout, is sent at once.
+func (reorder *Reorder) Done(client string, sequence uint64, send func()) {
+	reorder.mu.Lock()
+	buffer, ok := reorder.clients[client]
+	if !ok || sequence < buffer.release {
+		reorder.mu.Unlock()
+		send()
+		return
+	}
+	buffer.pending[sequence] = send
+	buffer.advance()
+	if reorder.MaxPending > 0 && len(buffer.pending) > reorder.MaxPending {
+		buffer.skip()
+	}
+	reorder.schedule(client, buffer)
+	reorder.flush(buffer)
+}
+
+// advance moves the replies following the released ones to the ready. Must be called with the lock
+func (buffer *reorderBuffer) advance() {
+	for {
+		send, ok := buffer.pending[buffer.release]
+		if !ok {
+			return
+		}
+		buffer.ready = append(buffer.ready, send)
+		delete(buffer.pending, buffer.release)
+		buffer.release++
+	}
+}
+
+// skip the missing replies up to the earliest pending one. Must be called with the lock
+func (buffer *reorderBuffer) skip() {
+	first := true
+	for sequence := range buffer.pending {
+		if first || sequence < buffer.release {
+			buffer.release = sequence
+			first = false
+		}
+	}
+	buffer.advance()
+}
+
+// schedule skips the missing replies after the timeout, if the replies are waiting. Must be called with the lock
+func (reorder *Reorder) schedule(client string, buffer *reorderBuffer) {
+	if len(buffer.pending) == 0 {
+		if buffer.timer != nil {
+			buffer.timer.Stop()
+			buffer.timer = nil
+		}
+		return
+	}
+	if buffer.timer != nil || reorder.Timeout <= 0 {
+		return
+	}
+	buffer.timer = time.AfterFunc(reorder.Timeout, func() {
+		reorder.mu.Lock()
+		if reorder.clients[client] != buffer {
+			reorder.mu.Unlock()
+			return
+		}
+		buffer.timer = nil
+		buffer.skip()
+		reorder.schedule(client, buffer)
+		reorder.flush(buffer)
+	})
+}
+
+// flush sends the ready replies, unless the other goroutine is sending them.
+// Must be called with the lock, which is released on return.
+func (reorder *Reorder) flush(buffer *reorderBuffer) {
+	if buffer.flushing {
+		reorder.mu.Unlock()
+		return
+	}
+	buffer.flushing = true
+	for len(buffer.ready) > 0 {
+		ready := buffer.ready
+		buffer.ready = nil
+		reorder.mu.Unlock()
+		for _, send := range ready {
+			send()
+		}
+		reorder.mu.Lock()
+	}
+	buffer.flushing = false
+	reorder.mu.Unlock()
+}
+
+// Pending returns the amount of the replies waiting for the earlier replies
+func (reorder *Reorder) Pending(client string) int {
+	reorder.mu.Lock()
+	defer reorder.mu.Unlock()
+
+	buffer, ok := reorder.clients[client]
+	if !ok {
+		return 0
+	}
+	return len(buffer.pending)
+}
+
+// Clients returns the amount of the ordered clients
+func (reorder *Reorder) Clients() int {
+	reorder.mu.Lock()
+	defer reorder.mu.Unlock()
+
+	return len(reorder.clients)
+}
+
+// Forget the client, for example when the client disconnects
+func (reorder *Reorder) Forget(client string) {
+	reorder.mu.Lock()
+	if buffer, ok := reorder.clients[client]; ok && buffer.timer != nil {
+		buffer.timer.Stop()
+	}
+	delete(reorder.clients, client)
+	reorder.mu.Unlock()
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentOrder records the order of the sent replies
+type sentOrder struct {
+	mu   sync.Mutex
+	sent []uint64
+}
+
+// send returns the reply of the sequence
+func (order *sentOrder) send(sequence uint64) func() {
+	return func() {
+		order.mu.Lock()
+		order.sent = append(order.sent, sequence)
+		order.mu.Unlock()
+	}
+}
+
+// equal returns true if the sequences were sent in the order
+func (order *sentOrder) equal(expected ...uint64) bool {
+	order.mu.Lock()
+	defer order.mu.Unlock()
+
+	if len(order.sent) != len(expected) {
+		return false
+	}
+	for i := range expected {
+		if order.sent[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nextSequences takes the sequences of the client
+func nextSequences(t *testing.T, reorder *Reorder, client string, amount int) {
+	for i := 0; i < amount; i++ {
+		if _, ok := reorder.Next(client); !ok {
+			t.Fatalf("the client '%s' is not ordered", client)
+		}
+	}
+}
+
+// TestReorderSendsInOrder checks that the replies wait for the earlier replies
+func TestReorderSendsInOrder(t *testing.T) {
+	reorder := NewReorder()
+	order := &sentOrder{}
+	nextSequences(t, reorder, "alice", 3)
+
+	reorder.Done("alice", 2, order.send(2))
+	reorder.Done("alice", 1, order.send(1))
+	if !order.equal() || reorder.Pending("alice") != 2 {
+		t.Fatalf("sent %v before the first reply", order.sent)
+	}
+	reorder.Done("alice", 0, order.send(0))
+	if !order.equal(0, 1, 2) || reorder.Pending("alice") != 0 {
+		t.Fatalf("sent %v", order.sent)
+	}
+}
+
+// TestReorderBounds checks the limit of the clients and of the waiting replies
+func TestReorderBounds(t *testing.T) {
+	reorder := NewReorder()
+	reorder.MaxClients = 1
+	reorder.MaxPending = 2
+	order := &sentOrder{}
+
+	nextSequences(t, reorder, "alice", 4)
+	if _, ok := reorder.Next("bob"); ok {
+		t.Fatalf("the client over the limit is ordered")
+	}
+	reorder.Done("alice", 1, order.send(1))
+	reorder.Done("alice", 2, order.send(2))
+	reorder.Done("alice", 3, order.send(3))
+	if !order.equal(1, 2, 3) {
+		t.Fatalf("the replies over the limit were not released, sent %v", order.sent)
+	}
+	reorder.Done("alice", 0, order.send(0))
+	if !order.equal(1, 2, 3, 0) {
+		t.Fatalf("the skipped reply was not sent, sent %v", order.sent)
+	}
+
+	reorder.Forget("alice")
+	if reorder.Clients() != 0 {
+		t.Fatalf("the forgotten client is kept")
+	}
+	if _, ok := reorder.Next("bob"); !ok {
+		t.Fatalf("the client is not ordered after the other one is forgotten")
+	}
+}
+
+// TestReorderReleasesStalled checks that the replies stop waiting for the missing reply after the timeout
+func TestReorderReleasesStalled(t *testing.T) {
+	reorder := NewReorder()
+	reorder.Timeout = 20 * time.Millisecond
+	order := &sentOrder{}
+
+	nextSequences(t, reorder, "alice", 2)
+	reorder.Done("alice", 1, order.send(1))
+	deadline := time.Now().Add(5 * time.Second)
+	for !order.equal(1) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the stalled reply was not released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	reorder.Done("alice", 0, order.send(0))
+	if !order.equal(1, 0) {
+		t.Fatalf("the late reply was not sent, sent %v", order.sent)
+	}
+}
+
+// TestTCPSourceReordersReplies checks that the tcp replies come in the order of the requests,
+// even if the later requests finish first
+func TestTCPSourceReordersReplies(t *testing.T) {
+	source, err := NewTCPSource(TCPSourceConfig{Port: 1})
+	if err != nil {
+		t.Fatalf("NewTCPSource: %v", err)
+	}
+	reorder := NewReorder()
+	const requests = 10
+	address := serveTCP(t, source.WithReorder(reorder), func(req *Envelope) *Reply {
+		index, _ := req.Parameters["index"].(float64)
+		time.Sleep(time.Duration(requests-index) * time.Millisecond)
+		return Ok(nil)
+	})
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	client := &tcpConn{Conn: conn}
+	for i := 0; i < requests; i++ {
+		body, err := NewEnvelope(&Request{Command: "echo", Parameters: map[string]interface{}{"index": i}}).Encode(LatestEnvelopeVersion)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		if err := client.write(uint64(i), body); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	reader := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < requests; i++ {
+		message, err := readTCPMessage(reader, 0)
+		if err != nil {
+			t.Fatalf("readTCPMessage: %v", err)
+		}
+		if message.call != uint64(i) {
+			t.Fatalf("the reply %d is the reply of the request %d", i, message.call)
+		}
+	}
+
+	_ = conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for reorder.Clients() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the closed connection is still ordered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
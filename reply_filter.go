@@ -0,0 +1,103 @@
+package proxy
+
+// MaskedValue replaces the value of the masked reply parameters
+const MaskedValue = "***"
+
+// ReplyFilter defines the reduced view of the reply parameters
+type ReplyFilter struct {
+	// Fields is the whitelist of the parameters. If it's empty, then all parameters are returned
+	Fields []string `json:"fields,omitempty" yaml:"fields,omitempty"`
+	// MaxArrayLength cuts the lists in the reply, including the nested ones. Zero means no limit
+	MaxArrayLength int `json:"max_array_length,omitempty" yaml:"max_array_length,omitempty"`
+	// Mask the values of the parameters
+	Mask []string `json:"mask,omitempty" yaml:"mask,omitempty"`
+}
+
+// ReplyFilters applies the reply filter per command
+type ReplyFilters struct {
+	filters map[string]ReplyFilter
+}
+
+// NewReplyFilters returns the filters by the command name
+func NewReplyFilters(filters map[string]ReplyFilter) *ReplyFilters {
+	return &ReplyFilters{filters: filters}
+}
+
+// Filter returns the reply parameters as defined by the filter.
+// The original parameters are not changed.
+func (filter *ReplyFilter) Filter(parameters map[string]interface{}) map[string]interface{} {
+	filtered := make(map[string]interface{}, len(parameters))
+	if len(filter.Fields) == 0 {
+		for name, value := range parameters {
+			filtered[name] = value
+		}
+	} else {
+		for _, name := range filter.Fields {
+			if value, ok := parameters[name]; ok {
+				filtered[name] = value
+			}
+		}
+	}
+
+	for _, name := range filter.Mask {
+		if _, ok := filtered[name]; ok {
+			filtered[name] = MaskedValue
+		}
+	}
+
+	if filter.MaxArrayLength > 0 {
+		for name, value := range filtered {
+			filtered[name] = cutArrays(value, filter.MaxArrayLength)
+		}
+	}
+
+	return filtered
+}
+
+// cutArrays returns the value where all lists are no longer than limit
+func cutArrays(value interface{}, limit int) interface{} {
+	switch typed := value.(type) {
+	case []interface{}:
+		if len(typed) > limit {
+			typed = typed[:limit]
+		}
+		cut := make([]interface{}, len(typed))
+		for i, item := range typed {
+			cut[i] = cutArrays(item, limit)
+		}
+		return cut
+	case map[string]interface{}:
+		cut := make(map[string]interface{}, len(typed))
+		for name, item := range typed {
+			cut[name] = cutArrays(item, limit)
+		}
+		return cut
+	default:
+		return value
+	}
+}
+
+// Filter the reply of the command.
+// If the command has no filter, then the reply returned as is.
+func (filters *ReplyFilters) Filter(command string, reply *Reply) *Reply {
+	filter, ok := filters.filters[command]
+	if !ok || reply == nil {
+		return reply
+	}
+
+	return &Reply{
+		Status:     reply.Status,
+		Message:    reply.Message,
+		Parameters: filter.Filter(reply.Parameters),
+	}
+}
+
+// Middleware filters the reply returned by the next handler
+func (filters *ReplyFilters) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			command := req.Command
+			return filters.Filter(command, next(req))
+		}
+	}
+}
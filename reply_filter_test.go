@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestReplyFilters checks the reduced view of the reply, keeping the original reply unchanged
+func TestReplyFilters(t *testing.T) {
+	original := Ok(map[string]interface{}{
+		"id":     "1",
+		"email":  "alice@example.com",
+		"orders": []interface{}{"a", "b", "c"},
+		"profile": map[string]interface{}{
+			"tags": []interface{}{"x", "y", "z"},
+		},
+		"internal": true,
+	})
+	filters := NewReplyFilters(map[string]ReplyFilter{
+		"users.get": {Fields: []string{"id", "email", "orders", "profile", "missing"}, Mask: []string{"email"}, MaxArrayLength: 2},
+		"lost":      {Fields: []string{"id"}},
+	})
+	handler := Wrap(func(req *Envelope) *Reply {
+		if req.Command == "lost" {
+			return nil
+		}
+		return original
+	}, filters.Middleware())
+
+	reply := handler(policyRequest("users.get", nil))
+	expected := map[string]interface{}{
+		"id":      "1",
+		"email":   MaskedValue,
+		"orders":  []interface{}{"a", "b"},
+		"profile": map[string]interface{}{"tags": []interface{}{"x", "y"}},
+	}
+	if !reply.IsOK() || !reflect.DeepEqual(reply.Parameters, expected) {
+		t.Fatalf("the filtered reply is %v", reply.Parameters)
+	}
+	if original.Parameters["email"] != "alice@example.com" || len(original.Parameters["orders"].([]interface{})) != 3 {
+		t.Fatalf("the original reply is changed: %v", original.Parameters)
+	}
+
+	if reply := handler(policyRequest("users.list", nil)); reply != original {
+		t.Fatalf("the command without the filter is filtered")
+	}
+	if reply := handler(policyRequest("lost", nil)); reply != nil {
+		t.Fatalf("the missing reply is replaced with %v", reply)
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import "time"
+
+// LatencyParam is the reply parameter with the time in nanoseconds the request took after the proxy
+const LatencyParam = "proxy_latency_ns"
+
+// ReplyHandler rewrites or enriches the destination reply before it's returned to the source
+type ReplyHandler func(req *Envelope, reply *Reply) *Reply
+
+// ReplyMiddleware passes the reply of the next handler through the reply handlers in order
+func ReplyMiddleware(handlers ...ReplyHandler) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			reply := next(req)
+			for _, handler := range handlers {
+				reply = handler(req, reply)
+			}
+			return reply
+		}
+	}
+}
+
+// StripReplyFields removes the internal parameters from the reply
+func StripReplyFields(names ...string) ReplyHandler {
+	return func(_ *Envelope, reply *Reply) *Reply {
+		if reply == nil {
+			return nil
+		}
+		// the destination may share the reply
+		reply = reply.Copy()
+		for _, name := range names {
+			delete(reply.Parameters, name)
+		}
+		return reply
+	}
+}
+
+// SetReplyFields adds the parameters to the reply, for example the proxy or instance id
+func SetReplyFields(parameters map[string]interface{}) ReplyHandler {
+	return func(_ *Envelope, reply *Reply) *Reply {
+		if reply == nil {
+			return nil
+		}
+		// the destination may share the reply
+		reply = reply.Copy()
+		if reply.Parameters == nil {
+			reply.Parameters = map[string]interface{}{}
+		}
+		for name, value := range parameters {
+			reply.Parameters[name] = value
+		}
+		return reply
+	}
+}
+
+// LatencyMiddleware adds the LatencyParam to the replies
+func LatencyMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			start := time.Now()
+			reply := next(req)
+			if reply == nil {
+				return nil
+			}
+			// the destination may share the reply
+			reply = reply.Copy()
+			if reply.Parameters == nil {
+				reply.Parameters = map[string]interface{}{}
+			}
+			reply.Parameters[LatencyParam] = time.Since(start).Nanoseconds()
+			return reply
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestReplyMiddleware checks that the reply handlers are applied in order to the copy of the reply
+func TestReplyMiddleware(t *testing.T) {
+	shared := Ok(map[string]interface{}{"id": "1", "internal_host": "10.0.0.1"})
+	handler := Wrap(func(req *Envelope) *Reply {
+		if req.Command == "lost" {
+			return nil
+		}
+		return shared
+	}, ReplyMiddleware(
+		StripReplyFields("internal_host"),
+		SetReplyFields(map[string]interface{}{"proxy": "proxy1"}),
+	), LatencyMiddleware())
+
+	reply := handler(policyRequest("users.get", nil))
+	latency, ok := reply.Parameters[LatencyParam].(int64)
+	if !ok || latency < 0 {
+		t.Fatalf("no latency in %v", reply.Parameters)
+	}
+	delete(reply.Parameters, LatencyParam)
+	if !reflect.DeepEqual(reply.Parameters, map[string]interface{}{"id": "1", "proxy": "proxy1"}) {
+		t.Fatalf("the reply parameters are %v", reply.Parameters)
+	}
+	if len(shared.Parameters) != 2 || shared.Parameters["internal_host"] != "10.0.0.1" {
+		t.Fatalf("the shared reply is changed: %v", shared.Parameters)
+	}
+
+	if reply := handler(policyRequest("lost", nil)); reply != nil {
+		t.Fatalf("the missing reply is replaced with %v", reply)
+	}
+}
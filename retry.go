@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+)
+
+// DefaultRetryAttempts is the number of attempts of the idempotent commands
+const DefaultRetryAttempts = 3
+
+// RetryPolicy defines which requests are sent again after the failure.
+// Only the transport errors are retried, the fail replies of the destination are returned as they are.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt. Zero means DefaultRetryAttempts
+	MaxAttempts int `json:"max_attempts" yaml:"max_attempts"`
+	// Backoff between the attempts. The unset fields are taken from the DefaultBackoff
+	Backoff Backoff `json:"backoff" yaml:"backoff"`
+	// Idempotent are the patterns of the commands that are safe to send twice, for example 'user.get*'.
+	// The rest fail fast on the first error.
+	Idempotent []string `json:"idempotent" yaml:"idempotent"`
+}
+
+// Retry is the destination transport that sends the idempotent requests again after the failure.
+// With the Balancer as the destination, the next attempt is likely to go to another instance.
+type Retry struct {
+	destination DestinationTransport
+	policy      RetryPolicy
+	metrics     *Metrics
+}
+
+// NewRetry returns the destination that retries the requests with the policy
+func NewRetry(destination DestinationTransport, policy RetryPolicy) (*Retry, error) {
+	if policy.MaxAttempts < 0 {
+		return nil, fmt.Errorf("negative max attempts")
+	}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = DefaultRetryAttempts
+	}
+	policy.Backoff = policy.Backoff.withDefaults()
+	for i, pattern := range policy.Idempotent {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("idempotent[%d] '%s': %w", i, pattern, err)
+		}
+	}
+	return &Retry{destination: destination, policy: policy}, nil
+}
+
+// WithMetrics counts the retries by the command
+func (retry *Retry) WithMetrics(metrics *Metrics) *Retry {
+	retry.metrics = metrics
+	return retry
+}
+
+// Idempotent returns true if the command is safe to retry
+func (retry *Retry) Idempotent(command string) bool {
+	for _, pattern := range retry.policy.Idempotent {
+		if matched, _ := path.Match(pattern, command); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Send the request, retrying the idempotent commands until the attempts are over or the context is done
+func (retry *Retry) Send(ctx context.Context, req *Envelope) (*Reply, error) {
+	attempts := 1
+	if retry.Idempotent(req.Command) {
+		attempts = retry.policy.MaxAttempts
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("attempt %d: %w (last error: %v)", attempt+1, ctx.Err(), err)
+			case <-time.After(retry.policy.Backoff.Delay(attempt)):
+			}
+			if retry.metrics != nil {
+				retry.metrics.Add("proxy_retries_total", "the requests sent again after the failure by command", 1, "command", retry.metrics.commandLabel(req.Command))
+			}
+		}
+
+		var reply *Reply
+		reply, err = retry.destination.Send(ctx, req)
+		if err == nil {
+			return reply, nil
+		}
+	}
+	if attempts == 1 {
+		return nil, fmt.Errorf("destination.Send: %w", err)
+	}
+	return nil, fmt.Errorf("destination.Send failed %d times: %w", attempts, err)
+}
+
+// Close the destination
+func (retry *Retry) Close() error {
+	return retry.destination.Close()
+}
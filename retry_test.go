@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRetryIdempotent checks that only the idempotent commands are retried, and only on the transport errors
+func TestRetryIdempotent(t *testing.T) {
+	calls := map[string]int{}
+	destination := funcTransport(func(req *Envelope) (*Reply, error) {
+		calls[req.Command]++
+		switch req.Command {
+		case "user.get", "user.update":
+			if calls[req.Command] < 3 {
+				return nil, fmt.Errorf("connection reset")
+			}
+			return Ok(nil), nil
+		default:
+			return Fail("user not found"), nil
+		}
+	})
+	metrics := NewMetrics()
+	retry, err := NewRetry(destination, RetryPolicy{
+		Backoff:    Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		Idempotent: []string{"user.get*", "user.find"},
+	})
+	if err != nil {
+		t.Fatalf("NewRetry: %v", err)
+	}
+	retry.WithMetrics(metrics)
+	ctx := context.Background()
+
+	if reply, err := retry.Send(ctx, policyRequest("user.get", nil)); err != nil || !reply.IsOK() || calls["user.get"] != 3 {
+		t.Fatalf("the idempotent command is sent %d times: %v, %v", calls["user.get"], reply, err)
+	}
+	if _, err := retry.Send(ctx, policyRequest("user.update", nil)); err == nil || calls["user.update"] != 1 {
+		t.Fatalf("the command is retried %d times", calls["user.update"])
+	}
+	if reply, err := retry.Send(ctx, policyRequest("user.find", nil)); err != nil || reply.IsOK() || calls["user.find"] != 1 {
+		t.Fatalf("the fail reply is retried %d times", calls["user.find"])
+	}
+
+	var out strings.Builder
+	_, _ = metrics.WriteTo(&out)
+	if !strings.Contains(out.String(), `proxy_retries_total{command="user.get"} 2`) {
+		t.Fatalf("the retries are not counted:\n%s", out.String())
+	}
+}
+
+// TestRetryGivesUp checks the attempts limit, the cancelled context and the invalid policy
+func TestRetryGivesUp(t *testing.T) {
+	calls := 0
+	destination := funcTransport(func(req *Envelope) (*Reply, error) {
+		calls++
+		return nil, fmt.Errorf("connection refused")
+	})
+	retry, err := NewRetry(destination, RetryPolicy{
+		MaxAttempts: 2,
+		Backoff:     Backoff{Initial: time.Millisecond},
+		Idempotent:  []string{"*"},
+	})
+	if err != nil {
+		t.Fatalf("NewRetry: %v", err)
+	}
+	if _, err := retry.Send(context.Background(), policyRequest("user.get", nil)); err == nil || calls != 2 {
+		t.Fatalf("the request is sent %d times: %v", calls, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	if _, err := retry.Send(ctx, policyRequest("user.get", nil)); err == nil || calls != 1 {
+		t.Fatalf("the cancelled request is sent %d times: %v", calls, err)
+	}
+
+	for _, policy := range []RetryPolicy{{MaxAttempts: -1}, {Idempotent: []string{"user.["}}} {
+		if _, err := NewRetry(destination, policy); err == nil {
+			t.Fatalf("the invalid policy %+v is accepted", policy)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"fmt"
+)
+
+// ParameterRename renames the parameter of the request
+type ParameterRename struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+// RewriteRule changes the requests that match the rule.
+// The Command and Parameters are the match conditions.
+// The rest of the fields are the actions applied in the order:
+// Rename, Delete, Set, then SetCommand.
+// The renames are applied in the listed order, so the chained renames are deterministic.
+type RewriteRule struct {
+	// Command to match. Empty command matches any request
+	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+	// Parameters to match by value
+	Parameters map[string]interface{} `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+
+	SetCommand string                 `json:"set_command,omitempty" yaml:"set_command,omitempty"`
+	Set        map[string]interface{} `json:"set,omitempty" yaml:"set,omitempty"`
+	Rename     []ParameterRename      `json:"rename,omitempty" yaml:"rename,omitempty"`
+	Delete     []string               `json:"delete,omitempty" yaml:"delete,omitempty"`
+}
+
+// Rewriter applies the rewrite rules on the requests
+type Rewriter struct {
+	rules []RewriteRule
+}
+
+// NewRewriter returns the rewriter with the validated rules
+func NewRewriter(rules []RewriteRule) (*Rewriter, error) {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("rules[%d].Validate: %w", i, err)
+		}
+	}
+
+	return &Rewriter{rules: rules}, nil
+}
+
+// Validate returns an error if the rule has no action
+func (rule *RewriteRule) Validate() error {
+	if len(rule.SetCommand) == 0 && len(rule.Set) == 0 && len(rule.Rename) == 0 && len(rule.Delete) == 0 {
+		return fmt.Errorf("no action in the rule of '%s' command", rule.Command)
+	}
+	for i, rename := range rule.Rename {
+		if len(rename.From) == 0 || len(rename.To) == 0 {
+			return fmt.Errorf("rename[%d] of '%s' parameter requires the names", i, rename.From)
+		}
+	}
+	return nil
+}
+
+// Match returns true if the request matches the rule.
+// The parameters are compared by their string representation,
+// so the numbers from yaml and json are considered equal.
+func (rule *RewriteRule) Match(req *Request) bool {
+	if len(rule.Command) > 0 && rule.Command != req.Command {
+		return false
+	}
+	for name, expected := range rule.Parameters {
+		value, ok := req.Parameters[name]
+		if !ok || fmt.Sprint(value) != fmt.Sprint(expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply the rule actions on the request
+func (rule *RewriteRule) Apply(req *Request) {
+	if req.Parameters == nil {
+		req.Parameters = map[string]interface{}{}
+	}
+
+	for _, rename := range rule.Rename {
+		value, ok := req.Parameters[rename.From]
+		if !ok {
+			continue
+		}
+		delete(req.Parameters, rename.From)
+		req.Parameters[rename.To] = value
+	}
+	for _, name := range rule.Delete {
+		delete(req.Parameters, name)
+	}
+	// the value is copied, so the next handlers can't change the rule
+	for name, value := range rule.Set {
+		req.Parameters[name] = copyValue(value)
+	}
+	if len(rule.SetCommand) > 0 {
+		req.Command = rule.SetCommand
+	}
+}
+
+// Rewrite applies all matching rules in order.
+// Each rule is matched against the request changed by the previous rules.
+func (rewriter *Rewriter) Rewrite(req *Request) {
+	for i := range rewriter.rules {
+		if rewriter.rules[i].Match(req) {
+			rewriter.rules[i].Apply(req)
+		}
+	}
+}
+
+// rewritable returns the copy of the envelope with its own parameters,
+// so the rewrite doesn't change the request of the caller, for example the retried one
+func rewritable(req *Envelope) *Envelope {
+	rewritten := *req
+	rewritten.Parameters = make(map[string]interface{}, len(req.Parameters))
+	for name, value := range req.Parameters {
+		rewritten.Parameters[name] = value
+	}
+	return &rewritten
+}
+
+// Middleware rewrites the copy of the request before passing it to the next handler
+func (rewriter *Rewriter) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			rewritten := rewritable(req)
+			rewriter.Rewrite(&rewritten.Request)
+			return next(rewritten)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import "testing"
+
+// TestRewriteRenamesInOrder checks that the renames are applied in the listed order
+func TestRewriteRenamesInOrder(t *testing.T) {
+	rewriter, err := NewRewriter([]RewriteRule{{
+		Command: "user.get",
+		Rename:  []ParameterRename{{From: "id", To: "user_id"}, {From: "uid", To: "id"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewRewriter: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		req := &Request{Command: "user.get", Parameters: map[string]interface{}{"id": 1, "uid": 2}}
+		rewriter.Rewrite(req)
+		if req.Parameters["user_id"] != 1 || req.Parameters["id"] != 2 {
+			t.Fatalf("the renames are applied out of order: %v", req.Parameters)
+		}
+	}
+	if _, err := NewRewriter([]RewriteRule{{Rename: []ParameterRename{{From: "id"}}}}); err == nil {
+		t.Fatalf("the rename without the new name was accepted")
+	}
+}
+
+// TestRewriteMiddlewareCopies checks that the middleware changes neither the request of the caller nor the rule
+func TestRewriteMiddlewareCopies(t *testing.T) {
+	rewriter, err := NewRewriter([]RewriteRule{{
+		Command:    "order",
+		SetCommand: "order.create",
+		Set:        map[string]interface{}{"tags": []interface{}{"web"}},
+		Delete:     []string{"debug"},
+	}})
+	if err != nil {
+		t.Fatalf("NewRewriter: %v", err)
+	}
+	handler := Wrap(func(req *Envelope) *Reply {
+		if req.Command != "order.create" {
+			return Fail("not rewritten")
+		}
+		// the next handler changes the value set by the rule
+		req.Parameters["tags"].([]interface{})[0] = "changed"
+		return Ok(nil)
+	}, rewriter.Middleware())
+
+	for i := 0; i < 2; i++ {
+		req := NewEnvelope(&Request{Command: "order", Parameters: map[string]interface{}{"debug": true}})
+		if reply := handler(req); !reply.IsOK() {
+			t.Fatalf("request %d: %s", i, reply.Message)
+		}
+		if req.Command != "order" || req.Parameters["debug"] != true {
+			t.Fatalf("the request of the caller was changed: %s %v", req.Command, req.Parameters)
+		}
+	}
+	if tag := rewriter.rules[0].Set["tags"].([]interface{})[0]; tag != "web" {
+		t.Fatalf("the rule value was changed to '%v'", tag)
+	}
+}
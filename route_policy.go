@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RouteAuth authenticates the commands by the Auth policy of their route.
+// The policy name is mapped to its verifier, the commands without the Auth policy pass as is.
+// The policy that has no verifier fails the request, so the typo in the route table doesn't open the command.
+type RouteAuth struct {
+	table     *RouteTable
+	verifiers map[string]Verifier
+}
+
+// NewRouteAuth returns the authentication of the routes with the verifiers by the policy name
+func NewRouteAuth(table *RouteTable, verifiers map[string]Verifier) *RouteAuth {
+	return &RouteAuth{table: table, verifiers: verifiers}
+}
+
+// Middleware authenticates the request by WithAuth of its route's verifier
+func (auth *RouteAuth) Middleware() Middleware {
+	return func(next Handler) Handler {
+		authenticated := make(map[string]Handler, len(auth.verifiers))
+		for name, verifier := range auth.verifiers {
+			authenticated[name] = WithAuth(verifier)(next)
+		}
+
+		return func(req *Envelope) *Reply {
+			policy, _ := auth.table.Routes().Policy(req.Command)
+			if len(policy.Auth) == 0 {
+				return next(req)
+			}
+			handler, ok := authenticated[policy.Auth]
+			if !ok {
+				return Fail(fmt.Sprintf("unauthorized: no '%s' auth policy", policy.Auth))
+			}
+			return handler(req)
+		}
+	}
+}
+
+// RouteCache caches the successful replies for the Cache seconds of the route policy.
+// The reply is cached by the command, the principal and the parameters of the request,
+// so the clients never get each other's replies.
+// The replies of each cache time are kept in their own DedupCache within the limit.
+type RouteCache struct {
+	table  *RouteTable
+	limit  int
+	mu     sync.Mutex
+	caches map[uint]*DedupCache
+}
+
+// NewRouteCache returns the cache of the replies. Zero limit means DefaultDedupLimit per cache time
+func NewRouteCache(table *RouteTable, limit int) *RouteCache {
+	return &RouteCache{table: table, limit: limit, caches: make(map[uint]*DedupCache)}
+}
+
+// cache returns the replies cached for the seconds
+func (routeCache *RouteCache) cache(seconds uint) *DedupCache {
+	routeCache.mu.Lock()
+	defer routeCache.mu.Unlock()
+
+	cache, ok := routeCache.caches[seconds]
+	if !ok {
+		cache = NewDedupCache(time.Duration(seconds) * time.Second).WithLimit(routeCache.limit)
+		routeCache.caches[seconds] = cache
+	}
+	return cache
+}
+
+// cacheKey returns the key of the request. The json of the map is sorted by the keys
+func cacheKey(req *Envelope) (string, error) {
+	parameters, err := json.Marshal(req.Parameters)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+	hash := sha256.New()
+	hash.Write([]byte(req.Command))
+	hash.Write([]byte{0})
+	hash.Write([]byte(req.Principal))
+	hash.Write([]byte{0})
+	hash.Write(parameters)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Middleware replies from the cache, or caches the successful reply of the next handler.
+// It must be after the authentication, so the principal is known.
+func (routeCache *RouteCache) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			policy, _ := routeCache.table.Routes().Policy(req.Command)
+			if policy.Cache == 0 {
+				return next(req)
+			}
+			key, err := cacheKey(req)
+			if err != nil {
+				return next(req)
+			}
+
+			cache := routeCache.cache(policy.Cache)
+			if reply, ok := cache.Get(key); ok {
+				return reply
+			}
+			reply := next(req)
+			if reply != nil && reply.IsOK() {
+				cache.Put(key, reply)
+			}
+			return reply
+		}
+	}
+}
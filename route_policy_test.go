@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// policyTable returns the table with the 'private' group of the policy
+func policyTable(t *testing.T, policy RoutePolicy, commands ...string) *RouteTable {
+	routes, err := NewRoutes(RoutePolicy{}, []RouteGroup{{Name: "private", Commands: commands, RoutePolicy: policy}})
+	if err != nil {
+		t.Fatalf("NewRoutes: %v", err)
+	}
+	return NewRouteTable(routes, "")
+}
+
+// policyRequest returns the envelope of the command
+func policyRequest(command string, parameters map[string]interface{}) *Envelope {
+	return NewEnvelope(&Request{Command: command, Parameters: parameters})
+}
+
+// TestRouteAuthEnforcesPolicy checks that only the commands with the Auth policy are authenticated
+func TestRouteAuthEnforcesPolicy(t *testing.T) {
+	keys, err := NewStaticKeys(map[string]string{"secret": "alice"})
+	if err != nil {
+		t.Fatalf("NewStaticKeys: %v", err)
+	}
+	table := policyTable(t, RoutePolicy{Auth: "keys"}, "balance")
+	handler := Wrap(func(req *Envelope) *Reply {
+		return Ok(map[string]interface{}{"principal": req.Principal})
+	}, NewRouteAuth(table, map[string]Verifier{"keys": keys}).Middleware())
+
+	if reply := handler(policyRequest("ping", map[string]interface{}{})); !reply.IsOK() {
+		t.Fatalf("the public command failed: %s", reply.Message)
+	}
+	if reply := handler(policyRequest("balance", map[string]interface{}{})); reply.IsOK() {
+		t.Fatalf("the command of the auth policy passed without the key")
+	}
+	reply := handler(policyRequest("balance", map[string]interface{}{AuthParam: "secret"}))
+	if !reply.IsOK() || reply.Parameters["principal"] != "alice" {
+		t.Fatalf("the authenticated command got %v: %s", reply.Parameters, reply.Message)
+	}
+
+	if err := table.Set(RouteGroup{Name: "private", Commands: []string{"balance"}, RoutePolicy: RoutePolicy{Auth: "typo"}}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if reply := handler(policyRequest("balance", map[string]interface{}{AuthParam: "secret"})); reply.IsOK() {
+		t.Fatalf("the unknown auth policy passed the command")
+	}
+}
+
+// TestRouteCacheByPrincipal checks that the successful replies are cached per principal and parameters
+func TestRouteCacheByPrincipal(t *testing.T) {
+	table := policyTable(t, RoutePolicy{Cache: 60}, "price")
+	var calls int32
+	handler := Wrap(func(req *Envelope) *Reply {
+		atomic.AddInt32(&calls, 1)
+		if req.StringParam("symbol") == "bad" {
+			return Fail("unknown symbol")
+		}
+		return Ok(map[string]interface{}{"principal": req.Principal})
+	}, NewRouteCache(table, 0).Middleware())
+
+	request := func(command string, principal string, symbol string) *Reply {
+		req := policyRequest(command, map[string]interface{}{"symbol": symbol})
+		req.Principal = principal
+		return handler(req)
+	}
+	request("price", "alice", "btc")
+	if reply := request("price", "alice", "btc"); reply.Parameters["principal"] != "alice" {
+		t.Fatalf("the cached reply is %v", reply.Parameters)
+	}
+	if calls != 1 {
+		t.Fatalf("the cached command reached the destination %d times", calls)
+	}
+	if reply := request("price", "bob", "btc"); reply.Parameters["principal"] != "bob" {
+		t.Fatalf("bob got the reply of %v", reply.Parameters["principal"])
+	}
+	request("price", "alice", "eth")
+	request("price", "alice", "bad")
+	request("price", "alice", "bad")
+	request("other", "alice", "btc")
+	request("other", "alice", "btc")
+	if calls != 7 {
+		t.Fatalf("the destination was called %d times instead of 7", calls)
+	}
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// The admin commands of the route table
+const (
+	RouteListCommand   = "route.list"
+	RouteSetCommand    = "route.set"
+	RouteDeleteCommand = "route.delete"
+	RouteSaveCommand   = "route.save"
+)
+
+// routesFile is the persisted route table
+type routesFile struct {
+	Default RoutePolicy  `json:"default"`
+	Groups  []RouteGroup `json:"groups"`
+}
+
+// RouteTable is the route table that is changed at runtime.
+// The readers get the current routes without locking,
+// the changes are validated then swapped atomically.
+type RouteTable struct {
+	routes atomic.Value
+	mu     sync.Mutex
+	path   string
+}
+
+// NewRouteTable returns the table with the routes.
+// The path is the file where the routes are saved. If it's empty, then Save fails.
+func NewRouteTable(routes *Routes, path string) *RouteTable {
+	table := &RouteTable{path: path}
+	table.routes.Store(routes)
+	return table
+}
+
+// LoadRouteTable returns the table with the routes saved in the file
+func LoadRouteTable(path string) (*RouteTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile('%s'): %w", path, err)
+	}
+	var file routesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal('%s'): %w", path, err)
+	}
+	routes, err := NewRoutes(file.Default, file.Groups)
+	if err != nil {
+		return nil, fmt.Errorf("NewRoutes: %w", err)
+	}
+	return NewRouteTable(routes, path), nil
+}
+
+// Routes returns the current routes
+func (table *RouteTable) Routes() *Routes {
+	return table.routes.Load().(*Routes)
+}
+
+// Set adds the group, or replaces the group with the same name
+func (table *RouteTable) Set(group RouteGroup) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	current := table.Routes()
+	groups := current.Groups()
+	replaced := false
+	for i := range groups {
+		if groups[i].Name == group.Name {
+			groups[i] = group
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		groups = append(groups, group)
+	}
+
+	routes, err := NewRoutes(current.Default, groups)
+	if err != nil {
+		return fmt.Errorf("NewRoutes: %w", err)
+	}
+	table.routes.Store(routes)
+	return nil
+}
+
+// SetDefault replaces the policy of the commands outside the groups
+func (table *RouteTable) SetDefault(policy RoutePolicy) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	routes, err := NewRoutes(policy, table.Routes().Groups())
+	if err != nil {
+		return fmt.Errorf("NewRoutes: %w", err)
+	}
+	table.routes.Store(routes)
+	return nil
+}
+
+// Update changes the default policy and the groups at once.
+// The change gets the copies of the current routes, and the result is swapped in one step,
+// so the readers see either the old or the new routes, never the partial change.
+// If the change returns an error, then the routes are not changed.
+func (table *RouteTable) Update(change func(policy *RoutePolicy, groups []RouteGroup) error) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	current := table.Routes()
+	policy, groups := current.Default, current.Groups()
+	if err := change(&policy, groups); err != nil {
+		return err
+	}
+	routes, err := NewRoutes(policy, groups)
+	if err != nil {
+		return fmt.Errorf("NewRoutes: %w", err)
+	}
+	table.routes.Store(routes)
+	return nil
+}
+
+// Delete the group by its name
+func (table *RouteTable) Delete(name string) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	current := table.Routes()
+	groups := current.Groups()
+	for i := range groups {
+		if groups[i].Name != name {
+			continue
+		}
+		groups = append(groups[:i], groups[i+1:]...)
+		routes, err := NewRoutes(current.Default, groups)
+		if err != nil {
+			return fmt.Errorf("NewRoutes: %w", err)
+		}
+		table.routes.Store(routes)
+		return nil
+	}
+
+	return fmt.Errorf("group '%s' not found", name)
+}
+
+// Save the current routes in the file
+func (table *RouteTable) Save() error {
+	if len(table.path) == 0 {
+		return fmt.Errorf("no file to save the routes")
+	}
+
+	routes := table.Routes()
+	data, err := json.MarshalIndent(routesFile{Default: routes.Default, Groups: routes.Groups()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	if err := writeFile(table.path, data); err != nil {
+		return fmt.Errorf("writeFile: %w", err)
+	}
+	return nil
+}
+
+// Handler replies to the route admin commands.
+// The RouteSetCommand expects the 'group' parameter,
+// the RouteDeleteCommand expects the 'name' parameter.
+func (table *RouteTable) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		switch req.Command {
+		case RouteListCommand:
+			routes := table.Routes()
+			parameters, err := toParameters(routesFile{Default: routes.Default, Groups: routes.Groups()})
+			if err != nil {
+				return Fail(fmt.Sprintf("toParameters: %v", err))
+			}
+			return Ok(parameters)
+		case RouteSetCommand:
+			raw, ok := req.Parameters["group"].(map[string]interface{})
+			if !ok {
+				return Fail("missing 'group' parameter")
+			}
+			var group RouteGroup
+			if err := fromParameters(raw, &group); err != nil {
+				return Fail(fmt.Sprintf("fromParameters: %v", err))
+			}
+			if err := table.Set(group); err != nil {
+				return Fail(fmt.Sprintf("table.Set: %v", err))
+			}
+			return Ok(nil)
+		case RouteDeleteCommand:
+			name := req.StringParam("name")
+			if len(name) == 0 {
+				return Fail("missing 'name' parameter")
+			}
+			if err := table.Delete(name); err != nil {
+				return Fail(fmt.Sprintf("table.Delete: %v", err))
+			}
+			return Ok(nil)
+		case RouteSaveCommand:
+			if err := table.Save(); err != nil {
+				return Fail(fmt.Sprintf("table.Save: %v", err))
+			}
+			return Ok(nil)
+		default:
+			return Fail(fmt.Sprintf("unknown command '%s'", req.Command))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// TestRouteTableUpdateFailureKeepsRoutes checks that the failed update changes nothing
+func TestRouteTableUpdateFailureKeepsRoutes(t *testing.T) {
+	routes, err := NewRoutes(RoutePolicy{Destination: "blue"}, []RouteGroup{
+		{Name: "users", Commands: []string{"get-user"}, RoutePolicy: RoutePolicy{Destination: "blue"}},
+	})
+	if err != nil {
+		t.Fatalf("NewRoutes: %v", err)
+	}
+	table := NewRouteTable(routes, "")
+
+	err = table.Update(func(policy *RoutePolicy, groups []RouteGroup) error {
+		policy.Destination = "green"
+		groups[0].Destination = "green"
+		return fmt.Errorf("rejected")
+	})
+	if err == nil {
+		t.Fatalf("the failed update succeeded")
+	}
+	if policy, _ := table.Routes().Policy("get-user"); policy.Destination != "blue" {
+		t.Fatalf("the failed update changed the group to '%s'", policy.Destination)
+	}
+	if table.Routes().Default.Destination != "blue" {
+		t.Fatalf("the failed update changed the default to '%s'", table.Routes().Default.Destination)
+	}
+
+	if err := table.Set(RouteGroup{Name: "orders", Commands: []string{"get-user"}}); err == nil {
+		t.Fatalf("the command was added to the second group")
+	}
+	if _, ok := table.Routes().Group("orders"); ok {
+		t.Fatalf("the invalid group was added")
+	}
+}
+
+// TestRouteTableAdminCommands changes, saves and loads the routes through the admin commands
+func TestRouteTableAdminCommands(t *testing.T) {
+	routes, err := NewRoutes(RoutePolicy{Destination: "main"}, nil)
+	if err != nil {
+		t.Fatalf("NewRoutes: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "routes.json")
+	handler := NewRouteTable(routes, path).Handler()
+
+	reply := handler(NewEnvelope(&Request{Command: RouteSetCommand, Parameters: map[string]interface{}{
+		"group": map[string]interface{}{"name": "users", "commands": []interface{}{"get-user"}, "destination": "users", "rate_limit": 5},
+	}}))
+	if !reply.IsOK() {
+		t.Fatalf("%s: %s", RouteSetCommand, reply.Message)
+	}
+	if reply := handler(NewEnvelope(&Request{Command: RouteSaveCommand, Parameters: map[string]interface{}{}})); !reply.IsOK() {
+		t.Fatalf("%s: %s", RouteSaveCommand, reply.Message)
+	}
+
+	loaded, err := LoadRouteTable(path)
+	if err != nil {
+		t.Fatalf("LoadRouteTable: %v", err)
+	}
+	policy, group := loaded.Routes().Policy("get-user")
+	if group != "users" || policy.Destination != "users" || policy.RateLimit != 5 {
+		t.Fatalf("loaded policy %+v of '%s'", policy, group)
+	}
+
+	reply = handler(NewEnvelope(&Request{Command: RouteDeleteCommand, Parameters: map[string]interface{}{"name": "users"}}))
+	if !reply.IsOK() {
+		t.Fatalf("%s: %s", RouteDeleteCommand, reply.Message)
+	}
+	reply = handler(NewEnvelope(&Request{Command: RouteDeleteCommand, Parameters: map[string]interface{}{"name": "users"}}))
+	if reply.IsOK() {
+		t.Fatalf("the missing group was deleted")
+	}
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// DefaultWindowMessage is returned outside the window if the message is not set
+const DefaultWindowMessage = "command is not available at this time"
+
+// RouteWindow is the time when the backend of the commands is available.
+// Outside the window, the commands are routed to the alternate destination,
+// or replied with the fail message if there is no alternate destination.
+type RouteWindow struct {
+	// Command is a pattern with the wildcards, for example 'report.*'
+	Command string `json:"command" yaml:"command"`
+	// Active is the cron expression of the active minutes, for example '* 9-17 * * 1-5'
+	Active      string `json:"active" yaml:"active"`
+	Destination string `json:"destination,omitempty" yaml:"destination,omitempty"`
+	Message     string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+type routeWindow struct {
+	RouteWindow
+	cron [5]map[int]struct{}
+}
+
+// RouteWindows applies the first window matching the command
+type RouteWindows struct {
+	windows  []routeWindow
+	location *time.Location
+	now      func() time.Time
+}
+
+// NewRouteWindows returns the windows evaluated in the location. Nil location means the local time
+func NewRouteWindows(windows []RouteWindow, location *time.Location) (*RouteWindows, error) {
+	if location == nil {
+		location = time.Local
+	}
+	routeWindows := &RouteWindows{
+		windows:  make([]routeWindow, len(windows)),
+		location: location,
+		now:      time.Now,
+	}
+	for i, window := range windows {
+		if _, err := path.Match(window.Command, ""); err != nil {
+			return nil, fmt.Errorf("windows[%d] command '%s': %w", i, window.Command, err)
+		}
+		cron, err := parseCron(window.Active)
+		if err != nil {
+			return nil, fmt.Errorf("windows[%d]: parseCron: %w", i, err)
+		}
+		if len(window.Message) == 0 {
+			window.Message = DefaultWindowMessage
+		}
+		routeWindows.windows[i] = routeWindow{RouteWindow: window, cron: cron}
+	}
+	return routeWindows, nil
+}
+
+// Outside returns the window of the command if it's not active now.
+// Returns false if the command has no window or the window is active.
+func (windows *RouteWindows) Outside(command string) (RouteWindow, bool) {
+	now := windows.now().In(windows.location)
+	for _, window := range windows.windows {
+		if matched, _ := path.Match(window.Command, command); !matched {
+			continue
+		}
+		if cronMatches(window.cron, now) {
+			return RouteWindow{}, false
+		}
+		return window.RouteWindow, true
+	}
+	return RouteWindow{}, false
+}
+
+// Middleware replies with the fail message outside the windows without the alternate destination.
+// The windows with the alternate destination are applied by the Router.
+func (windows *RouteWindows) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			if window, ok := windows.Outside(req.Command); ok && len(window.Destination) == 0 {
+				return Fail(window.Message)
+			}
+			return next(req)
+		}
+	}
+}
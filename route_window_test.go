@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRouteWindows checks that the commands are rejected outside their window,
+// unless the window has the alternate destination
+func TestRouteWindows(t *testing.T) {
+	windows, err := NewRouteWindows([]RouteWindow{
+		{Command: "report.*", Active: "* 9-17 * * 1-5"},
+		{Command: "billing.*", Active: "* 9-17 * * 1-5", Destination: "billing-replica", Message: "billing is closed"},
+		{Command: "*", Active: "* * * * *"},
+	}, time.UTC)
+	if err != nil {
+		t.Fatalf("NewRouteWindows: %v", err)
+	}
+	handler := Wrap(func(req *Envelope) *Reply { return Ok(nil) }, windows.Middleware())
+
+	// monday noon
+	windows.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+	if reply := handler(policyRequest("report.daily", nil)); !reply.IsOK() {
+		t.Fatalf("the command is rejected in the window: %s", reply.Message)
+	}
+	if _, ok := windows.Outside("billing.charge"); ok {
+		t.Fatalf("the command is outside the active window")
+	}
+
+	// saturday noon
+	windows.now = func() time.Time { return time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC) }
+	if reply := handler(policyRequest("report.daily", nil)); reply.IsOK() || reply.Message != DefaultWindowMessage {
+		t.Fatalf("the command is passed outside the window: %v", reply)
+	}
+	window, ok := windows.Outside("billing.charge")
+	if !ok || window.Destination != "billing-replica" || window.Message != "billing is closed" {
+		t.Fatalf("the window is %+v", window)
+	}
+	if reply := handler(policyRequest("billing.charge", nil)); !reply.IsOK() {
+		t.Fatalf("the command with the alternate destination is rejected: %s", reply.Message)
+	}
+	if reply := handler(policyRequest("users.get", nil)); !reply.IsOK() {
+		t.Fatalf("the always active command is rejected: %s", reply.Message)
+	}
+}
+
+// TestNewRouteWindowsValidates checks the invalid patterns and cron expressions
+func TestNewRouteWindowsValidates(t *testing.T) {
+	for _, window := range []RouteWindow{
+		{Command: "report.[", Active: "* * * * *"},
+		{Command: "report.*", Active: "* 25 * * *"},
+		{Command: "report.*", Active: "* *"},
+	} {
+		if _, err := NewRouteWindows([]RouteWindow{window}, nil); err == nil {
+			t.Fatalf("the invalid window %+v is accepted", window)
+		}
+	}
+}
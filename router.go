@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// DestinationRule routes the matching requests to the destination.
+// The command is a pattern with the wildcards, for example 'user.*'.
+// If the parameter is set, then the request must have the parameter with the value.
+type DestinationRule struct {
+	Command     string `json:"command" yaml:"command"`
+	Param       string `json:"param,omitempty" yaml:"param,omitempty"`
+	Value       string `json:"value,omitempty" yaml:"value,omitempty"`
+	Destination string `json:"destination" yaml:"destination"`
+}
+
+// Match returns true if the request matches the rule
+func (rule *DestinationRule) Match(req *Request) bool {
+	if matched, _ := path.Match(rule.Command, req.Command); !matched {
+		return false
+	}
+	if len(rule.Param) == 0 {
+		return true
+	}
+	value, ok := req.Parameters[rule.Param]
+	return ok && fmt.Sprint(value) == rule.Value
+}
+
+// Router fans out the requests to the multiple destinations.
+// Outside the route window, the alternate destination of the window is chosen.
+// With the tenants, the request of the tenant goes to the tenant's destination.
+// Otherwise, the destination is chosen by the first matching rule,
+// then by the destination of the route policy, then the default destination.
+// Then the experiment of the chosen destination may route the request to its alternate.
+type Router struct {
+	destinations map[string]DestinationTransport
+	rules        []DestinationRule
+	table        *RouteTable
+	windows      *RouteWindows
+	tenants      *Tenants
+	experiments  map[string]*Experiment
+	fallback     string
+}
+
+// NewRouter returns the router of the named destinations.
+// The table is optional. The fallback is the default destination name.
+func NewRouter(destinations map[string]DestinationTransport, rules []DestinationRule, table *RouteTable, fallback string) (*Router, error) {
+	if len(destinations) == 0 {
+		return nil, fmt.Errorf("no destinations")
+	}
+	if _, ok := destinations[fallback]; !ok {
+		return nil, fmt.Errorf("default destination '%s' not registered", fallback)
+	}
+	for i, rule := range rules {
+		if _, err := path.Match(rule.Command, ""); err != nil {
+			return nil, fmt.Errorf("rules[%d] command '%s': %w", i, rule.Command, err)
+		}
+		if _, ok := destinations[rule.Destination]; !ok {
+			return nil, fmt.Errorf("rules[%d] destination '%s' not registered", i, rule.Destination)
+		}
+	}
+
+	return &Router{
+		destinations: destinations,
+		rules:        rules,
+		table:        table,
+		fallback:     fallback,
+	}, nil
+}
+
+// SetWindows sets the route windows with the alternate destinations.
+// Call it before serving the requests.
+func (router *Router) SetWindows(windows *RouteWindows) error {
+	for _, window := range windows.windows {
+		if len(window.Destination) == 0 {
+			continue
+		}
+		if _, ok := router.destinations[window.Destination]; !ok {
+			return fmt.Errorf("window of '%s' destination '%s' not registered", window.Command, window.Destination)
+		}
+	}
+	router.windows = windows
+	return nil
+}
+
+// SetTenants routes the requests of the tenants to their destinations.
+// The request of the tenant whose destination is not registered fails,
+// rather than reaching the destination shared by the other tenants.
+func (router *Router) SetTenants(tenants *Tenants) {
+	router.tenants = tenants
+}
+
+// SetExperiments splits the clients of the experiment destinations.
+// The destination may have only one experiment. Call it before serving the requests.
+func (router *Router) SetExperiments(experiments []Experiment) error {
+	byDestination := make(map[string]*Experiment, len(experiments))
+	for i := range experiments {
+		experiment := experiments[i]
+		if err := experiment.Validate(); err != nil {
+			return fmt.Errorf("experiments[%d]: %w", i, err)
+		}
+		for _, name := range []string{experiment.Destination, experiment.Alternate} {
+			if _, ok := router.destinations[name]; !ok {
+				return fmt.Errorf("experiment '%s' destination '%s' not registered", experiment.Name, name)
+			}
+		}
+		if other, ok := byDestination[experiment.Destination]; ok {
+			return fmt.Errorf("experiments '%s' and '%s' split the same destination '%s'", other.Name, experiment.Name, experiment.Destination)
+		}
+		byDestination[experiment.Destination] = &experiment
+	}
+	router.experiments = byDestination
+	return nil
+}
+
+// Destination returns the name of the request's destination
+func (router *Router) Destination(req *Envelope) string {
+	if router.windows != nil {
+		if window, ok := router.windows.Outside(req.Command); ok && len(window.Destination) > 0 {
+			return window.Destination
+		}
+	}
+	if router.tenants != nil {
+		if tenant, err := router.tenants.Tenant(req); err == nil {
+			// the destination is empty on error, so the request fails
+			destination, _ := router.tenants.Destination(tenant)
+			return destination
+		}
+	}
+	for i := range router.rules {
+		if router.rules[i].Match(&req.Request) {
+			return router.rules[i].Destination
+		}
+	}
+	if router.table != nil {
+		policy, _ := router.table.Routes().Policy(req.Command)
+		if _, ok := router.destinations[policy.Destination]; ok {
+			return policy.Destination
+		}
+	}
+	return router.fallback
+}
+
+// Names returns the sorted names of the destinations
+func (router *Router) Names() []string {
+	names := make([]string, 0, len(router.destinations))
+	for name := range router.destinations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Handler forwards the requests to their destinations
+func (router *Router) Handler() Handler {
+	forwards := make(map[string]Handler, len(router.destinations))
+	for name, destination := range router.destinations {
+		forwards[name] = Forward(destination)
+	}
+
+	return func(req *Envelope) *Reply {
+		destination := router.Destination(req)
+		experiment, ok := router.experiments[destination]
+		arm := ""
+		if ok {
+			destination, arm = experiment.Route(req)
+		}
+		forward, ok := forwards[destination]
+		if !ok {
+			return Fail(fmt.Sprintf("destination '%s' not registered", destination))
+		}
+		reply := forward(req)
+		if experiment != nil && reply != nil {
+			// the destination may share the reply
+			reply = reply.Copy()
+			experiment.Tag(reply, arm)
+		}
+		return reply
+	}
+}
+
+// Close all destinations. Returns the first error
+func (router *Router) Close() error {
+	var first error
+	for _, name := range router.Names() {
+		if err := router.destinations[name].Close(); err != nil && first == nil {
+			first = fmt.Errorf("destination '%s' Close: %w", name, err)
+		}
+	}
+	return first
+}
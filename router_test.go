@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// namedDestinations returns the destinations replying with their names
+func namedDestinations(names ...string) map[string]DestinationTransport {
+	destinations := make(map[string]DestinationTransport, len(names))
+	for _, name := range names {
+		name := name
+		destinations[name] = NewHandlerDestination(func(*Envelope) *Reply {
+			return Ok(map[string]interface{}{"destination": name})
+		})
+	}
+	return destinations
+}
+
+// TestRouterDestination checks the order of the routing: the rules, the route policy, then the default
+func TestRouterDestination(t *testing.T) {
+	routes, err := NewRoutes(RoutePolicy{}, []RouteGroup{
+		{Name: "reports", Commands: []string{"report.daily", "user.get"}, RoutePolicy: RoutePolicy{Destination: "analytics"}},
+		{Name: "legacy", Commands: []string{"legacy.get"}, RoutePolicy: RoutePolicy{Destination: "missing"}},
+	})
+	if err != nil {
+		t.Fatalf("NewRoutes: %v", err)
+	}
+	router, err := NewRouter(namedDestinations("users", "eu-users", "analytics", "shared"), []DestinationRule{
+		{Command: "user.*", Param: "region", Value: "eu", Destination: "eu-users"},
+		{Command: "user.*", Destination: "users"},
+	}, NewRouteTable(routes, ""), "shared")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	handler := router.Handler()
+
+	expected := map[*Envelope]string{
+		policyRequest("user.get", map[string]interface{}{"region": "eu"}): "eu-users",
+		policyRequest("user.get", map[string]interface{}{"region": "us"}): "users",
+		policyRequest("report.daily", nil):                                "analytics",
+		policyRequest("legacy.get", nil):                                  "shared",
+		policyRequest("ping", nil):                                        "shared",
+	}
+	for req, destination := range expected {
+		if reply := handler(req); reply.Parameters["destination"] != destination {
+			t.Fatalf("'%s' %v is routed to %v, expected '%s'", req.Command, req.Parameters, reply.Parameters["destination"], destination)
+		}
+	}
+	if !reflect.DeepEqual(router.Names(), []string{"analytics", "eu-users", "shared", "users"}) {
+		t.Fatalf("the destination names are %v", router.Names())
+	}
+}
+
+// TestRouterWindows checks that the alternate destination of the window wins outside the window
+func TestRouterWindows(t *testing.T) {
+	router, err := NewRouter(namedDestinations("billing", "billing-replica"), []DestinationRule{{Command: "billing.*", Destination: "billing"}}, nil, "billing")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	windows, err := NewRouteWindows([]RouteWindow{{Command: "billing.*", Active: "* 9-17 * * *", Destination: "billing-replica"}}, time.UTC)
+	if err != nil {
+		t.Fatalf("NewRouteWindows: %v", err)
+	}
+	windows.now = func() time.Time { return time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC) }
+	if err := router.SetWindows(windows); err != nil {
+		t.Fatalf("router.SetWindows: %v", err)
+	}
+	if destination := router.Destination(policyRequest("billing.charge", nil)); destination != "billing-replica" {
+		t.Fatalf("routed to '%s' outside the window", destination)
+	}
+
+	unknown, _ := NewRouteWindows([]RouteWindow{{Command: "*", Active: "* * * * *", Destination: "missing"}}, nil)
+	if err := router.SetWindows(unknown); err == nil {
+		t.Fatalf("the window with the unknown destination is set")
+	}
+}
+
+// TestNewRouterValidates checks the unknown destinations and the invalid rules
+func TestNewRouterValidates(t *testing.T) {
+	destinations := namedDestinations("shared")
+	if _, err := NewRouter(nil, nil, nil, "shared"); err == nil {
+		t.Fatalf("the router without the destinations is created")
+	}
+	if _, err := NewRouter(destinations, nil, nil, "missing"); err == nil {
+		t.Fatalf("the router with the unknown default is created")
+	}
+	for _, rule := range []DestinationRule{{Command: "user.[", Destination: "shared"}, {Command: "user.*", Destination: "missing"}} {
+		if _, err := NewRouter(destinations, []DestinationRule{rule}, nil, "shared"); err == nil {
+			t.Fatalf("the invalid rule %+v is accepted", rule)
+		}
+	}
+}
+
+// closingTransport fails to close
+type closingTransport struct {
+	funcTransport
+	err error
+}
+
+func (transport closingTransport) Close() error {
+	return transport.err
+}
+
+// TestRouterClose checks that all destinations are closed, and the first error is returned
+func TestRouterClose(t *testing.T) {
+	destinations := map[string]DestinationTransport{
+		"a": closingTransport{err: nil},
+		"b": closingTransport{err: fmt.Errorf("b")},
+		"c": closingTransport{err: fmt.Errorf("c")},
+	}
+	router, err := NewRouter(destinations, nil, nil, "a")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	if err := router.Close(); err == nil || err.Error() != "destination 'b' Close: b" {
+		t.Fatalf("router.Close: %v", err)
+	}
+}
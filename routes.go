@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RoutePolicy is the routing and the policies of the command
+type RoutePolicy struct {
+	Destination string `json:"destination,omitempty" yaml:"destination,omitempty"`
+	// RateLimit is the amount of requests per second. Zero means no limit
+	RateLimit float64 `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	// Auth is the name of the authentication policy enforced by RouteAuth. Empty means no authentication
+	Auth string `json:"auth,omitempty" yaml:"auth,omitempty"`
+	// Cache is the time in seconds that RouteCache keeps the successful replies. Zero means no caching
+	Cache uint `json:"cache,omitempty" yaml:"cache,omitempty"`
+	// Timeout is the time in milliseconds to wait for the destination. Zero means the global timeout
+	Timeout uint64 `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// Merge returns the policy where the non-empty fields of the overlay replace the fields
+func (policy RoutePolicy) Merge(overlay RoutePolicy) RoutePolicy {
+	if len(overlay.Destination) > 0 {
+		policy.Destination = overlay.Destination
+	}
+	if overlay.RateLimit > 0 {
+		policy.RateLimit = overlay.RateLimit
+	}
+	if len(overlay.Auth) > 0 {
+		policy.Auth = overlay.Auth
+	}
+	if overlay.Cache > 0 {
+		policy.Cache = overlay.Cache
+	}
+	if overlay.Timeout > 0 {
+		policy.Timeout = overlay.Timeout
+	}
+	return policy
+}
+
+// RouteGroup is the set of the commands sharing the same policy
+type RouteGroup struct {
+	Name        string   `json:"name" yaml:"name"`
+	Commands    []string `json:"commands" yaml:"commands"`
+	RoutePolicy `yaml:",inline"`
+}
+
+// Routes is the route table grouped by the policies.
+// The commands outside the groups get the default policy.
+type Routes struct {
+	Default  RoutePolicy
+	groups   []RouteGroup
+	commands map[string]int
+}
+
+// NewRoutes returns the route table.
+// Returns an error if the group names are not unique, or the command is in more than one group.
+func NewRoutes(defaultPolicy RoutePolicy, groups []RouteGroup) (*Routes, error) {
+	routes := &Routes{
+		Default:  defaultPolicy,
+		groups:   groups,
+		commands: make(map[string]int),
+	}
+
+	names := make(map[string]struct{}, len(groups))
+	for i, group := range groups {
+		if len(group.Name) == 0 {
+			return nil, fmt.Errorf("groups[%d] has no name", i)
+		}
+		if _, ok := names[group.Name]; ok {
+			return nil, fmt.Errorf("groups[%d]: duplicate group '%s'", i, group.Name)
+		}
+		names[group.Name] = struct{}{}
+
+		for _, command := range group.Commands {
+			if at, ok := routes.commands[command]; ok {
+				return nil, fmt.Errorf("command '%s' is in '%s' and '%s' groups", command, groups[at].Name, group.Name)
+			}
+			routes.commands[command] = i
+		}
+	}
+
+	return routes, nil
+}
+
+// Policy returns the policy of the command, and the name of its group.
+// The group policy is layered over the default policy.
+// If the command is not in any group, then the group name is empty.
+func (routes *Routes) Policy(command string) (RoutePolicy, string) {
+	at, ok := routes.commands[command]
+	if !ok {
+		return routes.Default, ""
+	}
+	group := routes.groups[at]
+	return routes.Default.Merge(group.RoutePolicy), group.Name
+}
+
+// Group returns the group by its name
+func (routes *Routes) Group(name string) (RouteGroup, bool) {
+	for _, group := range routes.groups {
+		if group.Name == name {
+			return group, true
+		}
+	}
+	return RouteGroup{}, false
+}
+
+// Groups returns the copy of the groups
+func (routes *Routes) Groups() []RouteGroup {
+	groups := make([]RouteGroup, len(routes.groups))
+	copy(groups, routes.groups)
+	return groups
+}
+
+// Commands returns the sorted list of the commands in the groups
+func (routes *Routes) Commands() []string {
+	commands := make([]string, 0, len(routes.commands))
+	for command := range routes.commands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands
+}
@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// ScaffoldConfig is the new proxy project generated by Scaffold
+type ScaffoldConfig struct {
+	// Name of the proxy, it's the lower case word, for example 'auth-proxy'
+	Name string `json:"name" yaml:"name"`
+	// Port of the tcp source
+	Port uint64 `json:"port" yaml:"port"`
+	// Destination is the address of the tcp destination, for example 'localhost:8081'
+	Destination string `json:"destination" yaml:"destination"`
+}
+
+// scaffoldFiles are the templates of the project files by their names
+var scaffoldFiles = map[string]string{
+	"main.go":       scaffoldMain,
+	"middleware.go": scaffoldMiddleware,
+	"main_test.go":  scaffoldTest,
+	"service.yml":   scaffoldService,
+	".gitignore":    "/{{.Name}}\n",
+	"README.md":     scaffoldReadme,
+}
+
+// Scaffold generates the new proxy project in the directory:
+// the main.go wired to New, the service.yml, the example middleware and its test over the MemoryTransport.
+// Then, run 'go mod init' and 'go mod tidy' in the directory.
+//
+// The existing files are never overwritten, the directory must not have any of the project files.
+func Scaffold(dir string, config ScaffoldConfig) error {
+	if len(config.Name) == 0 || strings.ContainsAny(config.Name, " /\\\"'") {
+		return fmt.Errorf("invalid name '%s'", config.Name)
+	}
+	if config.Port == 0 {
+		return fmt.Errorf("no port")
+	}
+	if len(config.Destination) == 0 {
+		return fmt.Errorf("no destination")
+	}
+
+	files := make(map[string][]byte, len(scaffoldFiles))
+	for name, text := range scaffoldFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return fmt.Errorf("'%s' already exists", name)
+		}
+		content, err := scaffoldFile(name, text, config)
+		if err != nil {
+			return fmt.Errorf("scaffoldFile('%s'): %w", name, err)
+		}
+		files[name] = content
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("os.MkdirAll: %w", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return fmt.Errorf("os.WriteFile('%s'): %w", name, err)
+		}
+	}
+	return nil
+}
+
+// scaffoldFile executes the template of the file. The go files are formatted
+func scaffoldFile(name string, text string, config ScaffoldConfig) ([]byte, error) {
+	parsed, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template.Parse: %w", err)
+	}
+	var content bytes.Buffer
+	if err := parsed.Execute(&content, config); err != nil {
+		return nil, fmt.Errorf("template.Execute: %w", err)
+	}
+	if filepath.Ext(name) != ".go" {
+		return content.Bytes(), nil
+	}
+	formatted, err := format.Source(content.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format.Source: %w", err)
+	}
+	return formatted, nil
+}
+
+const scaffoldMain = `// Command {{.Name}} is the proxy generated by the proxy-lib Scaffold
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ahmetson/proxy-lib"
+	"gopkg.in/yaml.v3"
+)
+
+func main() {
+	configPath := flag.String("config", "service.yml", "the configuration of the proxy")
+	lint := flag.Bool("lint", false, "check the configuration and exit")
+	flag.Parse()
+
+	if err := run(*configPath, *lint); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run the proxy until the interrupt signal, then stop it gracefully
+func run(configPath string, lint bool) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("os.ReadFile: %w", err)
+	}
+	var config proxy.LintConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("yaml.Unmarshal: %w", err)
+	}
+	if issues := proxy.Lint(config); issues != nil {
+		return issues
+	}
+	if lint {
+		fmt.Println("the configuration has no issues")
+		return nil
+	}
+
+	source, err := proxy.NewSource(config.Source)
+	if err != nil {
+		return fmt.Errorf("proxy.NewSource: %w", err)
+	}
+	pipeline, err := proxy.BuildPipeline(config.Pipeline)
+	if err != nil {
+		return fmt.Errorf("proxy.BuildPipeline: %w", err)
+	}
+	destinations := make(map[string]proxy.DestinationTransport, len(config.Destinations))
+	for name, destinationConfig := range config.Destinations {
+		destination, err := proxy.NewDestination(destinationConfig)
+		if err != nil {
+			return fmt.Errorf("proxy.NewDestination('%s'): %w", name, err)
+		}
+		destinations[name] = destination
+	}
+	router, err := proxy.NewRouter(destinations, config.Rules, nil, config.Default)
+	if err != nil {
+		return fmt.Errorf("proxy.NewRouter: %w", err)
+	}
+
+	service, err := proxy.New()
+	if err != nil {
+		return fmt.Errorf("proxy.New: %w", err)
+	}
+	service.Server = &proxy.Server{
+		Source:      source,
+		Destination: proxy.NewHandlerDestination(router.Handler()),
+		Middlewares: append(pipeline, Stamp("{{.Name}}")),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := service.Start(ctx); err != nil {
+		return fmt.Errorf("service.Start: %w", err)
+	}
+	// the source stopped accepting the requests on the signal, the requests in flight are drained
+	if err := service.Stop(); err != nil {
+		return fmt.Errorf("service.Stop: %w", err)
+	}
+	if err := router.Close(); err != nil {
+		return fmt.Errorf("router.Close: %w", err)
+	}
+	return nil
+}
+`
+
+const scaffoldMiddleware = `package main
+
+import (
+	"github.com/ahmetson/proxy-lib"
+)
+
+// ProxyParam is the reply parameter with the name of the proxy that forwarded the request
+const ProxyParam = "proxy"
+
+// Stamp adds the name of the proxy to the successful replies.
+// It's the example middleware, replace it with the logic of the proxy.
+func Stamp(name string) proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(req *proxy.Envelope) *proxy.Reply {
+			reply := next(req)
+			if reply == nil || !reply.IsOK() {
+				return reply
+			}
+			// the destination may share the reply
+			reply = reply.Copy()
+			if reply.Parameters == nil {
+				reply.Parameters = map[string]interface{}{}
+			}
+			reply.Parameters[ProxyParam] = name
+			return reply
+		}
+	}
+}
+`
+
+const scaffoldTest = `package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ahmetson/proxy-lib"
+)
+
+// TestStamp runs the middleware in the proxy over the in-memory transports
+func TestStamp(t *testing.T) {
+	source := proxy.NewMemoryTransport(1)
+	server := &proxy.Server{
+		Source: source,
+		Destination: proxy.NewHandlerDestination(func(req *proxy.Envelope) *proxy.Reply {
+			return proxy.Ok(nil)
+		}),
+		Middlewares: []proxy.Middleware{Stamp("{{.Name}}")},
+	}
+	go func() {
+		_ = server.Start(context.Background())
+	}()
+	defer func() {
+		_ = server.Stop()
+	}()
+
+	reply, err := source.Send(context.Background(), proxy.NewEnvelope(&proxy.Request{Command: "hello"}))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if !reply.IsOK() || reply.Parameters[ProxyParam] != "{{.Name}}" {
+		t.Fatalf("the proxy replied %v", reply)
+	}
+}
+`
+
+const scaffoldService = `# The configuration of the {{.Name}} proxy. Check it with 'go run . -lint'
+source:
+  type: tcp
+  settings:
+    port: {{.Port}}
+pipeline:
+  - name: probe:{{.Name}}
+destinations:
+  main:
+    type: tcp
+    settings:
+      address: {{.Destination}}
+default: main
+`
+
+const scaffoldReadme = `# {{.Name}}
+The proxy generated by the proxy-lib Scaffold.
+
+It listens on the tcp port {{.Port}} and forwards the requests to {{.Destination}}.
+The example middleware is in the middleware.go.
+
+Run it with:
+
+    go mod init {{.Name}}
+    go mod tidy
+    go run . -lint
+    go run .
+`
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestScaffoldGeneratesProject checks that the project files are generated once, and the go files are valid
+func TestScaffoldGeneratesProject(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "demo")
+	config := ScaffoldConfig{Name: "demo", Port: 8080, Destination: "localhost:8081"}
+	if err := Scaffold(dir, config); err != nil {
+		t.Fatalf("Scaffold: %v", err)
+	}
+
+	for name := range scaffoldFiles {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("os.ReadFile: %v", err)
+		}
+		if strings.Contains(string(content), "{{") {
+			t.Fatalf("'%s' has the template left:\n%s", name, content)
+		}
+		if filepath.Ext(name) == ".go" {
+			if _, err := parser.ParseFile(token.NewFileSet(), name, content, 0); err != nil {
+				t.Fatalf("'%s' is invalid: %v", name, err)
+			}
+		}
+	}
+	service, _ := os.ReadFile(filepath.Join(dir, "service.yml"))
+	if !strings.Contains(string(service), "port: 8080") || !strings.Contains(string(service), "address: localhost:8081") {
+		t.Fatalf("service.yml has no source or destination:\n%s", service)
+	}
+
+	if err := Scaffold(dir, config); err == nil {
+		t.Fatalf("Scaffold overwrote the project")
+	}
+	if err := Scaffold(t.TempDir(), ScaffoldConfig{Name: "my proxy", Port: 8080, Destination: "localhost:8081"}); err == nil {
+		t.Fatalf("Scaffold accepted the invalid name")
+	}
+}
@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The admin commands of the scheduler
+const (
+	ScheduleListCommand   = "schedule.list"
+	ScheduleAddCommand    = "schedule.add"
+	ScheduleRemoveCommand = "schedule.remove"
+)
+
+// Schedule is the request sent by the proxy on its own.
+// The spec is either the cron expression with five fields 'minute hour day month weekday',
+// or '@every <duration>', for example '@every 30s'.
+// Unlike the classic cron, the day and the weekday must both match.
+type Schedule struct {
+	Name       string                 `json:"name" yaml:"name"`
+	Spec       string                 `json:"spec" yaml:"spec"`
+	Command    string                 `json:"command" yaml:"command"`
+	Parameters map[string]interface{} `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+}
+
+type scheduledJob struct {
+	schedule Schedule
+	every    time.Duration
+	cron     [5]map[int]struct{}
+	next     time.Time
+}
+
+// cronRanges are the bounds of the cron fields
+var cronRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// parseCronField returns the values of the field, like '*', '*/5', '1-10/2' or '1,15'
+func parseCronField(field string, low int, high int) (map[int]struct{}, error) {
+	values := make(map[int]struct{})
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if at := strings.Index(part, "/"); at > -1 {
+			parsed, err := strconv.Atoi(part[at+1:])
+			if err != nil || parsed < 1 {
+				return nil, fmt.Errorf("invalid step in '%s'", part)
+			}
+			step = parsed
+			part = part[:at]
+		}
+
+		from, to := low, high
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			parsed, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", part)
+			}
+			from, to = parsed, parsed
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid range '%s'", part)
+				}
+			}
+		}
+		if from < low || to > high || from > to {
+			return nil, fmt.Errorf("'%s' is out of %d-%d", part, low, high)
+		}
+
+		for value := from; value <= to; value += step {
+			values[value] = struct{}{}
+		}
+	}
+	return values, nil
+}
+
+// newScheduledJob parses the spec of the schedule
+func newScheduledJob(schedule Schedule) (*scheduledJob, error) {
+	if len(schedule.Name) == 0 || len(schedule.Command) == 0 {
+		return nil, fmt.Errorf("schedule requires the name and the command")
+	}
+
+	job := &scheduledJob{schedule: schedule}
+	if strings.HasPrefix(schedule.Spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimPrefix(schedule.Spec, "@every "))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("invalid duration in '%s'", schedule.Spec)
+		}
+		job.every = every
+		return job, nil
+	}
+
+	cron, err := parseCron(schedule.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("parseCron: %w", err)
+	}
+	job.cron = cron
+	return job, nil
+}
+
+// parseCron returns the values of the five fields of the cron expression
+func parseCron(spec string) ([5]map[int]struct{}, error) {
+	var cron [5]map[int]struct{}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return cron, fmt.Errorf("cron expression '%s' must have five fields", spec)
+	}
+	for i, field := range fields {
+		values, err := parseCronField(field, cronRanges[i][0], cronRanges[i][1])
+		if err != nil {
+			return cron, fmt.Errorf("field %d of '%s': %w", i+1, spec, err)
+		}
+		cron[i] = values
+	}
+	return cron, nil
+}
+
+// after returns the next run time after the given time
+func (job *scheduledJob) after(now time.Time) time.Time {
+	if job.every > 0 {
+		return now.Add(job.every)
+	}
+
+	next := now.Truncate(time.Minute).Add(time.Minute)
+	// four years are enough to find any valid expression, like the 29th of February
+	for limit := next.AddDate(4, 0, 0); next.Before(limit); next = next.Add(time.Minute) {
+		if job.matches(next) {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+func (job *scheduledJob) matches(at time.Time) bool {
+	return cronMatches(job.cron, at)
+}
+
+// cronMatches returns true if the minute of the time is in the cron expression
+func cronMatches(cron [5]map[int]struct{}, at time.Time) bool {
+	values := [5]int{at.Minute(), at.Hour(), at.Day(), int(at.Month()), int(at.Weekday())}
+	for i, value := range values {
+		if _, ok := cron[i][value]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Scheduler sends the scheduled requests to the handler,
+// for example to warm the caches or to keep the destination alive.
+type Scheduler struct {
+	// OnResult is called with the reply of the scheduled request. Optional
+	OnResult func(name string, reply *Reply)
+
+	handler   Handler
+	mu        sync.Mutex
+	jobs      map[string]*scheduledJob
+	resources *Resources
+	now       func() time.Time
+}
+
+// NewScheduler returns the scheduler that sends the requests to the handler
+func NewScheduler(handler Handler) *Scheduler {
+	return &Scheduler{handler: handler, jobs: make(map[string]*scheduledJob), now: time.Now}
+}
+
+// WithResources tracks the goroutines of the scheduled requests
+func (scheduler *Scheduler) WithResources(resources *Resources) *Scheduler {
+	scheduler.resources = resources
+	return scheduler
+}
+
+// Add the schedule, or replace the schedule with the same name
+func (scheduler *Scheduler) Add(schedule Schedule) error {
+	job, err := newScheduledJob(schedule)
+	if err != nil {
+		return fmt.Errorf("newScheduledJob: %w", err)
+	}
+	job.next = job.after(scheduler.now())
+
+	scheduler.mu.Lock()
+	scheduler.jobs[schedule.Name] = job
+	scheduler.mu.Unlock()
+	return nil
+}
+
+// Remove the schedule by its name
+func (scheduler *Scheduler) Remove(name string) error {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	if _, ok := scheduler.jobs[name]; !ok {
+		return fmt.Errorf("schedule '%s' not found", name)
+	}
+	delete(scheduler.jobs, name)
+	return nil
+}
+
+// List returns the schedules sorted by the name
+func (scheduler *Scheduler) List() []Schedule {
+	scheduler.mu.Lock()
+	schedules := make([]Schedule, 0, len(scheduler.jobs))
+	for _, job := range scheduler.jobs {
+		schedules = append(schedules, job.schedule)
+	}
+	scheduler.mu.Unlock()
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Name < schedules[j].Name
+	})
+	return schedules
+}
+
+// Run sends the due requests until the context is cancelled
+func (scheduler *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scheduler.runDue()
+		}
+	}
+}
+
+func (scheduler *Scheduler) runDue() {
+	now := scheduler.now()
+
+	scheduler.mu.Lock()
+	var due []Schedule
+	for _, job := range scheduler.jobs {
+		if job.next.IsZero() || now.Before(job.next) {
+			continue
+		}
+		due = append(due, job.schedule)
+		job.next = job.after(now)
+	}
+	scheduler.mu.Unlock()
+
+	for _, schedule := range due {
+		schedule := schedule
+		scheduler.resources.Go("scheduler", schedule.Name, DefaultRequestMaxAge, func() {
+			parameters := make(map[string]interface{}, len(schedule.Parameters))
+			for name, value := range schedule.Parameters {
+				parameters[name] = value
+			}
+			reply := scheduler.handler(NewEnvelope(&Request{Command: schedule.Command, Parameters: parameters}))
+			if scheduler.OnResult != nil {
+				scheduler.OnResult(schedule.Name, reply)
+			}
+		})
+	}
+}
+
+// Handler replies to the scheduler admin commands.
+// The ScheduleAddCommand expects the 'schedule' parameter,
+// the ScheduleRemoveCommand expects the 'name' parameter.
+func (scheduler *Scheduler) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		switch req.Command {
+		case ScheduleListCommand:
+			parameters, err := toParameters(map[string]interface{}{"schedules": scheduler.List()})
+			if err != nil {
+				return Fail(fmt.Sprintf("toParameters: %v", err))
+			}
+			return Ok(parameters)
+		case ScheduleAddCommand:
+			raw, ok := req.Parameters["schedule"].(map[string]interface{})
+			if !ok {
+				return Fail("missing 'schedule' parameter")
+			}
+			var schedule Schedule
+			if err := fromParameters(raw, &schedule); err != nil {
+				return Fail(fmt.Sprintf("fromParameters: %v", err))
+			}
+			if err := scheduler.Add(schedule); err != nil {
+				return Fail(fmt.Sprintf("scheduler.Add: %v", err))
+			}
+			return Ok(nil)
+		case ScheduleRemoveCommand:
+			if err := scheduler.Remove(req.StringParam("name")); err != nil {
+				return Fail(fmt.Sprintf("scheduler.Remove: %v", err))
+			}
+			return Ok(nil)
+		default:
+			return Fail(fmt.Sprintf("unknown command '%s'", req.Command))
+		}
+	}
+}
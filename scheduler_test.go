@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSchedulerRunsDue checks that the due schedules are sent with their own parameters
+func TestSchedulerRunsDue(t *testing.T) {
+	now := time.Date(2024, 1, 1, 8, 59, 30, 0, time.UTC)
+	received := make(chan *Envelope, 4)
+	scheduler := NewScheduler(func(req *Envelope) *Reply {
+		req.Parameters["changed"] = true
+		received <- req
+		return Ok(nil)
+	})
+	scheduler.now = func() time.Time { return now }
+	results := make(chan string, 4)
+	scheduler.OnResult = func(name string, reply *Reply) { results <- name }
+
+	warm := Schedule{Name: "warm", Spec: "0 9 * * *", Command: "cache.warm", Parameters: map[string]interface{}{"key": "users"}}
+	if err := scheduler.Add(warm); err != nil {
+		t.Fatalf("scheduler.Add: %v", err)
+	}
+	if err := scheduler.Add(Schedule{Name: "keepalive", Spec: "@every 1m", Command: PingCommand}); err != nil {
+		t.Fatalf("scheduler.Add: %v", err)
+	}
+
+	scheduler.runDue()
+	if len(received) != 0 {
+		t.Fatalf("sent the schedules before they are due")
+	}
+
+	now = now.Add(time.Minute)
+	scheduler.runDue()
+	commands := map[string]*Envelope{}
+	for i := 0; i < 2; i++ {
+		select {
+		case req := <-received:
+			commands[req.Command] = req
+			<-results
+		case <-time.After(time.Second):
+			t.Fatalf("the due schedules are not sent")
+		}
+	}
+	if commands["cache.warm"].StringParam("key") != "users" || commands[PingCommand] == nil {
+		t.Fatalf("sent %v", commands)
+	}
+	if _, ok := warm.Parameters["changed"]; ok {
+		t.Fatalf("the handler changed the parameters of the schedule")
+	}
+
+	scheduler.runDue()
+	select {
+	case req := <-received:
+		t.Fatalf("sent '%s' twice in the same minute", req.Command)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+// TestSchedulerHandler checks the admin commands
+func TestSchedulerHandler(t *testing.T) {
+	scheduler := NewScheduler(func(req *Envelope) *Reply { return Ok(nil) })
+	handler := scheduler.Handler()
+
+	add := policyRequest(ScheduleAddCommand, map[string]interface{}{"schedule": map[string]interface{}{
+		"name": "warm", "spec": "*/5 * * * *", "command": "cache.warm",
+	}})
+	if reply := handler(add); !reply.IsOK() {
+		t.Fatalf("the add command failed: %s", reply.Message)
+	}
+	invalid := []map[string]interface{}{
+		nil,
+		{"schedule": map[string]interface{}{"name": "warm", "spec": "@every 1ms", "command": "cache.warm"}},
+		{"schedule": map[string]interface{}{"name": "warm", "spec": "60 * * * *", "command": "cache.warm"}},
+		{"schedule": map[string]interface{}{"spec": "* * * * *", "command": "cache.warm"}},
+	}
+	for _, parameters := range invalid {
+		if reply := handler(policyRequest(ScheduleAddCommand, parameters)); reply.IsOK() {
+			t.Fatalf("the invalid schedule %v is added", parameters)
+		}
+	}
+
+	reply := handler(policyRequest(ScheduleListCommand, nil))
+	schedules, _ := reply.Parameters["schedules"].([]interface{})
+	if !reply.IsOK() || len(schedules) != 1 {
+		t.Fatalf("the list command replied %v", reply)
+	}
+	if reply := handler(policyRequest(ScheduleRemoveCommand, map[string]interface{}{"name": "warm"})); !reply.IsOK() {
+		t.Fatalf("the remove command failed: %s", reply.Message)
+	}
+	if reply := handler(policyRequest(ScheduleRemoveCommand, map[string]interface{}{"name": "warm"})); reply.IsOK() {
+		t.Fatalf("removed the missing schedule")
+	}
+	if reply := handler(policyRequest("schedule.pause", nil)); reply.IsOK() {
+		t.Fatalf("the unknown command succeeded")
+	}
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// The parameter types of the schema
+const (
+	AnyType    = "any"
+	StringType = "string"
+	NumberType = "number"
+	BoolType   = "bool"
+	ObjectType = "object"
+	ArrayType  = "array"
+)
+
+// ParamSchema is the declared message parameter
+type ParamSchema struct {
+	Type        string `json:"type" yaml:"type"`
+	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// CommandSchema is the declared request and reply parameters of the command
+type CommandSchema struct {
+	Description string                 `json:"description,omitempty" yaml:"description,omitempty"`
+	Request     map[string]ParamSchema `json:"request,omitempty" yaml:"request,omitempty"`
+	Reply       map[string]ParamSchema `json:"reply,omitempty" yaml:"reply,omitempty"`
+}
+
+// typeOf returns the schema type of the decoded json value
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return StringType
+	case float64, float32, int, int64, int32, uint, uint64, uint32:
+		return NumberType
+	case bool:
+		return BoolType
+	case map[string]interface{}:
+		return ObjectType
+	case []interface{}:
+		return ArrayType
+	default:
+		return AnyType
+	}
+}
+
+// checkParameters returns the mismatches between the parameters and the schema.
+// If strict is true, then the undeclared parameters are the mismatches too.
+func checkParameters(schema map[string]ParamSchema, parameters map[string]interface{}, strict bool) []string {
+	var violations []string
+	for name, param := range schema {
+		value, ok := parameters[name]
+		if !ok {
+			if param.Required {
+				violations = append(violations, fmt.Sprintf("missing required '%s' parameter", name))
+			}
+			continue
+		}
+		if param.Type != AnyType && len(param.Type) > 0 && typeOf(value) != param.Type {
+			violations = append(violations, fmt.Sprintf("'%s' parameter is %s, expected %s", name, typeOf(value), param.Type))
+		}
+	}
+	if strict {
+		for name := range parameters {
+			if _, ok := schema[name]; !ok {
+				violations = append(violations, fmt.Sprintf("undeclared '%s' parameter", name))
+			}
+		}
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+// Conformance checks that the destination replies match the declared schemas.
+// It catches the contract drift between the services.
+type Conformance struct {
+	schemas map[string]CommandSchema
+	// Strict flags the undeclared reply parameters, and replaces the violating reply with the fail reply
+	Strict bool
+	// OnViolation is called with the violations of the reply. Use it to log them
+	OnViolation func(command string, violations []string)
+	violations  uint64
+}
+
+// NewConformance returns the checker of the schemas by the command name
+func NewConformance(schemas map[string]CommandSchema, strict bool) *Conformance {
+	return &Conformance{schemas: schemas, Strict: strict}
+}
+
+// CheckReply returns the violations of the reply.
+// The commands without the schema are not checked.
+// Only the successful replies are checked against the parameters.
+func (conformance *Conformance) CheckReply(command string, reply *Reply) []string {
+	schema, ok := conformance.schemas[command]
+	if !ok {
+		return nil
+	}
+	if reply == nil {
+		return []string{"no reply"}
+	}
+	if reply.Status != OK && reply.Status != FAIL {
+		return []string{fmt.Sprintf("invalid status '%s'", reply.Status)}
+	}
+	if reply.Status == FAIL {
+		if len(reply.Message) == 0 {
+			return []string{"fail reply without message"}
+		}
+		return nil
+	}
+	return checkParameters(schema.Reply, reply.Parameters, conformance.Strict)
+}
+
+// Violations returns the amount of the violating replies
+func (conformance *Conformance) Violations() uint64 {
+	return atomic.LoadUint64(&conformance.violations)
+}
+
+// Middleware checks the replies of the next handler
+func (conformance *Conformance) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			command := req.Command
+			reply := next(req)
+
+			violations := conformance.CheckReply(command, reply)
+			if len(violations) == 0 {
+				return reply
+			}
+			atomic.AddUint64(&conformance.violations, 1)
+			if conformance.OnViolation != nil {
+				conformance.OnViolation(command, violations)
+			}
+			if conformance.Strict {
+				return Fail(fmt.Sprintf("destination reply violates '%s' schema: %v", command, violations))
+			}
+			return reply
+		}
+	}
+}
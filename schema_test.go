@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestConformance checks that the replies violating the schema are counted,
+// and replaced with the fail reply in the strict mode
+func TestConformance(t *testing.T) {
+	schemas := map[string]CommandSchema{
+		"users.get": {Reply: map[string]ParamSchema{
+			"id":   {Type: StringType, Required: true},
+			"age":  {Type: NumberType},
+			"tags": {Type: AnyType},
+		}},
+	}
+	replies := map[string]*Reply{
+		"valid":      Ok(map[string]interface{}{"id": "1", "age": float64(30), "tags": "x"}),
+		"undeclared": Ok(map[string]interface{}{"id": "1", "internal": true}),
+		"mistyped":   Ok(map[string]interface{}{"age": "30"}),
+		"fail":       Fail(""),
+		"status":     {Status: "unknown"},
+		"lost":       nil,
+	}
+	var violated []string
+	conformance := NewConformance(schemas, false)
+	conformance.OnViolation = func(command string, violations []string) {
+		violated = append(violated, violations...)
+	}
+
+	if violations := conformance.CheckReply("users.get", replies["valid"]); len(violations) != 0 {
+		t.Fatalf("the valid reply violates %v", violations)
+	}
+	if violations := conformance.CheckReply("users.get", replies["undeclared"]); len(violations) != 0 {
+		t.Fatalf("the undeclared parameter violates the lenient schema: %v", violations)
+	}
+	expected := []string{"'age' parameter is string, expected number", "missing required 'id' parameter"}
+	if violations := conformance.CheckReply("users.get", replies["mistyped"]); !reflect.DeepEqual(violations, expected) {
+		t.Fatalf("the violations are %v", violations)
+	}
+	if violations := conformance.CheckReply("users.list", replies["status"]); len(violations) != 0 {
+		t.Fatalf("the command without the schema is checked")
+	}
+
+	reply := replies["fail"]
+	handler := Wrap(func(req *Envelope) *Reply { return reply }, conformance.Middleware())
+	for _, name := range []string{"fail", "status", "lost"} {
+		reply = replies[name]
+		if handler(policyRequest("users.get", nil)) != reply {
+			t.Fatalf("the lenient conformance replaced the '%s' reply", name)
+		}
+	}
+	if conformance.Violations() != 3 || len(violated) != 3 {
+		t.Fatalf("counted %d violations: %v", conformance.Violations(), violated)
+	}
+
+	conformance.Strict = true
+	reply = replies["undeclared"]
+	if reply := handler(policyRequest("users.get", nil)); reply.IsOK() {
+		t.Fatalf("the strict conformance passed the undeclared parameter")
+	}
+	reply = nil
+	if reply := handler(policyRequest("users.get", nil)); reply == nil || reply.IsOK() {
+		t.Fatalf("the strict conformance passed the missing reply")
+	}
+}
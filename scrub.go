@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// tokenPatterns match the values that look like the secrets
+var tokenPatterns = []*regexp.Regexp{
+	// bearer tokens
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9\-._~+/]+=*`),
+	// json web tokens
+	regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`),
+	// long hex or base64 keys
+	regexp.MustCompile(`\b[A-Fa-f0-9]{32,}\b`),
+	regexp.MustCompile(`\b[A-Za-z0-9+/]{40,}={0,2}`),
+}
+
+// DefaultSecretNames are the parameters that are always scrubbed
+var DefaultSecretNames = []string{"password", "secret", "token", "api_key", "auth_token", "private_key"}
+
+// Scrubber removes the secrets from the parameters, log lines and errors.
+// The parameters are scrubbed by their name, the texts by the token-looking values
+// and the 'name=value' or 'name: value' pairs of the secret names.
+type Scrubber struct {
+	names map[string]struct{}
+	pairs *regexp.Regexp
+}
+
+// NewScrubber returns the scrubber of the secret names in addition to the DefaultSecretNames
+func NewScrubber(names ...string) *Scrubber {
+	scrubber := &Scrubber{names: make(map[string]struct{})}
+
+	all := append(append([]string{}, DefaultSecretNames...), names...)
+	quoted := make([]string, 0, len(all))
+	for _, name := range all {
+		scrubber.names[strings.ToLower(name)] = struct{}{}
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	// the word boundary keeps the longer names like 'max_token' unmasked
+	scrubber.pairs = regexp.MustCompile(`(?i)("?\b(?:` + strings.Join(quoted, "|") + `)"?\s*[:=]\s*)("[^"]*"|[^\s,}&]+)`)
+
+	return scrubber
+}
+
+// Secret returns true if the parameter name is the secret
+func (scrubber *Scrubber) Secret(name string) bool {
+	_, ok := scrubber.names[strings.ToLower(name)]
+	return ok
+}
+
+// String returns the text with the masked secrets
+func (scrubber *Scrubber) String(text string) string {
+	text = scrubber.pairs.ReplaceAllString(text, "${1}"+MaskedValue)
+	for _, pattern := range tokenPatterns {
+		text = pattern.ReplaceAllString(text, MaskedValue)
+	}
+	return text
+}
+
+// Error returns the error with the masked message.
+// The returned error still unwraps to the original error.
+func (scrubber *Scrubber) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &scrubbedError{message: scrubber.String(err.Error()), err: err}
+}
+
+// Middleware masks the secrets in the messages of the fail replies, for example the error strings.
+// Put it first, so the replies of all other middlewares are scrubbed.
+func (scrubber *Scrubber) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			reply := next(req)
+			if reply == nil || reply.IsOK() {
+				return reply
+			}
+			scrubbed := *reply
+			scrubbed.Message = scrubber.String(reply.Message)
+			return &scrubbed
+		}
+	}
+}
+
+// Parameters returns the copy of the parameters with the masked secrets, including the nested ones
+func (scrubber *Scrubber) Parameters(parameters map[string]interface{}) map[string]interface{} {
+	scrubbed := make(map[string]interface{}, len(parameters))
+	for name, value := range parameters {
+		if scrubber.Secret(name) {
+			scrubbed[name] = MaskedValue
+			continue
+		}
+		scrubbed[name] = scrubber.value(value)
+	}
+	return scrubbed
+}
+
+func (scrubber *Scrubber) value(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case string:
+		return scrubber.String(typed)
+	case map[string]interface{}:
+		return scrubber.Parameters(typed)
+	case []interface{}:
+		scrubbed := make([]interface{}, len(typed))
+		for i, item := range typed {
+			scrubbed[i] = scrubber.value(item)
+		}
+		return scrubbed
+	default:
+		return value
+	}
+}
+
+type scrubbedError struct {
+	message string
+	err     error
+}
+
+func (err *scrubbedError) Error() string {
+	return err.message
+}
+
+func (err *scrubbedError) Unwrap() error {
+	return err.err
+}
+
+// Is compares the original error, so errors.Is works through the scrubbing
+func (err *scrubbedError) Is(target error) bool {
+	return errors.Is(err.err, target)
+}
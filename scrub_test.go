@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestScrubberString checks the secret pairs and the token-looking values
+func TestScrubberString(t *testing.T) {
+	scrubber := NewScrubber("session")
+	texts := map[string]string{
+		"login failed for password=hunter2":                  "login failed for password=" + MaskedValue,
+		`{"api_key": "abc def", "user": "alice"}`:            `{"api_key": ` + MaskedValue + `, "user": "alice"}`,
+		"Session: 123 expired":                               "Session: " + MaskedValue + " expired",
+		"max_token=100":                                      "max_token=100",
+		"header Authorization: Bearer abc.def-ghi":           "header Authorization: " + MaskedValue,
+		"key " + strings.Repeat("a1", 16) + " is rejected":   "key " + MaskedValue + " is rejected",
+		"jwt eyJhbGciOi.eyJzdWIiOi.c2lnbmF0dXJl in the logs": "jwt " + MaskedValue + " in the logs",
+	}
+	for text, expected := range texts {
+		if scrubbed := scrubber.String(text); scrubbed != expected {
+			t.Fatalf("'%s' is scrubbed as '%s', expected '%s'", text, scrubbed, expected)
+		}
+	}
+}
+
+// TestScrubberParameters checks the nested secrets, keeping the original parameters
+func TestScrubberParameters(t *testing.T) {
+	scrubber := NewScrubber()
+	parameters := map[string]interface{}{
+		"Password": "hunter2",
+		"user":     map[string]interface{}{"token": "abc", "name": "alice"},
+		"notes":    []interface{}{"secret=abc", 1},
+	}
+	scrubbed := scrubber.Parameters(parameters)
+	user := scrubbed["user"].(map[string]interface{})
+	notes := scrubbed["notes"].([]interface{})
+	if scrubbed["Password"] != MaskedValue || user["token"] != MaskedValue || user["name"] != "alice" {
+		t.Fatalf("the scrubbed parameters are %v", scrubbed)
+	}
+	if notes[0] != "secret="+MaskedValue || notes[1] != 1 {
+		t.Fatalf("the scrubbed list is %v", notes)
+	}
+	if parameters["Password"] != "hunter2" || parameters["user"].(map[string]interface{})["token"] != "abc" {
+		t.Fatalf("the original parameters are changed")
+	}
+}
+
+// TestScrubberErrorAndMiddleware checks that the scrubbed error unwraps to the original,
+// and only the fail replies are scrubbed
+func TestScrubberErrorAndMiddleware(t *testing.T) {
+	scrubber := NewScrubber()
+	original := errors.New("password=hunter2 rejected")
+	err := scrubber.Error(fmt.Errorf("login: %w", original))
+	if strings.Contains(err.Error(), "hunter2") || !errors.Is(err, original) {
+		t.Fatalf("the scrubbed error is '%v'", err)
+	}
+	if scrubber.Error(nil) != nil {
+		t.Fatalf("the nil error is scrubbed")
+	}
+
+	shared := Fail("token=abc")
+	replies := map[string]*Reply{"fail": shared, "ok": Ok(map[string]interface{}{"token": "abc"}), "lost": nil}
+	handler := Wrap(func(req *Envelope) *Reply { return replies[req.Command] }, scrubber.Middleware())
+	if reply := handler(policyRequest("fail", nil)); reply.Message != "token="+MaskedValue || shared.Message != "token=abc" {
+		t.Fatalf("the fail reply is scrubbed as '%s', the shared reply as '%s'", reply.Message, shared.Message)
+	}
+	if reply := handler(policyRequest("ok", nil)); reply.Parameters["token"] != "abc" {
+		t.Fatalf("the ok reply is scrubbed")
+	}
+	if reply := handler(policyRequest("lost", nil)); reply != nil {
+		t.Fatalf("the missing reply is replaced with %v", reply)
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"fmt"
+)
+
+// The commands of the secrets handler
+const (
+	GetStringCommand    = "GetString"
+	SetStringCommand    = "SetString"
+	GetSecretCommand    = "GetSecret"
+	DeleteSecretCommand = "DeleteSecret"
+	ListSecretsCommand  = "ListSecrets"
+)
+
+// SecretStore is the credential provider that could be changed
+type SecretStore interface {
+	CredentialProvider
+	SetString(bucket string, key string, value string) error
+	// GetSecret returns all secrets of the bucket
+	GetSecret(bucket string) (map[string]string, error)
+	// DeleteSecret deletes the key of the bucket. The empty key deletes the whole bucket
+	DeleteSecret(bucket string, key string) error
+	// ListSecrets returns the sorted bucket names
+	ListSecrets() ([]string, error)
+}
+
+// SecretsHandler replies to the secret commands of the store.
+// The commands have the 'bucket', 'key' and 'value' parameters as needed.
+// The unknown commands get the fail reply.
+//
+// The secrets are readable and writable by the commands, so the handler requires the access control.
+// The principal must be allowed to call the command by the rbac, and the calls are audited.
+// Put the authentication before the handler, so the requests have the principal.
+func SecretsHandler(store SecretStore, rbac *RBAC) Handler {
+	if rbac == nil {
+		return func(req *Envelope) *Reply {
+			return Fail("secrets handler has no access control")
+		}
+	}
+
+	commands := map[string]Handler{
+		GetStringCommand: func(req *Envelope) *Reply {
+			value, err := store.GetString(req.StringParam("bucket"), req.StringParam("key"))
+			if err != nil {
+				return Fail(fmt.Sprintf("store.GetString: %v", err))
+			}
+			return Ok(map[string]interface{}{"value": value})
+		},
+		SetStringCommand: func(req *Envelope) *Reply {
+			bucket, key := req.StringParam("bucket"), req.StringParam("key")
+			if len(bucket) == 0 || len(key) == 0 {
+				return Fail("missing 'bucket' or 'key' parameter")
+			}
+			if err := store.SetString(bucket, key, req.StringParam("value")); err != nil {
+				return Fail(fmt.Sprintf("store.SetString: %v", err))
+			}
+			return Ok(nil)
+		},
+		GetSecretCommand: func(req *Envelope) *Reply {
+			secrets, err := store.GetSecret(req.StringParam("bucket"))
+			if err != nil {
+				return Fail(fmt.Sprintf("store.GetSecret: %v", err))
+			}
+			parameters := make(map[string]interface{}, len(secrets))
+			for key, value := range secrets {
+				parameters[key] = value
+			}
+			return Ok(map[string]interface{}{"secrets": parameters})
+		},
+		DeleteSecretCommand: func(req *Envelope) *Reply {
+			bucket := req.StringParam("bucket")
+			if len(bucket) == 0 {
+				return Fail("missing 'bucket' parameter")
+			}
+			if err := store.DeleteSecret(bucket, req.StringParam("key")); err != nil {
+				return Fail(fmt.Sprintf("store.DeleteSecret: %v", err))
+			}
+			return Ok(nil)
+		},
+		ListSecretsCommand: func(req *Envelope) *Reply {
+			buckets, err := store.ListSecrets()
+			if err != nil {
+				return Fail(fmt.Sprintf("store.ListSecrets: %v", err))
+			}
+			return Ok(map[string]interface{}{"buckets": buckets})
+		},
+	}
+
+	return rbac.Middleware()(func(req *Envelope) *Reply {
+		handler, ok := commands[req.Command]
+		if !ok {
+			return Fail(fmt.Sprintf("unknown command '%s'", req.Command))
+		}
+		return handler(req)
+	})
+}
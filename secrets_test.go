@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSecretsHandler checks that the secret commands require the access control,
+// and only the allowed principals call them
+func TestSecretsHandler(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	path := filepath.Join(t.TempDir(), "credentials")
+	if err := EncryptCredentials(path, key, nil, DatabaseCredentials{}); err != nil {
+		t.Fatalf("EncryptCredentials: %v", err)
+	}
+	store, err := NewFileCredentials(path, key)
+	if err != nil {
+		t.Fatalf("NewFileCredentials: %v", err)
+	}
+
+	if reply := SecretsHandler(store, nil)(tenantRequest("alice", ListSecretsCommand)); reply.IsOK() {
+		t.Fatalf("the secrets are served without the access control")
+	}
+
+	var audit bytes.Buffer
+	rbac, err := NewRBAC(RBACConfig{
+		Roles:      map[string][]string{"reader": {"Get*", ListSecretsCommand}, "writer": {"*"}},
+		Principals: map[string][]string{"alice": {"writer"}, "bob": {"reader"}},
+	}, &audit)
+	if err != nil {
+		t.Fatalf("NewRBAC: %v", err)
+	}
+	handler := SecretsHandler(store, rbac)
+	call := func(principal string, command string, parameters map[string]interface{}) *Reply {
+		req := tenantRequest(principal, command)
+		for name, value := range parameters {
+			req.Parameters[name] = value
+		}
+		return handler(req)
+	}
+
+	if reply := call("alice", SetStringCommand, map[string]interface{}{"bucket": "api", "key": "token", "value": "abc"}); !reply.IsOK() {
+		t.Fatalf("the set command failed: %s", reply.Message)
+	}
+	if reply := call("bob", SetStringCommand, map[string]interface{}{"bucket": "api", "key": "token", "value": "xyz"}); reply.IsOK() {
+		t.Fatalf("the reader changed the secret")
+	}
+	if reply := call("bob", GetStringCommand, map[string]interface{}{"bucket": "api", "key": "token"}); reply.Parameters["value"] != "abc" {
+		t.Fatalf("the get command replied %v", reply)
+	}
+	reply := call("bob", GetSecretCommand, map[string]interface{}{"bucket": "api"})
+	if secrets, _ := reply.Parameters["secrets"].(map[string]interface{}); secrets["token"] != "abc" {
+		t.Fatalf("the bucket command replied %v", reply)
+	}
+	if reply := call("alice", SetStringCommand, map[string]interface{}{"bucket": "api"}); reply.IsOK() {
+		t.Fatalf("the secret without the key is set")
+	}
+	if reply := call("alice", DeleteSecretCommand, map[string]interface{}{"bucket": "api"}); !reply.IsOK() {
+		t.Fatalf("the delete command failed: %s", reply.Message)
+	}
+	if reply := call("alice", ListSecretsCommand, nil); !reply.IsOK() || len(reply.Parameters["buckets"].([]string)) != 0 {
+		t.Fatalf("the list command replied %v", reply)
+	}
+	if reply := call("alice", "RotateSecret", nil); reply.IsOK() || !strings.Contains(reply.Message, "unknown command") {
+		t.Fatalf("the unknown command replied %v", reply)
+	}
+
+	if strings.Count(audit.String(), "\n") != 8 || strings.Contains(audit.String(), "abc") {
+		t.Fatalf("the audit is\n%s", audit.String())
+	}
+}
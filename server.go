@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Server runs the proxy between the source and the destination transports,
+// and stops it gracefully: the source stops accepting the requests,
+// the requests in flight are drained, then the destination is closed.
+type Server struct {
+	Source      SourceTransport
+	Destination DestinationTransport
+	Middlewares []Middleware
+	// DrainTimeout limits the waiting for the requests in flight. Zero means DefaultDrainTimeout.
+	// It's also set to the DrainingSource
+	DrainTimeout time.Duration
+	// Registration is optional. The proxy is registered in the destination before serving,
+	// and deregistered after the requests are drained.
+	Registration *Registration
+	// Diagnostics is optional. It's emitted when the proxy starts, before serving
+	Diagnostics *Diagnostics
+	// DiagnosticsOut is where the Diagnostics is emitted. Nil means os.Stderr
+	DiagnosticsOut io.Writer
+	// Queue is optional. The requests are queued by their priority, and the Workers forward them,
+	// so the higher tiers overtake the lower ones under the load.
+	// Put the Queue.Authenticate into the Middlewares, so the priority is set by the tier of the principal.
+	Queue *PriorityQueue
+	// Workers is the amount of the requests forwarded at once from the Queue. Zero means DefaultWorkers
+	Workers int
+
+	mu          sync.Mutex
+	cancel      context.CancelFunc
+	stopWorkers context.CancelFunc
+	served      chan error
+	stopping    bool
+	inFlight    sync.WaitGroup
+}
+
+// Start serving. Blocks until the context is cancelled or Stop is called.
+func (server *Server) Start(ctx context.Context) error {
+	server.mu.Lock()
+	if server.cancel != nil {
+		server.mu.Unlock()
+		return fmt.Errorf("server is already started")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	server.cancel = cancel
+	// the workers outlive the serving, so the queued requests are drained on Stop
+	workers, stopWorkers := context.WithCancel(context.Background())
+	server.stopWorkers = stopWorkers
+	served := make(chan error, 1)
+	server.served = served
+	server.stopping = false
+	server.mu.Unlock()
+
+	// Stop waits for the served error, so it's sent on every return
+	if server.Registration != nil {
+		if err := server.Registration.Register(ctx, server.Destination); err != nil {
+			server.mu.Lock()
+			if server.served == served {
+				server.cancel = nil
+			}
+			server.mu.Unlock()
+			cancel()
+			stopWorkers()
+			served <- err
+			return fmt.Errorf("registration.Register: %w", err)
+		}
+	}
+
+	if server.Diagnostics != nil {
+		out := server.DiagnosticsOut
+		if out == nil {
+			out = os.Stderr
+		}
+		// the diagnostics are the best effort, the proxy serves without them
+		_ = server.Diagnostics.Emit(out)
+	}
+
+	if source, ok := server.Source.(DrainingSource); ok && server.DrainTimeout > 0 {
+		source.SetDrainTimeout(server.DrainTimeout)
+	}
+	middlewares := append([]Middleware{server.track()}, server.Middlewares...)
+	if server.Queue != nil {
+		middlewares = append(middlewares, server.Queue.Dispatch(workers, server.Workers))
+	}
+	handler := Wrap(Forward(server.Destination), middlewares...)
+	err := server.Source.Serve(ctx, handler)
+	served <- err
+	if err != nil {
+		return fmt.Errorf("source.Serve: %w", err)
+	}
+	return nil
+}
+
+// track counts the requests in flight, and rejects the new requests while stopping
+func (server *Server) track() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			server.mu.Lock()
+			if server.stopping {
+				server.mu.Unlock()
+				return Fail("proxy is stopping")
+			}
+			server.inFlight.Add(1)
+			server.mu.Unlock()
+
+			defer server.inFlight.Done()
+			return next(req)
+		}
+	}
+}
+
+// Stop the server gracefully.
+// Returns an error if the requests in flight were not drained within the timeout,
+// the destination is closed anyway.
+func (server *Server) Stop() error {
+	server.mu.Lock()
+	if server.cancel == nil {
+		server.mu.Unlock()
+		return fmt.Errorf("server is not started")
+	}
+	server.stopping = true
+	cancel, served, stopWorkers := server.cancel, server.served, server.stopWorkers
+	server.cancel, server.stopWorkers = nil, nil
+	server.mu.Unlock()
+
+	cancel()
+	<-served
+
+	timeout := server.DrainTimeout
+	if timeout == 0 {
+		timeout = DefaultDrainTimeout
+	}
+	drained := make(chan struct{})
+	go func() {
+		server.inFlight.Wait()
+		close(drained)
+	}()
+
+	var drainErr error
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		drainErr = fmt.Errorf("requests in flight not drained within %s", timeout)
+	}
+	stopWorkers()
+
+	if server.Registration != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := server.Registration.Deregister(ctx, server.Destination)
+		cancel()
+		if err != nil && drainErr == nil {
+			drainErr = fmt.Errorf("registration.Deregister: %w", err)
+		}
+	}
+
+	if err := server.Destination.Close(); err != nil {
+		return fmt.Errorf("destination.Close: %w", err)
+	}
+	return drainErr
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drainingSource records the drain timeout set by the server
+type drainingSource struct {
+	*MemoryTransport
+	timeout time.Duration
+}
+
+func (source *drainingSource) SetDrainTimeout(timeout time.Duration) {
+	source.timeout = timeout
+}
+
+// TestServerStopWhileRegistering stops the server before the registration replied.
+// Stop must not wait forever for the serving that never started.
+func TestServerStopWhileRegistering(t *testing.T) {
+	registering := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	destination := NewHandlerDestination(func(req *Envelope) *Reply {
+		if req.Command == RegisterCommand {
+			close(registering)
+			<-done
+		}
+		return Ok(nil)
+	})
+	server := &Server{
+		Source:       NewMemoryTransport(1),
+		Destination:  destination,
+		Registration: &Registration{Name: "proxy-1", Version: "v1.0.0"},
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- server.Start(context.Background())
+	}()
+	<-registering
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Stop()
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Stop blocked while the registration was in flight")
+	}
+	if err := <-started; err == nil {
+		t.Fatalf("Start succeeded without the registration")
+	}
+}
+
+// TestServerDrainsInFlight checks that Stop waits for the requests in flight,
+// and rejects the new requests meanwhile
+func TestServerDrainsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	source := &drainingSource{MemoryTransport: NewMemoryTransport(1)}
+	var out bytes.Buffer
+	server := &Server{
+		Source: source,
+		Destination: NewHandlerDestination(func(req *Envelope) *Reply {
+			close(entered)
+			<-release
+			return Ok(nil)
+		}),
+		DrainTimeout:   time.Second,
+		Diagnostics:    NewDiagnostics("proxy-1", nil),
+		DiagnosticsOut: &out,
+	}
+	go func() {
+		_ = server.Start(context.Background())
+	}()
+
+	replied := make(chan *Reply, 1)
+	go func() {
+		reply, _ := source.Send(context.Background(), NewEnvelope(&Request{Command: "slow"}))
+		replied <- reply
+	}()
+	<-entered
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Stop()
+	}()
+	select {
+	case <-stopped:
+		t.Fatalf("Stop returned before the request in flight finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if reply := <-replied; !reply.IsOK() {
+		t.Fatalf("the request in flight failed: %s", reply.Message)
+	}
+	if source.timeout != time.Second {
+		t.Fatalf("the source drain timeout is %s, expected the server's", source.timeout)
+	}
+	if !strings.Contains(out.String(), `"name":"proxy-1"`) {
+		t.Fatalf("the diagnostics were not emitted: %q", out.String())
+	}
+}
@@ -1,7 +1,14 @@
-// Package proxy defines the script that acts as the middleware
+//go:build !nozmq
+
+// Package proxy defines the script that acts as the middleware.
+//
+// The Proxy service runs on the service-lib, which needs cgo and libzmq.
+// Build with the 'nozmq' tag to leave it out, then the proxy is run by the Server
+// over the transports of the standard library, for example the TCPSource and the TCPDestination.
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"github.com/ahmetson/service-lib"
 )
@@ -9,6 +16,9 @@ import (
 // Proxy defines the parameters of the proxy service
 type Proxy struct {
 	*service.Service
+	// Server runs the proxy between the source and the destination with the graceful shutdown.
+	// Set it before Start.
+	Server *Server
 }
 
 // New proxy service returned
@@ -18,5 +28,35 @@ func New() (*Proxy, error) {
 		return nil, fmt.Errorf("service.New: %w", err)
 	}
 
-	return &Proxy{independent}, nil
+	return &Proxy{Service: independent}, nil
+}
+
+// Start serving the proxy. Blocks until the context is cancelled or Stop is called.
+// Unlike Run, it returns, so the process could exit after Stop.
+func (proxy *Proxy) Start(ctx context.Context) error {
+	if proxy.Server == nil {
+		return fmt.Errorf("no server")
+	}
+	return proxy.Server.Start(ctx)
+}
+
+// Stop the proxy gracefully: the source stops accepting the requests,
+// the requests in flight are drained within the Server.DrainTimeout, then the destination is closed.
+func (proxy *Proxy) Stop() error {
+	if proxy.Server == nil {
+		return fmt.Errorf("no server")
+	}
+	return proxy.Server.Stop()
+}
+
+// ValidateTypes cross-checks the source and the pipeline of the proxy before it's run.
+// See the package ValidateTypes.
+func (proxy *Proxy) ValidateTypes(source SourceConfig, pipeline []MiddlewareConfig) error {
+	return ValidateTypes(source, pipeline)
+}
+
+// Lint checks the configuration of the proxy for the common mistakes before it's run.
+// See the package Lint.
+func (proxy *Proxy) Lint(config LintConfig) LintIssues {
+	return Lint(config)
 }
@@ -0,0 +1,8 @@
+//go:build nozmq
+
+// Package proxy defines the script that acts as the middleware.
+//
+// It's built with the 'nozmq' tag, so the Proxy service of the service-lib is left out,
+// and the proxy needs neither cgo nor libzmq.
+// Run it by the Server over the transports of the standard library, for example the TCPSource and the TCPDestination.
+package proxy
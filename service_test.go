@@ -0,0 +1,39 @@
+//go:build !nozmq
+
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestProxyStartStop checks that the proxy serves through its server, and Start returns after Stop
+func TestProxyStartStop(t *testing.T) {
+	source := NewMemoryTransport(1)
+	proxy := &Proxy{Server: &Server{
+		Source:      source,
+		Destination: NewHandlerDestination(func(req *Envelope) *Reply { return Ok(nil) }),
+	}}
+	if err := (&Proxy{}).Stop(); err == nil {
+		t.Fatalf("the proxy without the server stopped")
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- proxy.Start(context.Background())
+	}()
+	reply, err := source.Send(context.Background(), NewEnvelope(&Request{Command: "hello"}))
+	if err != nil || !reply.IsOK() {
+		t.Fatalf("the proxy replied %v, %v", reply, err)
+	}
+
+	if err := proxy.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatalf("Start didn't return after Stop")
+	}
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the amount of the virtual nodes per proxy instance on the ring
+const DefaultReplicas = 100
+
+// OwnerParam is the reply parameter with the instance that owns the client
+const OwnerParam = "owner"
+
+// Ring is the consistent hash ring of the proxy instances.
+// All instances with the same list of nodes agree on the owner of the client.
+// Adding or removing a node moves only the clients of that node.
+type Ring struct {
+	hashes []uint32
+	nodes  map[uint32]string
+}
+
+// NewRing returns the ring of the nodes.
+// If replicas is zero, then DefaultReplicas is used.
+func NewRing(nodes []string, replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+
+	ring := &Ring{
+		hashes: make([]uint32, 0, len(nodes)*replicas),
+		nodes:  make(map[uint32]string, len(nodes)*replicas),
+	}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(node + "#" + strconv.Itoa(i)))
+			if _, ok := ring.nodes[hash]; ok {
+				continue
+			}
+			ring.nodes[hash] = node
+			ring.hashes = append(ring.hashes, hash)
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool {
+		return ring.hashes[i] < ring.hashes[j]
+	})
+
+	return ring
+}
+
+// Owner returns the node that owns the key.
+// Returns an empty string if the ring has no nodes.
+func (ring *Ring) Owner(key string) string {
+	if len(ring.hashes) == 0 {
+		return ""
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	at := sort.Search(len(ring.hashes), func(i int) bool {
+		return ring.hashes[i] >= hash
+	})
+	if at == len(ring.hashes) {
+		at = 0
+	}
+	return ring.nodes[ring.hashes[at]]
+}
+
+// Shard routes the clients to the proxy instance that owns their state
+type Shard struct {
+	// Self is the name of this instance on the ring
+	Self string
+	// Key is the request parameter that identifies the client
+	Key   string
+	ring  *Ring
+	peers map[string]DestinationTransport
+}
+
+// NewShard returns the shard of this instance.
+// The peers are the transports to the other instances by their names.
+func NewShard(self string, key string, ring *Ring, peers map[string]DestinationTransport) *Shard {
+	return &Shard{Self: self, Key: key, ring: ring, peers: peers}
+}
+
+// Middleware passes the requests of the own clients to the next handler.
+// The requests of the other clients are forwarded to their owner.
+// If there is no transport to the owner, then the fail reply with the owner is returned,
+// so the client could reconnect.
+// The requests without the key are handled locally.
+// The forwarded request is never forwarded again, so the instances that disagree on the ring,
+// for example while the nodes are changing, don't bounce it between each other.
+func (shard *Shard) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			client, ok := req.Parameters[shard.Key]
+			if !ok {
+				return next(req)
+			}
+
+			owner := shard.ring.Owner(fmt.Sprint(client))
+			if len(owner) == 0 || owner == shard.Self {
+				return next(req)
+			}
+
+			peer, ok := shard.peers[owner]
+			if !ok || req.Hops > 0 {
+				reply := Fail(fmt.Sprintf("client is owned by '%s'", owner))
+				reply.Parameters[OwnerParam] = owner
+				return reply
+			}
+
+			ctx, cancel := envelopeContext(req)
+			defer cancel()
+			forwarded := *req
+			forwarded.Hops++
+			reply, err := peer.Send(ctx, &forwarded)
+			if err != nil {
+				return Fail(fmt.Sprintf("peer '%s' Send: %v", owner, err))
+			}
+			return reply
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// TestRingOwnerIsStable checks that the ring agrees on the owner, and moves only the part of the clients
+func TestRingOwnerIsStable(t *testing.T) {
+	ring := NewRing([]string{"alpha", "beta", "gamma"}, 0)
+	same := NewRing([]string{"gamma", "alpha", "beta"}, 0)
+	grown := NewRing([]string{"alpha", "beta", "gamma", "delta"}, 0)
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		client := fmt.Sprintf("client-%d", i)
+		owner := ring.Owner(client)
+		if same.Owner(client) != owner {
+			t.Fatalf("the order of the nodes changed the owner of '%s'", client)
+		}
+		if grown.Owner(client) != owner {
+			moved++
+		}
+	}
+	if moved == 0 || moved > 400 {
+		t.Fatalf("%d of 1000 clients moved to the new node, expected about a quarter", moved)
+	}
+}
+
+// TestShardDoesNotBounce checks that the instances that disagree on the ring
+// don't forward the request back and forth
+func TestShardDoesNotBounce(t *testing.T) {
+	var forwards int32
+	var alpha, beta *Shard
+	handle := func(shard **Shard) Handler {
+		return Wrap(func(req *Envelope) *Reply { return Ok(nil) }, func(next Handler) Handler {
+			return func(req *Envelope) *Reply {
+				atomic.AddInt32(&forwards, 1)
+				return (*shard).Middleware()(next)(req)
+			}
+		})
+	}
+	// each instance thinks the other one owns every client
+	alpha = NewShard("alpha", "client", NewRing([]string{"beta"}, 0), map[string]DestinationTransport{
+		"beta": NewHandlerDestination(handle(&beta)),
+	})
+	beta = NewShard("beta", "client", NewRing([]string{"alpha"}, 0), map[string]DestinationTransport{
+		"alpha": NewHandlerDestination(handle(&alpha)),
+	})
+
+	reply := handle(&alpha)(NewEnvelope(&Request{Command: "get", Parameters: map[string]interface{}{"client": "c1"}}))
+	if reply.IsOK() {
+		t.Fatalf("the request of the disputed client succeeded")
+	}
+	if reply.Parameters[OwnerParam] != "alpha" {
+		t.Fatalf("the owner is '%v', expected the beta's view 'alpha'", reply.Parameters[OwnerParam])
+	}
+	if forwards != 2 {
+		t.Fatalf("the request passed %d instances, expected 2", forwards)
+	}
+}
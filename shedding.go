@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultShedMessage is the reply message of the shed request
+const DefaultShedMessage = "proxy is overloaded, try again later"
+
+// shedProbe is how often the request is let through while the latency is over the limit,
+// so the moving average follows the recovered destination
+const shedProbe = 20
+
+// latencyWeight is the weight of the new latency in the moving average
+const latencyWeight = 0.1
+
+// LoadShedder rejects the low priority requests when the proxy is overloaded.
+// The proxy is overloaded when the depth or the average latency exceeds the threshold.
+// The requests with the priority at or above the Protected are never shed.
+// The priority of the unauthenticated request comes from the client, so such request is never protected.
+// Put the shedder after the PriorityQueue.Authenticate, so the priority is set by the proxy.
+type LoadShedder struct {
+	// Depth returns the amount of the queued requests, for example PriorityQueue.Len. Optional
+	Depth    func() int
+	MaxDepth int
+	// MaxLatency of the moving average of the replies. Zero means the latency is not considered
+	MaxLatency time.Duration
+	// Pressure returns true if the proxy is overloaded otherwise, for example MemoryGuard.Pressure. Optional
+	Pressure func() bool
+	// Protected is the lowest priority that is never shed
+	Protected int
+	// Message of the rejection reply
+	Message string
+
+	// tiers are the sorted priorities of the tiers, the shed requests are counted by them
+	tiers      []int
+	mu         sync.Mutex
+	latency    float64
+	shed       map[int]uint64
+	candidates uint64
+	metrics    *Metrics
+}
+
+// NewLoadShedder returns the shedder that protects the priority and above
+func NewLoadShedder(protected int, depth func() int, maxDepth int, maxLatency time.Duration) *LoadShedder {
+	return &LoadShedder{
+		Depth:      depth,
+		MaxDepth:   maxDepth,
+		MaxLatency: maxLatency,
+		Protected:  protected,
+		Message:    DefaultShedMessage,
+		tiers:      tierPriorities(DefaultTiers()),
+		shed:       make(map[int]uint64),
+	}
+}
+
+// tierPriorities returns the sorted unique priorities of the tiers
+func tierPriorities(tiers map[string]TierPolicy) []int {
+	unique := make(map[int]struct{}, len(tiers))
+	priorities := make([]int, 0, len(tiers))
+	for _, policy := range tiers {
+		if _, ok := unique[policy.Priority]; !ok {
+			unique[policy.Priority] = struct{}{}
+			priorities = append(priorities, policy.Priority)
+		}
+	}
+	sort.Ints(priorities)
+	return priorities
+}
+
+// WithTiers counts the shed requests by the priorities of the tiers, like the PriorityQueue of the tiers.
+// By default, the DefaultTiers are used.
+func (shedder *LoadShedder) WithTiers(tiers map[string]TierPolicy) *LoadShedder {
+	if len(tiers) > 0 {
+		shedder.tiers = tierPriorities(tiers)
+	}
+	return shedder
+}
+
+// tier returns the highest tier priority at or below the priority, otherwise the lowest tier priority.
+// So the priority sent by the client never adds the new counter.
+func (shedder *LoadShedder) tier(priority int) int {
+	// the first tier above the priority
+	above := sort.SearchInts(shedder.tiers, priority+1)
+	if above == 0 {
+		return shedder.tiers[0]
+	}
+	return shedder.tiers[above-1]
+}
+
+// WithMetrics counts the shed requests by the tier priority
+func (shedder *LoadShedder) WithMetrics(metrics *Metrics) *LoadShedder {
+	shedder.metrics = metrics
+	return shedder
+}
+
+// Overloaded returns true if the depth or the latency is over the threshold, or there is a pressure
+func (shedder *LoadShedder) Overloaded() bool {
+	if shedder.pressured() {
+		return true
+	}
+
+	shedder.mu.Lock()
+	defer shedder.mu.Unlock()
+
+	return shedder.slow()
+}
+
+// pressured returns true if the depth is over the threshold, or there is a pressure
+func (shedder *LoadShedder) pressured() bool {
+	if shedder.Pressure != nil && shedder.Pressure() {
+		return true
+	}
+	return shedder.Depth != nil && shedder.MaxDepth > 0 && shedder.Depth() >= shedder.MaxDepth
+}
+
+// slow returns true if the latency is over the threshold. Must be called with the lock
+func (shedder *LoadShedder) slow() bool {
+	return shedder.MaxLatency > 0 && time.Duration(shedder.latency) > shedder.MaxLatency
+}
+
+// Shed returns true if the request of the priority must be rejected.
+// While only the latency is over the threshold, every shedProbe request is let through,
+// otherwise the average of the shed requests would never go down.
+func (shedder *LoadShedder) Shed(priority int) bool {
+	if priority >= shedder.Protected {
+		return false
+	}
+	pressured := shedder.pressured()
+
+	shedder.mu.Lock()
+	if !pressured {
+		if !shedder.slow() {
+			shedder.mu.Unlock()
+			return false
+		}
+		shedder.candidates++
+		if shedder.candidates%shedProbe == 0 {
+			shedder.mu.Unlock()
+			return false
+		}
+	}
+	priority = shedder.tier(priority)
+	shedder.shed[priority]++
+	shedder.mu.Unlock()
+
+	if shedder.metrics != nil {
+		shedder.metrics.Add("proxy_shed_total", "the requests rejected by the load shedder by priority", 1, "priority", strconv.Itoa(priority))
+	}
+	return true
+}
+
+// observe adds the latency to the moving average
+func (shedder *LoadShedder) observe(latency time.Duration) {
+	shedder.mu.Lock()
+	defer shedder.mu.Unlock()
+
+	if shedder.latency == 0 {
+		shedder.latency = float64(latency)
+		return
+	}
+	shedder.latency = shedder.latency*(1-latencyWeight) + float64(latency)*latencyWeight
+}
+
+// ShedCounts returns the amount of the shed requests by the tier priority
+func (shedder *LoadShedder) ShedCounts() map[int]uint64 {
+	shedder.mu.Lock()
+	defer shedder.mu.Unlock()
+
+	counts := make(map[int]uint64, len(shedder.shed))
+	for priority, count := range shedder.shed {
+		counts[priority] = count
+	}
+	return counts
+}
+
+// Middleware rejects the requests while the proxy is overloaded
+func (shedder *LoadShedder) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			priority := req.Priority
+			if len(req.Principal) == 0 && priority >= shedder.Protected {
+				priority = shedder.Protected - 1
+			}
+			if shedder.Shed(priority) {
+				return Fail(shedder.Message)
+			}
+			start := time.Now()
+			reply := next(req)
+			shedder.observe(time.Since(start))
+			return reply
+		}
+	}
+}
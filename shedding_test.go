@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLoadShedderRecovers checks that the shedding stops once the destination is fast again,
+// even if only the low priority requests arrive
+func TestLoadShedderRecovers(t *testing.T) {
+	shedder := NewLoadShedder(10, nil, 0, 5*time.Millisecond)
+	slow := true
+	handler := Wrap(func(req *Envelope) *Reply {
+		if slow {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return Ok(nil)
+	}, shedder.Middleware())
+
+	handler(NewEnvelope(&Request{Command: "work"}))
+	if !shedder.Overloaded() {
+		t.Fatalf("the slow destination doesn't overload the shedder")
+	}
+
+	slow = false
+	for i := 0; i < 100*shedProbe && shedder.Overloaded(); i++ {
+		handler(NewEnvelope(&Request{Command: "work"}))
+	}
+	if shedder.Overloaded() {
+		t.Fatalf("the shedding is permanent after the destination recovered")
+	}
+	if shedder.ShedCounts()[0] == 0 {
+		t.Fatalf("nothing was shed while overloaded")
+	}
+}
+
+// TestLoadShedderIgnoresClientPriority checks that the unauthenticated client can't claim the protected priority
+func TestLoadShedderIgnoresClientPriority(t *testing.T) {
+	shedder := NewLoadShedder(10, func() int { return 1 }, 1, 0)
+	handler := Wrap(func(req *Envelope) *Reply { return Ok(nil) }, shedder.Middleware())
+
+	req := NewEnvelope(&Request{Command: "work"})
+	req.Priority = 100
+	if reply := handler(req); reply.IsOK() {
+		t.Fatalf("the unauthenticated request with the protected priority was not shed")
+	}
+
+	req.Principal = "admin"
+	if reply := handler(req); !reply.IsOK() {
+		t.Fatalf("the authenticated protected request was shed")
+	}
+}
+
+// TestLoadShedderCountsByTier checks that the shed requests are counted only by the priorities of the tiers
+func TestLoadShedderCountsByTier(t *testing.T) {
+	shedder := NewLoadShedder(10, func() int { return 1 }, 1, 0)
+	for _, priority := range []int{-1000, -1, 0, 1, 2, 3, 7, 9} {
+		if !shedder.Shed(priority) {
+			t.Fatalf("the priority %d was not shed", priority)
+		}
+	}
+
+	counts := shedder.ShedCounts()
+	expected := map[int]uint64{0: 3, 1: 1, 2: 4}
+	if len(counts) != len(expected) {
+		t.Fatalf("counted %v, expected %v", counts, expected)
+	}
+	for priority, count := range expected {
+		if counts[priority] != count {
+			t.Fatalf("counted %v, expected %v", counts, expected)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// sloBuckets is the amount of the buckets in the SLO window
+const sloBuckets = 60
+
+// The kinds of the SLO events
+const (
+	LatencyBurn = "latency"
+	ErrorBurn   = "errors"
+)
+
+// DefaultBurnThreshold emits the event when the budget burns twice as fast as allowed
+const DefaultBurnThreshold = 2.0
+
+// SLO is the service level objective of the route
+type SLO struct {
+	// Latency is the p99 latency target. Zero means no latency objective
+	Latency time.Duration `json:"latency,omitempty" yaml:"latency,omitempty"`
+	// ErrorBudget is the allowed fraction of the failed replies, for example 0.001
+	ErrorBudget float64 `json:"error_budget,omitempty" yaml:"error_budget,omitempty"`
+	// Window is the time over which the burn rate is measured.
+	// The window shorter than 60 milliseconds is replaced with one hour
+	Window time.Duration `json:"window" yaml:"window"`
+}
+
+// SLOStatus is the current burn rates of the route.
+// The burn rate 1 means the budget is consumed exactly as allowed.
+type SLOStatus struct {
+	Requests        uint64  `json:"requests"`
+	LatencyBurnRate float64 `json:"latency_burn_rate"`
+	ErrorBurnRate   float64 `json:"error_burn_rate"`
+}
+
+// SLOEvent is emitted when the budget of the route is at risk
+type SLOEvent struct {
+	Route    string  `json:"route"`
+	Kind     string  `json:"kind"`
+	BurnRate float64 `json:"burn_rate"`
+}
+
+type sloBucket struct {
+	start  time.Time
+	total  uint64
+	failed uint64
+	slow   uint64
+}
+
+type sloWindow struct {
+	slo      SLO
+	buckets  [sloBuckets]sloBucket
+	notified map[string]time.Time
+}
+
+// SLOTracker tracks the burn rates of the routes.
+// The route is the group name in the route table, or the command if it's not in the group.
+type SLOTracker struct {
+	// BurnThreshold is the burn rate that emits the event
+	BurnThreshold float64
+	// OnEvent is called when the budget is at risk, at most once per bucket per kind
+	OnEvent func(event SLOEvent)
+
+	table   *RouteTable
+	mu      sync.Mutex
+	windows map[string]*sloWindow
+	now     func() time.Time
+}
+
+// NewSLOTracker returns the tracker of the objectives by the route.
+// The table is optional, if it's nil, then the routes are the commands.
+func NewSLOTracker(slos map[string]SLO, table *RouteTable) *SLOTracker {
+	tracker := &SLOTracker{
+		BurnThreshold: DefaultBurnThreshold,
+		table:         table,
+		windows:       make(map[string]*sloWindow, len(slos)),
+		now:           time.Now,
+	}
+	for route, slo := range slos {
+		if slo.Window < sloBuckets*time.Millisecond {
+			slo.Window = time.Hour
+		}
+		tracker.windows[route] = &sloWindow{slo: slo, notified: make(map[string]time.Time)}
+	}
+	return tracker
+}
+
+// route returns the route of the command
+func (tracker *SLOTracker) route(command string) string {
+	if tracker.table == nil {
+		return command
+	}
+	if _, group := tracker.table.Routes().Policy(command); len(group) > 0 {
+		return group
+	}
+	return command
+}
+
+// bucketSize returns the duration of the bucket
+func (window *sloWindow) bucketSize() time.Duration {
+	return window.slo.Window / sloBuckets
+}
+
+// status sums the buckets within the window
+func (window *sloWindow) status(now time.Time) SLOStatus {
+	var total, failed, slow uint64
+	for _, bucket := range window.buckets {
+		if now.Sub(bucket.start) >= window.slo.Window {
+			continue
+		}
+		total += bucket.total
+		failed += bucket.failed
+		slow += bucket.slow
+	}
+
+	status := SLOStatus{Requests: total}
+	if total == 0 {
+		return status
+	}
+	if window.slo.Latency > 0 {
+		// p99 allows one percent of the requests to be slower than the target
+		status.LatencyBurnRate = float64(slow) / float64(total) / 0.01
+	}
+	if window.slo.ErrorBudget > 0 {
+		status.ErrorBurnRate = float64(failed) / float64(total) / window.slo.ErrorBudget
+	}
+	return status
+}
+
+// Record the reply of the command with its latency
+func (tracker *SLOTracker) Record(command string, latency time.Duration, failed bool) {
+	route := tracker.route(command)
+	now := tracker.now()
+
+	tracker.mu.Lock()
+	window, ok := tracker.windows[route]
+	if !ok {
+		tracker.mu.Unlock()
+		return
+	}
+
+	size := window.bucketSize()
+	start := now.Truncate(size)
+	bucket := &window.buckets[(start.UnixNano()/int64(size))%sloBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = sloBucket{start: start}
+	}
+	bucket.total++
+	if failed {
+		bucket.failed++
+	}
+	if window.slo.Latency > 0 && latency > window.slo.Latency {
+		bucket.slow++
+	}
+
+	status := window.status(now)
+	var events []SLOEvent
+	for kind, rate := range map[string]float64{LatencyBurn: status.LatencyBurnRate, ErrorBurn: status.ErrorBurnRate} {
+		if rate < tracker.BurnThreshold || now.Sub(window.notified[kind]) < size {
+			continue
+		}
+		window.notified[kind] = now
+		events = append(events, SLOEvent{Route: route, Kind: kind, BurnRate: rate})
+	}
+	tracker.mu.Unlock()
+
+	if tracker.OnEvent != nil {
+		for _, event := range events {
+			tracker.OnEvent(event)
+		}
+	}
+}
+
+// Status returns the burn rates of the routes with the objectives
+func (tracker *SLOTracker) Status() map[string]SLOStatus {
+	now := tracker.now()
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	statuses := make(map[string]SLOStatus, len(tracker.windows))
+	for route, window := range tracker.windows {
+		statuses[route] = window.status(now)
+	}
+	return statuses
+}
+
+// Middleware records the latency and the status of the replies
+func (tracker *SLOTracker) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			command := req.Command
+			start := tracker.now()
+			reply := next(req)
+			tracker.Record(command, tracker.now().Sub(start), reply == nil || !reply.IsOK())
+			return reply
+		}
+	}
+}
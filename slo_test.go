@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSLOTrackerBurnRates checks the burn rates of the route, and the events when the budget is at risk
+func TestSLOTrackerBurnRates(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewSLOTracker(map[string]SLO{
+		"private": {Latency: 100 * time.Millisecond, ErrorBudget: 0.1, Window: time.Minute},
+	}, policyTable(t, RoutePolicy{}, "users.get", "users.list"))
+	tracker.now = func() time.Time { return now }
+	var events []SLOEvent
+	tracker.OnEvent = func(event SLOEvent) { events = append(events, event) }
+
+	failed := false
+	handler := Wrap(func(req *Envelope) *Reply {
+		if req.Command == "users.list" {
+			return nil
+		}
+		if failed {
+			return Fail("destination is down")
+		}
+		return Ok(nil)
+	}, tracker.Middleware())
+
+	for i := 0; i < 9; i++ {
+		handler(policyRequest("users.get", nil))
+	}
+	// the missing reply is the failure
+	handler(policyRequest("users.list", nil))
+	// the commands without the objective are not tracked
+	handler(policyRequest("ping", nil))
+
+	status := tracker.Status()["private"]
+	if status.Requests != 10 || status.ErrorBurnRate != 1 || status.LatencyBurnRate != 0 {
+		t.Fatalf("the status is %+v", status)
+	}
+	if len(events) != 0 {
+		t.Fatalf("emitted %+v within the budget", events)
+	}
+
+	failed = true
+	handler(policyRequest("users.get", nil))
+	handler(policyRequest("users.get", nil))
+	if len(events) != 1 || events[0].Route != "private" || events[0].Kind != ErrorBurn {
+		t.Fatalf("the events are %+v", events)
+	}
+
+	tracker.Record("users.get", time.Second, false)
+	if len(events) != 2 || events[1].Kind != LatencyBurn {
+		t.Fatalf("the slow request emitted %+v", events)
+	}
+
+	// the requests out of the window are forgotten
+	now = now.Add(2 * time.Minute)
+	if status := tracker.Status()["private"]; status.Requests != 0 {
+		t.Fatalf("the status after the window is %+v", status)
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// StateDir is the directory in the data path where the snapshots are stored
+const StateDir = "state"
+
+// Snapshotter is the component with the runtime state that survives the restart
+type Snapshotter interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// StateStore saves the snapshots of the components in the data path,
+// and restores them on the start.
+// Each component has its own file named after the component.
+type StateStore struct {
+	dir        string
+	mu         sync.Mutex
+	components map[string]Snapshotter
+}
+
+// NewStateStore returns the store in the data path
+func NewStateStore(dataPath string) *StateStore {
+	return &StateStore{
+		dir:        filepath.Join(dataPath, StateDir),
+		components: make(map[string]Snapshotter),
+	}
+}
+
+// Register the component by its unique name
+func (store *StateStore) Register(name string, component Snapshotter) error {
+	if len(name) == 0 || filepath.Base(name) != name {
+		return fmt.Errorf("invalid component name '%s'", name)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.components[name]; ok {
+		return fmt.Errorf("component '%s' already registered", name)
+	}
+	store.components[name] = component
+	return nil
+}
+
+// names returns the sorted names of the components
+func (store *StateStore) names() []string {
+	names := make([]string, 0, len(store.components))
+	for name := range store.components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Snapshot saves the state of all components
+func (store *StateStore) Snapshot() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, name := range store.names() {
+		data, err := store.components[name].Snapshot()
+		if err != nil {
+			return fmt.Errorf("component '%s' Snapshot: %w", name, err)
+		}
+		if err := writeFile(filepath.Join(store.dir, name+".json"), data); err != nil {
+			return fmt.Errorf("component '%s' writeFile: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Restore the state of the components that have the snapshot.
+// The components without the snapshot are skipped.
+func (store *StateStore) Restore() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, name := range store.names() {
+		path := filepath.Join(store.dir, name+".json")
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("os.ReadFile('%s'): %w", path, err)
+		}
+		if err := store.components[name].Restore(data); err != nil {
+			return fmt.Errorf("component '%s' Restore: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestStateStoreRoundTrip checks that the state of the components survives the restart
+func TestStateStoreRoundTrip(t *testing.T) {
+	dataPath := t.TempDir()
+	journal := NewJournal(0)
+	id := journal.Append(policyRequest("payment", map[string]interface{}{"amount": float64(10)}))
+
+	store := NewStateStore(dataPath)
+	if err := store.Register("journal", journal); err != nil {
+		t.Fatalf("store.Register: %v", err)
+	}
+	for _, name := range []string{"journal", "", "../journal"} {
+		if err := store.Register(name, journal); err == nil {
+			t.Fatalf("registered '%s' component", name)
+		}
+	}
+	if err := store.Snapshot(); err != nil {
+		t.Fatalf("store.Snapshot: %v", err)
+	}
+
+	restarted := NewStateStore(dataPath)
+	restored := NewJournal(0)
+	missing := NewJournal(0)
+	if err := restarted.Register("journal", restored); err != nil {
+		t.Fatalf("restarted.Register: %v", err)
+	}
+	if err := restarted.Register("queue", missing); err != nil {
+		t.Fatalf("restarted.Register: %v", err)
+	}
+	if err := restarted.Restore(); err != nil {
+		t.Fatalf("restarted.Restore: %v", err)
+	}
+	if restored.Len() != 1 || restored.Ack(id) != 1 || missing.Len() != 0 {
+		t.Fatalf("the state is not restored")
+	}
+}
+
+// TestStateStoreRestoreFails checks that the corrupted snapshot fails the restore
+func TestStateStoreRestoreFails(t *testing.T) {
+	dataPath := t.TempDir()
+	store := NewStateStore(dataPath)
+	if err := store.Register("journal", NewJournal(0)); err != nil {
+		t.Fatalf("store.Register: %v", err)
+	}
+	if err := writeFile(filepath.Join(store.dir, "journal.json"), []byte("not json")); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+	if err := store.Restore(); err == nil {
+		t.Fatalf("restored the corrupted snapshot")
+	}
+}
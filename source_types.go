@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SourceConfig is the source of the proxy defined in the configuration
+type SourceConfig struct {
+	Type     string                 `json:"type" yaml:"type"`
+	Settings map[string]interface{} `json:"settings,omitempty" yaml:"settings,omitempty"`
+}
+
+// SourceFactory creates the source transport from the configuration settings
+type SourceFactory func(settings map[string]interface{}) (SourceTransport, error)
+
+var sourceTypes = struct {
+	sync.RWMutex
+	factories map[string]SourceFactory
+}{factories: map[string]SourceFactory{
+	"http":      httpSourceFactory,
+	"websocket": webSocketSourceFactory,
+	"tcp":       tcpSourceFactory,
+}}
+
+// RegisterSourceType adds the source type, so it could be used in the configuration
+func RegisterSourceType(name string, factory SourceFactory) error {
+	if len(name) == 0 {
+		return fmt.Errorf("empty source type")
+	}
+
+	sourceTypes.Lock()
+	defer sourceTypes.Unlock()
+
+	if _, ok := sourceTypes.factories[name]; ok {
+		return fmt.Errorf("source type '%s' already registered", name)
+	}
+	sourceTypes.factories[name] = factory
+	return nil
+}
+
+// RegisterSourceFactory adds the source type contributed by the external package.
+// It's the same as RegisterSourceType, and the source is created from the configuration by NewSource.
+func RegisterSourceFactory(name string, factory SourceFactory) error {
+	return RegisterSourceType(name, factory)
+}
+
+// RegisteredSourceTypes returns the sorted names of the source types
+func RegisteredSourceTypes() []string {
+	sourceTypes.RLock()
+	names := make([]string, 0, len(sourceTypes.factories))
+	for name := range sourceTypes.factories {
+		names = append(names, name)
+	}
+	sourceTypes.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// ValidateSource returns an error if the source type is not registered
+func ValidateSource(config SourceConfig) error {
+	sourceTypes.RLock()
+	defer sourceTypes.RUnlock()
+
+	if _, ok := sourceTypes.factories[config.Type]; !ok {
+		return fmt.Errorf("source type '%s' not registered", config.Type)
+	}
+	return nil
+}
+
+// NewSource creates the source transport of the registered type
+func NewSource(config SourceConfig) (SourceTransport, error) {
+	sourceTypes.RLock()
+	factory, ok := sourceTypes.factories[config.Type]
+	sourceTypes.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("source type '%s' not registered", config.Type)
+	}
+
+	source, err := factory(config.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("source type '%s': %w", config.Type, err)
+	}
+	return source, nil
+}
+
+// httpSourceFactory expects the settings of HTTPSourceConfig
+func httpSourceFactory(settings map[string]interface{}) (SourceTransport, error) {
+	var config HTTPSourceConfig
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	return NewHTTPSource(config)
+}
+
+// webSocketSourceFactory expects the settings of WebSocketSourceConfig.
+// The source created from the configuration has no subscriber.
+func webSocketSourceFactory(settings map[string]interface{}) (SourceTransport, error) {
+	var config WebSocketSourceConfig
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	return NewWebSocketSource(config, nil)
+}
+
+// tcpSourceFactory expects the settings of TCPSourceConfig
+func tcpSourceFactory(settings map[string]interface{}) (SourceTransport, error) {
+	var config TCPSourceConfig
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	return NewTCPSource(config)
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// stubSource is the source that serves nothing
+type stubSource struct {
+	name string
+}
+
+func (source *stubSource) Serve(ctx context.Context, handler Handler) error {
+	<-ctx.Done()
+	return nil
+}
+
+// TestSourceTypes checks the built-in source types and the contributed ones
+func TestSourceTypes(t *testing.T) {
+	source, err := NewSource(SourceConfig{Type: "tcp", Settings: map[string]interface{}{"port": 6000}})
+	if err != nil {
+		t.Fatalf("NewSource: %v", err)
+	}
+	if tcp, ok := source.(*TCPSource); !ok || tcp.config.Port != 6000 {
+		t.Fatalf("the tcp source is %#v", source)
+	}
+	for _, settings := range []map[string]interface{}{{}, {"port": "6000"}} {
+		if _, err := NewSource(SourceConfig{Type: "tcp", Settings: settings}); err == nil {
+			t.Fatalf("the tcp source with %v is created", settings)
+		}
+	}
+
+	factory := func(settings map[string]interface{}) (SourceTransport, error) {
+		return &stubSource{name: settings["name"].(string)}, nil
+	}
+	if err := RegisterSourceFactory("test.stub", factory); err != nil {
+		t.Fatalf("RegisterSourceFactory: %v", err)
+	}
+	for _, name := range []string{"test.stub", "http", ""} {
+		if err := RegisterSourceType(name, factory); err == nil {
+			t.Fatalf("registered '%s' source type", name)
+		}
+	}
+	config := SourceConfig{Type: "test.stub", Settings: map[string]interface{}{"name": "stub"}}
+	if err := ValidateSource(config); err != nil {
+		t.Fatalf("ValidateSource: %v", err)
+	}
+	source, err = NewSource(config)
+	if err != nil || !reflect.DeepEqual(source, &stubSource{name: "stub"}) {
+		t.Fatalf("the contributed source is %#v, %v", source, err)
+	}
+
+	registered := RegisteredSourceTypes()
+	for _, name := range []string{"http", "tcp", "test.stub", "websocket"} {
+		found := false
+		for _, other := range registered {
+			found = found || other == name
+		}
+		if !found {
+			t.Fatalf("'%s' is not in %v", name, registered)
+		}
+	}
+	if err := ValidateSource(SourceConfig{Type: "test.missing"}); err == nil {
+		t.Fatalf("the unregistered source type passed")
+	}
+	if _, err := NewSource(SourceConfig{Type: "test.missing"}); err == nil {
+		t.Fatalf("the unregistered source type is created")
+	}
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// StartupMode defines what proxy does when the destination is not reachable on start
+type StartupMode string
+
+const (
+	// WaitDestination waits for the destination before binding the source
+	WaitDestination StartupMode = "wait"
+	// QueueRequests binds the source immediately, and holds the requests until the destination is ready
+	QueueRequests StartupMode = "queue"
+)
+
+// Backoff is the growing delay between the attempts
+type Backoff struct {
+	Initial    time.Duration `json:"initial" yaml:"initial"`
+	Max        time.Duration `json:"max" yaml:"max"`
+	Multiplier float64       `json:"multiplier" yaml:"multiplier"`
+}
+
+// DefaultBackoff starts with 100 milliseconds, doubling it up to 5 seconds
+func DefaultBackoff() Backoff {
+	return Backoff{Initial: 100 * time.Millisecond, Max: 5 * time.Second, Multiplier: 2}
+}
+
+// withDefaults returns the backoff with the unset fields taken from the DefaultBackoff
+func (backoff Backoff) withDefaults() Backoff {
+	defaults := DefaultBackoff()
+	if backoff.Initial <= 0 {
+		backoff.Initial = defaults.Initial
+	}
+	if backoff.Max <= 0 {
+		backoff.Max = defaults.Max
+	}
+	if backoff.Multiplier <= 0 {
+		backoff.Multiplier = defaults.Multiplier
+	}
+	return backoff
+}
+
+// Delay returns the delay before the attempt. The first attempt is 0.
+// The unset fields are taken from the DefaultBackoff, so the partial setting never busy-loops.
+func (backoff Backoff) Delay(attempt int) time.Duration {
+	if attempt <= 0 {
+		return 0
+	}
+	backoff = backoff.withDefaults()
+	delay := float64(backoff.Initial)
+	for i := 1; i < attempt; i++ {
+		delay *= backoff.Multiplier
+		if backoff.Max > 0 && delay >= float64(backoff.Max) {
+			return backoff.Max
+		}
+	}
+	return time.Duration(delay)
+}
+
+// ReachProbe returns nil if the destination is reachable
+type ReachProbe func(ctx context.Context) error
+
+// DialProbe checks that the tcp address accepts the connections
+func DialProbe(address string) ReachProbe {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return fmt.Errorf("dialer.DialContext('%s'): %w", address, err)
+		}
+		return conn.Close()
+	}
+}
+
+// WaitFor calls the probe with the backoff until it succeeds or the context is done.
+// Use the context with timeout to limit the waiting.
+func WaitFor(ctx context.Context, probe ReachProbe, backoff Backoff) error {
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("%w: %v", ctx.Err(), lastErr)
+			}
+			return ctx.Err()
+		case <-time.After(backoff.Delay(attempt)):
+		}
+
+		lastErr = probe(ctx)
+		if lastErr == nil {
+			return nil
+		}
+	}
+}
+
+// ReadyGate holds the requests until the destination is ready.
+// Used in the QueueRequests startup mode.
+type ReadyGate struct {
+	// Timeout of the request waiting for the destination. Zero means no limit
+	Timeout time.Duration
+	once    sync.Once
+	ready   chan struct{}
+}
+
+// NewReadyGate returns the closed gate
+func NewReadyGate(timeout time.Duration) *ReadyGate {
+	return &ReadyGate{Timeout: timeout, ready: make(chan struct{})}
+}
+
+// Ready opens the gate. Call it when the destination is reachable
+func (gate *ReadyGate) Ready() {
+	gate.once.Do(func() {
+		close(gate.ready)
+	})
+}
+
+// IsReady returns true if the gate is open
+func (gate *ReadyGate) IsReady() bool {
+	select {
+	case <-gate.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Middleware blocks the request until the gate is open.
+// If the gate is not opened within the timeout, then the fail reply returned.
+func (gate *ReadyGate) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			if gate.Timeout == 0 {
+				<-gate.ready
+				return next(req)
+			}
+
+			timer := time.NewTimer(gate.Timeout)
+			defer timer.Stop()
+			select {
+			case <-gate.ready:
+				return next(req)
+			case <-timer.C:
+				return Fail("destination is not ready")
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestBackoffDelay checks the growth of the delay up to the max, and the defaults of the unset fields
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}
+	expected := []time.Duration{0, 10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}
+	for attempt, delay := range expected {
+		if backoff.Delay(attempt) != delay {
+			t.Fatalf("attempt %d delay is %s, expected %s", attempt, backoff.Delay(attempt), delay)
+		}
+	}
+	if delay := (Backoff{}).Delay(1); delay != DefaultBackoff().Initial {
+		t.Fatalf("the unset backoff delay is %s", delay)
+	}
+}
+
+// TestWaitFor checks that the probe is retried until the destination is reachable
+func TestWaitFor(t *testing.T) {
+	backoff := Backoff{Initial: time.Millisecond, Max: time.Millisecond}
+	attempts := 0
+	probe := func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+	if err := WaitFor(context.Background(), probe, backoff); err != nil || attempts != 3 {
+		t.Fatalf("WaitFor after %d attempts: %v", attempts, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := WaitFor(ctx, func(ctx context.Context) error { return fmt.Errorf("connection refused") }, backoff)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("the unreachable destination returned %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	address := listener.Addr().String()
+	if err := DialProbe(address)(context.Background()); err != nil {
+		t.Fatalf("DialProbe: %v", err)
+	}
+	_ = listener.Close()
+	if err := DialProbe(address)(context.Background()); err == nil {
+		t.Fatalf("the closed listener is reachable")
+	}
+}
+
+// TestReadyGate checks that the requests wait for the destination, and time out
+func TestReadyGate(t *testing.T) {
+	gate := NewReadyGate(20 * time.Millisecond)
+	handler := Wrap(func(req *Envelope) *Reply { return Ok(nil) }, gate.Middleware())
+
+	if reply := handler(policyRequest("ping", nil)); reply.IsOK() || gate.IsReady() {
+		t.Fatalf("the request passed the closed gate")
+	}
+
+	replies := make(chan *Reply, 1)
+	gate.Timeout = 0
+	go func() {
+		replies <- handler(policyRequest("ping", nil))
+	}()
+	select {
+	case <-replies:
+		t.Fatalf("the request passed the closed gate without the timeout")
+	case <-time.After(10 * time.Millisecond):
+	}
+	gate.Ready()
+	gate.Ready()
+	if reply := <-replies; !reply.IsOK() || !gate.IsReady() {
+		t.Fatalf("the request is not passed after the gate is open")
+	}
+}
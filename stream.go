@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StreamCommand relays the events of the destination topic back to the requester.
+// The request has the 'topic' parameter, and optionally 'messages' and 'seconds' to bound the stream.
+const StreamCommand = "proxy.stream"
+
+// Event is the message published by the destination
+type Event struct {
+	Topic      string                 `json:"topic"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// Subscriber is the destination that publishes the events.
+// The channel is closed when the context is cancelled or the publisher is gone.
+type Subscriber interface {
+	Subscribe(ctx context.Context, topic string) (<-chan Event, error)
+}
+
+// StreamLimits bound the stream. The request can only lower them.
+type StreamLimits struct {
+	MaxMessages int           `json:"max_messages" yaml:"max_messages"`
+	MaxDuration time.Duration `json:"max_duration" yaml:"max_duration"`
+}
+
+// DefaultStreamLimits is used when the limits are not set
+var DefaultStreamLimits = StreamLimits{MaxMessages: 100, MaxDuration: 10 * time.Second}
+
+// Streamer subscribes to the destination topic on the requester's behalf.
+// It collects the events until the limits are reached, then replies with the sequenced frames.
+type Streamer struct {
+	subscriber Subscriber
+	limits     StreamLimits
+}
+
+// NewStreamer returns the streamer of the subscriber's topics
+func NewStreamer(subscriber Subscriber, limits StreamLimits) *Streamer {
+	if limits.MaxMessages <= 0 {
+		limits.MaxMessages = DefaultStreamLimits.MaxMessages
+	}
+	if limits.MaxDuration <= 0 {
+		limits.MaxDuration = DefaultStreamLimits.MaxDuration
+	}
+	return &Streamer{subscriber: subscriber, limits: limits}
+}
+
+// requestLimits returns the limits lowered by the request parameters
+func (streamer *Streamer) requestLimits(req *Envelope) StreamLimits {
+	limits := streamer.limits
+	if messages, ok := req.Parameters["messages"].(float64); ok && messages > 0 && int(messages) < limits.MaxMessages {
+		limits.MaxMessages = int(messages)
+	}
+	if seconds, ok := req.Parameters["seconds"].(float64); ok && seconds > 0 {
+		duration := time.Duration(seconds * float64(time.Second))
+		if duration < limits.MaxDuration {
+			limits.MaxDuration = duration
+		}
+	}
+	return limits
+}
+
+// Handler replies to the StreamCommand.
+// Each frame has the 'seq' starting from 1, and the event parameters.
+// The 'complete' parameter is true if the stream ended by the messages limit.
+func (streamer *Streamer) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		topic := req.StringParam("topic")
+		if len(topic) == 0 {
+			return Fail("missing 'topic' parameter")
+		}
+		limits := streamer.requestLimits(req)
+
+		ctx, cancel := context.WithTimeout(context.Background(), limits.MaxDuration)
+		defer cancel()
+
+		events, err := streamer.subscriber.Subscribe(ctx, topic)
+		if err != nil {
+			return Fail(fmt.Sprintf("subscriber.Subscribe('%s'): %v", topic, err))
+		}
+
+		frames := make([]interface{}, 0, limits.MaxMessages)
+		for len(frames) < limits.MaxMessages {
+			select {
+			case <-ctx.Done():
+				return streamReply(topic, frames, false)
+			case event, ok := <-events:
+				if !ok {
+					return streamReply(topic, frames, false)
+				}
+				frames = append(frames, map[string]interface{}{
+					"seq":        len(frames) + 1,
+					"parameters": event.Parameters,
+				})
+			}
+		}
+		return streamReply(topic, frames, true)
+	}
+}
+
+func streamReply(topic string, frames []interface{}, complete bool) *Reply {
+	return Ok(map[string]interface{}{
+		"topic":    topic,
+		"frames":   frames,
+		"complete": complete,
+	})
+}
+
+// MemoryPublisher delivers the events to the subscribers within the same process.
+// The events are dropped for the subscribers that don't keep up.
+type MemoryPublisher struct {
+	mu          sync.Mutex
+	bufferSize  int
+	subscribers map[string]map[chan Event]struct{}
+	resources   *Resources
+}
+
+// NewMemoryPublisher returns the publisher with the given buffer per subscriber
+func NewMemoryPublisher(bufferSize int) *MemoryPublisher {
+	return &MemoryPublisher{
+		bufferSize:  bufferSize,
+		subscribers: make(map[string]map[chan Event]struct{}),
+	}
+}
+
+// WithResources tracks the goroutines of the subscriptions
+func (publisher *MemoryPublisher) WithResources(resources *Resources) *MemoryPublisher {
+	publisher.resources = resources
+	return publisher
+}
+
+// Subscribe to the topic until the context is cancelled
+func (publisher *MemoryPublisher) Subscribe(ctx context.Context, topic string) (<-chan Event, error) {
+	events := make(chan Event, publisher.bufferSize)
+
+	publisher.mu.Lock()
+	if publisher.subscribers[topic] == nil {
+		publisher.subscribers[topic] = make(map[chan Event]struct{})
+	}
+	publisher.subscribers[topic][events] = struct{}{}
+	publisher.mu.Unlock()
+
+	publisher.resources.Go("publisher", "subscription "+topic, 0, func() {
+		<-ctx.Done()
+		publisher.mu.Lock()
+		delete(publisher.subscribers[topic], events)
+		if len(publisher.subscribers[topic]) == 0 {
+			delete(publisher.subscribers, topic)
+		}
+		close(events)
+		publisher.mu.Unlock()
+	})
+
+	return events, nil
+}
+
+// Publish the event to the subscribers of the topic. Returns the number of the receivers
+func (publisher *MemoryPublisher) Publish(topic string, parameters map[string]interface{}) int {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+
+	received := 0
+	for events := range publisher.subscribers[topic] {
+		select {
+		case events <- Event{Topic: topic, Parameters: parameters}:
+			received++
+		default:
+		}
+	}
+	return received
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// TestStreamerFrames checks that the stream ends by the limits of the request
+func TestStreamerFrames(t *testing.T) {
+	publisher := NewMemoryPublisher(8)
+	streamer := NewStreamer(publisher, StreamLimits{MaxMessages: 10, MaxDuration: time.Second})
+	handler := streamer.Handler()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// the streamer subscribes on the request
+		for sent := 0; sent < 2; {
+			sent += publisher.Publish("prices", map[string]interface{}{"btc": sent})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	reply := handler(policyRequest(StreamCommand, map[string]interface{}{"topic": "prices", "messages": float64(2)}))
+	<-done
+	frames, _ := reply.Parameters["frames"].([]interface{})
+	if !reply.IsOK() || reply.Parameters["complete"] != true || len(frames) != 2 {
+		t.Fatalf("the stream replied %v", reply)
+	}
+	first := frames[0].(map[string]interface{})
+	second := frames[1].(map[string]interface{})
+	if first["seq"] != 1 || second["seq"] != 2 || first["parameters"].(map[string]interface{})["btc"] != 0 {
+		t.Fatalf("the frames are %v", frames)
+	}
+
+	start := time.Now()
+	reply = handler(policyRequest(StreamCommand, map[string]interface{}{"topic": "quiet", "seconds": 0.02}))
+	frames, _ = reply.Parameters["frames"].([]interface{})
+	if !reply.IsOK() || reply.Parameters["complete"] != false || len(frames) != 0 {
+		t.Fatalf("the quiet stream replied %v", reply)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("the stream ignored the requested duration")
+	}
+
+	if reply := handler(policyRequest(StreamCommand, nil)); reply.IsOK() {
+		t.Fatalf("the stream without the topic succeeded")
+	}
+}
+
+// TestStreamerLimits checks that the request can only lower the limits
+func TestStreamerLimits(t *testing.T) {
+	streamer := NewStreamer(nil, StreamLimits{})
+	if streamer.limits != DefaultStreamLimits {
+		t.Fatalf("the unset limits are %+v", streamer.limits)
+	}
+	limits := streamer.requestLimits(policyRequest(StreamCommand, map[string]interface{}{"messages": float64(1000), "seconds": float64(60)}))
+	if limits != DefaultStreamLimits {
+		t.Fatalf("the request raised the limits to %+v", limits)
+	}
+	limits = streamer.requestLimits(policyRequest(StreamCommand, map[string]interface{}{"messages": float64(5), "seconds": 0.5}))
+	if limits.MaxMessages != 5 || limits.MaxDuration != 500*time.Millisecond {
+		t.Fatalf("the request didn't lower the limits: %+v", limits)
+	}
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"fmt"
+)
+
+// TenantParam is the default request parameter that has the tenant id
+const TenantParam = "tenant_id"
+
+// TenantConfig is the routing and quota of the tenant
+type TenantConfig struct {
+	// Destination of the tenant. If it's empty, then the destination is derived from the prefix
+	Destination string `json:"destination,omitempty" yaml:"destination,omitempty"`
+	// DailyQuota is the amount of requests per day. Zero means no limit
+	DailyQuota uint64 `json:"daily_quota,omitempty" yaml:"daily_quota,omitempty"`
+	// MonthlyQuota is the amount of requests per month. Zero means no limit
+	MonthlyQuota uint64 `json:"monthly_quota,omitempty" yaml:"monthly_quota,omitempty"`
+	// RateLimit is the amount of requests per second. Zero means no limit
+	RateLimit float64 `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	// AllowedCommands of the tenant. If it's empty, then all commands are allowed
+	AllowedCommands []string `json:"allowed_commands,omitempty" yaml:"allowed_commands,omitempty"`
+}
+
+// Merge returns the configuration where the non-empty fields of the overlay replace the fields
+func (config TenantConfig) Merge(overlay TenantConfig) TenantConfig {
+	if len(overlay.Destination) > 0 {
+		config.Destination = overlay.Destination
+	}
+	if overlay.DailyQuota > 0 {
+		config.DailyQuota = overlay.DailyQuota
+	}
+	if overlay.MonthlyQuota > 0 {
+		config.MonthlyQuota = overlay.MonthlyQuota
+	}
+	if overlay.RateLimit > 0 {
+		config.RateLimit = overlay.RateLimit
+	}
+	if len(overlay.AllowedCommands) > 0 {
+		config.AllowedCommands = overlay.AllowedCommands
+	}
+	return config
+}
+
+// Allowed returns true if the tenant is allowed to call the command
+func (config TenantConfig) Allowed(command string) bool {
+	if len(config.AllowedCommands) == 0 {
+		return true
+	}
+	for _, allowed := range config.AllowedCommands {
+		if allowed == command {
+			return true
+		}
+	}
+	return false
+}
+
+// Tenants routes the requests of a multi-tenant platform.
+// The tenant is the authenticated principal, so put the tenants after WithAuth.
+// Pass the tenants to the Router, so the requests go to the tenant's destination.
+type Tenants struct {
+	// Key is the request parameter with the tenant id of the unauthenticated requests.
+	// It's read only if TrustParameter is true
+	Key string
+	// TrustParameter reads the tenant id from the Key parameter when the request has no principal.
+	// The client could claim any tenant, so enable it only behind the trusted clients
+	TrustParameter bool
+	// DestinationPrefix is prepended to the tenant id for the tenants without explicit destination
+	DestinationPrefix string
+	// Base is the configuration of all tenants
+	Base     TenantConfig
+	tenants  map[string]TenantConfig
+	overlays *TenantOverlays
+	usage    *UsageTracker
+}
+
+// NewTenants returns the tenant router.
+// The usage tracker counts the requests per tenant to enforce the quotas.
+// If the usage is nil, then the quotas are not enforced.
+func NewTenants(tenants map[string]TenantConfig, usage *UsageTracker) *Tenants {
+	if tenants == nil {
+		tenants = make(map[string]TenantConfig)
+	}
+	return &Tenants{
+		Key:     TenantParam,
+		tenants: tenants,
+		usage:   usage,
+	}
+}
+
+// Tenant returns the tenant id of the request, which is its principal
+func (tenants *Tenants) Tenant(req *Envelope) (string, error) {
+	if len(req.Principal) > 0 {
+		return req.Principal, nil
+	}
+	if !tenants.TrustParameter {
+		return "", fmt.Errorf("unauthenticated request has no tenant")
+	}
+	tenant := req.StringParam(tenants.Key)
+	if len(tenant) == 0 {
+		return "", fmt.Errorf("missing '%s' parameter", tenants.Key)
+	}
+	return tenant, nil
+}
+
+// Label returns the tenant of the request for the metrics.
+// The clients could claim any tenant, so the tenants without the configuration are the OtherTenant.
+// The request without a tenant has the empty label.
+func (tenants *Tenants) Label(req *Envelope) string {
+	tenant, err := tenants.Tenant(req)
+	if err != nil {
+		return ""
+	}
+	if _, ok := tenants.tenants[tenant]; !ok {
+		return OtherTenant
+	}
+	return tenant
+}
+
+// SetOverlays sets the per-tenant overrides loaded from the files
+func (tenants *Tenants) SetOverlays(overlays *TenantOverlays) {
+	tenants.overlays = overlays
+}
+
+// Config returns the configuration of the tenant.
+// The configuration is layered: base, then the tenant's configuration, then the overlay.
+func (tenants *Tenants) Config(tenant string) (TenantConfig, error) {
+	config := tenants.Base.Merge(tenants.tenants[tenant])
+	if tenants.overlays == nil {
+		return config, nil
+	}
+
+	overlay, err := tenants.overlays.Overlay(tenant)
+	if err != nil {
+		return config, fmt.Errorf("overlays.Overlay: %w", err)
+	}
+	return config.Merge(overlay), nil
+}
+
+// Destination returns the destination of the tenant
+func (tenants *Tenants) Destination(tenant string) (string, error) {
+	config, err := tenants.Config(tenant)
+	if err != nil {
+		return "", fmt.Errorf("tenants.Config: %w", err)
+	}
+	if len(config.Destination) > 0 {
+		return config.Destination, nil
+	}
+	return tenants.DestinationPrefix + tenant, nil
+}
+
+// Allow returns an error if the tenant is not allowed to call the command or exceeded the quota
+func (tenants *Tenants) Allow(tenant string, command string) error {
+	config, err := tenants.Config(tenant)
+	if err != nil {
+		return fmt.Errorf("tenants.Config: %w", err)
+	}
+	if !config.Allowed(command) {
+		return fmt.Errorf("tenant '%s' is not allowed to call '%s'", tenant, command)
+	}
+	if tenants.usage == nil {
+		return nil
+	}
+
+	if config.DailyQuota > 0 && tenants.usage.Today(tenant).Requests >= config.DailyQuota {
+		return fmt.Errorf("tenant '%s' exceeded daily quota of %d requests", tenant, config.DailyQuota)
+	}
+	if config.MonthlyQuota > 0 && tenants.usage.ThisMonth(tenant).Requests >= config.MonthlyQuota {
+		return fmt.Errorf("tenant '%s' exceeded monthly quota of %d requests", tenant, config.MonthlyQuota)
+	}
+	return nil
+}
+
+// Middleware rejects the requests without a tenant or over the quota.
+// The accepted requests are counted in the usage tracker.
+func (tenants *Tenants) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			tenant, err := tenants.Tenant(req)
+			if err != nil {
+				return Fail(fmt.Sprintf("tenants.Tenant: %v", err))
+			}
+			config, err := tenants.Config(tenant)
+			if err != nil {
+				return Fail(fmt.Sprintf("tenants.Config: %v", err))
+			}
+			if !config.Allowed(req.Command) {
+				return Fail(fmt.Sprintf("tenant '%s' is not allowed to call '%s'", tenant, req.Command))
+			}
+			if tenants.usage != nil {
+				if err := tenants.usage.RecordWithin(tenant, req.Size(), config.DailyQuota, config.MonthlyQuota); err != nil {
+					return Fail(fmt.Sprintf("usage.RecordWithin: %v", err))
+				}
+			}
+			return next(req)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultOverlayInterval is how often the overlay file is checked for changes
+const DefaultOverlayInterval = 10 * time.Second
+
+// TenantOverlays loads the per-tenant configuration from the directory.
+// Each tenant has its own '<tenant id>.json' file.
+// The files are loaded on the first request of the tenant,
+// and reloaded when the file changes.
+// Only the loaded files are cached, so the tenant ids of the requests can't grow the cache.
+type TenantOverlays struct {
+	dir      string
+	Interval time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*tenantOverlay
+	now   func() time.Time
+}
+
+type tenantOverlay struct {
+	config  TenantConfig
+	modTime time.Time
+	checked time.Time
+}
+
+// NewTenantOverlays returns the overlays stored in the directory
+func NewTenantOverlays(dir string) *TenantOverlays {
+	return &TenantOverlays{
+		dir:      dir,
+		Interval: DefaultOverlayInterval,
+		cache:    make(map[string]*tenantOverlay),
+		now:      time.Now,
+	}
+}
+
+// Overlay returns the configuration of the tenant in the directory.
+// If the tenant has no file, then an empty configuration returned.
+func (overlays *TenantOverlays) Overlay(tenant string) (TenantConfig, error) {
+	if len(tenant) == 0 || strings.ContainsAny(tenant, `/\`) || strings.Contains(tenant, "..") {
+		return TenantConfig{}, fmt.Errorf("invalid tenant id '%s'", tenant)
+	}
+
+	overlays.mu.Lock()
+	defer overlays.mu.Unlock()
+
+	now := overlays.now()
+	cached, ok := overlays.cache[tenant]
+	if ok && now.Sub(cached.checked) < overlays.Interval {
+		return cached.config, nil
+	}
+
+	path := filepath.Join(overlays.dir, tenant+".json")
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		delete(overlays.cache, tenant)
+		return TenantConfig{}, nil
+	}
+	if err != nil {
+		return TenantConfig{}, fmt.Errorf("os.Stat('%s'): %w", path, err)
+	}
+	if ok && info.ModTime().Equal(cached.modTime) {
+		cached.checked = now
+		return cached.config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return TenantConfig{}, fmt.Errorf("os.ReadFile('%s'): %w", path, err)
+	}
+	var config TenantConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return TenantConfig{}, fmt.Errorf("json.Unmarshal('%s'): %w", path, err)
+	}
+
+	overlays.cache[tenant] = &tenantOverlay{config: config, modTime: info.ModTime(), checked: now}
+	return config, nil
+}
+
+// Reload drops the cached overlays, so they are read from the files on the next request
+func (overlays *TenantOverlays) Reload() {
+	overlays.mu.Lock()
+	overlays.cache = make(map[string]*tenantOverlay)
+	overlays.mu.Unlock()
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTenantOverlaysSkipMisses checks that the tenants without the file are not cached,
+// and the file created later is loaded on the next request
+func TestTenantOverlaysSkipMisses(t *testing.T) {
+	dir := t.TempDir()
+	overlays := NewTenantOverlays(dir)
+	for i := 0; i < 100; i++ {
+		if _, err := overlays.Overlay(fmt.Sprintf("tenant-%d", i)); err != nil {
+			t.Fatalf("Overlay: %v", err)
+		}
+	}
+	if len(overlays.cache) != 0 {
+		t.Fatalf("cached %d tenants without the files", len(overlays.cache))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "tenant-1.json"), []byte(`{"rate_limit": 5}`), 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	config, err := overlays.Overlay("tenant-1")
+	if err != nil {
+		t.Fatalf("Overlay: %v", err)
+	}
+	if config.RateLimit != 5 || len(overlays.cache) != 1 {
+		t.Fatalf("the new file was not loaded: %+v", config)
+	}
+	if _, err := overlays.Overlay("../tenant-1"); err == nil {
+		t.Fatalf("the tenant id out of the directory was accepted")
+	}
+}
+
+// TestRateLimiterTenantOverlay checks that the limiter applies the rate of the tenant's overlay
+func TestRateLimiterTenantOverlay(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "alice.json"), []byte(`{"rate_limit": 2}`), 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	tenants := NewTenants(nil, nil)
+	tenants.SetOverlays(NewTenantOverlays(dir))
+	limiter := NewRateLimiter(RateLimitConfig{}).WithTenants(tenants)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	allowed := func(principal string) int {
+		count := 0
+		for i := 0; i < 10; i++ {
+			req := NewEnvelope(&Request{Command: "work"})
+			req.Principal = principal
+			if exceeded, _ := limiter.Allow(req); len(exceeded) == 0 {
+				count++
+			}
+		}
+		return count
+	}
+	if count := allowed("alice"); count != 2 {
+		t.Fatalf("alice passed %d requests over the overlay rate of 2", count)
+	}
+	if count := allowed("bob"); count != 10 {
+		t.Fatalf("bob without the overlay passed %d of 10 requests", count)
+	}
+}
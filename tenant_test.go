@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tenantRequest returns the envelope of the tenant's command
+func tenantRequest(tenant string, command string) *Envelope {
+	req := NewEnvelope(&Request{Command: command, Parameters: map[string]interface{}{}})
+	req.Principal = tenant
+	return req
+}
+
+// TestTenantsRouteAndQuota checks that the tenant's requests go to its destination within its quota
+func TestTenantsRouteAndQuota(t *testing.T) {
+	usage, err := NewUsageTracker(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewUsageTracker: %v", err)
+	}
+	tenants := NewTenants(map[string]TenantConfig{
+		"acme":   {Destination: "acme-db", DailyQuota: 2, AllowedCommands: []string{"get"}},
+		"globex": {},
+	}, usage)
+	tenants.DestinationPrefix = "tenant-"
+
+	destinations := map[string]DestinationTransport{}
+	for _, name := range []string{"shared", "acme-db", "tenant-globex"} {
+		name := name
+		destinations[name] = NewHandlerDestination(func(*Envelope) *Reply {
+			return Ok(map[string]interface{}{"destination": name})
+		})
+	}
+	router, err := NewRouter(destinations, nil, nil, "shared")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	router.SetTenants(tenants)
+	handler := Wrap(router.Handler(), tenants.Middleware())
+
+	for tenant, destination := range map[string]string{"acme": "acme-db", "globex": "tenant-globex"} {
+		reply := handler(tenantRequest(tenant, "get"))
+		if !reply.IsOK() || reply.Parameters["destination"] != destination {
+			t.Fatalf("the request of '%s' replied %v", tenant, reply)
+		}
+	}
+	if reply := handler(tenantRequest("acme", "put")); reply.IsOK() {
+		t.Fatalf("the tenant called the command it's not allowed to")
+	}
+	if reply := handler(tenantRequest("acme", "get")); !reply.IsOK() {
+		t.Fatalf("the request within the quota failed: %s", reply.Message)
+	}
+	if reply := handler(tenantRequest("acme", "get")); reply.IsOK() {
+		t.Fatalf("the request over the daily quota passed")
+	}
+	if reply := handler(tenantRequest("", "get")); reply.IsOK() {
+		t.Fatalf("the request without the tenant passed")
+	}
+
+	// the unknown tenant's destination is not registered, so it never reaches the shared one
+	if reply := handler(tenantRequest("initech", "get")); reply.IsOK() {
+		t.Fatalf("the unknown tenant reached the destination %v", reply.Parameters["destination"])
+	}
+}
+
+// TestTenantsLabelMetricsAndCapture checks that the metrics and the captured records have the tenant
+func TestTenantsLabelMetricsAndCapture(t *testing.T) {
+	tenants := NewTenants(map[string]TenantConfig{"acme": {}}, nil)
+	metrics := NewMetrics().WithTenants(tenants)
+	capture := NewCapture(t.TempDir(), nil).WithTenants(tenants)
+	path, err := capture.Start(time.Minute)
+	if err != nil {
+		t.Fatalf("capture.Start: %v", err)
+	}
+	handler := Wrap(func(*Envelope) *Reply { return Ok(nil) }, metrics.Middleware(), capture.Middleware())
+
+	handler(tenantRequest("acme", "get"))
+	handler(tenantRequest("mallory", "get"))
+	if err := capture.Stop(); err != nil {
+		t.Fatalf("capture.Stop: %v", err)
+	}
+
+	var out bytes.Buffer
+	if _, err := metrics.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	for _, series := range []string{
+		`proxy_requests_total{command="get",status="OK",tenant="acme"} 1`,
+		`proxy_requests_total{command="get",status="OK",tenant="other"} 1`,
+	} {
+		if !strings.Contains(out.String(), series) {
+			t.Fatalf("no '%s' series in:\n%s", series, out.String())
+		}
+	}
+
+	reader, err := OpenCapture(path)
+	if err != nil {
+		t.Fatalf("OpenCapture: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+	for _, tenant := range []string{"acme", "mallory"} {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatalf("reader.Next: %v", err)
+		}
+		if record.Tenant != tenant {
+			t.Fatalf("the record has the tenant '%s', expected '%s'", record.Tenant, tenant)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+)
+
+// RequestTimeout fails the requests that the destination doesn't reply to in time.
+// The timeout of the command comes from its route policy, otherwise the global timeout is used.
+//
+// The timeout is set as the time to live of the envelope, so Forward cancels the call to the destination
+// and frees the slot in flight. The request that already has the shorter time to live keeps it.
+type RequestTimeout struct {
+	global time.Duration
+	table  *RouteTable
+}
+
+// NewRequestTimeout returns the timeout. The table is optional, zero global timeout means no limit
+func NewRequestTimeout(global time.Duration, table *RouteTable) *RequestTimeout {
+	return &RequestTimeout{global: global, table: table}
+}
+
+// Timeout returns the timeout of the command
+func (timeout *RequestTimeout) Timeout(command string) time.Duration {
+	if timeout.table != nil {
+		if policy, _ := timeout.table.Routes().Policy(command); policy.Timeout > 0 {
+			return time.Duration(policy.Timeout) * time.Millisecond
+		}
+	}
+	return timeout.global
+}
+
+// Middleware limits the time of the next handler
+func (timeout *RequestTimeout) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			limit := timeout.Timeout(req.Command)
+			if limit <= 0 {
+				return next(req)
+			}
+
+			now := time.Now()
+			limited := *req
+			deadline := now.Add(limit)
+			if limited.Timestamp == 0 || limited.Ttl == 0 || time.UnixMilli(limited.Timestamp+int64(limited.Ttl)).After(deadline) {
+				limited.Timestamp = now.UnixMilli()
+				limited.Ttl = uint64(limit.Milliseconds())
+				if limited.Ttl == 0 {
+					limited.Ttl = 1
+				}
+			}
+
+			replied := make(chan *Reply, 1)
+			go func() {
+				replied <- next(&limited)
+			}()
+
+			timer := time.NewTimer(limit)
+			defer timer.Stop()
+			select {
+			case reply := <-replied:
+				return reply
+			case <-timer.C:
+				return Fail(fmt.Sprintf("request timed out after %s", limit))
+			}
+		}
+	}
+}
+
+// timeoutFactory expects the 'timeout' setting in milliseconds
+func timeoutFactory(_ string, settings map[string]interface{}) (Middleware, error) {
+	var config struct {
+		Timeout uint64 `json:"timeout"`
+	}
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	if config.Timeout == 0 {
+		return nil, fmt.Errorf("no 'timeout' setting")
+	}
+	return NewRequestTimeout(time.Duration(config.Timeout)*time.Millisecond, nil).Middleware(), nil
+}
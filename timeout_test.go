@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRequestTimeout checks the timeout of the route policy, and the time to live of the limited request
+func TestRequestTimeout(t *testing.T) {
+	table := policyTable(t, RoutePolicy{Timeout: 20}, "report.daily")
+	timeout := NewRequestTimeout(time.Second, table)
+	if timeout.Timeout("report.daily") != 20*time.Millisecond || timeout.Timeout("users.get") != time.Second {
+		t.Fatalf("the timeouts are %s and %s", timeout.Timeout("report.daily"), timeout.Timeout("users.get"))
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	var ttl uint64
+	handler := Wrap(func(req *Envelope) *Reply {
+		if req.Command == "report.daily" {
+			<-release
+			return Ok(nil)
+		}
+		ttl = req.Ttl
+		return Ok(nil)
+	}, timeout.Middleware())
+
+	if reply := handler(policyRequest("report.daily", nil)); reply.IsOK() {
+		t.Fatalf("the slow request is not timed out")
+	}
+	req := policyRequest("users.get", nil)
+	if reply := handler(req); !reply.IsOK() || ttl != 1000 || req.Ttl != 0 {
+		t.Fatalf("the request replied %v with %d ttl, the original ttl is %d", reply, ttl, req.Ttl)
+	}
+
+	// the shorter time to live of the request is kept
+	req.Timestamp = time.Now().UnixMilli()
+	req.Ttl = 100
+	if reply := handler(req); !reply.IsOK() || ttl != 100 {
+		t.Fatalf("the request replied %v with %d ttl", reply, ttl)
+	}
+}
+
+// TestTimeoutFactory checks the timeout from the pipeline configuration
+func TestTimeoutFactory(t *testing.T) {
+	if _, err := BuildPipeline([]MiddlewareConfig{{Name: "timeout"}}); err == nil {
+		t.Fatalf("the timeout without the setting is created")
+	}
+	pipeline, err := BuildPipeline([]MiddlewareConfig{{Name: "timeout", Settings: map[string]interface{}{"timeout": 10}}})
+	if err != nil {
+		t.Fatalf("BuildPipeline: %v", err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	handler := Wrap(func(req *Envelope) *Reply {
+		<-release
+		return Ok(nil)
+	}, pipeline...)
+	if reply := handler(policyRequest("users.get", nil)); reply.IsOK() {
+		t.Fatalf("the slow request is not timed out")
+	}
+}
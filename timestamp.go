@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+)
+
+// timestampLayouts are tried in order to parse the string timestamps
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.RFC822Z,
+	time.RFC822,
+	time.ANSIC,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// TimestampNormalizer converts the timestamp parameters into RFC3339 in UTC.
+// The parameters are matched by name at any depth, like the secrets of the Scrubber.
+//
+// The strings in the known layouts are parsed, and the ones without the zone are in the location.
+// The numbers are unix time: in milliseconds if they are too big for seconds.
+// The values that can't be parsed are passed as is.
+type TimestampNormalizer struct {
+	fields   map[string]struct{}
+	location *time.Location
+}
+
+// NewTimestampNormalizer returns the normalizer of the fields.
+// Nil location means UTC.
+func NewTimestampNormalizer(location *time.Location, fields ...string) *TimestampNormalizer {
+	if location == nil {
+		location = time.UTC
+	}
+	normalizer := &TimestampNormalizer{
+		fields:   make(map[string]struct{}, len(fields)),
+		location: location,
+	}
+	for _, field := range fields {
+		normalizer.fields[field] = struct{}{}
+	}
+	return normalizer
+}
+
+// Normalize returns the timestamp in RFC3339 UTC.
+// Returns false if the value is not the timestamp.
+func (normalizer *TimestampNormalizer) Normalize(value interface{}) (string, bool) {
+	var parsed time.Time
+	switch typed := value.(type) {
+	case string:
+		ok := false
+		for _, layout := range timestampLayouts {
+			if at, err := time.ParseInLocation(layout, typed, normalizer.location); err == nil {
+				parsed, ok = at, true
+				break
+			}
+		}
+		if !ok {
+			return "", false
+		}
+	case float64:
+		// 1e12 seconds is in the year 33658, so bigger numbers are milliseconds
+		if typed >= 1e12 {
+			parsed = time.UnixMilli(int64(typed))
+		} else {
+			parsed = time.Unix(int64(typed), int64((typed-float64(int64(typed)))*1e9))
+		}
+	default:
+		return "", false
+	}
+	return parsed.UTC().Format(time.RFC3339Nano), true
+}
+
+// Parameters returns the copy of the parameters with the normalized timestamps, including the nested ones
+func (normalizer *TimestampNormalizer) Parameters(parameters map[string]interface{}) map[string]interface{} {
+	if parameters == nil {
+		return nil
+	}
+	normalized := make(map[string]interface{}, len(parameters))
+	for name, value := range parameters {
+		if _, ok := normalizer.fields[name]; ok {
+			if timestamp, ok := normalizer.Normalize(value); ok {
+				normalized[name] = timestamp
+				continue
+			}
+		}
+		normalized[name] = normalizer.value(value)
+	}
+	return normalized
+}
+
+func (normalizer *TimestampNormalizer) value(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return normalizer.Parameters(typed)
+	case []interface{}:
+		normalized := make([]interface{}, len(typed))
+		for i, item := range typed {
+			normalized[i] = normalizer.value(item)
+		}
+		return normalized
+	default:
+		return value
+	}
+}
+
+// Middleware normalizes the timestamps of the request, and of the reply returned by the next handler
+func (normalizer *TimestampNormalizer) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			normalized := *req
+			normalized.Parameters = normalizer.Parameters(req.Parameters)
+
+			reply := next(&normalized)
+			if reply == nil {
+				return nil
+			}
+			copied := *reply
+			copied.Parameters = normalizer.Parameters(reply.Parameters)
+			return &copied
+		}
+	}
+}
+
+// timestampFactory expects the 'fields' setting, and the optional 'location' of the timestamps without the zone
+func timestampFactory(_ string, settings map[string]interface{}) (Middleware, error) {
+	var config struct {
+		Fields   []string `json:"fields"`
+		Location string   `json:"location"`
+	}
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	if len(config.Fields) == 0 {
+		return nil, fmt.Errorf("no 'fields' setting")
+	}
+
+	location := time.UTC
+	if len(config.Location) > 0 {
+		loaded, err := time.LoadLocation(config.Location)
+		if err != nil {
+			return nil, fmt.Errorf("time.LoadLocation('%s'): %w", config.Location, err)
+		}
+		location = loaded
+	}
+	return NewTimestampNormalizer(location, config.Fields...).Middleware(), nil
+}
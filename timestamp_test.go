@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTimestampNormalize checks the layouts, the unix time and the location of the timestamps without the zone
+func TestTimestampNormalize(t *testing.T) {
+	location := time.FixedZone("UTC+2", 2*60*60)
+	normalizer := NewTimestampNormalizer(location)
+	values := map[interface{}]string{
+		"2024-01-01T12:00:00+02:00":       "2024-01-01T10:00:00Z",
+		"Mon, 01 Jan 2024 12:00:00 +0000": "2024-01-01T12:00:00Z",
+		"2024-01-01 12:00:00":             "2024-01-01T10:00:00Z",
+		"2024-01-01":                      "2023-12-31T22:00:00Z",
+		float64(1704110400):               "2024-01-01T12:00:00Z",
+		float64(1704110400500):            "2024-01-01T12:00:00.5Z",
+		float64(1704110400.25):            "2024-01-01T12:00:00.25Z",
+	}
+	for value, expected := range values {
+		if normalized, ok := normalizer.Normalize(value); !ok || normalized != expected {
+			t.Fatalf("%v is normalized as '%s', expected '%s'", value, normalized, expected)
+		}
+	}
+	for _, value := range []interface{}{"yesterday", true, nil} {
+		if _, ok := normalizer.Normalize(value); ok {
+			t.Fatalf("%v is normalized", value)
+		}
+	}
+}
+
+// TestTimestampMiddleware checks that the nested fields of the request and the reply are normalized
+func TestTimestampMiddleware(t *testing.T) {
+	pipeline, err := BuildPipeline([]MiddlewareConfig{{Name: "timestamps", Settings: map[string]interface{}{"fields": []interface{}{"created"}}}})
+	if err != nil {
+		t.Fatalf("BuildPipeline: %v", err)
+	}
+	var received *Envelope
+	shared := Ok(map[string]interface{}{"orders": []interface{}{map[string]interface{}{"created": float64(0)}}})
+	handler := Wrap(func(req *Envelope) *Reply {
+		received = req
+		return shared
+	}, pipeline...)
+
+	req := policyRequest("orders.list", map[string]interface{}{
+		"filter":  map[string]interface{}{"created": "2024-01-01"},
+		"updated": "2024-01-01",
+		"created": "yesterday",
+	})
+	reply := handler(req)
+	if received.Parameters["filter"].(map[string]interface{})["created"] != "2024-01-01T00:00:00Z" {
+		t.Fatalf("the nested request timestamp is %v", received.Parameters["filter"])
+	}
+	if received.Parameters["updated"] != "2024-01-01" || received.Parameters["created"] != "yesterday" {
+		t.Fatalf("the other parameters are changed: %v", received.Parameters)
+	}
+	if req.Parameters["filter"].(map[string]interface{})["created"] != "2024-01-01" {
+		t.Fatalf("the original request is changed")
+	}
+	order := reply.Parameters["orders"].([]interface{})[0].(map[string]interface{})
+	if order["created"] != "1970-01-01T00:00:00Z" {
+		t.Fatalf("the reply timestamp is %v", order["created"])
+	}
+	if shared.Parameters["orders"].([]interface{})[0].(map[string]interface{})["created"] != float64(0) {
+		t.Fatalf("the shared reply is changed")
+	}
+
+	for _, settings := range []map[string]interface{}{{}, {"fields": []interface{}{"created"}, "location": "Mars/Base"}} {
+		if _, err := BuildPipeline([]MiddlewareConfig{{Name: "timestamps", Settings: settings}}); err == nil {
+			t.Fatalf("the timestamps with %v are created", settings)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultTLSReloadInterval is how often the certificates are checked for the changes
+const DefaultTLSReloadInterval = time.Minute
+
+// The keys of the certificates in the credential provider bucket
+const (
+	TLSCertKey     = "cert"
+	TLSKeyKey      = "key"
+	TLSClientCAKey = "client_ca"
+)
+
+// TLSConfig is the tls setting of the http and websocket sources.
+// With the client certificate authorities, the clients must present the certificates signed by them,
+// and the common name of the client certificate is the principal of the requests.
+type TLSConfig struct {
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+	// ClientCAFile is the pem of the client certificate authorities. Empty means the clients are not verified
+	ClientCAFile string `json:"client_ca_file,omitempty" yaml:"client_ca_file,omitempty"`
+	// ReloadInterval is how often the files are checked for the changes. Zero means DefaultTLSReloadInterval
+	ReloadInterval time.Duration `json:"reload_interval,omitempty" yaml:"reload_interval,omitempty"`
+}
+
+// tlsMaterial is the pem of the certificates
+type tlsMaterial struct {
+	cert     []byte
+	key      []byte
+	clientCA []byte
+}
+
+// equal returns true if the pem didn't change
+func (material tlsMaterial) equal(other tlsMaterial) bool {
+	return bytes.Equal(material.cert, other.cert) &&
+		bytes.Equal(material.key, other.key) &&
+		bytes.Equal(material.clientCA, other.clientCA)
+}
+
+// TLSCertificates is the tls configuration of the source that picks up the renewed certificates.
+// On the handshake, the certificates are read again once the reload interval passed,
+// so the rotated certificates are used without restarting the proxy.
+// If the renewed certificates are invalid, then the previous ones are kept.
+type TLSCertificates struct {
+	read     func() (tlsMaterial, error)
+	interval time.Duration
+
+	mu       sync.Mutex
+	material tlsMaterial
+	config   *tls.Config
+	checked  time.Time
+	now      func() time.Time
+}
+
+// newTLSCertificates reads the certificates for the first time
+func newTLSCertificates(read func() (tlsMaterial, error), interval time.Duration) (*TLSCertificates, error) {
+	if interval <= 0 {
+		interval = DefaultTLSReloadInterval
+	}
+	certificates := &TLSCertificates{read: read, interval: interval, now: time.Now}
+	if err := certificates.reload(); err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
+
+// NewFileCertificates returns the certificates of the files in the config
+func NewFileCertificates(config TLSConfig) (*TLSCertificates, error) {
+	if len(config.CertFile) == 0 || len(config.KeyFile) == 0 {
+		return nil, fmt.Errorf("tls requires the cert and the key files")
+	}
+	read := func() (tlsMaterial, error) {
+		var material tlsMaterial
+		var err error
+		if material.cert, err = os.ReadFile(config.CertFile); err != nil {
+			return material, fmt.Errorf("os.ReadFile('%s'): %w", config.CertFile, err)
+		}
+		if material.key, err = os.ReadFile(config.KeyFile); err != nil {
+			return material, fmt.Errorf("os.ReadFile('%s'): %w", config.KeyFile, err)
+		}
+		if len(config.ClientCAFile) > 0 {
+			if material.clientCA, err = os.ReadFile(config.ClientCAFile); err != nil {
+				return material, fmt.Errorf("os.ReadFile('%s'): %w", config.ClientCAFile, err)
+			}
+		}
+		return material, nil
+	}
+	return newTLSCertificates(read, config.ReloadInterval)
+}
+
+// NewProviderCertificates returns the certificates kept in the bucket of the credential provider,
+// for example the vault. The bucket has the pem in the TLSCertKey, TLSKeyKey and optional TLSClientCAKey.
+// Zero interval means DefaultTLSReloadInterval.
+func NewProviderCertificates(provider CredentialProvider, bucket string, interval time.Duration) (*TLSCertificates, error) {
+	read := func() (tlsMaterial, error) {
+		var material tlsMaterial
+		cert, err := provider.GetString(bucket, TLSCertKey)
+		if err != nil {
+			return material, fmt.Errorf("provider.GetString('%s', '%s'): %w", bucket, TLSCertKey, err)
+		}
+		key, err := provider.GetString(bucket, TLSKeyKey)
+		if err != nil {
+			return material, fmt.Errorf("provider.GetString('%s', '%s'): %w", bucket, TLSKeyKey, err)
+		}
+		// the client authorities are optional
+		clientCA, _ := provider.GetString(bucket, TLSClientCAKey)
+		return tlsMaterial{cert: []byte(cert), key: []byte(key), clientCA: []byte(clientCA)}, nil
+	}
+	return newTLSCertificates(read, interval)
+}
+
+// buildTLSConfig returns the server configuration of the certificates
+func buildTLSConfig(material tlsMaterial) (*tls.Config, error) {
+	certificate, err := tls.X509KeyPair(material.cert, material.key)
+	if err != nil {
+		return nil, fmt.Errorf("tls.X509KeyPair: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(material.clientCA) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(material.clientCA) {
+			return nil, fmt.Errorf("no client certificate authorities in the pem")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// reload reads the certificates, and rebuilds the configuration if they changed
+func (certificates *TLSCertificates) reload() error {
+	material, err := certificates.read()
+	if err != nil {
+		return err
+	}
+
+	certificates.mu.Lock()
+	defer certificates.mu.Unlock()
+
+	certificates.checked = certificates.now()
+	if certificates.config != nil && material.equal(certificates.material) {
+		return nil
+	}
+	config, err := buildTLSConfig(material)
+	if err != nil {
+		return fmt.Errorf("buildTLSConfig: %w", err)
+	}
+	certificates.material = material
+	certificates.config = config
+	return nil
+}
+
+// current returns the configuration of the handshake, reloading the certificates if it's time
+func (certificates *TLSCertificates) current(*tls.ClientHelloInfo) (*tls.Config, error) {
+	certificates.mu.Lock()
+	due := certificates.now().Sub(certificates.checked) >= certificates.interval
+	if due {
+		// the other handshakes use the current config meanwhile
+		certificates.checked = certificates.now()
+	}
+	certificates.mu.Unlock()
+
+	if due {
+		// the invalid renewal keeps the previous certificates
+		_ = certificates.reload()
+	}
+
+	certificates.mu.Lock()
+	defer certificates.mu.Unlock()
+	return certificates.config, nil
+}
+
+// ServerConfig returns the tls configuration of the listener
+func (certificates *TLSCertificates) ServerConfig() *tls.Config {
+	return &tls.Config{GetConfigForClient: certificates.current, MinVersion: tls.VersionTLS12}
+}
+
+// CertificatePrincipal returns the principal of the verified client certificate:
+// its common name, or the first dns name if the common name is empty.
+// Returns empty string if the client was not verified.
+func CertificatePrincipal(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	leaf := state.VerifiedChains[0][0]
+	if len(leaf.Subject.CommonName) > 0 {
+		return leaf.Subject.CommonName
+	}
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0]
+	}
+	return ""
+}
+
+// ClientCertificate is the verifier of the requests with the principal of the client certificate.
+// Use it with WithAuth and the other verifier consumers, when the source terminates the mutual tls.
+var ClientCertificate Verifier = VerifierFunc(func(req *Envelope) (string, error) {
+	if len(req.Principal) == 0 {
+		return "", fmt.Errorf("no client certificate")
+	}
+	return req.Principal, nil
+})
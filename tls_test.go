@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSigned returns the pem of the self-signed certificate and its key
+func selfSigned(t *testing.T, commonName string) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// leafName returns the common name of the configured certificate
+func leafName(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestFileCertificates(t *testing.T) {
+	if _, err := NewFileCertificates(TLSConfig{}); err == nil {
+		t.Fatalf("expected an error without the files")
+	}
+
+	dir := t.TempDir()
+	cert, key := selfSigned(t, "proxy")
+	config := TLSConfig{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	if _, err := NewFileCertificates(config); err == nil {
+		t.Fatalf("expected an error for the missing files")
+	}
+	if err := os.WriteFile(config.CertFile, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config.KeyFile, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	certificates, err := NewFileCertificates(config)
+	if err != nil {
+		t.Fatalf("NewFileCertificates: %v", err)
+	}
+	current, err := certificates.current(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := leafName(t, current); name != "proxy" {
+		t.Fatalf("expected 'proxy' certificate, got '%s'", name)
+	}
+	if current.ClientAuth != tls.NoClientCert {
+		t.Fatalf("expected no client verification without the authorities")
+	}
+	if server := certificates.ServerConfig(); server.GetConfigForClient == nil {
+		t.Fatalf("expected the server config to pick the current certificates")
+	}
+}
+
+func TestProviderCertificatesClientCA(t *testing.T) {
+	cert, key := selfSigned(t, "proxy")
+	ca, _ := selfSigned(t, "authority")
+	t.Setenv("TEST_TLS_CERT", string(cert))
+	t.Setenv("TEST_TLS_KEY", string(key))
+	t.Setenv("TEST_TLS_CLIENT_CA", string(ca))
+	provider := NewEnvCredentials("test")
+
+	certificates, err := NewProviderCertificates(provider, "tls", 0)
+	if err != nil {
+		t.Fatalf("NewProviderCertificates: %v", err)
+	}
+	if certificates.interval != DefaultTLSReloadInterval {
+		t.Fatalf("expected the default interval, got %s", certificates.interval)
+	}
+	current, _ := certificates.current(nil)
+	if current.ClientAuth != tls.RequireAndVerifyClientCert || current.ClientCAs == nil {
+		t.Fatalf("expected the clients to be verified by the authorities")
+	}
+
+	if _, err := NewProviderCertificates(provider, "missing", 0); err == nil {
+		t.Fatalf("expected an error for the missing bucket")
+	}
+}
+
+func TestTLSCertificatesReload(t *testing.T) {
+	first, firstKey := selfSigned(t, "first")
+	second, secondKey := selfSigned(t, "second")
+
+	material := tlsMaterial{cert: first, key: firstKey}
+	reads := 0
+	read := func() (tlsMaterial, error) {
+		reads++
+		if material.cert == nil {
+			return material, fmt.Errorf("not readable")
+		}
+		return material, nil
+	}
+	certificates, err := newTLSCertificates(read, time.Minute)
+	if err != nil {
+		t.Fatalf("newTLSCertificates: %v", err)
+	}
+	now := time.Now()
+	certificates.now = func() time.Time { return now }
+
+	// not due yet
+	material = tlsMaterial{cert: second, key: secondKey}
+	current, _ := certificates.current(nil)
+	if name := leafName(t, current); name != "first" || reads != 1 {
+		t.Fatalf("expected the first certificate without reading, got '%s' after %d reads", name, reads)
+	}
+
+	now = now.Add(time.Minute)
+	current, _ = certificates.current(nil)
+	if name := leafName(t, current); name != "second" {
+		t.Fatalf("expected the renewed certificate, got '%s'", name)
+	}
+
+	// the invalid renewal keeps the previous certificates
+	material = tlsMaterial{cert: first, key: secondKey}
+	now = now.Add(time.Minute)
+	current, _ = certificates.current(nil)
+	if name := leafName(t, current); name != "second" {
+		t.Fatalf("expected the previous certificate on the invalid renewal, got '%s'", name)
+	}
+
+	material = tlsMaterial{}
+	now = now.Add(time.Minute)
+	if current, _ = certificates.current(nil); current == nil || leafName(t, current) != "second" {
+		t.Fatalf("expected the previous certificate on the read error")
+	}
+}
+
+func TestCertificatePrincipal(t *testing.T) {
+	if principal := CertificatePrincipal(nil); principal != "" {
+		t.Fatalf("expected no principal without the state, got '%s'", principal)
+	}
+	if principal := CertificatePrincipal(&tls.ConnectionState{}); principal != "" {
+		t.Fatalf("expected no principal without the verified chains, got '%s'", principal)
+	}
+
+	named := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, DNSNames: []string{"alice.example"}}
+	state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{named}}}
+	if principal := CertificatePrincipal(state); principal != "alice" {
+		t.Fatalf("expected the common name, got '%s'", principal)
+	}
+
+	dns := &x509.Certificate{DNSNames: []string{"bob.example"}}
+	state = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{dns}}}
+	if principal := CertificatePrincipal(state); principal != "bob.example" {
+		t.Fatalf("expected the dns name, got '%s'", principal)
+	}
+
+	if _, err := ClientCertificate.Verify(&Envelope{}); err == nil {
+		t.Fatalf("expected an error without the client certificate")
+	}
+	if principal, err := ClientCertificate.Verify(&Envelope{Principal: "alice"}); err != nil || principal != "alice" {
+		t.Fatalf("expected 'alice', got '%s' %v", principal, err)
+	}
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TopologyCommand returns how the requests are routed to the destinations
+const TopologyCommand = "proxy.topology"
+
+// TopologyDestination is the destination in the topology with the ways the requests reach it
+type TopologyDestination struct {
+	Name string `json:"name"`
+	// Default is true if the requests without the other route go to the destination
+	Default bool              `json:"default,omitempty"`
+	Rules   []DestinationRule `json:"rules,omitempty"`
+	// Groups are the route groups whose policy has the destination
+	Groups []string `json:"groups,omitempty"`
+	// Windows are the commands routed to the destination outside their route window
+	Windows []string `json:"windows,omitempty"`
+	// Experiments splitting the destination, or having it as the alternate
+	Experiments []string `json:"experiments,omitempty"`
+	// Health of the destination's instances by their names
+	Health map[string]HealthStatus `json:"health,omitempty"`
+}
+
+// Topology replies to the TopologyCommand with the destinations of the router,
+// the rules, route groups, windows and experiments leading to them, and their health.
+type Topology struct {
+	router *Router
+	health map[string]*HealthChecker
+}
+
+// NewTopology returns the topology of the router
+func NewTopology(router *Router) *Topology {
+	return &Topology{router: router, health: make(map[string]*HealthChecker)}
+}
+
+// WithHealth adds the health checks of the destination's instances.
+// Call it before serving the requests.
+func (topology *Topology) WithHealth(destination string, checker *HealthChecker) *Topology {
+	topology.health[destination] = checker
+	return topology
+}
+
+// Destinations returns the destinations sorted by their names
+func (topology *Topology) Destinations() []TopologyDestination {
+	router := topology.router
+	byName := make(map[string]*TopologyDestination, len(router.destinations))
+	names := router.Names()
+	destinations := make([]TopologyDestination, len(names))
+	for i, name := range names {
+		destinations[i] = TopologyDestination{Name: name, Default: name == router.fallback}
+		if checker, ok := topology.health[name]; ok {
+			destinations[i].Health = checker.DestinationStatus()
+		}
+		byName[name] = &destinations[i]
+	}
+
+	for _, rule := range router.rules {
+		if destination, ok := byName[rule.Destination]; ok {
+			destination.Rules = append(destination.Rules, rule)
+		}
+	}
+	if router.table != nil {
+		for _, group := range router.table.Routes().Groups() {
+			if destination, ok := byName[group.Destination]; ok {
+				destination.Groups = append(destination.Groups, group.Name)
+			}
+		}
+	}
+	if router.windows != nil {
+		for _, window := range router.windows.windows {
+			if destination, ok := byName[window.Destination]; ok {
+				destination.Windows = append(destination.Windows, window.Command)
+			}
+		}
+	}
+	for _, experiment := range router.experiments {
+		for _, name := range []string{experiment.Destination, experiment.Alternate} {
+			if destination, ok := byName[name]; ok {
+				destination.Experiments = append(destination.Experiments, experiment.Name)
+			}
+		}
+	}
+	// the experiments are kept in the map
+	for i := range destinations {
+		sort.Strings(destinations[i].Experiments)
+	}
+	return destinations
+}
+
+// Handler replies to the TopologyCommand with the 'destinations' parameter
+func (topology *Topology) Handler() Handler {
+	return func(req *Envelope) *Reply {
+		parameters, err := toParameters(struct {
+			Destinations []TopologyDestination `json:"destinations"`
+		}{topology.Destinations()})
+		if err != nil {
+			return Fail(fmt.Sprintf("toParameters: %v", err))
+		}
+		return Ok(parameters)
+	}
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestTopologyListsRoutes checks that the topology has the routes and the health of each destination
+func TestTopologyListsRoutes(t *testing.T) {
+	up := funcTransport(func(*Envelope) (*Reply, error) { return Ok(nil), nil })
+	down := funcTransport(func(*Envelope) (*Reply, error) { return nil, fmt.Errorf("down") })
+	balancer, err := NewBalancer(RoundRobin, []Instance{{Name: "a", Transport: up}, {Name: "b", Transport: down}})
+	if err != nil {
+		t.Fatalf("NewBalancer: %v", err)
+	}
+	checker := NewHealthChecker(balancer, HealthConfig{FailThreshold: 1})
+	checker.Check(context.Background())
+
+	table := policyTable(t, RoutePolicy{Destination: "reports"}, "report.daily")
+	rules := []DestinationRule{{Command: "user.*", Destination: "primary"}}
+	router, err := NewRouter(map[string]DestinationTransport{"primary": balancer, "reports": up, "canary": up}, rules, table, "primary")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	if err := router.SetExperiments([]Experiment{{Name: "new-reports", Percent: 10, Destination: "reports", Alternate: "canary"}}); err != nil {
+		t.Fatalf("SetExperiments: %v", err)
+	}
+
+	destinations := NewTopology(router).WithHealth("primary", checker).Destinations()
+	if len(destinations) != 3 {
+		t.Fatalf("expected 3 destinations, got %v", destinations)
+	}
+	canary, primary, reports := destinations[0], destinations[1], destinations[2]
+	if !primary.Default || len(primary.Rules) != 1 || primary.Rules[0].Command != "user.*" {
+		t.Fatalf("primary destination is %+v", primary)
+	}
+	if !primary.Health["a"].Healthy || primary.Health["b"].Healthy {
+		t.Fatalf("primary health is %+v", primary.Health)
+	}
+	if len(reports.Groups) != 1 || reports.Groups[0] != "private" || len(reports.Experiments) != 1 {
+		t.Fatalf("reports destination is %+v", reports)
+	}
+	if canary.Default || len(canary.Experiments) != 1 || canary.Experiments[0] != "new-reports" {
+		t.Fatalf("canary destination is %+v", canary)
+	}
+
+	reply := NewTopology(router).Handler()(policyRequest(TopologyCommand, nil))
+	if !reply.IsOK() {
+		t.Fatalf("topology failed: %s", reply.Message)
+	}
+	if list, ok := reply.Parameters["destinations"].([]interface{}); !ok || len(list) != 3 {
+		t.Fatalf("topology replied %v", reply.Parameters)
+	}
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TraceparentHeader is the W3C trace context header
+const TraceparentHeader = "traceparent"
+
+// TraceContext is the position of the request in the distributed trace
+type TraceContext struct {
+	// TraceId is 32 hex characters
+	TraceId string
+	// SpanId is 16 hex characters
+	SpanId  string
+	Sampled bool
+}
+
+// isHex returns true if the text has the length and only lower case hex characters, not all zeros
+func isHex(text string, length int) bool {
+	if len(text) != length || strings.Trim(text, "0") == "" {
+		return false
+	}
+	for _, char := range text {
+		if (char < '0' || char > '9') && (char < 'a' || char > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTraceparent parses the W3C traceparent: 'version-traceid-spanid-flags'
+func ParseTraceparent(traceparent string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, fmt.Errorf("traceparent has %d parts, expected 4", len(parts))
+	}
+	if len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return TraceContext{}, fmt.Errorf("unsupported traceparent version '%s'", parts[0])
+	}
+	if !isHex(parts[1], 32) {
+		return TraceContext{}, fmt.Errorf("invalid trace id '%s'", parts[1])
+	}
+	if !isHex(parts[2], 16) {
+		return TraceContext{}, fmt.Errorf("invalid span id '%s'", parts[2])
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return TraceContext{}, fmt.Errorf("invalid trace flags '%s'", parts[3])
+	}
+	return TraceContext{TraceId: parts[1], SpanId: parts[2], Sampled: flags[0]&1 == 1}, nil
+}
+
+// flags returns the W3C trace flags as two hex characters
+func (trace TraceContext) flags() string {
+	if trace.Sampled {
+		return "01"
+	}
+	return "00"
+}
+
+// Traceparent returns the W3C traceparent of the trace context
+func (trace TraceContext) Traceparent() string {
+	return "00-" + trace.TraceId + "-" + trace.SpanId + "-" + trace.flags()
+}
+
+// Valid returns true if the trace context has the ids
+func (trace TraceContext) Valid() bool {
+	return isHex(trace.TraceId, 32) && isHex(trace.SpanId, 16)
+}
+
+// ExtractTrace returns the trace context of the envelope.
+// The envelope without the trace flags is sampled.
+// Returns false if the envelope is not traced.
+func ExtractTrace(req *Envelope) (TraceContext, bool) {
+	trace := TraceContext{TraceId: req.TraceId, SpanId: req.SpanId, Sampled: true}
+	if len(req.TraceFlags) > 0 {
+		flags, err := hex.DecodeString(req.TraceFlags)
+		if err != nil || len(flags) != 1 {
+			return trace, false
+		}
+		trace.Sampled = flags[0]&1 == 1
+	}
+	return trace, trace.Valid()
+}
+
+// InjectTrace sets the trace context to the envelope
+func InjectTrace(req *Envelope, trace TraceContext) {
+	req.TraceId = trace.TraceId
+	req.SpanId = trace.SpanId
+	req.TraceFlags = trace.flags()
+}
+
+// NewSpanId returns the random span id
+func NewSpanId() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		// the crypto source never fails on the supported platforms
+		panic(fmt.Sprintf("rand.Read: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+// Span is the operation in the trace
+type Span interface {
+	// Context of the span, passed to the next hop
+	Context() TraceContext
+	SetAttribute(key string, value string)
+	// End the span. The error marks the span failed
+	End(err error)
+}
+
+// Tracer starts the spans.
+// Plug the tracing backend by implementing it, for example with OpenTelemetry's TracerProvider.
+type Tracer interface {
+	// Start the span as the child of the parent.
+	// If the parent is not valid, then the span starts a new trace.
+	Start(parent TraceContext, name string) Span
+}
+
+// propagationTracer only creates the ids, so the trace is propagated without recording
+type propagationTracer struct{}
+
+type propagationSpan struct {
+	trace TraceContext
+}
+
+func (propagationTracer) Start(parent TraceContext, _ string) Span {
+	trace := TraceContext{TraceId: parent.TraceId, SpanId: NewSpanId(), Sampled: parent.Sampled}
+	if !parent.Valid() {
+		trace.TraceId = NewId()
+		trace.Sampled = true
+	}
+	return &propagationSpan{trace: trace}
+}
+
+func (span *propagationSpan) Context() TraceContext       { return span.trace }
+func (span *propagationSpan) SetAttribute(string, string) {}
+func (span *propagationSpan) End(error)                   {}
+
+// Tracing starts the span for each request, named 'proxy <command>' with the command and destination attributes.
+// The request is passed to the next handler with the span as its parent.
+// If the tracer is nil, then the trace is only propagated.
+func Tracing(tracer Tracer, destination string) Middleware {
+	if tracer == nil {
+		tracer = propagationTracer{}
+	}
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			parent, _ := ExtractTrace(req)
+			span := tracer.Start(parent, "proxy "+req.Command)
+			span.SetAttribute("command", req.Command)
+			span.SetAttribute("destination", destination)
+
+			traced := *req
+			InjectTrace(&traced, span.Context())
+			reply := next(&traced)
+
+			if reply == nil {
+				span.End(fmt.Errorf("no reply to '%s'", req.Command))
+			} else if reply.IsOK() {
+				span.End(nil)
+			} else {
+				span.End(fmt.Errorf("%s", reply.Message))
+			}
+			return reply
+		}
+	}
+}
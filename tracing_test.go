@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"testing"
+)
+
+const (
+	testTraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanId  = "00f067aa0ba902b7"
+)
+
+// recordingTracer keeps the started spans
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	name       string
+	trace      TraceContext
+	attributes map[string]string
+	ended      bool
+	err        error
+}
+
+func (tracer *recordingTracer) Start(parent TraceContext, name string) Span {
+	span := &recordingSpan{
+		name:       name,
+		trace:      TraceContext{TraceId: parent.TraceId, SpanId: NewSpanId(), Sampled: parent.Sampled},
+		attributes: map[string]string{},
+	}
+	tracer.spans = append(tracer.spans, span)
+	return span
+}
+
+func (span *recordingSpan) Context() TraceContext { return span.trace }
+func (span *recordingSpan) SetAttribute(key string, value string) {
+	span.attributes[key] = value
+}
+func (span *recordingSpan) End(err error) {
+	span.ended = true
+	span.err = err
+}
+
+// tracedRequest returns the envelope of the command in the trace
+func tracedRequest(command string, traceId string, spanId string) *Envelope {
+	return &Envelope{TraceId: traceId, SpanId: spanId, Request: Request{Command: command}}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	trace, err := ParseTraceparent("00-" + testTraceId + "-" + testSpanId + "-01")
+	if err != nil {
+		t.Fatalf("ParseTraceparent: %v", err)
+	}
+	if trace.TraceId != testTraceId || trace.SpanId != testSpanId || !trace.Sampled {
+		t.Fatalf("unexpected trace context %+v", trace)
+	}
+	if traceparent := trace.Traceparent(); traceparent != "00-"+testTraceId+"-"+testSpanId+"-01" {
+		t.Fatalf("expected the same traceparent, got '%s'", traceparent)
+	}
+
+	// the future versions may have more parts
+	if _, err := ParseTraceparent("01-" + testTraceId + "-" + testSpanId + "-00-extra"); err != nil {
+		t.Fatalf("expected the future version to be parsed: %v", err)
+	}
+
+	invalid := []string{
+		"",
+		"00-" + testTraceId + "-" + testSpanId,
+		"ff-" + testTraceId + "-" + testSpanId + "-01",
+		"00-" + testTraceId + "-" + testSpanId + "-01-extra",
+		"00-00000000000000000000000000000000-" + testSpanId + "-01",
+		"00-" + testTraceId + "-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-" + testSpanId + "-01",
+		"00-" + testTraceId + "-" + testSpanId + "-zz",
+	}
+	for _, traceparent := range invalid {
+		if _, err := ParseTraceparent(traceparent); err == nil {
+			t.Fatalf("expected an error for '%s'", traceparent)
+		}
+	}
+}
+
+func TestExtractInjectTrace(t *testing.T) {
+	if _, ok := ExtractTrace(&Envelope{}); ok {
+		t.Fatalf("expected the envelope without the ids not to be traced")
+	}
+
+	req := &Envelope{TraceId: testTraceId, SpanId: testSpanId}
+	trace, ok := ExtractTrace(req)
+	if !ok || !trace.Sampled {
+		t.Fatalf("expected the envelope without the flags to be sampled, got %+v", trace)
+	}
+
+	req.TraceFlags = "00"
+	if trace, ok = ExtractTrace(req); !ok || trace.Sampled {
+		t.Fatalf("expected the unsampled trace, got %+v", trace)
+	}
+	req.TraceFlags = "x"
+	if _, ok = ExtractTrace(req); ok {
+		t.Fatalf("expected the invalid flags not to be traced")
+	}
+
+	var injected Envelope
+	InjectTrace(&injected, TraceContext{TraceId: testTraceId, SpanId: testSpanId, Sampled: true})
+	if injected.TraceId != testTraceId || injected.SpanId != testSpanId || injected.TraceFlags != "01" {
+		t.Fatalf("unexpected injected envelope %+v", injected)
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	tracer := &recordingTracer{}
+	var received *Envelope
+	handler := Wrap(func(req *Envelope) *Reply {
+		received = req
+		if req.Command == "missing" {
+			return nil
+		}
+		if req.Command == "broken" {
+			return Fail("broken")
+		}
+		return Ok(nil)
+	}, Tracing(tracer, "backend"))
+
+	req := tracedRequest("get", testTraceId, testSpanId)
+	if reply := handler(req); !reply.IsOK() {
+		t.Fatalf("expected the ok reply, got '%s'", reply.Message)
+	}
+	span := tracer.spans[0]
+	if span.name != "proxy get" || span.attributes["command"] != "get" || span.attributes["destination"] != "backend" {
+		t.Fatalf("unexpected span %+v", span)
+	}
+	if !span.ended || span.err != nil {
+		t.Fatalf("expected the span to end without an error, got %v", span.err)
+	}
+	if received.TraceId != testTraceId || received.SpanId != span.trace.SpanId {
+		t.Fatalf("expected the span to be the parent of the next hop, got %+v", received)
+	}
+	if req.SpanId != testSpanId {
+		t.Fatalf("expected the original request to keep its span")
+	}
+
+	handler(tracedRequest("broken", "", ""))
+	if err := tracer.spans[1].err; err == nil || err.Error() != "broken" {
+		t.Fatalf("expected the failed span, got %v", err)
+	}
+
+	if reply := handler(tracedRequest("missing", "", "")); reply != nil {
+		t.Fatalf("expected no reply, got %+v", reply)
+	}
+	if span := tracer.spans[2]; !span.ended || span.err == nil {
+		t.Fatalf("expected the span without the reply to fail")
+	}
+}
+
+func TestTracingPropagation(t *testing.T) {
+	var received *Envelope
+	handler := Wrap(func(req *Envelope) *Reply {
+		received = req
+		return Ok(nil)
+	}, Tracing(nil, "backend"))
+
+	handler(tracedRequest("get", "", ""))
+	trace, ok := ExtractTrace(received)
+	if !ok || !trace.Sampled {
+		t.Fatalf("expected the new sampled trace, got %+v", trace)
+	}
+
+	req := tracedRequest("get", testTraceId, testSpanId)
+	req.TraceFlags = "00"
+	handler(req)
+	trace, ok = ExtractTrace(received)
+	if !ok || trace.TraceId != testTraceId || trace.SpanId == testSpanId || trace.Sampled {
+		t.Fatalf("expected the child span of the unsampled trace, got %+v", trace)
+	}
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+)
+
+// Transform maps the payload of the command with the Go templates.
+// Each parameter of the new payload is the template executed with the message:
+// '.Command' and '.Parameters' of the request, and for the reply '.Request' too.
+//
+// If the template output is valid json, then it's decoded, otherwise it's kept as a string.
+// Use the 'json' function to pass the objects and lists, for example '{{json .Parameters.user}}'.
+type Transform struct {
+	Command string `json:"command" yaml:"command"`
+	// Request replaces the request parameters. Empty means the request is passed as is
+	Request map[string]string `json:"request,omitempty" yaml:"request,omitempty"`
+	// Reply replaces the parameters of the successful reply. Empty means the reply is returned as is
+	Reply map[string]string `json:"reply,omitempty" yaml:"reply,omitempty"`
+}
+
+type compiledTransform struct {
+	request map[string]*template.Template
+	reply   map[string]*template.Template
+}
+
+// Transformer applies the transforms by the command
+type Transformer struct {
+	transforms map[string]compiledTransform
+}
+
+var transformFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	},
+}
+
+// NewTransformer returns the transformer with the parsed templates
+func NewTransformer(transforms []Transform) (*Transformer, error) {
+	transformer := &Transformer{transforms: make(map[string]compiledTransform, len(transforms))}
+	for i, transform := range transforms {
+		if len(transform.Command) == 0 {
+			return nil, fmt.Errorf("transforms[%d] has no command", i)
+		}
+		if _, ok := transformer.transforms[transform.Command]; ok {
+			return nil, fmt.Errorf("transforms[%d]: duplicate command '%s'", i, transform.Command)
+		}
+
+		request, err := parseTemplates(transform.Request)
+		if err != nil {
+			return nil, fmt.Errorf("transforms[%d] request: %w", i, err)
+		}
+		reply, err := parseTemplates(transform.Reply)
+		if err != nil {
+			return nil, fmt.Errorf("transforms[%d] reply: %w", i, err)
+		}
+		transformer.transforms[transform.Command] = compiledTransform{request: request, reply: reply}
+	}
+	return transformer, nil
+}
+
+func parseTemplates(texts map[string]string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(texts))
+	for name, text := range texts {
+		parsed, err := template.New(name).Funcs(transformFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template.Parse('%s'): %w", name, err)
+		}
+		templates[name] = parsed
+	}
+	return templates, nil
+}
+
+// execute the templates with the data, returning the new parameters
+func execute(templates map[string]*template.Template, data interface{}) (map[string]interface{}, error) {
+	parameters := make(map[string]interface{}, len(templates))
+	var buf bytes.Buffer
+	for name, parsed := range templates {
+		buf.Reset()
+		if err := parsed.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("'%s': %w", name, err)
+		}
+
+		var value interface{}
+		if err := json.Unmarshal(buf.Bytes(), &value); err != nil {
+			value = buf.String()
+		}
+		parameters[name] = value
+	}
+	return parameters, nil
+}
+
+// Middleware transforms the request before passing it to the next handler,
+// and the reply returned by the next handler.
+func (transformer *Transformer) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			transform, ok := transformer.transforms[req.Command]
+			if !ok {
+				return next(req)
+			}
+
+			original := req.Request
+			if len(transform.request) > 0 {
+				parameters, err := execute(transform.request, original)
+				if err != nil {
+					return Fail(fmt.Sprintf("request transform %v", err))
+				}
+				transformed := *req
+				transformed.Parameters = parameters
+				req = &transformed
+			}
+
+			reply := next(req)
+			if len(transform.reply) == 0 || reply == nil || !reply.IsOK() {
+				return reply
+			}
+
+			parameters, err := execute(transform.reply, map[string]interface{}{
+				"Command":    original.Command,
+				"Parameters": reply.Parameters,
+				"Request":    original,
+			})
+			if err != nil {
+				return Fail(fmt.Sprintf("reply transform %v", err))
+			}
+			return Ok(parameters)
+		}
+	}
+}
+
+// transformFactory expects the 'transforms' setting with the list of Transform
+func transformFactory(_ string, settings map[string]interface{}) (Middleware, error) {
+	var config struct {
+		Transforms []Transform `json:"transforms"`
+	}
+	if err := decodeSettings(settings, &config); err != nil {
+		return nil, fmt.Errorf("decodeSettings: %w", err)
+	}
+	transformer, err := NewTransformer(config.Transforms)
+	if err != nil {
+		return nil, fmt.Errorf("NewTransformer: %w", err)
+	}
+	return transformer.Middleware(), nil
+}
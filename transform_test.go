@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func TestNewTransformerErrors(t *testing.T) {
+	invalid := [][]Transform{
+		{{Request: map[string]string{"a": "{{.Command}}"}}},
+		{{Command: "get"}, {Command: "get"}},
+		{{Command: "get", Request: map[string]string{"a": "{{.Command"}}},
+		{{Command: "get", Reply: map[string]string{"a": "{{end}}"}}},
+	}
+	for i, transforms := range invalid {
+		if _, err := NewTransformer(transforms); err == nil {
+			t.Fatalf("expected an error for the transforms %d", i)
+		}
+	}
+}
+
+func TestTransformMiddleware(t *testing.T) {
+	transformer, err := NewTransformer([]Transform{{
+		Command: "get",
+		Request: map[string]string{
+			"id":   "{{.Parameters.user_id}}",
+			"user": "{{json .Parameters.user}}",
+			"name": "user-{{.Parameters.user_id}}",
+		},
+		Reply: map[string]string{
+			"command": "{{.Command}}",
+			"balance": "{{.Parameters.amount}}",
+			"asked":   "{{.Request.Parameters.user_id}}",
+		},
+	}})
+	if err != nil {
+		t.Fatalf("NewTransformer: %v", err)
+	}
+
+	var received *Envelope
+	handler := Wrap(func(req *Envelope) *Reply {
+		received = req
+		switch req.Command {
+		case "missing":
+			return nil
+		case "broken":
+			return Fail("broken")
+		}
+		return Ok(map[string]interface{}{"amount": 10})
+	}, transformer.Middleware())
+
+	req := policyRequest("get", map[string]interface{}{
+		"user_id": 7,
+		"user":    map[string]interface{}{"name": "alice"},
+	})
+	reply := handler(req)
+	if !reply.IsOK() {
+		t.Fatalf("expected the ok reply, got '%s'", reply.Message)
+	}
+	if received.Parameters["id"] != float64(7) || received.Parameters["name"] != "user-7" {
+		t.Fatalf("unexpected transformed request %v", received.Parameters)
+	}
+	if user, ok := received.Parameters["user"].(map[string]interface{}); !ok || user["name"] != "alice" {
+		t.Fatalf("expected the json object, got %v", received.Parameters["user"])
+	}
+	if _, ok := req.Parameters["id"]; ok {
+		t.Fatalf("expected the original request to be kept")
+	}
+	if reply.Parameters["command"] != "get" || reply.Parameters["balance"] != float64(10) || reply.Parameters["asked"] != float64(7) {
+		t.Fatalf("unexpected transformed reply %v", reply.Parameters)
+	}
+
+	// the missing parameter fails the request
+	if reply := handler(policyRequest("get", map[string]interface{}{})); reply.IsOK() {
+		t.Fatalf("expected the request transform to fail")
+	}
+
+	// the other commands are passed as is
+	if reply := handler(policyRequest("broken", map[string]interface{}{"user_id": 7})); reply.IsOK() || reply.Message != "broken" {
+		t.Fatalf("expected the failed reply as is, got %+v", reply)
+	}
+	if reply := handler(policyRequest("missing", nil)); reply != nil {
+		t.Fatalf("expected no reply, got %+v", reply)
+	}
+}
+
+func TestTransformWithoutReply(t *testing.T) {
+	transformer, err := NewTransformer([]Transform{{
+		Command: "get",
+		Reply:   map[string]string{"balance": "{{.Parameters.amount}}"},
+	}})
+	if err != nil {
+		t.Fatalf("NewTransformer: %v", err)
+	}
+	handler := Wrap(func(req *Envelope) *Reply { return nil }, transformer.Middleware())
+	if reply := handler(policyRequest("get", nil)); reply != nil {
+		t.Fatalf("expected no reply, got %+v", reply)
+	}
+
+	failing := Wrap(func(req *Envelope) *Reply { return Ok(nil) }, transformer.Middleware())
+	if reply := failing(policyRequest("get", nil)); reply.IsOK() {
+		t.Fatalf("expected the reply transform to fail without the amount")
+	}
+}
+
+func TestTransformFactory(t *testing.T) {
+	middleware, err := transformFactory("transform", map[string]interface{}{
+		"transforms": []interface{}{
+			map[string]interface{}{"command": "get", "request": map[string]interface{}{"id": "{{.Parameters.user_id}}"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("transformFactory: %v", err)
+	}
+	var received *Envelope
+	handler := Wrap(func(req *Envelope) *Reply {
+		received = req
+		return Ok(nil)
+	}, middleware)
+	handler(policyRequest("get", map[string]interface{}{"user_id": "u1"}))
+	if received.Parameters["id"] != "u1" {
+		t.Fatalf("expected the transformed request, got %v", received.Parameters)
+	}
+
+	if _, err := transformFactory("transform", map[string]interface{}{
+		"transforms": []interface{}{map[string]interface{}{"command": ""}},
+	}); err == nil {
+		t.Fatalf("expected an error for the transform without the command")
+	}
+}
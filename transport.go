@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SourceTransport receives the requests from the clients and returns the replies
+type SourceTransport interface {
+	// Serve passes the requests to the handler until the context is cancelled
+	Serve(ctx context.Context, handler Handler) error
+}
+
+// DrainingSource is the source that gives the requests in progress the time to finish on cancel.
+// The Server sets its DrainTimeout to the source before serving.
+type DrainingSource interface {
+	SetDrainTimeout(timeout time.Duration)
+}
+
+// DestinationTransport sends the requests to the destination
+type DestinationTransport interface {
+	// Send the request and wait for the reply
+	Send(ctx context.Context, req *Envelope) (*Reply, error)
+	Close() error
+}
+
+// envelopeContext returns the context cancelled after the time to live of the envelope
+func envelopeContext(req *Envelope) (context.Context, context.CancelFunc) {
+	if req.Ttl > 0 && req.Timestamp > 0 {
+		return context.WithDeadline(context.Background(), time.UnixMilli(req.Timestamp+int64(req.Ttl)))
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Forward returns the handler that sends the requests to the destination.
+// If the envelope has the time to live, then the request is cancelled after it.
+// The transport errors are returned as the fail replies.
+func Forward(destination DestinationTransport) Handler {
+	return func(req *Envelope) *Reply {
+		ctx, cancel := envelopeContext(req)
+		defer cancel()
+
+		reply, err := destination.Send(ctx, req)
+		if err != nil {
+			return Fail(fmt.Sprintf("destination.Send: %v", err))
+		}
+		return reply
+	}
+}
+
+// Pipe serves the source, passing the requests through the middlewares to the destination.
+// Blocks until the context is cancelled. The destination is closed on return.
+func Pipe(ctx context.Context, source SourceTransport, destination DestinationTransport, middlewares ...Middleware) error {
+	defer func() {
+		_ = destination.Close()
+	}()
+
+	handler := Wrap(Forward(destination), middlewares...)
+	if err := source.Serve(ctx, handler); err != nil {
+		return fmt.Errorf("source.Serve: %w", err)
+	}
+	return nil
+}
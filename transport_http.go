@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxRequestSize is the body limit of the http requests if it's not set
+const DefaultMaxRequestSize = 1 << 20
+
+// HTTPSourceConfig is the setting of the http source
+type HTTPSourceConfig struct {
+	Port uint64 `json:"port" yaml:"port"`
+	// PathPrefix is the path of the commands, for example '/api' accepts '/api/get-user'
+	PathPrefix string `json:"path_prefix,omitempty" yaml:"path_prefix,omitempty"`
+	// MaxRequestSize is the limit of the request body in bytes
+	MaxRequestSize int64 `json:"max_request_size,omitempty" yaml:"max_request_size,omitempty"`
+	// TLS serves the https. Optional
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+// HTTPSource accepts the REST/JSON requests, so the proxy serves as the http gateway to the destination.
+//
+// The command is the part of the path after the prefix, and the json body is the parameters.
+// The bearer token of the Authorization header is passed as the AuthParam,
+// and the W3C traceparent header as the trace of the envelope.
+// The reply is returned as json with 200 status, even if the reply failed.
+// Only the malformed http requests get the error statuses.
+//
+// With the mutual tls, the principal of the verified client certificate is the Principal of the envelope.
+type HTTPSource struct {
+	config       HTTPSourceConfig
+	prefix       string
+	drainTimeout time.Duration
+	resources    *Resources
+	certificates *TLSCertificates
+	transcoder   *Transcoder
+}
+
+// NewHTTPSource returns the http source
+func NewHTTPSource(config HTTPSourceConfig) (*HTTPSource, error) {
+	if config.Port == 0 {
+		return nil, fmt.Errorf("no port")
+	}
+	if config.MaxRequestSize <= 0 {
+		config.MaxRequestSize = DefaultMaxRequestSize
+	}
+	prefix := "/" + strings.Trim(config.PathPrefix, "/")
+	if prefix != "/" {
+		prefix += "/"
+	}
+	source := &HTTPSource{config: config, prefix: prefix, drainTimeout: DefaultDrainTimeout}
+	if config.TLS != nil {
+		certificates, err := NewFileCertificates(*config.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("NewFileCertificates: %w", err)
+		}
+		source.certificates = certificates
+	}
+	return source, nil
+}
+
+// WithCertificates serves the https with the certificates, for example from the vault.
+// It replaces the certificates of the TLS config.
+func (source *HTTPSource) WithCertificates(certificates *TLSCertificates) *HTTPSource {
+	source.certificates = certificates
+	return source
+}
+
+// SetDrainTimeout sets the time given to the requests in progress on cancel
+func (source *HTTPSource) SetDrainTimeout(timeout time.Duration) {
+	source.drainTimeout = timeout
+}
+
+// WithResources tracks the listener and the requests in progress
+func (source *HTTPSource) WithResources(resources *Resources) *HTTPSource {
+	source.resources = resources
+	return source
+}
+
+// WithTranscoder decodes the request parameters and encodes the replies with the source codec of the transcoder,
+// instead of json. The Content-Type of the reply is 'application/' with the codec name.
+func (source *HTTPSource) WithTranscoder(transcoder *Transcoder) *HTTPSource {
+	source.transcoder = transcoder
+	return source
+}
+
+// Serve the http requests until the context is cancelled.
+// On cancel, the requests in progress are given the drain timeout to finish.
+func (source *HTTPSource) Serve(ctx context.Context, handler Handler) error {
+	listener, err := listenPort(source.config.Port)
+	if err != nil {
+		return fmt.Errorf("listenPort: %w", err)
+	}
+	defer source.resources.Track("http", SocketResource, listener.Addr().String(), 0)()
+	return serveHTTP(ctx, listenTLS(listener, source.certificates), source.HTTPHandler(handler), source.drainTimeout)
+}
+
+// listenPort listens the tcp port on all interfaces
+func listenPort(port uint64) (net.Listener, error) {
+	listener, err := net.Listen("tcp", ":"+strconv.FormatUint(port, 10))
+	if err != nil {
+		return nil, fmt.Errorf("net.Listen: %w", err)
+	}
+	return listener, nil
+}
+
+// listenTLS wraps the listener with the tls if there are the certificates
+func listenTLS(listener net.Listener, certificates *TLSCertificates) net.Listener {
+	if certificates == nil {
+		return listener
+	}
+	return tls.NewListener(listener, certificates.ServerConfig())
+}
+
+// serveHTTP runs the http server on the listener until the context is cancelled.
+// The context is the base of the request contexts, so the long living requests could stop with it.
+// On cancel, the requests in progress are given the drain timeout to finish.
+func serveHTTP(ctx context.Context, listener net.Listener, handler http.Handler, drainTimeout time.Duration) error {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return fmt.Errorf("server.Serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("server.Shutdown: %w", err)
+	}
+	if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server.Serve: %w", err)
+	}
+	return nil
+}
+
+// HTTPHandler converts the http requests to the proxy requests.
+// Use it to mount the source into the existing http server.
+func (source *HTTPSource) HTTPHandler(handler Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, source.prefix) {
+			http.NotFound(w, r)
+			return
+		}
+		command := strings.Trim(r.URL.Path[len(source.prefix):], "/")
+		if len(command) == 0 {
+			http.Error(w, "missing command in the path", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, source.config.MaxRequestSize))
+		if err != nil {
+			http.Error(w, "request body exceeds the limit", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		codec := source.transcoder.SourceCodec()
+		req := &Request{Command: command, Parameters: map[string]interface{}{}}
+		if len(body) > 0 {
+			if err := codec.Unmarshal(body, &req.Parameters); err != nil {
+				http.Error(w, fmt.Sprintf("body is not a %s object", codec.Name()), http.StatusBadRequest)
+				return
+			}
+			if req.Parameters == nil {
+				req.Parameters = map[string]interface{}{}
+			}
+		}
+
+		if _, ok := req.Parameters[AuthParam]; !ok {
+			if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+				req.Parameters[AuthParam] = auth[7:]
+			}
+		}
+
+		envelope := NewEnvelope(req)
+		envelope.Principal = CertificatePrincipal(r.TLS)
+		if id := r.Header.Get("X-Request-Id"); len(id) > 0 {
+			envelope.Id = id
+		}
+		if trace, err := ParseTraceparent(r.Header.Get(TraceparentHeader)); err == nil {
+			InjectTrace(envelope, trace)
+		}
+		release := source.resources.Track("http", GoroutineResource, command, DefaultRequestMaxAge)
+		reply := handler(envelope)
+		release()
+		if reply == nil {
+			reply = Fail(fmt.Sprintf("no reply to '%s'", command))
+		}
+
+		data, err := source.transcoder.EncodeReply(reply)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/"+codec.Name())
+		_, _ = w.Write(data)
+	})
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// httpCall sends the POST request to the handler, returning the recorded response
+func httpCall(handler http.Handler, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestNewHTTPSource(t *testing.T) {
+	if _, err := NewHTTPSource(HTTPSourceConfig{}); err == nil {
+		t.Fatalf("expected an error without the port")
+	}
+	if _, err := NewHTTPSource(HTTPSourceConfig{Port: 8080, TLS: &TLSConfig{}}); err == nil {
+		t.Fatalf("expected an error for the tls without the files")
+	}
+
+	source, err := NewHTTPSource(HTTPSourceConfig{Port: 8080, PathPrefix: "/api/"})
+	if err != nil {
+		t.Fatalf("NewHTTPSource: %v", err)
+	}
+	if source.prefix != "/api/" || source.config.MaxRequestSize != DefaultMaxRequestSize {
+		t.Fatalf("unexpected source '%s' %d", source.prefix, source.config.MaxRequestSize)
+	}
+	if source, _ = NewHTTPSource(HTTPSourceConfig{Port: 8080}); source.prefix != "/" {
+		t.Fatalf("expected the root prefix, got '%s'", source.prefix)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	source, err := NewHTTPSource(HTTPSourceConfig{Port: 8080, PathPrefix: "api", MaxRequestSize: 64})
+	if err != nil {
+		t.Fatalf("NewHTTPSource: %v", err)
+	}
+	var received *Envelope
+	handler := source.HTTPHandler(func(req *Envelope) *Reply {
+		received = req
+		if req.Command == "missing" {
+			return nil
+		}
+		return Ok(map[string]interface{}{"user": req.Parameters["user"]})
+	})
+
+	w := httpCall(handler, "/api/get-user", `{"user":"alice"}`, map[string]string{
+		"Authorization":   "Bearer secret",
+		"X-Request-Id":    "r1",
+		TraceparentHeader: "00-" + testTraceId + "-" + testSpanId + "-01",
+	})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected the json reply, got %d '%s'", w.Code, w.Header().Get("Content-Type"))
+	}
+	var reply Reply
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil || !reply.IsOK() || reply.Parameters["user"] != "alice" {
+		t.Fatalf("unexpected reply '%s'", w.Body.String())
+	}
+	if received.Command != "get-user" || received.Id != "r1" || received.Parameters[AuthParam] != "secret" {
+		t.Fatalf("unexpected envelope %+v", received)
+	}
+	if received.TraceId != testTraceId || received.SpanId != testSpanId {
+		t.Fatalf("expected the trace of the header, got '%s' '%s'", received.TraceId, received.SpanId)
+	}
+
+	// the auth parameter of the body is kept
+	httpCall(handler, "/api/get-user", `{"`+AuthParam+`":"body"}`, map[string]string{"Authorization": "Bearer header"})
+	if auth := received.Parameters[AuthParam]; auth != "body" {
+		t.Fatalf("expected the body auth to be kept, got %v", auth)
+	}
+
+	// the empty body has no parameters
+	httpCall(handler, "/api/list", "", nil)
+	if received.Command != "list" || received.Parameters == nil || len(received.Parameters) != 0 {
+		t.Fatalf("expected the empty parameters, got %+v", received.Parameters)
+	}
+	httpCall(handler, "/api/list", "null", nil)
+	if received.Parameters == nil {
+		t.Fatalf("expected the null body to have the empty parameters")
+	}
+
+	w = httpCall(handler, "/api/missing", "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil || reply.IsOK() || reply.Message != "no reply to 'missing'" {
+		t.Fatalf("expected the fail reply without the reply, got '%s'", w.Body.String())
+	}
+}
+
+func TestHTTPHandlerErrors(t *testing.T) {
+	source, err := NewHTTPSource(HTTPSourceConfig{Port: 8080, PathPrefix: "/api", MaxRequestSize: 16})
+	if err != nil {
+		t.Fatalf("NewHTTPSource: %v", err)
+	}
+	handler := source.HTTPHandler(func(req *Envelope) *Reply {
+		t.Fatalf("unexpected request '%s'", req.Command)
+		return nil
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/get", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Fatalf("expected the method not allowed, got %d", w.Code)
+	}
+
+	cases := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/other/get", "", http.StatusNotFound},
+		{"/api/", "", http.StatusNotFound},
+		{"/api/get", `{"user":"a very long name"}`, http.StatusRequestEntityTooLarge},
+		{"/api/get", `[1]`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if w := httpCall(handler, c.path, c.body, nil); w.Code != c.status {
+			t.Fatalf("expected %d for '%s' '%s', got %d", c.status, c.path, c.body, w.Code)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MemoryTransport passes the requests over the channels within the same process.
+// The proxy serves it as a source, while the clients call Send.
+// Since it implements both transports, it's also the destination of the previous proxy in the chain.
+type MemoryTransport struct {
+	calls  chan *memoryCall
+	closed chan struct{}
+	once   sync.Once
+}
+
+type memoryCall struct {
+	req   *Envelope
+	reply chan *Reply
+}
+
+// NewMemoryTransport returns the transport with the queue of the given size
+func NewMemoryTransport(queueSize int) *MemoryTransport {
+	return &MemoryTransport{
+		calls:  make(chan *memoryCall, queueSize),
+		closed: make(chan struct{}),
+	}
+}
+
+// Serve passes the requests to the handler, each in its own goroutine.
+// Returns nil when the context is cancelled or the transport is closed.
+func (transport *MemoryTransport) Serve(ctx context.Context, handler Handler) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-transport.closed:
+			return nil
+		case call := <-transport.calls:
+			go func() {
+				call.reply <- handler(call.req)
+			}()
+		}
+	}
+}
+
+// Send the request to the proxy and wait for the reply
+func (transport *MemoryTransport) Send(ctx context.Context, req *Envelope) (*Reply, error) {
+	call := &memoryCall{req: req, reply: make(chan *Reply, 1)}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-transport.closed:
+		return nil, fmt.Errorf("transport closed")
+	case transport.calls <- call:
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case reply := <-call.reply:
+		return reply, nil
+	}
+}
+
+// Close the transport. The waiting Serve returns
+func (transport *MemoryTransport) Close() error {
+	transport.once.Do(func() {
+		close(transport.closed)
+	})
+	return nil
+}
+
+// HandlerDestination calls the handler as the destination.
+// Used to embed the destination service into the same process with the proxy.
+type HandlerDestination struct {
+	handler Handler
+}
+
+// NewHandlerDestination returns the destination that calls the handler directly
+func NewHandlerDestination(handler Handler) *HandlerDestination {
+	return &HandlerDestination{handler: handler}
+}
+
+// Send the request to the handler.
+// The handler is not interrupted by the context, but its reply is dropped.
+func (destination *HandlerDestination) Send(ctx context.Context, req *Envelope) (*Reply, error) {
+	done := make(chan *Reply, 1)
+	go func() {
+		done <- destination.handler(req)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case reply := <-done:
+		return reply, nil
+	}
+}
+
+// Close does nothing, the handler has no resources
+func (destination *HandlerDestination) Close() error {
+	return nil
+}
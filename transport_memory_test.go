@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryTransport(t *testing.T) {
+	transport := NewMemoryTransport(1)
+	served := make(chan error, 1)
+	go func() {
+		served <- transport.Serve(context.Background(), func(req *Envelope) *Reply {
+			return Ok(map[string]interface{}{"command": req.Command})
+		})
+	}()
+
+	reply, err := transport.Send(context.Background(), policyRequest("get", nil))
+	if err != nil || !reply.IsOK() || reply.Parameters["command"] != "get" {
+		t.Fatalf("expected the reply of the handler, got %+v %v", reply, err)
+	}
+
+	if err := transport.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// closing twice is fine
+	if err := transport.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Serve to return on close")
+	}
+	if _, err := transport.Send(context.Background(), policyRequest("get", nil)); err == nil {
+		t.Fatalf("expected an error after close")
+	}
+}
+
+func TestMemoryTransportContext(t *testing.T) {
+	transport := NewMemoryTransport(0)
+
+	// nobody serves the transport
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := transport.Send(ctx, policyRequest("get", nil)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline error, got %v", err)
+	}
+
+	serveCtx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	release := make(chan struct{})
+	go func() {
+		served <- transport.Serve(serveCtx, func(req *Envelope) *Reply {
+			<-release
+			return Ok(nil)
+		})
+	}()
+	defer close(release)
+
+	// the handler is too slow
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := transport.Send(ctx, policyRequest("get", nil)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline error while waiting for the reply, got %v", err)
+	}
+
+	stop()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Serve to return on cancel")
+	}
+}
+
+func TestHandlerDestination(t *testing.T) {
+	release := make(chan struct{})
+	destination := NewHandlerDestination(func(req *Envelope) *Reply {
+		if req.Command == "slow" {
+			<-release
+		}
+		return Ok(map[string]interface{}{"command": req.Command})
+	})
+	defer close(release)
+
+	reply, err := destination.Send(context.Background(), policyRequest("get", nil))
+	if err != nil || reply.Parameters["command"] != "get" {
+		t.Fatalf("expected the reply of the handler, got %+v %v", reply, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := destination.Send(ctx, policyRequest("slow", nil)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline error, got %v", err)
+	}
+	if err := destination.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
@@ -0,0 +1,475 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// tcpHeaderSize is the length of the tcp message header: the body length and the call id
+const tcpHeaderSize = 12
+
+// DefaultTCPInFlight is the limit of the parallel requests per tcp connection
+const DefaultTCPInFlight = 64
+
+// DefaultDialTimeout limits the connecting to the tcp destination
+const DefaultDialTimeout = 5 * time.Second
+
+// TCPSourceConfig is the setting of the tcp source
+type TCPSourceConfig struct {
+	Port uint64 `json:"port" yaml:"port"`
+	// MaxMessageSize is the limit of the client message in bytes
+	MaxMessageSize int64 `json:"max_message_size,omitempty" yaml:"max_message_size,omitempty"`
+	// MaxInFlight is the limit of the requests handled in parallel per connection. Zero means DefaultTCPInFlight
+	MaxInFlight int `json:"max_in_flight,omitempty" yaml:"max_in_flight,omitempty"`
+}
+
+// tcpMessage is the message of the tcp transport.
+// On the wire, it's the big endian 4 bytes body length, 8 bytes call id, then the body:
+// the json or the binary frame.
+// The reply has the call id of the request, so the requests of the connection are replied out of order.
+type tcpMessage struct {
+	call uint64
+	body []byte
+}
+
+// readTCPMessage reads the message, the body over the limit is an error
+func readTCPMessage(reader io.Reader, limit int64) (*tcpMessage, error) {
+	var header [tcpHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if limit > 0 && int64(size) > limit {
+		return nil, fmt.Errorf("message of %d bytes exceeds %d bytes", size, limit)
+	}
+	message := &tcpMessage{call: binary.BigEndian.Uint64(header[4:]), body: make([]byte, size)}
+	if _, err := io.ReadFull(reader, message.body); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// tcpConn writes the messages of the parallel requests one by one
+type tcpConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+// write the message with the header
+func (conn *tcpConn) write(call uint64, body []byte) error {
+	data := make([]byte, tcpHeaderSize+len(body))
+	binary.BigEndian.PutUint32(data[:4], uint32(len(body)))
+	binary.BigEndian.PutUint64(data[4:tcpHeaderSize], call)
+	copy(data[tcpHeaderSize:], body)
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	_, err := conn.Conn.Write(data)
+	return err
+}
+
+// TCPSource accepts the length-prefixed json envelopes over the plain tcp.
+// It needs only the standard library, so the proxy built with the 'nozmq' tag needs neither cgo nor libzmq,
+// for example on Windows or when cross-compiling.
+// The requests of the connection are handled in parallel, so the replies could come out of order.
+//
+// With the command registry, the source also accepts the binary frames in the hybrid mode.
+// The json body starts with '{', while the frame starts with the FrameVersion.
+// The successful reply with only the binary PayloadParam is sent back as the frame of the same command,
+// other replies are sent as json, so their parameters are kept.
+//
+// With the Reorder, the replies of the connection are sent in the order of the requests.
+type TCPSource struct {
+	config     TCPSourceConfig
+	registry   *CommandRegistry
+	resources  *Resources
+	reorder    *Reorder
+	transcoder *Transcoder
+}
+
+// NewTCPSource returns the tcp source
+func NewTCPSource(config TCPSourceConfig) (*TCPSource, error) {
+	if config.Port == 0 {
+		return nil, fmt.Errorf("no port")
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = DefaultMaxRequestSize
+	}
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = DefaultTCPInFlight
+	}
+	return &TCPSource{config: config}, nil
+}
+
+// WithFrames accepts the binary frames of the commands in the registry
+func (source *TCPSource) WithFrames(registry *CommandRegistry) *TCPSource {
+	source.registry = registry
+	return source
+}
+
+// WithResources tracks the listener, the connections and the requests in progress
+func (source *TCPSource) WithResources(resources *Resources) *TCPSource {
+	source.resources = resources
+	return source
+}
+
+// WithReorder sends the replies of each connection in the order of its requests
+func (source *TCPSource) WithReorder(reorder *Reorder) *TCPSource {
+	source.reorder = reorder
+	return source
+}
+
+// WithTranscoder decodes the envelopes and encodes the replies with the source codec of the transcoder,
+// instead of json
+func (source *TCPSource) WithTranscoder(transcoder *Transcoder) *TCPSource {
+	source.transcoder = transcoder
+	return source
+}
+
+// Serve the tcp connections until the context is cancelled.
+// The connections are closed on cancel.
+func (source *TCPSource) Serve(ctx context.Context, handler Handler) error {
+	listener, err := listenPort(source.config.Port)
+	if err != nil {
+		return fmt.Errorf("listenPort: %w", err)
+	}
+	return source.serve(ctx, listener, handler)
+}
+
+// serve accepts the connections of the listener until the context is cancelled
+func (source *TCPSource) serve(ctx context.Context, listener net.Listener, handler Handler) error {
+	defer source.resources.Track("tcp", SocketResource, listener.Addr().String(), 0)()
+	source.resources.Go("tcp", "close listener", 0, func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	})
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("listener.Accept: %w", err)
+		}
+
+		wg.Add(1)
+		source.resources.Go("tcp", "connection "+conn.RemoteAddr().String(), 0, func() {
+			defer wg.Done()
+			defer source.resources.Track("tcp", SocketResource, conn.RemoteAddr().String(), 0)()
+			source.serveConn(ctx, &tcpConn{Conn: conn}, handler)
+		})
+	}
+}
+
+// serveConn reads the messages until the connection or the context is closed
+func (source *TCPSource) serveConn(ctx context.Context, conn *tcpConn, handler Handler) {
+	ctx, cancel := context.WithCancel(ctx)
+	source.resources.Go("tcp", "close connection", 0, func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	})
+
+	client := "tcp " + conn.RemoteAddr().String()
+	if source.reorder != nil {
+		defer source.reorder.Forget(client)
+	}
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	reader := bufio.NewReader(conn)
+	slots := make(chan struct{}, source.config.MaxInFlight)
+	for {
+		message, err := readTCPMessage(reader, source.config.MaxMessageSize)
+		if err != nil {
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		sequence, ordered := uint64(0), false
+		if source.reorder != nil {
+			sequence, ordered = source.reorder.Next(client)
+		}
+		wg.Add(1)
+		source.resources.Go("tcp", "request", DefaultRequestMaxAge, func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			body, err := source.handle(message, handler)
+			if err != nil {
+				body, _ = source.transcoder.EncodeReply(Fail(err.Error()))
+			}
+			send := func() { _ = conn.write(message.call, body) }
+			if ordered {
+				source.reorder.Done(client, sequence, send)
+			} else {
+				send()
+			}
+		})
+	}
+}
+
+// handle returns the reply body of the message
+func (source *TCPSource) handle(message *tcpMessage, handler Handler) ([]byte, error) {
+	if source.registry != nil && len(message.body) > 0 && message.body[0] == FrameVersion {
+		return source.handleFrame(message, handler)
+	}
+	envelope, err := source.transcoder.DecodeRequest(message.body)
+	if err != nil {
+		return nil, fmt.Errorf("transcoder.DecodeRequest: %w", err)
+	}
+	return source.transcoder.EncodeReply(handler(envelope))
+}
+
+// handleFrame passes the frame to the handler without decoding the payload
+func (source *TCPSource) handleFrame(message *tcpMessage, handler Handler) ([]byte, error) {
+	frame, err := DecodeFrame(message.body)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeFrame: %w", err)
+	}
+	envelope, err := source.registry.Envelope(frame)
+	if err != nil {
+		return nil, fmt.Errorf("registry.Envelope: %w", err)
+	}
+	reply := handler(envelope)
+	if reply.IsOK() {
+		if payload, err := framePayload(reply.Parameters); err == nil {
+			replied := Frame{FrameHeader: frame.FrameHeader, Payload: payload}
+			return replied.Encode(), nil
+		}
+	}
+	return source.transcoder.EncodeReply(reply)
+}
+
+// TCPDestination sends the envelopes to the TCPSource of the next proxy or the service.
+// The requests share one connection, which is dialed on the first request,
+// and again on the next request after it's lost.
+//
+// With the command registry, the envelopes with only the binary PayloadParam are sent as the frames,
+// so the payload is never encoded as json. The frame reply is the successful reply with the payload.
+type TCPDestination struct {
+	address    string
+	limit      int64
+	registry   *CommandRegistry
+	resources  *Resources
+	transcoder *Transcoder
+
+	mu      sync.Mutex
+	conn    *tcpConn
+	calls   uint64
+	pending map[uint64]chan *tcpMessage
+	closed  bool
+}
+
+// NewTCPDestination returns the destination at the address, for example 'localhost:8080'
+func NewTCPDestination(address string) *TCPDestination {
+	return &TCPDestination{
+		address: address,
+		limit:   DefaultMaxRequestSize,
+		pending: make(map[uint64]chan *tcpMessage),
+	}
+}
+
+// WithFrames sends the commands in the registry as the binary frames
+func (destination *TCPDestination) WithFrames(registry *CommandRegistry) *TCPDestination {
+	destination.registry = registry
+	return destination
+}
+
+// WithResources tracks the connection and its reading goroutine
+func (destination *TCPDestination) WithResources(resources *Resources) *TCPDestination {
+	destination.resources = resources
+	return destination
+}
+
+// WithMaxReplySize limits the reply in bytes. The connection with the reply over the limit is dropped.
+// By default, it's DefaultMaxRequestSize.
+func (destination *TCPDestination) WithMaxReplySize(limit int64) *TCPDestination {
+	if limit > 0 {
+		destination.limit = limit
+	}
+	return destination
+}
+
+// WithTranscoder encodes the envelopes and decodes the replies with the destination codec of their command,
+// instead of json
+func (destination *TCPDestination) WithTranscoder(transcoder *Transcoder) *TCPDestination {
+	destination.transcoder = transcoder
+	return destination
+}
+
+// encode returns the frame of the binary payload, otherwise the encoded envelope
+func (destination *TCPDestination) encode(req *Envelope) ([]byte, error) {
+	if destination.registry != nil {
+		if frame, err := destination.registry.Frame(req); err == nil {
+			return frame.Encode(), nil
+		}
+	}
+	return destination.transcoder.EncodeRequest(req)
+}
+
+// connect returns the connection, dialing it if necessary.
+// The dial is done without the lock, so the slow destination doesn't block Close and the waiting replies.
+// If the connections are dialed at once, then the first one is kept.
+func (destination *TCPDestination) connect(ctx context.Context) (*tcpConn, error) {
+	destination.mu.Lock()
+	closed, conn := destination.closed, destination.conn
+	destination.mu.Unlock()
+	if closed {
+		return nil, fmt.Errorf("destination closed")
+	}
+	if conn != nil {
+		return conn, nil
+	}
+
+	dialer := net.Dialer{Timeout: DefaultDialTimeout}
+	dialed, err := dialer.DialContext(ctx, "tcp", destination.address)
+	if err != nil {
+		return nil, fmt.Errorf("dialer.DialContext('%s'): %w", destination.address, err)
+	}
+
+	destination.mu.Lock()
+	defer destination.mu.Unlock()
+	if destination.closed {
+		_ = dialed.Close()
+		return nil, fmt.Errorf("destination closed")
+	}
+	if destination.conn != nil {
+		_ = dialed.Close()
+		return destination.conn, nil
+	}
+	destination.conn = &tcpConn{Conn: dialed}
+	established := destination.conn
+	destination.resources.Go("tcp", "destination "+destination.address, 0, func() {
+		defer destination.resources.Track("tcp", SocketResource, destination.address, 0)()
+		destination.read(established)
+	})
+	return established, nil
+}
+
+// read passes the replies to the waiting requests until the connection is lost.
+// Then the waiting requests fail.
+func (destination *TCPDestination) read(conn *tcpConn) {
+	reader := bufio.NewReader(conn)
+	for {
+		message, err := readTCPMessage(reader, destination.limit)
+		if err != nil {
+			break
+		}
+
+		destination.mu.Lock()
+		wait, ok := destination.pending[message.call]
+		delete(destination.pending, message.call)
+		destination.mu.Unlock()
+		if ok {
+			wait <- message
+		}
+	}
+
+	_ = conn.Close()
+	destination.mu.Lock()
+	if destination.conn == conn {
+		destination.conn = nil
+		for call, wait := range destination.pending {
+			close(wait)
+			delete(destination.pending, call)
+		}
+	}
+	destination.mu.Unlock()
+}
+
+// send writes the body and waits for the reply body
+func (destination *TCPDestination) send(ctx context.Context, body []byte) (*tcpMessage, error) {
+	wait := make(chan *tcpMessage, 1)
+
+	conn, err := destination.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	destination.mu.Lock()
+	// the connection was lost after it's returned, so its waiting calls are already failed
+	if destination.conn != conn {
+		destination.mu.Unlock()
+		return nil, fmt.Errorf("connection to '%s' lost", destination.address)
+	}
+	destination.calls++
+	call := destination.calls
+	destination.pending[call] = wait
+	destination.mu.Unlock()
+
+	if err := conn.write(call, body); err != nil {
+		_ = conn.Close()
+		destination.forget(call)
+		return nil, fmt.Errorf("conn.write: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		destination.forget(call)
+		return nil, ctx.Err()
+	case message, ok := <-wait:
+		if !ok {
+			return nil, fmt.Errorf("connection to '%s' lost", destination.address)
+		}
+		return message, nil
+	}
+}
+
+// forget the call that is not waited anymore
+func (destination *TCPDestination) forget(call uint64) {
+	destination.mu.Lock()
+	delete(destination.pending, call)
+	destination.mu.Unlock()
+}
+
+// Send the request and wait for the reply
+func (destination *TCPDestination) Send(ctx context.Context, req *Envelope) (*Reply, error) {
+	body, err := destination.encode(req)
+	if err != nil {
+		return nil, fmt.Errorf("destination.encode: %w", err)
+	}
+	message, err := destination.send(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(message.body) > 0 && message.body[0] == FrameVersion {
+		frame, err := DecodeFrame(message.body)
+		if err != nil {
+			return nil, fmt.Errorf("DecodeFrame: %w", err)
+		}
+		return Ok(map[string]interface{}{PayloadParam: frame.Payload, BinaryParam: true}), nil
+	}
+	reply, err := destination.transcoder.DecodeReply(req.Command, message.body)
+	if err != nil {
+		return nil, fmt.Errorf("transcoder.DecodeReply: %w", err)
+	}
+	return reply, nil
+}
+
+// Close the connection. The waiting requests fail
+func (destination *TCPDestination) Close() error {
+	destination.mu.Lock()
+	defer destination.mu.Unlock()
+
+	destination.closed = true
+	if destination.conn == nil {
+		return nil
+	}
+	return destination.conn.Close()
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestTCPOutOfOrderReplies checks that the parallel requests of one connection get their own replies,
+// even if the later requests finish first
+func TestTCPOutOfOrderReplies(t *testing.T) {
+	source, err := NewTCPSource(TCPSourceConfig{Port: 1})
+	if err != nil {
+		t.Fatalf("NewTCPSource: %v", err)
+	}
+	const requests = 10
+	address := serveTCP(t, source, func(req *Envelope) *Reply {
+		index, _ := req.Parameters["index"].(float64)
+		time.Sleep(time.Duration(requests-index) * time.Millisecond)
+		return Ok(map[string]interface{}{"index": index})
+	})
+	destination := NewTCPDestination(address)
+	defer destination.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := NewEnvelope(&Request{Command: "echo", Parameters: map[string]interface{}{"index": i}})
+			reply, err := destination.Send(context.Background(), req)
+			if err != nil {
+				t.Errorf("Send: %v", err)
+				return
+			}
+			if fmt.Sprint(reply.Parameters["index"]) != fmt.Sprint(i) {
+				t.Errorf("request %d got the reply of %v", i, reply.Parameters["index"])
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestTCPCloseFailsPending checks that Close fails the requests waiting for the reply
+func TestTCPCloseFailsPending(t *testing.T) {
+	source, err := NewTCPSource(TCPSourceConfig{Port: 1})
+	if err != nil {
+		t.Fatalf("NewTCPSource: %v", err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	entered := make(chan struct{}, 1)
+	address := serveTCP(t, source, func(req *Envelope) *Reply {
+		entered <- struct{}{}
+		<-release
+		return Ok(nil)
+	})
+	destination := NewTCPDestination(address)
+
+	failed := make(chan error, 1)
+	go func() {
+		_, err := destination.Send(context.Background(), NewEnvelope(&Request{Command: "slow", Parameters: map[string]interface{}{}}))
+		failed <- err
+	}()
+	<-entered
+	_ = destination.Close()
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Fatalf("the pending request succeeded after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the pending request was not failed by Close")
+	}
+}
+
+// TestTCPDestinationReplyLimit checks that the reply over the configured limit fails the request
+func TestTCPDestinationReplyLimit(t *testing.T) {
+	source, err := NewTCPSource(TCPSourceConfig{Port: 1})
+	if err != nil {
+		t.Fatalf("NewTCPSource: %v", err)
+	}
+	address := serveTCP(t, source, func(req *Envelope) *Reply {
+		return Ok(map[string]interface{}{"data": strings.Repeat("x", 1000)})
+	})
+
+	limited := NewTCPDestination(address).WithMaxReplySize(100)
+	defer limited.Close()
+	if _, err := limited.Send(context.Background(), NewEnvelope(&Request{Command: "get", Parameters: map[string]interface{}{}})); err == nil {
+		t.Fatalf("the reply over the limit was accepted")
+	}
+
+	destination := NewTCPDestination(address)
+	defer destination.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := destination.Send(context.Background(), NewEnvelope(&Request{Command: "get", Parameters: map[string]interface{}{}})); err != nil {
+				t.Errorf("Send: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
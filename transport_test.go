@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// contextTransport is the destination transport of the tests that sees the context of the request
+type contextTransport struct {
+	send   func(ctx context.Context, req *Envelope) (*Reply, error)
+	closed int
+}
+
+func (transport *contextTransport) Send(ctx context.Context, req *Envelope) (*Reply, error) {
+	return transport.send(ctx, req)
+}
+
+func (transport *contextTransport) Close() error {
+	transport.closed++
+	return nil
+}
+
+// handlerSource passes the requests to the handler once, then fails with the error
+type handlerSource struct {
+	requests []*Envelope
+	replies  []*Reply
+	err      error
+}
+
+func (source *handlerSource) Serve(_ context.Context, handler Handler) error {
+	for _, req := range source.requests {
+		source.replies = append(source.replies, handler(req))
+	}
+	return source.err
+}
+
+func TestForwardDeadline(t *testing.T) {
+	var deadline time.Time
+	var hasDeadline bool
+	destination := &contextTransport{send: func(ctx context.Context, req *Envelope) (*Reply, error) {
+		deadline, hasDeadline = ctx.Deadline()
+		return Ok(nil), nil
+	}}
+	forward := Forward(destination)
+
+	if reply := forward(policyRequest("get", nil)); !reply.IsOK() || hasDeadline {
+		t.Fatalf("expected the request without the ttl to have no deadline")
+	}
+
+	req := policyRequest("get", nil)
+	req.Timestamp = time.Now().UnixMilli()
+	req.Ttl = 1000
+	forward(req)
+	if !hasDeadline || !deadline.Equal(time.UnixMilli(req.Timestamp+1000)) {
+		t.Fatalf("expected the deadline after the ttl, got %s", deadline)
+	}
+
+	// the ttl without the timestamp has no start
+	req = policyRequest("get", nil)
+	req.Ttl = 1000
+	if forward(req); hasDeadline {
+		t.Fatalf("expected the request without the timestamp to have no deadline")
+	}
+}
+
+func TestForwardError(t *testing.T) {
+	forward := Forward(funcTransport(func(req *Envelope) (*Reply, error) {
+		return nil, fmt.Errorf("unreachable")
+	}))
+	reply := forward(policyRequest("get", nil))
+	if reply.IsOK() || reply.Message != "destination.Send: unreachable" {
+		t.Fatalf("expected the transport error as the fail reply, got %+v", reply)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	destination := &contextTransport{send: func(ctx context.Context, req *Envelope) (*Reply, error) {
+		return Ok(map[string]interface{}{"command": req.Command}), nil
+	}}
+	source := &handlerSource{requests: []*Envelope{policyRequest("get", nil)}}
+	marked := func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			reply := next(req).Copy()
+			reply.Parameters["marked"] = true
+			return reply
+		}
+	}
+
+	if err := Pipe(context.Background(), source, destination, marked); err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	if reply := source.replies[0]; !reply.IsOK() || reply.Parameters["command"] != "get" || reply.Parameters["marked"] != true {
+		t.Fatalf("expected the reply through the middleware, got %+v", reply)
+	}
+	if destination.closed != 1 {
+		t.Fatalf("expected the destination to be closed, closed %d times", destination.closed)
+	}
+
+	source = &handlerSource{err: fmt.Errorf("listen")}
+	if err := Pipe(context.Background(), source, destination); err == nil {
+		t.Fatalf("expected the source error")
+	}
+	if destination.closed != 2 {
+		t.Fatalf("expected the destination to be closed on the error")
+	}
+}
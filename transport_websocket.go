@@ -0,0 +1,530 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SubscribeCommand subscribes the websocket connection to the 'topic' of the destination.
+// The events are pushed to the connection until it's closed.
+const SubscribeCommand = "proxy.subscribe"
+
+// websocketGuid is appended to the client key in the handshake by RFC 6455
+const websocketGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The websocket opcodes
+const (
+	wsContinuation byte = 0x0
+	wsText         byte = 0x1
+	wsBinary       byte = 0x2
+	wsClose        byte = 0x8
+	wsPing         byte = 0x9
+	wsPong         byte = 0xA
+)
+
+// WebSocketSourceConfig is the setting of the websocket source
+type WebSocketSourceConfig struct {
+	Port uint64 `json:"port" yaml:"port"`
+	// Path of the websocket endpoint. Empty means any path
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// MaxMessageSize is the limit of the client message in bytes
+	MaxMessageSize int64 `json:"max_message_size,omitempty" yaml:"max_message_size,omitempty"`
+	// MaxInFlight is the limit of the requests handled in parallel per connection.
+	// The connection is not read while it's full. Zero means DefaultWebSocketInFlight
+	MaxInFlight int `json:"max_in_flight,omitempty" yaml:"max_in_flight,omitempty"`
+	// MaxSubscriptions is the limit of the topics per connection. Zero means DefaultWebSocketSubscriptions
+	MaxSubscriptions int `json:"max_subscriptions,omitempty" yaml:"max_subscriptions,omitempty"`
+	// TLS serves the wss. Optional
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+	// AllowedOrigins are the origins of the browser pages allowed to connect, for example 'https://app.example.com'.
+	// '*' allows any origin. Empty means only the page served from the same host.
+	// The clients without the Origin header are not browsers, so they are not checked.
+	AllowedOrigins []string `json:"allowed_origins,omitempty" yaml:"allowed_origins,omitempty"`
+	// IdleTimeout closes the connection that sends nothing, not even the pong, for that long.
+	// The source pings the connection at the half of it. Zero means DefaultWebSocketIdleTimeout
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+}
+
+// The limits of the websocket connection
+const (
+	DefaultWebSocketInFlight      = 64
+	DefaultWebSocketSubscriptions = 16
+	DefaultWebSocketIdleTimeout   = time.Minute
+)
+
+// WebSocketSource accepts the browser connections.
+// Each message is the json envelope, and each reply is sent back as json with the envelope id.
+// The requests of the connection are handled in parallel, so the replies could come out of order.
+//
+// If the source has the subscriber, then the clients could subscribe to the destination topics
+// with the SubscribeCommand, and the events are pushed to them as Event json.
+// The SubscribeCommand passes the handler like any other request, so the middlewares authorize it,
+// and the topic is subscribed only if the reply is successful.
+// Therefore, the destination must accept the SubscribeCommand of the topics the clients may read.
+//
+// With the mutual tls, the principal of the verified client certificate is the Principal of the envelopes.
+// The browser sends the client certificate even if the connection is opened by another site's page,
+// so the upgrade from the origin that is not allowed is rejected.
+//
+// With the Reorder, the replies of the connection are sent in the order of the requests.
+// The events of the subscriptions are pushed as they come.
+type WebSocketSource struct {
+	config       WebSocketSourceConfig
+	subscriber   Subscriber
+	drainTimeout time.Duration
+	resources    *Resources
+	certificates *TLSCertificates
+	reorder      *Reorder
+	transcoder   *Transcoder
+}
+
+// webSocketReply is the reply with the id of the request it replies to
+type webSocketReply struct {
+	Id string `json:"id,omitempty"`
+	*Reply
+}
+
+// NewWebSocketSource returns the websocket source. The subscriber is optional
+func NewWebSocketSource(config WebSocketSourceConfig, subscriber Subscriber) (*WebSocketSource, error) {
+	if config.Port == 0 {
+		return nil, fmt.Errorf("no port")
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = DefaultMaxRequestSize
+	}
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = DefaultWebSocketInFlight
+	}
+	if config.MaxSubscriptions <= 0 {
+		config.MaxSubscriptions = DefaultWebSocketSubscriptions
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultWebSocketIdleTimeout
+	}
+	for i, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if parsed, err := url.Parse(origin); err != nil || len(parsed.Scheme) == 0 || len(parsed.Host) == 0 {
+			return nil, fmt.Errorf("allowed_origins[%d] '%s' is not the scheme and the host", i, origin)
+		}
+	}
+	source := &WebSocketSource{config: config, subscriber: subscriber, drainTimeout: DefaultDrainTimeout}
+	if config.TLS != nil {
+		certificates, err := NewFileCertificates(*config.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("NewFileCertificates: %w", err)
+		}
+		source.certificates = certificates
+	}
+	return source, nil
+}
+
+// WithCertificates serves the wss with the certificates, for example from the vault.
+// It replaces the certificates of the TLS config.
+func (source *WebSocketSource) WithCertificates(certificates *TLSCertificates) *WebSocketSource {
+	source.certificates = certificates
+	return source
+}
+
+// SetDrainTimeout sets the time given to the connections being upgraded on cancel
+func (source *WebSocketSource) SetDrainTimeout(timeout time.Duration) {
+	source.drainTimeout = timeout
+}
+
+// WithResources tracks the listener, the connections, the requests and the subscriptions
+func (source *WebSocketSource) WithResources(resources *Resources) *WebSocketSource {
+	source.resources = resources
+	return source
+}
+
+// WithReorder sends the replies of each connection in the order of its requests
+func (source *WebSocketSource) WithReorder(reorder *Reorder) *WebSocketSource {
+	source.reorder = reorder
+	return source
+}
+
+// WithTranscoder decodes the envelopes and encodes the replies and the events
+// with the source codec of the transcoder, instead of json.
+// The messages of the other codecs are sent as the binary messages.
+func (source *WebSocketSource) WithTranscoder(transcoder *Transcoder) *WebSocketSource {
+	source.transcoder = transcoder
+	return source
+}
+
+// Serve the websocket connections until the context is cancelled.
+// The connections are closed on cancel.
+func (source *WebSocketSource) Serve(ctx context.Context, handler Handler) error {
+	listener, err := listenPort(source.config.Port)
+	if err != nil {
+		return fmt.Errorf("listenPort: %w", err)
+	}
+	defer source.resources.Track("websocket", SocketResource, listener.Addr().String(), 0)()
+	return serveHTTP(ctx, listenTLS(listener, source.certificates), source.HTTPHandler(handler), source.drainTimeout)
+}
+
+// HTTPHandler upgrades the http requests to the websocket connections.
+// The connection lives until the request context is cancelled.
+func (source *WebSocketSource) HTTPHandler(handler Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(source.config.Path) > 0 && r.URL.Path != source.config.Path {
+			http.NotFound(w, r)
+			return
+		}
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if r.Method != http.MethodGet || len(key) == 0 ||
+			!headerContains(r.Header, "Connection", "upgrade") ||
+			!headerContains(r.Header, "Upgrade", "websocket") {
+			http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+			return
+		}
+		if !source.originAllowed(r) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "websocket is not supported by the server", http.StatusInternalServerError)
+			return
+		}
+		netConn, rw, err := hijacker.Hijack()
+		if err != nil {
+			return
+		}
+
+		accept := sha1.Sum([]byte(key + websocketGuid))
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+		if err := rw.Flush(); err != nil {
+			_ = netConn.Close()
+			return
+		}
+
+		conn := &wsConn{conn: netConn, reader: rw.Reader, limit: source.config.MaxMessageSize, idle: source.config.IdleTimeout}
+		defer source.resources.Track("websocket", SocketResource, netConn.RemoteAddr().String(), 0)()
+		source.serveConn(r.Context(), conn, CertificatePrincipal(r.TLS), handler)
+	})
+}
+
+// originAllowed returns true if the upgrade comes from the allowed origin, or not from the browser
+func (source *WebSocketSource) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	for _, allowed := range source.config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	if len(source.config.AllowedOrigins) > 0 {
+		return false
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, r.Host)
+}
+
+// serveConn reads the messages until the connection or the context is closed.
+// The principal of the client certificate is set to every envelope.
+func (source *WebSocketSource) serveConn(ctx context.Context, conn *wsConn, principal string, handler Handler) {
+	ctx, cancel := context.WithCancel(ctx)
+	source.resources.Go("websocket", "close connection", 0, func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	})
+	// the pong of the client extends the idle deadline of the connection that only receives the events
+	source.resources.Go("websocket", "ping", 0, func() {
+		ticker := time.NewTicker(conn.idle / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := conn.writeFrame(wsPing, nil); err != nil {
+					return
+				}
+			}
+		}
+	})
+
+	client := "websocket " + conn.conn.RemoteAddr().String()
+	if source.reorder != nil {
+		defer source.reorder.Forget(client)
+	}
+	// the subscriptions end only after the context is cancelled
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// the slots of the requests in flight and of the subscriptions
+	slots := make(chan struct{}, source.config.MaxInFlight)
+	subscriptions := make(chan struct{}, source.config.MaxSubscriptions)
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		sequence, ordered := uint64(0), false
+		if source.reorder != nil {
+			sequence, ordered = source.reorder.Next(client)
+		}
+		// reply is called once per request
+		reply := func(message webSocketReply) {
+			send := func() { _ = conn.write(source.transcoder, message) }
+			if ordered {
+				source.reorder.Done(client, sequence, send)
+			} else {
+				send()
+			}
+		}
+		untrack := source.resources.Track("websocket", GoroutineResource, "request", DefaultRequestMaxAge)
+		var once sync.Once
+		release := func() {
+			once.Do(func() {
+				untrack()
+				<-slots
+			})
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer release()
+
+			envelope, err := source.transcoder.DecodeRequest(data)
+			if err != nil {
+				reply(webSocketReply{Reply: Fail(fmt.Sprintf("transcoder.DecodeRequest: %v", err))})
+				return
+			}
+			envelope.Principal = principal
+			if envelope.Command == SubscribeCommand && source.subscriber != nil {
+				select {
+				case subscriptions <- struct{}{}:
+					source.subscribe(ctx, conn, envelope, handler, reply, release)
+					<-subscriptions
+				default:
+					reply(webSocketReply{Id: envelope.Id, Reply: Fail("too many subscriptions")})
+				}
+				return
+			}
+			reply(webSocketReply{Id: envelope.Id, Reply: handler(envelope)})
+		}()
+	}
+}
+
+// subscribe the connection to the topic, and push the events until the context is cancelled.
+// The request is authorized by the handler first.
+// The slot of the request is released once subscribed, since the subscription lives with the connection.
+func (source *WebSocketSource) subscribe(ctx context.Context, conn *wsConn, req *Envelope, handler Handler, reply func(webSocketReply), release func()) {
+	topic := req.StringParam("topic")
+	if len(topic) == 0 {
+		reply(webSocketReply{Id: req.Id, Reply: Fail("missing 'topic' parameter")})
+		return
+	}
+	if authorized := handler(req); authorized == nil || !authorized.IsOK() {
+		if authorized == nil {
+			authorized = Fail(fmt.Sprintf("no reply to '%s'", req.Command))
+		}
+		reply(webSocketReply{Id: req.Id, Reply: authorized})
+		return
+	}
+	events, err := source.subscriber.Subscribe(ctx, topic)
+	if err != nil {
+		reply(webSocketReply{Id: req.Id, Reply: Fail(fmt.Sprintf("subscriber.Subscribe: %v", err))})
+		return
+	}
+	reply(webSocketReply{Id: req.Id, Reply: Ok(nil)})
+	release()
+	defer source.resources.Track("websocket", GoroutineResource, "subscription "+topic, 0)()
+
+	for event := range events {
+		if err := conn.write(source.transcoder, event); err != nil {
+			return
+		}
+	}
+}
+
+// headerContains returns true if the comma separated header has the token
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is the server side of the websocket connection.
+// Only the reading is done in one goroutine, writing is safe from many.
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	limit  int64
+	// idle is the time to wait for the next frame. Zero means no limit
+	idle    time.Duration
+	writeMu sync.Mutex
+}
+
+// ReadMessage returns the payload of the next text or binary message.
+// The control frames are answered on the way.
+func (conn *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		final, opcode, payload, err := conn.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := conn.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			_ = conn.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		case wsText, wsBinary:
+			if message != nil {
+				return nil, fmt.Errorf("new message before the previous one finished")
+			}
+			message = payload
+		case wsContinuation:
+			if message == nil {
+				return nil, fmt.Errorf("continuation without a message")
+			}
+			if int64(len(message)+len(payload)) > conn.limit {
+				return nil, fmt.Errorf("message exceeds %d bytes", conn.limit)
+			}
+			message = append(message, payload...)
+		default:
+			return nil, fmt.Errorf("unknown opcode %d", opcode)
+		}
+
+		if final {
+			return message, nil
+		}
+	}
+}
+
+// readFrame returns the unmasked frame of the client
+func (conn *wsConn) readFrame() (bool, byte, []byte, error) {
+	if conn.idle > 0 {
+		if err := conn.conn.SetReadDeadline(time.Now().Add(conn.idle)); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	var head [2]byte
+	if _, err := io.ReadFull(conn.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	final := head[0]&0x80 != 0
+	opcode := head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, fmt.Errorf("client frame is not masked")
+	}
+
+	length := int64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(conn.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(conn.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(extended[:]) & (1<<63 - 1))
+	}
+	if length > conn.limit {
+		return false, 0, nil, fmt.Errorf("frame exceeds %d bytes", conn.limit)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(conn.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return final, opcode, payload, nil
+}
+
+// writeFrame sends the single unmasked frame
+func (conn *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	if _, err := conn.conn.Write(frame); err != nil {
+		return fmt.Errorf("conn.Write: %w", err)
+	}
+	return nil
+}
+
+// write sends the value encoded by the source codec of the transcoder.
+// The json is sent as the text message, the other codecs as the binary message.
+func (conn *wsConn) write(transcoder *Transcoder, value interface{}) error {
+	data, err := transcoder.EncodeReply(value)
+	if err != nil {
+		return fmt.Errorf("transcoder.EncodeReply: %w", err)
+	}
+	if transcoder.SourceCodec().Name() == JsonCodec {
+		return conn.writeFrame(wsText, data)
+	}
+	return conn.writeFrame(wsBinary, data)
+}
+
+// Close the connection
+func (conn *wsConn) Close() error {
+	return conn.conn.Close()
+}
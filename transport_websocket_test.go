@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mustDialWebSocket upgrades the connection, closed when the test ends
+func mustDialWebSocket(t testing.TB, address string) *wsClient {
+	client, err := dialWebSocket(address, "")
+	if err != nil {
+		t.Fatalf("dialWebSocket: %v", err)
+	}
+	t.Cleanup(func() { _ = client.conn.Close() })
+	return client
+}
+
+// send the message, failing the test on error
+func (client *wsClient) send(t testing.TB, message interface{}) {
+	if err := client.write(message); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// receive the message, failing the test on error
+func (client *wsClient) receive(t testing.TB, message interface{}) {
+	if err := client.read(message); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+}
+
+// TestWebSocketSubscribeIsAuthorized checks that the subscription passes the middlewares
+func TestWebSocketSubscribeIsAuthorized(t *testing.T) {
+	keys, err := NewStaticKeys(map[string]string{"secret": "alice"})
+	if err != nil {
+		t.Fatalf("NewStaticKeys: %v", err)
+	}
+	publisher := NewMemoryPublisher(1)
+	source, err := NewWebSocketSource(WebSocketSourceConfig{Port: 1}, publisher)
+	if err != nil {
+		t.Fatalf("NewWebSocketSource: %v", err)
+	}
+	handler := Wrap(func(req *Envelope) *Reply { return Ok(nil) }, WithAuth(keys))
+	client := mustDialWebSocket(t, serveLoopback(t, source.HTTPHandler(handler)))
+
+	client.send(t, Request{Command: SubscribeCommand, Parameters: map[string]interface{}{"topic": "prices"}})
+	var reply Reply
+	client.receive(t, &reply)
+	if reply.IsOK() {
+		t.Fatalf("the unauthenticated client subscribed")
+	}
+	if received := publisher.Publish("prices", nil); received != 0 {
+		t.Fatalf("the event was delivered to %d unauthorized subscribers", received)
+	}
+
+	client.send(t, Request{Command: SubscribeCommand, Parameters: map[string]interface{}{"topic": "prices", AuthParam: "secret"}})
+	client.receive(t, &reply)
+	if !reply.IsOK() {
+		t.Fatalf("the authenticated client was not subscribed: %s", reply.Message)
+	}
+	if received := publisher.Publish("prices", map[string]interface{}{"price": 1}); received != 1 {
+		t.Fatalf("the event was delivered to %d subscribers", received)
+	}
+	var event Event
+	client.receive(t, &event)
+	if event.Topic != "prices" {
+		t.Fatalf("received the event of '%s'", event.Topic)
+	}
+}
+
+// TestWebSocketBoundsInFlight checks that the connection handles at most MaxInFlight requests at once
+func TestWebSocketBoundsInFlight(t *testing.T) {
+	source, err := NewWebSocketSource(WebSocketSourceConfig{Port: 1, MaxInFlight: 2}, nil)
+	if err != nil {
+		t.Fatalf("NewWebSocketSource: %v", err)
+	}
+
+	var running, peak int32
+	release := make(chan struct{})
+	var once sync.Once
+	handler := func(req *Envelope) *Reply {
+		now := atomic.AddInt32(&running, 1)
+		for {
+			seen := atomic.LoadInt32(&peak)
+			if now <= seen || atomic.CompareAndSwapInt32(&peak, seen, now) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		return Ok(nil)
+	}
+	client := mustDialWebSocket(t, serveLoopback(t, source.HTTPHandler(handler)))
+	defer once.Do(func() { close(release) })
+
+	const requests = 10
+	for i := 0; i < requests; i++ {
+		client.send(t, Request{Command: "work", Parameters: map[string]interface{}{}})
+	}
+	time.Sleep(50 * time.Millisecond)
+	if seen := atomic.LoadInt32(&peak); seen > 2 {
+		t.Fatalf("%d requests ran at once over the limit of 2", seen)
+	}
+
+	once.Do(func() { close(release) })
+	for i := 0; i < requests; i++ {
+		var reply Reply
+		client.receive(t, &reply)
+		if !reply.IsOK() {
+			t.Fatalf("request failed: %s", reply.Message)
+		}
+	}
+}
+
+// TestWebSocketChecksOrigin checks that the browser page of the other site can't open the connection
+func TestWebSocketChecksOrigin(t *testing.T) {
+	handler := func(req *Envelope) *Reply { return Ok(nil) }
+
+	source, err := NewWebSocketSource(WebSocketSourceConfig{Port: 1}, nil)
+	if err != nil {
+		t.Fatalf("NewWebSocketSource: %v", err)
+	}
+	address := serveLoopback(t, source.HTTPHandler(handler))
+	if _, err := dialWebSocket(address, "https://evil.example.com"); err == nil {
+		t.Fatalf("the other site was upgraded without the allowed origins")
+	}
+	for _, origin := range []string{"", "http://" + address} {
+		client, err := dialWebSocket(address, origin)
+		if err != nil {
+			t.Fatalf("the origin '%s' was rejected: %v", origin, err)
+		}
+		_ = client.conn.Close()
+	}
+
+	source, err = NewWebSocketSource(WebSocketSourceConfig{Port: 1, AllowedOrigins: []string{"https://app.example.com"}}, nil)
+	if err != nil {
+		t.Fatalf("NewWebSocketSource: %v", err)
+	}
+	address = serveLoopback(t, source.HTTPHandler(handler))
+	if _, err := dialWebSocket(address, "http://"+address); err == nil {
+		t.Fatalf("the origin out of the allowed origins was upgraded")
+	}
+	client, err := dialWebSocket(address, "https://app.example.com")
+	if err != nil {
+		t.Fatalf("the allowed origin was rejected: %v", err)
+	}
+	_ = client.conn.Close()
+
+	if _, err := NewWebSocketSource(WebSocketSourceConfig{Port: 1, AllowedOrigins: []string{"app.example.com"}}, nil); err == nil {
+		t.Fatalf("the origin without the scheme was accepted")
+	}
+}
+
+// TestWebSocketClosesIdleConnection checks that the connection is closed if the client doesn't answer the pings
+func TestWebSocketClosesIdleConnection(t *testing.T) {
+	source, err := NewWebSocketSource(WebSocketSourceConfig{Port: 1, IdleTimeout: 100 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("NewWebSocketSource: %v", err)
+	}
+	client := mustDialWebSocket(t, serveLoopback(t, source.HTTPHandler(func(req *Envelope) *Reply { return Ok(nil) })))
+
+	// the pings are read, but never answered
+	_ = client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	pinged := false
+	var head [2]byte
+	for {
+		if _, err := io.ReadFull(client.reader, head[:]); err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				t.Fatalf("the idle connection was not closed")
+			}
+			break
+		}
+		if head[0]&0x0F == wsPing {
+			pinged = true
+		}
+		if _, err := io.CopyN(io.Discard, client.reader, int64(head[1]&0x7F)); err != nil {
+			break
+		}
+	}
+	if !pinged {
+		t.Fatalf("the idle connection was not pinged")
+	}
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// The tuning profiles
+const (
+	SmallProfile  = "small"
+	MediumProfile = "medium"
+	LargeProfile  = "large"
+)
+
+// Tuning is the performance settings of the proxy
+type Tuning struct {
+	// Workers is the amount of the requests processed in parallel
+	Workers int `json:"workers,omitempty" yaml:"workers,omitempty"`
+	// Hwm is the high water mark of the sockets
+	Hwm int `json:"hwm,omitempty" yaml:"hwm,omitempty"`
+	// BatchSize is the amount of the messages sent at once
+	BatchSize int `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`
+	// QueueSize is the amount of the requests waiting for the worker
+	QueueSize int `json:"queue_size,omitempty" yaml:"queue_size,omitempty"`
+	// CacheBytes is the memory budget of the caches
+	CacheBytes uint64 `json:"cache_bytes,omitempty" yaml:"cache_bytes,omitempty"`
+}
+
+var profiles = map[string]Tuning{
+	SmallProfile:  {Workers: 4, Hwm: 1000, BatchSize: 16, QueueSize: 256, CacheBytes: 16 << 20},
+	MediumProfile: {Workers: 16, Hwm: 10000, BatchSize: 64, QueueSize: 2048, CacheBytes: 128 << 20},
+	LargeProfile:  {Workers: 64, Hwm: 100000, BatchSize: 256, QueueSize: 16384, CacheBytes: 1 << 30},
+}
+
+// TuningProfile returns the preset by its name
+func TuningProfile(name string) (Tuning, error) {
+	tuning, ok := profiles[name]
+	if !ok {
+		return Tuning{}, fmt.Errorf("unknown tuning profile '%s'", name)
+	}
+	return tuning, nil
+}
+
+// DetectProfile returns the profile name that fits the machine.
+// The processors are limited by GOMAXPROCS, the memory is read from /proc/meminfo if available.
+func DetectProfile() string {
+	processors := runtime.GOMAXPROCS(0)
+	memory := totalMemory()
+
+	switch {
+	case processors >= 16 && (memory == 0 || memory >= 16<<30):
+		return LargeProfile
+	case processors >= 4 && (memory == 0 || memory >= 4<<30):
+		return MediumProfile
+	default:
+		return SmallProfile
+	}
+}
+
+// DetectTuning returns the tuning of the detected profile
+func DetectTuning() Tuning {
+	tuning, _ := TuningProfile(DetectProfile())
+	return tuning
+}
+
+// Merge returns the tuning where the non-zero fields of the overrides replace the fields
+func (tuning Tuning) Merge(overrides Tuning) Tuning {
+	if overrides.Workers > 0 {
+		tuning.Workers = overrides.Workers
+	}
+	if overrides.Hwm > 0 {
+		tuning.Hwm = overrides.Hwm
+	}
+	if overrides.BatchSize > 0 {
+		tuning.BatchSize = overrides.BatchSize
+	}
+	if overrides.QueueSize > 0 {
+		tuning.QueueSize = overrides.QueueSize
+	}
+	if overrides.CacheBytes > 0 {
+		tuning.CacheBytes = overrides.CacheBytes
+	}
+	return tuning
+}
+
+// totalMemory returns the memory of the machine in bytes, or 0 if it's unknown
+func totalMemory() uint64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kilobytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kilobytes << 10
+	}
+	return 0
+}
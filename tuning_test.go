@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func TestTuningProfile(t *testing.T) {
+	previous := Tuning{}
+	for _, name := range []string{SmallProfile, MediumProfile, LargeProfile} {
+		tuning, err := TuningProfile(name)
+		if err != nil {
+			t.Fatalf("TuningProfile('%s'): %v", name, err)
+		}
+		if tuning.Workers <= previous.Workers || tuning.QueueSize <= previous.QueueSize || tuning.CacheBytes <= previous.CacheBytes {
+			t.Fatalf("expected '%s' profile to be larger than the previous, got %+v", name, tuning)
+		}
+		previous = tuning
+	}
+	if _, err := TuningProfile("huge"); err == nil {
+		t.Fatalf("expected an error for the unknown profile")
+	}
+}
+
+func TestDetectTuning(t *testing.T) {
+	name := DetectProfile()
+	profile, err := TuningProfile(name)
+	if err != nil {
+		t.Fatalf("expected the known profile, got '%s'", name)
+	}
+	if tuning := DetectTuning(); tuning != profile {
+		t.Fatalf("expected the tuning of '%s', got %+v", name, tuning)
+	}
+}
+
+func TestTuningMerge(t *testing.T) {
+	small, _ := TuningProfile(SmallProfile)
+	merged := small.Merge(Tuning{Workers: 2, CacheBytes: 1 << 10})
+	if merged.Workers != 2 || merged.CacheBytes != 1<<10 {
+		t.Fatalf("expected the overrides, got %+v", merged)
+	}
+	if merged.Hwm != small.Hwm || merged.BatchSize != small.BatchSize || merged.QueueSize != small.QueueSize {
+		t.Fatalf("expected the zero overrides to keep the profile, got %+v", merged)
+	}
+	if small.Workers == 2 {
+		t.Fatalf("expected the profile to be kept")
+	}
+	if merged := small.Merge(Tuning{Hwm: 5, BatchSize: 6, QueueSize: 7}); merged.Hwm != 5 || merged.BatchSize != 6 || merged.QueueSize != 7 {
+		t.Fatalf("expected the overrides, got %+v", merged)
+	}
+}
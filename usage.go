@@ -0,0 +1,382 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UsageReportCommand returns the usage of the principal
+const UsageReportCommand = "proxy.usage"
+
+// UsageFile is the file name in the data path where usage is persisted
+const UsageFile = "usage.json"
+
+const (
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// DefaultUsageDays is how many days the daily usage is kept. The monthly usage is kept forever
+const DefaultUsageDays = 92
+
+// Usage is the amount of requests and bytes in a period
+type Usage struct {
+	Requests uint64 `json:"requests"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// UsageReport is the usage of the principal rolled up by days and months
+type UsageReport struct {
+	Principal string           `json:"principal"`
+	Daily     map[string]Usage `json:"daily"`
+	Monthly   map[string]Usage `json:"monthly"`
+}
+
+// UsageTracker counts the requests and bytes per authenticated principal.
+// The counters are persisted in the data path.
+// Over the MaxPrincipals, the usage of the least recently active principal is dropped.
+type UsageTracker struct {
+	// Days is how many days the daily usage is kept, including today
+	Days int
+	// MaxPrincipals is the limit of the tracked principals. Zero means no limit
+	MaxPrincipals int
+
+	mu      sync.Mutex
+	pruned  string
+	path    string
+	reports map[string]*UsageReport
+	active  map[string]string
+	evicted uint64
+	rbac    *RBAC
+	now     func() time.Time
+}
+
+// lastActive returns the last day of the principals. Used after the reports are loaded
+func lastActive(reports map[string]*UsageReport) map[string]string {
+	active := make(map[string]string, len(reports))
+	for principal, report := range reports {
+		for day := range report.Daily {
+			if day > active[principal] {
+				active[principal] = day
+			}
+		}
+	}
+	return active
+}
+
+// NewUsageTracker returns the tracker that stores the usage in the dataPath.
+// If the data path has the usage file from the previous run, then it's loaded.
+func NewUsageTracker(dataPath string) (*UsageTracker, error) {
+	tracker := &UsageTracker{
+		Days:    DefaultUsageDays,
+		path:    filepath.Join(dataPath, UsageFile),
+		reports: make(map[string]*UsageReport),
+		active:  make(map[string]string),
+		now:     time.Now,
+	}
+
+	data, err := os.ReadFile(tracker.path)
+	if os.IsNotExist(err) {
+		return tracker, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile('%s'): %w", tracker.path, err)
+	}
+	if err := json.Unmarshal(data, &tracker.reports); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal('%s'): %w", tracker.path, err)
+	}
+	tracker.active = lastActive(tracker.reports)
+
+	return tracker, nil
+}
+
+// WithRBAC allows the principals with the UsageReportCommand role to read the usage of the others
+func (tracker *UsageTracker) WithRBAC(rbac *RBAC) *UsageTracker {
+	tracker.rbac = rbac
+	return tracker
+}
+
+// Record adds the request of the given size to the principal's usage
+func (tracker *UsageTracker) Record(principal string, size int) {
+	now := tracker.now().UTC()
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.record(principal, size, now)
+}
+
+// RecordWithin adds the request to the principal's usage, unless the request exceeds the quotas.
+// The quotas are the amount of requests, zero means no limit.
+// The check and the record are done at once, so the concurrent requests don't overshoot the quota.
+func (tracker *UsageTracker) RecordWithin(principal string, size int, daily uint64, monthly uint64) error {
+	now := tracker.now().UTC()
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if report, ok := tracker.reports[principal]; ok {
+		if daily > 0 && report.Daily[now.Format(dayLayout)].Requests >= daily {
+			return fmt.Errorf("'%s' exceeded daily quota of %d requests", principal, daily)
+		}
+		if monthly > 0 && report.Monthly[now.Format(monthLayout)].Requests >= monthly {
+			return fmt.Errorf("'%s' exceeded monthly quota of %d requests", principal, monthly)
+		}
+	}
+	tracker.record(principal, size, now)
+	return nil
+}
+
+// record adds the request to the usage. Must be called with the lock
+func (tracker *UsageTracker) record(principal string, size int, now time.Time) {
+	if today := now.Format(dayLayout); tracker.pruned != today {
+		tracker.prune(now)
+		tracker.pruned = today
+	}
+
+	day := now.Format(dayLayout)
+	report, ok := tracker.reports[principal]
+	if !ok {
+		for tracker.MaxPrincipals > 0 && len(tracker.reports) >= tracker.MaxPrincipals {
+			tracker.evict()
+		}
+		report = &UsageReport{
+			Principal: principal,
+			Daily:     make(map[string]Usage),
+			Monthly:   make(map[string]Usage),
+		}
+		tracker.reports[principal] = report
+	}
+
+	tracker.active[principal] = day
+	usage := report.Daily[day]
+	usage.Requests++
+	usage.Bytes += uint64(size)
+	report.Daily[day] = usage
+
+	month := now.Format(monthLayout)
+	usage = report.Monthly[month]
+	usage.Requests++
+	usage.Bytes += uint64(size)
+	report.Monthly[month] = usage
+}
+
+// evict drops the usage of the least recently active principal. Must be called with the lock
+func (tracker *UsageTracker) evict() {
+	oldest := ""
+	for principal := range tracker.reports {
+		if len(oldest) == 0 || tracker.active[principal] < tracker.active[oldest] {
+			oldest = principal
+		}
+	}
+	delete(tracker.reports, oldest)
+	delete(tracker.active, oldest)
+	tracker.evicted++
+}
+
+// Evicted returns the amount of the principals dropped over the MaxPrincipals
+func (tracker *UsageTracker) Evicted() uint64 {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	return tracker.evicted
+}
+
+// prune removes the daily usage older than the Days. Must be called with the lock
+func (tracker *UsageTracker) prune(now time.Time) {
+	if tracker.Days <= 0 {
+		return
+	}
+	oldest := now.AddDate(0, 0, 1-tracker.Days).Format(dayLayout)
+	for _, report := range tracker.reports {
+		for day := range report.Daily {
+			// the layout is sorted as the text
+			if day < oldest {
+				delete(report.Daily, day)
+			}
+		}
+	}
+}
+
+// Middleware counts the requests of the authenticated principals, so put it after WithAuth.
+// The requests without the principal are not counted.
+func (tracker *UsageTracker) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			if len(req.Principal) > 0 {
+				tracker.Record(req.Principal, req.Size())
+			}
+			return next(req)
+		}
+	}
+}
+
+// Today returns the usage of the principal in the current day
+func (tracker *UsageTracker) Today(principal string) Usage {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	report, ok := tracker.reports[principal]
+	if !ok {
+		return Usage{}
+	}
+	return report.Daily[tracker.now().UTC().Format(dayLayout)]
+}
+
+// ThisMonth returns the usage of the principal in the current month
+func (tracker *UsageTracker) ThisMonth(principal string) Usage {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	report, ok := tracker.reports[principal]
+	if !ok {
+		return Usage{}
+	}
+	return report.Monthly[tracker.now().UTC().Format(monthLayout)]
+}
+
+// Report returns the copy of the principal's usage
+func (tracker *UsageTracker) Report(principal string) UsageReport {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	copied := UsageReport{
+		Principal: principal,
+		Daily:     make(map[string]Usage),
+		Monthly:   make(map[string]Usage),
+	}
+	report, ok := tracker.reports[principal]
+	if !ok {
+		return copied
+	}
+	for day, usage := range report.Daily {
+		copied.Daily[day] = usage
+	}
+	for month, usage := range report.Monthly {
+		copied.Monthly[month] = usage
+	}
+	return copied
+}
+
+// Principals returns the sorted list of the tracked principals
+func (tracker *UsageTracker) Principals() []string {
+	tracker.mu.Lock()
+	principals := make([]string, 0, len(tracker.reports))
+	for principal := range tracker.reports {
+		principals = append(principals, principal)
+	}
+	tracker.mu.Unlock()
+
+	sort.Strings(principals)
+	return principals
+}
+
+// Save the usage in the data path
+func (tracker *UsageTracker) Save() error {
+	data, err := tracker.Snapshot()
+	if err != nil {
+		return fmt.Errorf("tracker.Snapshot: %w", err)
+	}
+
+	if err := writeFile(tracker.path, data); err != nil {
+		return fmt.Errorf("writeFile: %w", err)
+	}
+	return nil
+}
+
+// Run saves the usage every interval, and once more when the context is cancelled,
+// so the usage is not lost on stop. The errors are passed to onError, if it's set.
+func (tracker *UsageTracker) Run(ctx context.Context, interval time.Duration, onError func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-ctx.Done():
+			stopped = true
+		case <-ticker.C:
+		}
+
+		if err := tracker.Save(); err != nil && onError != nil {
+			onError(err)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// HandleReport is the handler of the UsageReportCommand, so put it after WithAuth.
+// The principal gets its own usage. The usage of the other principal in the 'principal' parameter
+// is returned only if the RBAC allows the UsageReportCommand to the caller.
+func (tracker *UsageTracker) HandleReport(req *Envelope) *Reply {
+	if len(req.Principal) == 0 {
+		return Fail("unauthorized: no principal")
+	}
+	principal := req.StringParam("principal")
+	if len(principal) == 0 {
+		principal = req.Principal
+	}
+	if principal != req.Principal && (tracker.rbac == nil || !tracker.rbac.Allowed(req.Principal, UsageReportCommand)) {
+		return Fail(fmt.Sprintf("forbidden: '%s' may not read the usage of '%s'", req.Principal, principal))
+	}
+
+	report := tracker.Report(principal)
+	return Ok(map[string]interface{}{
+		"principal": report.Principal,
+		"daily":     report.Daily,
+		"monthly":   report.Monthly,
+	})
+}
+
+// writeFile writes the data into the temporary file first, then renames it.
+// So the crash during the writing won't corrupt the previous file.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("os.MkdirAll: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return fmt.Errorf("os.WriteFile('%s'): %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("os.Rename('%s'): %w", tmp, err)
+	}
+	return nil
+}
+
+// Snapshot returns the usage, so it's saved by the StateStore
+func (tracker *UsageTracker) Snapshot() ([]byte, error) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	data, err := json.Marshal(tracker.reports)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	return data, nil
+}
+
+// Restore replaces the usage with the snapshot
+func (tracker *UsageTracker) Restore(data []byte) error {
+	reports := make(map[string]*UsageReport)
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	active := lastActive(reports)
+
+	tracker.mu.Lock()
+	tracker.reports = reports
+	tracker.active = active
+	tracker.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestUsageQuotaUnderConcurrency checks that the concurrent requests don't overshoot the daily quota
+func TestUsageQuotaUnderConcurrency(t *testing.T) {
+	tracker, err := NewUsageTracker(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewUsageTracker: %v", err)
+	}
+
+	var accepted int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tracker.RecordWithin("alice", 10, 20, 0) == nil {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 20 {
+		t.Fatalf("accepted %d requests over the daily quota of 20", accepted)
+	}
+	if requests := tracker.Today("alice").Requests; requests != 20 {
+		t.Fatalf("recorded %d requests, expected 20", requests)
+	}
+}
+
+// TestUsageEvictsIdlePrincipals checks that the tracker keeps at most MaxPrincipals
+func TestUsageEvictsIdlePrincipals(t *testing.T) {
+	tracker, err := NewUsageTracker(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewUsageTracker: %v", err)
+	}
+	tracker.MaxPrincipals = 2
+	for _, principal := range []string{"alice", "bob", "carol"} {
+		tracker.Record(principal, 10)
+	}
+	if principals := tracker.Principals(); len(principals) != 2 {
+		t.Fatalf("tracking %v over the limit of 2", principals)
+	}
+	if tracker.Evicted() != 1 {
+		t.Fatalf("evicted %d principals, expected 1", tracker.Evicted())
+	}
+}
+
+// TestUsageReportIsOwn checks that the principal reads only its own usage, unless the RBAC allows more
+func TestUsageReportIsOwn(t *testing.T) {
+	tracker, err := NewUsageTracker(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewUsageTracker: %v", err)
+	}
+	tracker.Record("alice", 10)
+	tracker.Record("bob", 10)
+
+	report := func(caller string, principal string) *Reply {
+		req := NewEnvelope(&Request{Command: UsageReportCommand, Parameters: map[string]interface{}{}})
+		req.Principal = caller
+		if len(principal) > 0 {
+			req.Parameters["principal"] = principal
+		}
+		return tracker.HandleReport(req)
+	}
+	if reply := report("", "alice"); reply.IsOK() {
+		t.Fatalf("the anonymous caller read the usage")
+	}
+	if reply := report("alice", ""); !reply.IsOK() || reply.Parameters["principal"] != "alice" {
+		t.Fatalf("alice didn't get her own usage: %v %s", reply.Parameters, reply.Message)
+	}
+	if reply := report("bob", "alice"); reply.IsOK() {
+		t.Fatalf("bob read the usage of alice")
+	}
+
+	rbac, err := NewRBAC(RBACConfig{
+		Roles:      map[string][]string{"billing": {UsageReportCommand}},
+		Principals: map[string][]string{"admin": {"billing"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewRBAC: %v", err)
+	}
+	tracker.WithRBAC(rbac)
+	if reply := report("bob", "alice"); reply.IsOK() {
+		t.Fatalf("bob without the role read the usage of alice")
+	}
+	if reply := report("admin", "alice"); !reply.IsOK() || reply.Parameters["principal"] != "alice" {
+		t.Fatalf("the admin didn't get the usage of alice: %s", reply.Message)
+	}
+}
+
+// TestUsageSavedOnStop checks that the usage is saved when the run stops
+func TestUsageSavedOnStop(t *testing.T) {
+	dataPath := t.TempDir()
+	tracker, err := NewUsageTracker(dataPath)
+	if err != nil {
+		t.Fatalf("NewUsageTracker: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		tracker.Run(ctx, time.Hour, func(err error) { t.Errorf("Save: %v", err) })
+		close(stopped)
+	}()
+	tracker.Record("alice", 10)
+	cancel()
+	<-stopped
+
+	loaded, err := NewUsageTracker(dataPath)
+	if err != nil {
+		t.Fatalf("NewUsageTracker: %v", err)
+	}
+	if requests := loaded.Today("alice").Requests; requests != 1 {
+		t.Fatalf("loaded %d requests after the stop, expected 1", requests)
+	}
+}
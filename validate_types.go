@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationErrors is the list of all problems found in the configuration
+type ValidationErrors []error
+
+// Error returns the problems one per line
+func (errs ValidationErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateTypes cross-checks the source and the pipeline at once.
+// The types must be registered and their settings must be accepted by the factories.
+// Unlike BuildPipeline and NewSource, it doesn't stop on the first problem,
+// and each problem has the hint how to fix it.
+//
+// The factories are called to check the settings, the created sources and middlewares are discarded.
+// Returns nil or ValidationErrors.
+func ValidateTypes(source SourceConfig, pipeline []MiddlewareConfig) error {
+	issues := lintTypes(source, pipeline)
+	if len(issues) == 0 {
+		return nil
+	}
+
+	errs := make(ValidationErrors, len(issues))
+	for i, issue := range issues {
+		errs[i] = fmt.Errorf("%s; %s", issue.Message, issue.Fix)
+	}
+	return errs
+}
@@ -0,0 +1,23 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidateTypesValid(t *testing.T) {
+	source := SourceConfig{Type: "tcp", Settings: map[string]interface{}{"port": 6000}}
+	if err := ValidateTypes(source, nil); err != nil {
+		t.Fatalf("expected the valid configuration, got %v", err)
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	errs := ValidationErrors{fmt.Errorf("first"), fmt.Errorf("second")}
+	if message := errs.Error(); message != "first\nsecond" {
+		t.Fatalf("expected one problem per line, got '%s'", message)
+	}
+	if message := (ValidationErrors{}).Error(); message != "" {
+		t.Fatalf("expected no problems, got '%s'", message)
+	}
+}
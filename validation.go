@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ValidationMode is how the unknown configuration settings are treated
+type ValidationMode string
+
+// The validation modes
+const (
+	// Strict mode rejects the configuration with the unknown settings
+	Strict ValidationMode = "strict"
+	// Permissive mode accepts the configuration, only calling the warning callback.
+	// Used to migrate the older configurations.
+	Permissive ValidationMode = "permissive"
+)
+
+var validation = struct {
+	sync.RWMutex
+	mode      ValidationMode
+	onWarning func(warning string)
+}{mode: Permissive}
+
+// SetValidationMode sets how the settings of the middlewares and the sources are validated.
+// The onWarning is called in the permissive mode, it could be nil.
+func SetValidationMode(mode ValidationMode, onWarning func(warning string)) error {
+	if mode != Strict && mode != Permissive {
+		return fmt.Errorf("unknown validation mode '%s'", mode)
+	}
+
+	validation.Lock()
+	validation.mode = mode
+	validation.onWarning = onWarning
+	validation.Unlock()
+	return nil
+}
+
+// decodeSettings converts the configuration settings into the structure.
+// The unknown settings are rejected or warned depending on the validation mode.
+func decodeSettings(settings map[string]interface{}, value interface{}) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	strictErr := decoder.Decode(value)
+	if strictErr == nil {
+		return nil
+	}
+
+	// the error could be the type mismatch, not the unknown field
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	validation.RLock()
+	mode, onWarning := validation.mode, validation.onWarning
+	validation.RUnlock()
+
+	if mode == Strict {
+		return fmt.Errorf("strict validation: %w", strictErr)
+	}
+	if onWarning != nil {
+		onWarning(strictErr.Error())
+	}
+	return nil
+}
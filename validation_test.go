@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"testing"
+)
+
+// validationSettings is the structure of the decoded settings in the tests
+type validationSettings struct {
+	Port int `json:"port"`
+}
+
+func TestSetValidationMode(t *testing.T) {
+	if err := SetValidationMode("lenient", nil); err == nil {
+		t.Fatalf("expected an error for the unknown mode")
+	}
+}
+
+func TestDecodeSettingsModes(t *testing.T) {
+	defer func() {
+		_ = SetValidationMode(Permissive, nil)
+	}()
+
+	var warnings []string
+	if err := SetValidationMode(Permissive, func(warning string) { warnings = append(warnings, warning) }); err != nil {
+		t.Fatalf("SetValidationMode: %v", err)
+	}
+
+	var config validationSettings
+	if err := decodeSettings(map[string]interface{}{"port": 80}, &config); err != nil || config.Port != 80 {
+		t.Fatalf("expected the known settings to be decoded, got %+v %v", config, err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", warnings)
+	}
+
+	config = validationSettings{}
+	if err := decodeSettings(map[string]interface{}{"port": 81, "prot": 82}, &config); err != nil || config.Port != 81 {
+		t.Fatalf("expected the permissive mode to accept the unknown settings, got %+v %v", config, err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected the warning of the unknown setting, got %v", warnings)
+	}
+
+	// the type mismatch fails in any mode
+	if err := decodeSettings(map[string]interface{}{"port": "eighty"}, &config); err == nil {
+		t.Fatalf("expected an error for the type mismatch")
+	}
+
+	if err := SetValidationMode(Strict, nil); err != nil {
+		t.Fatalf("SetValidationMode: %v", err)
+	}
+	if err := decodeSettings(map[string]interface{}{"port": 81, "prot": 82}, &config); err == nil {
+		t.Fatalf("expected the strict mode to reject the unknown settings")
+	}
+	if err := decodeSettings(map[string]interface{}{"port": 83}, &config); err != nil || config.Port != 83 {
+		t.Fatalf("expected the strict mode to accept the known settings, got %+v %v", config, err)
+	}
+}
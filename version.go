@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parseVersion returns the major, minor and patch numbers of the semantic version.
+// The 'v' prefix and the pre-release or build suffix are ignored.
+func parseVersion(version string) ([3]int, error) {
+	var parsed [3]int
+
+	trimmed := strings.TrimPrefix(version, "v")
+	if at := strings.IndexAny(trimmed, "-+"); at > -1 {
+		trimmed = trimmed[:at]
+	}
+	parts := strings.Split(trimmed, ".")
+	if len(trimmed) == 0 || len(parts) > 3 {
+		return parsed, fmt.Errorf("invalid version '%s'", version)
+	}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return parsed, fmt.Errorf("invalid version '%s'", version)
+		}
+		parsed[i] = number
+	}
+	return parsed, nil
+}
+
+// compareVersions returns -1, 0 or 1 if the first version is lower, equal or greater than the second
+func compareVersions(first [3]int, second [3]int) int {
+	for i := range first {
+		if first[i] < second[i] {
+			return -1
+		}
+		if first[i] > second[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// VersionPin checks that the destination version is in the compatible range.
+// The version is recorded from the handshake with the destination.
+type VersionPin struct {
+	// Min is the lowest compatible version, inclusive. Empty means no limit
+	Min string
+	// Max is the first incompatible version, exclusive. Empty means no limit
+	Max string
+	// Warn passes the requests to the incompatible destination, only calling OnMismatch
+	Warn bool
+	// OnMismatch is called when the incompatible version is recorded. Use it to log the warning
+	OnMismatch func(version string, err error)
+
+	mu      sync.RWMutex
+	version string
+	err     error
+}
+
+// NewVersionPin returns the pin of the range
+func NewVersionPin(low string, high string) (*VersionPin, error) {
+	if len(low) > 0 {
+		if _, err := parseVersion(low); err != nil {
+			return nil, fmt.Errorf("min: %w", err)
+		}
+	}
+	if len(high) > 0 {
+		if _, err := parseVersion(high); err != nil {
+			return nil, fmt.Errorf("max: %w", err)
+		}
+	}
+	return &VersionPin{Min: low, Max: high, err: fmt.Errorf("destination version is not recorded")}, nil
+}
+
+// Check returns an error if the version is outside the range
+func (pin *VersionPin) Check(version string) error {
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return err
+	}
+	if len(pin.Min) > 0 {
+		low, _ := parseVersion(pin.Min)
+		if compareVersions(parsed, low) < 0 {
+			return fmt.Errorf("version '%s' is lower than '%s'", version, pin.Min)
+		}
+	}
+	if len(pin.Max) > 0 {
+		high, _ := parseVersion(pin.Max)
+		if compareVersions(parsed, high) >= 0 {
+			return fmt.Errorf("version '%s' is not lower than '%s'", version, pin.Max)
+		}
+	}
+	return nil
+}
+
+// Record the destination version from the handshake reply.
+// Returns the compatibility error.
+func (pin *VersionPin) Record(handshake Handshake) error {
+	err := pin.Check(handshake.Version)
+
+	pin.mu.Lock()
+	pin.version = handshake.Version
+	pin.err = err
+	pin.mu.Unlock()
+
+	if err != nil && pin.OnMismatch != nil {
+		pin.OnMismatch(handshake.Version, err)
+	}
+	return err
+}
+
+// Version returns the recorded destination version
+func (pin *VersionPin) Version() string {
+	pin.mu.RLock()
+	defer pin.mu.RUnlock()
+
+	return pin.version
+}
+
+// Middleware refuses the requests while the destination version is incompatible.
+// In the Warn mode, the requests are passed anyway.
+func (pin *VersionPin) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Envelope) *Reply {
+			pin.mu.RLock()
+			err := pin.err
+			pin.mu.RUnlock()
+
+			if err != nil && !pin.Warn {
+				return Fail(fmt.Sprintf("incompatible destination: %v", err))
+			}
+			return next(req)
+		}
+	}
+}
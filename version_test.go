@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	valid := map[string][3]int{
+		"1":                {1, 0, 0},
+		"v1.2":             {1, 2, 0},
+		"1.2.3":            {1, 2, 3},
+		"v1.2.3-rc.1":      {1, 2, 3},
+		"1.2.3+build.5":    {1, 2, 3},
+		"10.20.30-beta+ab": {10, 20, 30},
+	}
+	for version, expected := range valid {
+		parsed, err := parseVersion(version)
+		if err != nil || parsed != expected {
+			t.Fatalf("expected %v for '%s', got %v %v", expected, version, parsed, err)
+		}
+	}
+
+	for _, version := range []string{"", "v", "1.2.3.4", "1.x", "1.-2", "-rc"} {
+		if _, err := parseVersion(version); err == nil {
+			t.Fatalf("expected an error for '%s'", version)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	if compareVersions([3]int{1, 2, 3}, [3]int{1, 2, 3}) != 0 {
+		t.Fatalf("expected the equal versions")
+	}
+	if compareVersions([3]int{1, 2, 3}, [3]int{1, 3, 0}) != -1 {
+		t.Fatalf("expected the lower minor version")
+	}
+	if compareVersions([3]int{2, 0, 0}, [3]int{1, 9, 9}) != 1 {
+		t.Fatalf("expected the greater major version")
+	}
+}
+
+func TestVersionPinCheck(t *testing.T) {
+	if _, err := NewVersionPin("x", ""); err == nil {
+		t.Fatalf("expected an error for the invalid min")
+	}
+	if _, err := NewVersionPin("", "x"); err == nil {
+		t.Fatalf("expected an error for the invalid max")
+	}
+
+	pin, err := NewVersionPin("1.2", "2")
+	if err != nil {
+		t.Fatalf("NewVersionPin: %v", err)
+	}
+	for _, version := range []string{"1.2.0", "1.9.9", "v1.2.0-rc"} {
+		if err := pin.Check(version); err != nil {
+			t.Fatalf("expected '%s' to be compatible: %v", version, err)
+		}
+	}
+	for _, version := range []string{"1.1.9", "2.0.0", "3", "invalid"} {
+		if err := pin.Check(version); err == nil {
+			t.Fatalf("expected '%s' to be incompatible", version)
+		}
+	}
+
+	unlimited, _ := NewVersionPin("", "")
+	if err := unlimited.Check("0.0.1"); err != nil {
+		t.Fatalf("expected any version without the limits: %v", err)
+	}
+}
+
+func TestVersionPinMiddleware(t *testing.T) {
+	pin, err := NewVersionPin("1", "2")
+	if err != nil {
+		t.Fatalf("NewVersionPin: %v", err)
+	}
+	var mismatched string
+	pin.OnMismatch = func(version string, err error) { mismatched = version }
+	handler := Wrap(func(req *Envelope) *Reply { return Ok(nil) }, pin.Middleware())
+
+	// the version is not recorded yet
+	if reply := handler(policyRequest("get", nil)); reply.IsOK() {
+		t.Fatalf("expected the request to be refused before the handshake")
+	}
+
+	if err := pin.Record(Handshake{Version: "1.5.0"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if reply := handler(policyRequest("get", nil)); !reply.IsOK() || pin.Version() != "1.5.0" {
+		t.Fatalf("expected the compatible destination, got '%s' %+v", pin.Version(), reply)
+	}
+	if len(mismatched) > 0 {
+		t.Fatalf("expected no mismatch, got '%s'", mismatched)
+	}
+
+	if err := pin.Record(Handshake{Version: "2.1.0"}); err == nil {
+		t.Fatalf("expected the incompatible version")
+	}
+	if mismatched != "2.1.0" || pin.Version() != "2.1.0" {
+		t.Fatalf("expected the mismatch of '2.1.0', got '%s'", mismatched)
+	}
+	if reply := handler(policyRequest("get", nil)); reply.IsOK() {
+		t.Fatalf("expected the request to the incompatible destination to be refused")
+	}
+
+	pin.Warn = true
+	if reply := handler(policyRequest("get", nil)); !reply.IsOK() {
+		t.Fatalf("expected the warn mode to pass the request, got '%s'", reply.Message)
+	}
+}